@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/muyiwadosunmu/hospital-management/internal/data"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+type admissionKey string
+
+const admissionCtx admissionKey = "admission"
+
+type AdmitPatientPayload struct {
+	BedID    int64  `json:"bedId" validate:"required,gt=0"`
+	DoctorID *int64 `json:"doctorId" validate:"omitempty,gt=0"`
+	Reason   string `json:"reason" validate:"required,max=2000"`
+}
+
+func (app *application) getAdmissionsHandler(w http.ResponseWriter, r *http.Request) {
+	patient := getPatientFromCtx(r)
+
+	admissions, err := app.models.Admissions.GetForPatient(r.Context(), patient.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": admissions}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) admitPatientHandler(w http.ResponseWriter, r *http.Request) {
+	var payload AdmitPatientPayload
+	patient := getPatientFromCtx(r)
+	receptionist := getRecUserFromContext(r)
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if payload.DoctorID != nil {
+		_, err := app.models.Doctors.GetById(r.Context(), *payload.DoctorID)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("doctorId", "doctor not found")
+		case err != nil:
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	admission := &data.Admission{
+		PatientID:      patient.ID,
+		BedID:          payload.BedID,
+		DoctorID:       payload.DoctorID,
+		ReceptionistID: receptionist.ID,
+		Reason:         strings.TrimSpace(payload.Reason),
+	}
+
+	err := app.models.Admissions.Admit(r.Context(), admission)
+	if err != nil {
+		app.admissionErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusCreated, envelope{"data": admission}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getAdmissionHandler(w http.ResponseWriter, r *http.Request) {
+	admission := getAdmissionFromCtx(r)
+
+	movements, err := app.models.Admissions.GetMovements(r.Context(), admission.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": admission, "movements": movements}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) transferPatientHandler(w http.ResponseWriter, r *http.Request) {
+	admission := getAdmissionFromCtx(r)
+	receptionist := getRecUserFromContext(r)
+
+	var payload struct {
+		BedID  int64  `json:"bedId" validate:"required,gt=0"`
+		Reason string `json:"reason" validate:"max=2000"`
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	movement, err := app.models.Admissions.Transfer(r.Context(), admission, payload.BedID, receptionist.ID,
+		strings.TrimSpace(payload.Reason))
+	if err != nil {
+		app.admissionErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": admission, "movement": movement}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) dischargePatientHandler(w http.ResponseWriter, r *http.Request) {
+	admission := getAdmissionFromCtx(r)
+	receptionist := getRecUserFromContext(r)
+
+	var payload struct {
+		Disposition string `json:"disposition" validate:"required"`
+		Reason      string `json:"reason" validate:"max=2000"`
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateDischarge(v, payload.Disposition); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movement, err := app.models.Admissions.Discharge(r.Context(), admission, receptionist.ID,
+		payload.Disposition, strings.TrimSpace(payload.Reason))
+	if err != nil {
+		app.admissionErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": admission, "movement": movement}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// admissionErrorResponse maps the errors returned by the ADT operations onto
+// responses.
+func (app *application) admissionErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		v := validator.New()
+		v.AddError("bedId", "bed not found")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, data.ErrEditConflict):
+		app.editConflictResponse(w, r)
+	case errors.Is(err, data.ErrAlreadyAdmitted),
+		errors.Is(err, data.ErrNotAdmitted),
+		errors.Is(err, data.ErrBedOccupied),
+		errors.Is(err, data.ErrBedNotReady),
+		errors.Is(err, data.ErrSameBed):
+		app.errorResponse(w, r, http.StatusConflict, err.Error())
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) admissionContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "admissionId"), 10, 64)
+		if err != nil || id < 1 {
+			app.notFoundResponse(w, r)
+			return
+		}
+		ctx := r.Context()
+		patient := getPatientFromCtx(r)
+
+		admission, err := app.models.Admissions.GetById(ctx, patient.ID, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, admissionCtx, admission)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getAdmissionFromCtx(r *http.Request) *data.Admission {
+	admission, _ := r.Context().Value(admissionCtx).(*data.Admission)
+	return admission
+}
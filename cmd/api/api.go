@@ -13,8 +13,15 @@ import (
 
 	"github.com/muyiwadosunmu/hospital-management/internal/auth"
 	"github.com/muyiwadosunmu/hospital-management/internal/data"
+	"github.com/muyiwadosunmu/hospital-management/internal/hl7"
+	"github.com/muyiwadosunmu/hospital-management/internal/immunization"
 	"github.com/muyiwadosunmu/hospital-management/internal/jsonlog"
-	"github.com/muyiwadosunmu/hospital-management/internal/mailer"
+	"github.com/muyiwadosunmu/hospital-management/internal/notify"
+	"github.com/muyiwadosunmu/hospital-management/internal/prescribing"
+	"github.com/muyiwadosunmu/hospital-management/internal/pubsub"
+	"github.com/muyiwadosunmu/hospital-management/internal/storage"
+	"github.com/muyiwadosunmu/hospital-management/internal/webhook"
+	"github.com/muyiwadosunmu/hospital-management/internal/x12"
 	"github.com/swaggo/swag/example/basic/docs"
 )
 
@@ -39,10 +46,24 @@ type application struct {
 	config config
 	models data.Models
 	logger *jsonlog.Logger
-	mailer mailer.Mailer
+	// notifier sends notifications by email and, when a gateway is configured, SMS.
+	notifier *notify.Service
 	// logger2 *slog.Logger
 	authenticator auth.Authenticator
 	wg            sync.WaitGroup
+	queueBroker   *pubsub.Broker
+	// vitalThresholds are the normal ranges abnormal vital signs are flagged against.
+	vitalThresholds *data.VitalThresholds
+	// prescriptionChecker checks new prescriptions for interactions and allergies.
+	prescriptionChecker *prescribing.Checker
+	// immunizationSchedule is the national schedule due vaccines are worked out from.
+	immunizationSchedule *immunization.Schedule
+	// storage holds uploaded patient attachments.
+	storage storage.Store
+	// exportStore holds the files written by bulk exports.
+	exportStore storage.Store
+	// webhookSender posts outbox events to webhook subscribers.
+	webhookSender *webhook.Sender
 }
 type config struct {
 	port        int
@@ -51,9 +72,141 @@ type config struct {
 	env         string
 	apiURL      string
 	frontendURL string
-	mail        mailConfig
-	auth        authConfig
-	redisConfig redisConfig
+	// hospitalName is printed on the documents given to patients.
+	hospitalName string
+	mail         mailConfig
+	auth         authConfig
+	redisConfig  redisConfig
+	vitals       vitalsConfig
+	prescribing  prescribingConfig
+	immunization immunizationConfig
+	storage      storageConfig
+	billing      billingConfig
+	claims       claimsConfig
+	fhir         fhirConfig
+	hl7          hl7Config
+	export       exportConfig
+	webhooks     webhookConfig
+	jobs         jobConfig
+	sms          smsConfig
+}
+
+type vitalsConfig struct {
+	// thresholdsFile is a JSON file of adult and paediatric normal ranges. The
+	// built-in defaults are used when it is empty.
+	thresholdsFile string
+}
+
+type prescribingConfig struct {
+	// interactionsFile is a JSON dataset of drug classes and known interactions.
+	// Without it only allergy and duplicate checks are made.
+	interactionsFile string
+}
+
+type immunizationConfig struct {
+	// scheduleFile is a JSON national immunization schedule. The built-in example
+	// schedule is used when it is empty.
+	scheduleFile string
+}
+
+type billingConfig struct {
+	// currency is the ISO 4217 code services are priced in unless given otherwise.
+	currency string
+}
+
+type claimsConfig struct {
+	// submitter and receiver identify the hospital and the clearinghouse or payer
+	// in 837 files. The provider is who the claims are billed by.
+	submitter      x12.Submitter
+	receiver       x12.Receiver
+	provider       x12.Provider
+	placeOfService string
+	// production marks 837 files as production data rather than test data.
+	production bool
+}
+
+type fhirConfig struct {
+	// baseURL is the public address of the FHIR facade, used for fullUrl, Location
+	// and paging links.
+	baseURL string
+	// licenseSystem is the identifier system practitioner license numbers are
+	// published under.
+	licenseSystem string
+}
+
+type hl7Config struct {
+	// addr is where the MLLP listener accepts HL7 v2 feeds. The listener is not
+	// started when it is empty.
+	addr string
+	// application and facility identify us in the MSH of acknowledgements.
+	application string
+	facility    string
+	// assigningAuthority marks PID-3 identifiers that are our own patient IDs.
+	assigningAuthority string
+	// receptionistID is who patients registered from ADT messages are added by.
+	receptionistID int64
+	// idleTimeout closes connections that have sent nothing for this long.
+	idleTimeout time.Duration
+}
+
+type exportConfig struct {
+	// dir is where export files are written.
+	dir string
+	// linkTTL is how long a download link stays valid, and retention how long the
+	// files of a finished export are kept.
+	linkTTL   time.Duration
+	retention time.Duration
+	// signingSecret signs download links.
+	signingSecret string
+}
+
+type webhookConfig struct {
+	// pollInterval is how often the outbox is checked for new events and due
+	// deliveries.
+	pollInterval time.Duration
+	// timeout bounds each delivery request.
+	timeout time.Duration
+	// A failed delivery is retried after retryBase, doubling each time up to
+	// retryMax, and dead-lettered after maxAttempts.
+	maxAttempts int
+	retryBase   time.Duration
+	retryMax    time.Duration
+	// insecureHosts may be sent events over plain http, for local development.
+	insecureHosts []string
+}
+
+type jobConfig struct {
+	// concurrency is how many jobs each instance runs at once.
+	concurrency int
+	// pollInterval is how often the queue is checked for due jobs.
+	pollInterval time.Duration
+	// A failed job is retried after retryBase, doubling each time up to retryMax,
+	// until it has been attempted maxAttempts times.
+	maxAttempts int
+	retryBase   time.Duration
+	retryMax    time.Duration
+	// drainTimeout is how long running jobs are given to finish at shutdown before
+	// they are cancelled and left for the next instance.
+	drainTimeout time.Duration
+}
+
+type smsConfig struct {
+	// gatewayURL is where text messages are posted. SMS is turned off when it is
+	// empty.
+	gatewayURL string
+	apiKey     string
+	// sender is the sender ID messages appear to come from.
+	sender  string
+	timeout time.Duration
+}
+
+type storageConfig struct {
+	// backend is either "local" or "s3".
+	backend  string
+	localDir string
+	s3       storage.S3Config
+	// maxUploadBytes caps the size of a single uploaded file.
+	maxUploadBytes int64
 }
 
 type redisConfig struct {
@@ -108,6 +261,55 @@ func (app *application) serve() error {
 		IdleTimeout:  60 * time.Second,
 	}
 
+	// Relay queue changes made by any API instance to our SSE subscribers. Both the
+	// listener and the open streams are stopped as soon as Shutdown() is called,
+	// otherwise the long-lived streams would hold up the graceful shutdown.
+	listenCtx, stopListening := context.WithCancel(context.Background())
+	srv.RegisterOnShutdown(func() {
+		stopListening()
+		app.queueBroker.Close()
+	})
+	app.background(func() {
+		app.relayNotifications(listenCtx, data.QueueEventsChannel, app.queueBroker, queueEventResync)
+	})
+
+	// Webhook deliveries in flight when shutdown starts are finished, not abandoned.
+	dispatchCtx, stopDispatching := context.WithCancel(context.Background())
+	srv.RegisterOnShutdown(stopDispatching)
+	app.background(func() {
+		app.dispatchWebhooks(dispatchCtx)
+	})
+
+	// Jobs already running are given a while to finish, and the rest left queued for
+	// the next start.
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	srv.RegisterOnShutdown(stopJobs)
+	app.background(func() {
+		app.runJobs(jobsCtx)
+	})
+
+	// The HL7 feed is served alongside the API when an MLLP address is configured.
+	var mllp *hl7.Server
+	if app.config.hl7.addr != "" {
+		mllp = &hl7.Server{
+			Addr:        app.config.hl7.addr,
+			Handler:     app.handleMLLP,
+			IdleTimeout: app.config.hl7.idleTimeout,
+			ErrorLog: func(err error, remoteAddr string) {
+				app.logger.PrintError(err, map[string]string{"remote_addr": remoteAddr})
+			},
+		}
+		go func() {
+			app.logger.PrintInfo("starting MLLP listener", map[string]string{
+				"addr": mllp.Addr,
+			})
+			err := mllp.ListenAndServe()
+			if !errors.Is(err, hl7.ErrServerClosed) {
+				app.logger.PrintError(err, map[string]string{"addr": mllp.Addr})
+			}
+		}()
+	}
+
 	// Create a shutdownError channel. We will use this to receive any errors returned
 	// by the graceful Shutdown() function.
 	shutdownError := make(chan error)
@@ -131,9 +333,21 @@ func (app *application) serve() error {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
+		// The MLLP listener drains alongside the HTTP server, with the same deadline,
+		// letting messages being handled finish and be acknowledged so senders don't
+		// resend them.
+		mllpShutdown := make(chan error, 1)
+		go func() {
+			if mllp == nil {
+				mllpShutdown <- nil
+				return
+			}
+			mllpShutdown <- mllp.Shutdown(ctx)
+		}()
+
 		// Call Shutdown() on the server like before, but now we only send on the
 		// shutdownError channel if it returns an error.
-		err := srv.Shutdown(ctx)
+		err := errors.Join(srv.Shutdown(ctx), <-mllpShutdown)
 		if err != nil {
 			shutdownError <- err
 		}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/go-chi/chi/v5"
+	"github.com/muyiwadosunmu/hospital-management/internal/data"
+	"github.com/muyiwadosunmu/hospital-management/internal/storage"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+type attachmentKey string
+
+const attachmentCtx attachmentKey = "attachment"
+
+// attachmentTypes are the file types that may be uploaded. The type is sniffed from
+// the file itself rather than trusted from the client.
+var attachmentTypes = []string{
+	"application/pdf",
+	"image/jpeg",
+	"image/png",
+	"image/tiff",
+	"image/gif",
+	"image/webp",
+	"image/heic",
+}
+
+func (app *application) getAttachmentsHandler(w http.ResponseWriter, r *http.Request) {
+	patient := getPatientFromCtx(r)
+
+	category := app.readString(r.URL.Query(), "category", "")
+	v := validator.New()
+	if category != "" {
+		v.Check(validator.In(category, data.AttachmentCategories...), "category", "invalid category")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	attachments, err := app.models.Attachments.GetForPatient(r.Context(), patient.ID, category)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": attachments}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// uploadAttachmentHandler accepts a multipart form with the file in "file" and the
+// "category" and optional "title" alongside it.
+func (app *application) uploadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	patient := getPatientFromCtx(r)
+	maxBytes := app.config.storage.maxUploadBytes
+
+	// Leave some room over the file size for the other form fields and the multipart
+	// framing.
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+64*1024)
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.errorResponse(w, r, http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("file must not be larger than %d bytes", maxBytes))
+		default:
+			app.badRequestResponse(w, r, fmt.Errorf("body must be a multipart form: %w", err))
+		}
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		v := validator.New()
+		v.AddError("file", "must be provided")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	defer file.Close()
+
+	attachment := &data.Attachment{
+		PatientID: patient.ID,
+		Category:  strings.TrimSpace(r.FormValue("category")),
+		Title:     strings.TrimSpace(r.FormValue("title")),
+		FileName:  uploadFileName(header.Filename),
+		SizeBytes: header.Size,
+	}
+	if doctor := getDocUserFromContext(r); doctor != nil {
+		attachment.UploadedByDoctorID = &doctor.ID
+	}
+	if receptionist := getRecUserFromContext(r); receptionist != nil {
+		attachment.UploadedByReceptionistID = &receptionist.ID
+	}
+
+	v := validator.New()
+	v.Check(header.Size <= maxBytes, "file", fmt.Sprintf("must not be larger than %d bytes", maxBytes))
+	if data.ValidateAttachment(v, attachment); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	mtype, checksum, err := inspectUpload(file)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !acceptedAttachmentType(mtype) {
+		v.AddError("file", fmt.Sprintf("files of type %s are not accepted", mtype.String()))
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	attachment.ContentType = mtype.String()
+	attachment.Checksum = checksum
+
+	attachment.StorageKey, err = attachmentStorageKey(patient.ID, mtype.Extension())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	err = app.storage.Put(ctx, attachment.StorageKey, file, attachment.SizeBytes, attachment.ContentType)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.models.Attachments.Insert(ctx, attachment); err != nil {
+		// Don't leave an object behind that nothing refers to.
+		if err := app.storage.Delete(context.Background(), attachment.StorageKey); err != nil {
+			app.logError(r, err)
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusCreated, envelope{"data": attachment}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// uploadFileName strips any directories a client includes in the name of an uploaded
+// file.
+func uploadFileName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	if name == "." || name == "/" {
+		return ""
+	}
+	return name
+}
+
+func acceptedAttachmentType(mtype *mimetype.MIME) bool {
+	for _, t := range attachmentTypes {
+		if mtype.Is(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// inspectUpload sniffs the type of an uploaded file and works out its SHA-256, leaving
+// the file rewound for storing.
+func inspectUpload(file multipart.File) (*mimetype.MIME, string, error) {
+	mtype, err := mimetype.DetectReader(file)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+	return mtype, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// attachmentStorageKey picks a random key for a new upload, so keys never depend on
+// what the client called the file.
+func attachmentStorageKey(patientID int64, ext string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("patients/%d/attachments/%s%s", patientID, hex.EncodeToString(b), ext), nil
+}
+
+func (app *application) getAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	attachment := getAttachmentFromCtx(r)
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": attachment}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// downloadAttachmentHandler streams the file from storage. Range and conditional
+// requests are handled by http.ServeContent, using the checksum as the ETag.
+func (app *application) downloadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	attachment := getAttachmentFromCtx(r)
+
+	object, err := app.storage.Open(r.Context(), attachment.StorageKey)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			app.logError(r, fmt.Errorf("attachment %d is missing from storage", attachment.ID))
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer object.Close()
+
+	disposition := "attachment"
+	if r.URL.Query().Get("inline") == "true" {
+		disposition = "inline"
+	}
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("%s; filename=%q", disposition, attachment.FileName))
+	w.Header().Set("ETag", `"`+attachment.Checksum+`"`)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, no-store")
+	http.ServeContent(w, r, attachment.FileName, attachment.CreatedAt, object)
+}
+
+func (app *application) deleteAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	attachment := getAttachmentFromCtx(r)
+
+	err := app.models.Attachments.Delete(r.Context(), attachment.PatientID, attachment.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The record is gone, so failing to remove the file only leaves an orphan behind.
+	if err := app.storage.Delete(r.Context(), attachment.StorageKey); err != nil {
+		app.logError(r, err)
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"message": "attachment successfully deleted"}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) attachmentContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "attachmentId"), 10, 64)
+		if err != nil || id < 1 {
+			app.notFoundResponse(w, r)
+			return
+		}
+		ctx := r.Context()
+		patient := getPatientFromCtx(r)
+
+		attachment, err := app.models.Attachments.GetById(ctx, patient.ID, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, attachmentCtx, attachment)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getAttachmentFromCtx(r *http.Request) *data.Attachment {
+	attachment, _ := r.Context().Value(attachmentCtx).(*data.Attachment)
+	return attachment
+}
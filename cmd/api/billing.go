@@ -0,0 +1,524 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/muyiwadosunmu/hospital-management/internal/data"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+type billingServiceKey string
+
+const billingServiceCtx billingServiceKey = "billingService"
+
+type invoiceKey string
+
+const invoiceCtx invoiceKey = "invoice"
+
+type CreateBillingServicePayload struct {
+	Code      string `json:"code" validate:"required,max=30"`
+	Name      string `json:"name" validate:"required,max=255"`
+	UnitPrice int64  `json:"unitPrice" validate:"gte=0"`
+	Currency  string `json:"currency"`
+}
+
+type InvoiceLinePayload struct {
+	ServiceID   int64  `json:"serviceId" validate:"required,gt=0"`
+	Quantity    int    `json:"quantity" validate:"omitempty,gt=0"`
+	Description string `json:"description" validate:"max=255"`
+}
+
+type CreateInvoicePayload struct {
+	Lines []InvoiceLinePayload `json:"lines" validate:"required,min=1,max=100,dive"`
+	Notes string               `json:"notes" validate:"max=2000"`
+	DueOn *data.Date           `json:"dueOn"`
+}
+
+type RecordPaymentPayload struct {
+	Amount        int64  `json:"amount" validate:"required,gt=0"`
+	Method        string `json:"method" validate:"required"`
+	ReceiptNumber string `json:"receiptNumber" validate:"required,max=50"`
+	Notes         string `json:"notes" validate:"max=2000"`
+}
+
+func (app *application) getBillingServicesHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	search := app.readString(qs, "search", "")
+	activeOnly := app.readString(qs, "active", "") == "true"
+
+	services, err := app.models.Services.GetAll(r.Context(), search, activeOnly)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": services}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createBillingServiceHandler adds a service to the price catalogue. Prices are in the
+// minor units of the currency, which defaults to the hospital's billing currency.
+func (app *application) createBillingServiceHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateBillingServicePayload
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	currency := data.NormalizeCurrency(payload.Currency)
+	if currency == "" {
+		currency = app.config.billing.currency
+	}
+	service := &data.BillingService{
+		Code:      strings.ToUpper(strings.TrimSpace(payload.Code)),
+		Name:      strings.TrimSpace(payload.Name),
+		UnitPrice: data.NewMoney(payload.UnitPrice, currency),
+		Active:    true,
+	}
+
+	v := validator.New()
+	if data.ValidateBillingService(v, service); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Services.Insert(r.Context(), service)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateServiceCode):
+			v.AddError("code", err.Error())
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusCreated, envelope{"data": service}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getBillingServiceHandler(w http.ResponseWriter, r *http.Request) {
+	service := getBillingServiceFromCtx(r)
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": service}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateBillingServiceHandler changes a catalogue entry. A service that is no longer
+// offered is deactivated rather than deleted, since invoices refer to it.
+func (app *application) updateBillingServiceHandler(w http.ResponseWriter, r *http.Request) {
+	service := getBillingServiceFromCtx(r)
+
+	var payload struct {
+		Code      *string `json:"code" validate:"omitempty,max=30"`
+		Name      *string `json:"name" validate:"omitempty,max=255"`
+		UnitPrice *int64  `json:"unitPrice" validate:"omitempty,gte=0"`
+		Currency  *string `json:"currency"`
+		Active    *bool   `json:"active"`
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if payload.Code != nil {
+		service.Code = strings.ToUpper(strings.TrimSpace(*payload.Code))
+	}
+	if payload.Name != nil {
+		service.Name = strings.TrimSpace(*payload.Name)
+	}
+	if payload.UnitPrice != nil {
+		service.UnitPrice.Amount = *payload.UnitPrice
+	}
+	if payload.Currency != nil {
+		service.UnitPrice.Currency = data.NormalizeCurrency(*payload.Currency)
+	}
+	if payload.Active != nil {
+		service.Active = *payload.Active
+	}
+
+	v := validator.New()
+	if data.ValidateBillingService(v, service); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Services.Update(r.Context(), service)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateServiceCode):
+			v.AddError("code", err.Error())
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": service}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getInvoicesHandler(w http.ResponseWriter, r *http.Request) {
+	patient := getPatientFromCtx(r)
+
+	var filters data.Filters
+	v := validator.New()
+	qs := r.URL.Query()
+
+	status := app.readString(qs, "status", "")
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "-issued_at")
+	filters.SortSafelist = []string{"issued_at", "due_on", "total", "-issued_at", "-due_on", "-total"}
+
+	if status != "" {
+		v.Check(validator.In(status, data.InvoiceStatuses...), "status", "must be open, paid or void")
+	}
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	invoices, metadata, err := app.models.Invoices.GetForPatient(r.Context(), patient.ID, status, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": invoices, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createInvoiceHandler raises an invoice from catalogue services. Each line is charged
+// at the service's current price, and every line must be in the same currency.
+func (app *application) createInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateInvoicePayload
+	patient := getPatientFromCtx(r)
+	receptionist := getRecUserFromContext(r)
+	ctx := r.Context()
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	invoice := &data.Invoice{
+		PatientID: patient.ID,
+		Status:    data.InvoiceOpen,
+		Notes:     strings.TrimSpace(payload.Notes),
+		DueOn:     payload.DueOn,
+		CreatedBy: receptionist.ID,
+	}
+
+	v := validator.New()
+	for i, line := range payload.Lines {
+		key := fmt.Sprintf("lines[%d].serviceId", i)
+		service, err := app.models.Services.GetById(ctx, line.ServiceID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError(key, "service does not exist")
+				continue
+			default:
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+		if !service.Active {
+			v.AddError(key, "service is no longer offered")
+			continue
+		}
+		if invoice.Total.Currency == "" {
+			invoice.Total = data.NewMoney(0, service.UnitPrice.Currency)
+			invoice.Paid = data.NewMoney(0, service.UnitPrice.Currency)
+		}
+		quantity := line.Quantity
+		if quantity == 0 {
+			quantity = 1
+		}
+		invoice.AddLine(service, quantity, strings.TrimSpace(line.Description))
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if data.ValidateInvoice(v, invoice); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.models.Invoices.Insert(ctx, invoice); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusCreated, envelope{"data": invoice}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	invoice := getInvoiceFromCtx(r)
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": invoice}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// voidInvoiceHandler cancels an invoice raised in error. Invoices with payments
+// against them can't be voided.
+func (app *application) voidInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	invoice := getInvoiceFromCtx(r)
+
+	var payload struct {
+		Reason string `json:"reason" validate:"required,max=2000"`
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	err := app.models.Invoices.Void(r.Context(), invoice, strings.TrimSpace(payload.Reason))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrInvoiceVoid), errors.Is(err, data.ErrInvoiceHasPayments):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": invoice}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getPaymentsHandler(w http.ResponseWriter, r *http.Request) {
+	invoice := getInvoiceFromCtx(r)
+
+	payments, err := app.models.Invoices.GetPayments(r.Context(), invoice.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": payments}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// recordPaymentHandler takes a full or partial payment against an invoice. The amount
+// is in the minor units of the invoice's currency and can't exceed the balance.
+func (app *application) recordPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	var payload RecordPaymentPayload
+	invoice := getInvoiceFromCtx(r)
+	receptionist := getRecUserFromContext(r)
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	payment := &data.Payment{
+		Amount:        data.NewMoney(payload.Amount, invoice.Total.Currency),
+		Method:        payload.Method,
+		ReceiptNumber: strings.TrimSpace(payload.ReceiptNumber),
+		Notes:         strings.TrimSpace(payload.Notes),
+		ReceivedBy:    receptionist.ID,
+	}
+
+	v := validator.New()
+	if data.ValidatePayment(v, payment); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Invoices.AddPayment(r.Context(), invoice, payment)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateReceipt):
+			v.AddError("receiptNumber", err.Error())
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrOverpayment):
+			v.AddError("amount", fmt.Sprintf("%s (outstanding %s)", err.Error(), invoice.Balance))
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrInvoiceVoid), errors.Is(err, data.ErrInvoicePaid):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"data": payment, "invoice": invoice}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getBalanceHandler returns what the patient owes, per currency.
+func (app *application) getBalanceHandler(w http.ResponseWriter, r *http.Request) {
+	patient := getPatientFromCtx(r)
+
+	balances, err := app.models.Invoices.Balances(r.Context(), patient.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": balances}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getStatementHandler returns the patient's account between from and to, both
+// inclusive. It defaults to the current month in the billing currency.
+func (app *application) getStatementHandler(w http.ResponseWriter, r *http.Request) {
+	patient := getPatientFromCtx(r)
+	qs := r.URL.Query()
+	v := validator.New()
+
+	now := time.Now().UTC()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	if t, err := app.readDateParam(qs, "from"); err != nil {
+		v.AddError("from", err.Error())
+	} else if t != nil {
+		from = *t
+	}
+	if t, err := app.readDateParam(qs, "to"); err != nil {
+		v.AddError("to", err.Error())
+	} else if t != nil {
+		to = *t
+	}
+	currency := data.NormalizeCurrency(app.readString(qs, "currency", app.config.billing.currency))
+
+	v.Check(!to.Before(from), "to", "must not be before from")
+	data.ValidateCurrency(v, "currency", currency)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	statement, err := app.models.Invoices.Statement(r.Context(), patient.ID, currency, from, to.AddDate(0, 0, 1))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	statement.To = to
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": statement}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) billingServiceContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "serviceId"), 10, 64)
+		if err != nil || id < 1 {
+			app.notFoundResponse(w, r)
+			return
+		}
+		ctx := r.Context()
+
+		service, err := app.models.Services.GetById(ctx, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, billingServiceCtx, service)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getBillingServiceFromCtx(r *http.Request) *data.BillingService {
+	service, _ := r.Context().Value(billingServiceCtx).(*data.BillingService)
+	return service
+}
+
+func (app *application) invoiceContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "invoiceId"), 10, 64)
+		if err != nil || id < 1 {
+			app.notFoundResponse(w, r)
+			return
+		}
+		ctx := r.Context()
+		patient := getPatientFromCtx(r)
+
+		invoice, err := app.models.Invoices.GetById(ctx, patient.ID, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, invoiceCtx, invoice)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getInvoiceFromCtx(r *http.Request) *data.Invoice {
+	invoice, _ := r.Context().Value(invoiceCtx).(*data.Invoice)
+	return invoice
+}
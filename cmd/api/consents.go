@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/muyiwadosunmu/hospital-management/internal/data"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+type consentKey string
+
+const consentCtx consentKey = "consent"
+
+type RecordConsentPayload struct {
+	Type        string     `json:"type" validate:"required"`
+	TextVersion string     `json:"textVersion" validate:"required,max=50"`
+	ConsentedOn *data.Date `json:"consentedOn"`
+}
+
+// newConsent builds a consent from the payload, witnessed by the receptionist
+// recording it. The consent is taken to have been given today unless a date is sent.
+func newConsent(patientID, witnessID int64, payload RecordConsentPayload) *data.Consent {
+	consent := &data.Consent{
+		PatientID:   patientID,
+		Type:        strings.TrimSpace(payload.Type),
+		TextVersion: strings.TrimSpace(payload.TextVersion),
+		ConsentedOn: data.NewDate(time.Now()),
+		WitnessID:   witnessID,
+	}
+	if payload.ConsentedOn != nil {
+		consent.ConsentedOn = *payload.ConsentedOn
+	}
+	return consent
+}
+
+func (app *application) getConsentsHandler(w http.ResponseWriter, r *http.Request) {
+	patient := getPatientFromCtx(r)
+
+	activeOnly := app.readString(r.URL.Query(), "active", "") == "true"
+
+	consents, err := app.models.Consents.GetForPatient(r.Context(), patient.ID, activeOnly)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": consents}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) recordConsentHandler(w http.ResponseWriter, r *http.Request) {
+	var payload RecordConsentPayload
+	patient := getPatientFromCtx(r)
+	receptionist := getRecUserFromContext(r)
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	consent := newConsent(patient.ID, receptionist.ID, payload)
+
+	v := validator.New()
+	if data.ValidateConsent(v, consent); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Consents.Insert(r.Context(), consent)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusCreated, envelope{"data": consent}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getConsentHandler(w http.ResponseWriter, r *http.Request) {
+	consent := getConsentFromCtx(r)
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": consent}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) withdrawConsentHandler(w http.ResponseWriter, r *http.Request) {
+	consent := getConsentFromCtx(r)
+	receptionist := getRecUserFromContext(r)
+
+	var payload struct {
+		Reason string `json:"reason" validate:"max=2000"`
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	err := app.models.Consents.Withdraw(r.Context(), consent, receptionist.ID, strings.TrimSpace(payload.Reason))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrConsentWithdrawn):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": consent}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) consentContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "consentId"), 10, 64)
+		if err != nil || id < 1 {
+			app.notFoundResponse(w, r)
+			return
+		}
+		ctx := r.Context()
+		patient := getPatientFromCtx(r)
+
+		consent, err := app.models.Consents.GetById(ctx, patient.ID, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, consentCtx, consent)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getConsentFromCtx(r *http.Request) *data.Consent {
+	consent, _ := r.Context().Value(consentCtx).(*data.Consent)
+	return consent
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/muyiwadosunmu/hospital-management/internal/data"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+type problemKey string
+
+const problemCtx problemKey = "problem"
+
+type DiagnosisPayload struct {
+	Code        string `json:"code" validate:"required,max=8"`
+	EncounterID *int64 `json:"encounterId" validate:"omitempty,gt=0"`
+	Notes       string `json:"notes" validate:"max=1000"`
+}
+
+type CreateProblemPayload struct {
+	Code         string     `json:"code" validate:"required,max=8"`
+	Status       string     `json:"status" validate:"omitempty,oneof=active resolved ruled-out"`
+	OnsetDate    *data.Date `json:"onsetDate"`
+	ResolvedDate *data.Date `json:"resolvedDate"`
+	Notes        string     `json:"notes" validate:"max=1000"`
+}
+
+func (app *application) searchICD10Handler(w http.ResponseWriter, r *http.Request) {
+	var queryDto struct {
+		Term string
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	queryDto.Term = strings.TrimSpace(app.readString(qs, "q", ""))
+	queryDto.Page = app.readInt(qs, "page", 1, v)
+	queryDto.PageSize = app.readInt(qs, "page_size", 20, v)
+	queryDto.Sort = "code"
+	queryDto.SortSafelist = []string{"code"}
+
+	v.Check(len(queryDto.Term) >= 2, "q", "must be at least 2 characters long")
+	if data.ValidateFilters(v, queryDto.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	codes, metadata, err := app.models.ICD10.Search(r.Context(), queryDto.Term, queryDto.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": codes, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getICD10CodeHandler(w http.ResponseWriter, r *http.Request) {
+	code, err := app.models.ICD10.Get(r.Context(), chi.URLParam(r, "code"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": code}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getProblemsHandler(w http.ResponseWriter, r *http.Request) {
+	patient := getPatientFromCtx(r)
+
+	v := validator.New()
+	status := app.readString(r.URL.Query(), "status", "")
+	if status != "" {
+		v.Check(validator.In(status, data.ProblemStatuses...), "status", "must be active, resolved or ruled-out")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	problems, err := app.models.Problems.GetForPatient(r.Context(), patient.ID, status)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": problems}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createProblemHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateProblemPayload
+	patient := getPatientFromCtx(r)
+	doctor := getDocUserFromContext(r)
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	problem := &data.Problem{
+		PatientID:    patient.ID,
+		Code:         data.NormalizeICD10Code(payload.Code),
+		Status:       payload.Status,
+		OnsetDate:    payload.OnsetDate,
+		ResolvedDate: payload.ResolvedDate,
+		Notes:        payload.Notes,
+		DoctorID:     doctor.ID,
+	}
+	if problem.Status == "" {
+		problem.Status = data.ProblemStatusActive
+	}
+	if problem.Status == data.ProblemStatusResolved && problem.ResolvedDate == nil {
+		today := data.NewDate(time.Now())
+		problem.ResolvedDate = &today
+	}
+
+	v := validator.New()
+	v.Check(data.ICD10CodeRX.MatchString(problem.Code), "code", "must be a valid ICD-10 code")
+	if data.ValidateProblem(v, problem); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Problems.Insert(r.Context(), problem)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnknownICD10Code):
+			v.AddError("code", err.Error())
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusCreated, envelope{"data": problem}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getProblemHandler(w http.ResponseWriter, r *http.Request) {
+	problem := getProblemFromCtx(r)
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": problem}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateProblemHandler(w http.ResponseWriter, r *http.Request) {
+	problem := getProblemFromCtx(r)
+
+	var payload struct {
+		Status       *string    `json:"status" validate:"omitempty,oneof=active resolved ruled-out"`
+		OnsetDate    *data.Date `json:"onsetDate"`
+		ResolvedDate *data.Date `json:"resolvedDate"`
+		Notes        *string    `json:"notes" validate:"omitempty,max=1000"`
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if payload.Status != nil {
+		problem.Status = *payload.Status
+		// Re-activating a problem clears its resolution date.
+		if problem.Status == data.ProblemStatusActive {
+			problem.ResolvedDate = nil
+		}
+	}
+	if payload.OnsetDate != nil {
+		problem.OnsetDate = payload.OnsetDate
+	}
+	if payload.ResolvedDate != nil {
+		problem.ResolvedDate = payload.ResolvedDate
+	}
+	if payload.Notes != nil {
+		problem.Notes = *payload.Notes
+	}
+	if problem.Status == data.ProblemStatusResolved && problem.ResolvedDate == nil {
+		today := data.NewDate(time.Now())
+		problem.ResolvedDate = &today
+	}
+
+	v := validator.New()
+	if data.ValidateProblem(v, problem); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Problems.Update(r.Context(), problem)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": problem}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getDiagnosesHandler(w http.ResponseWriter, r *http.Request) {
+	var filters data.Filters
+	patient := getPatientFromCtx(r)
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "-diagnosed_at")
+	filters.SortSafelist = []string{"diagnosed_at", "-diagnosed_at"}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	diagnoses, metadata, err := app.models.Diagnoses.GetForPatient(r.Context(), patient.ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": diagnoses, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// buildDiagnoses checks the diagnoses sent with a doctor's update of a patient and
+// turns them into records ready to be saved. Problems are reported on v.
+func (app *application) buildDiagnoses(ctx context.Context, v *validator.Validator, patient *data.Patient, doctor *data.Doctor, payload []DiagnosisPayload) ([]*data.Diagnosis, error) {
+	now := time.Now()
+	diagnoses := make([]*data.Diagnosis, 0, len(payload))
+	codes := make([]string, 0, len(payload))
+
+	for i, p := range payload {
+		code := data.NormalizeICD10Code(p.Code)
+		v.Check(data.ICD10CodeRX.MatchString(code), "diagnoses["+strconv.Itoa(i)+"].code", "must be a valid ICD-10 code")
+		codes = append(codes, code)
+
+		if p.EncounterID != nil {
+			encounter, err := app.models.Encounters.GetById(ctx, patient.ID, *p.EncounterID)
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("diagnoses["+strconv.Itoa(i)+"].encounterId", "encounter not found for this patient")
+			case err != nil:
+				return nil, err
+			case encounter.IsClosed():
+				v.AddError("diagnoses["+strconv.Itoa(i)+"].encounterId", data.ErrEncounterClosed.Error())
+			}
+		}
+
+		diagnoses = append(diagnoses, &data.Diagnosis{
+			PatientID:   patient.ID,
+			EncounterID: p.EncounterID,
+			DoctorID:    doctor.ID,
+			Code:        code,
+			Notes:       p.Notes,
+			DiagnosedAt: now,
+		})
+	}
+	if !v.Valid() {
+		return diagnoses, nil
+	}
+
+	missing, err := app.models.ICD10.Missing(ctx, codes)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range missing {
+		v.AddError("diagnoses", "unknown ICD-10 code "+code)
+	}
+	return diagnoses, nil
+}
+
+func (app *application) problemContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "problemId"), 10, 64)
+		if err != nil || id < 1 {
+			app.notFoundResponse(w, r)
+			return
+		}
+		ctx := r.Context()
+		patient := getPatientFromCtx(r)
+
+		problem, err := app.models.Problems.GetById(ctx, patient.ID, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, problemCtx, problem)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getProblemFromCtx(r *http.Request) *data.Problem {
+	problem, _ := r.Context().Value(problemCtx).(*data.Problem)
+	return problem
+}
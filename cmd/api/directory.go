@@ -0,0 +1,430 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/muyiwadosunmu/hospital-management/internal/data"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+type departmentKey string
+
+const departmentCtx departmentKey = "department"
+
+type doctorKey string
+
+// doctorCtx holds the doctor named in the URL, as opposed to userCtx which holds the
+// signed in user.
+const doctorCtx doctorKey = "doctor"
+
+type DepartmentPayload struct {
+	Name        string `json:"name" validate:"required,max=100"`
+	Description string `json:"description" validate:"max=2000"`
+}
+
+// DoctorProfilePayload updates a doctor's profile. Only the fields sent are changed,
+// and specialtyIds replaces the doctor's specialties when it is sent.
+type DoctorProfilePayload struct {
+	Title            *string    `json:"title" validate:"omitempty,max=100"`
+	DepartmentID     *int64     `json:"departmentId" validate:"omitempty,gte=0"`
+	SpecialtyIDs     []int64    `json:"specialtyIds" validate:"omitempty,max=10,dive,gt=0"`
+	LicenseNumber    *string    `json:"licenseNumber" validate:"omitempty,max=50"`
+	LicenseExpiresOn *data.Date `json:"licenseExpiresOn"`
+	Phone            *string    `json:"phone" validate:"omitempty,max=30"`
+	Bio              *string    `json:"bio" validate:"omitempty,max=5000"`
+}
+
+func (app *application) getDepartmentsHandler(w http.ResponseWriter, r *http.Request) {
+	departments, err := app.models.Departments.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": departments}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createDepartmentHandler(w http.ResponseWriter, r *http.Request) {
+	var payload DepartmentPayload
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	department := &data.Department{
+		Name:        strings.TrimSpace(payload.Name),
+		Description: strings.TrimSpace(payload.Description),
+	}
+
+	v := validator.New()
+	if data.ValidateDepartment(v, department); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Departments.Insert(r.Context(), department)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateDepartment):
+			v.AddError("name", err.Error())
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusCreated, envelope{"data": department}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getDepartmentHandler(w http.ResponseWriter, r *http.Request) {
+	department := getDepartmentFromCtx(r)
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": department}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateDepartmentHandler(w http.ResponseWriter, r *http.Request) {
+	department := getDepartmentFromCtx(r)
+
+	var payload struct {
+		Name        *string `json:"name" validate:"omitempty,max=100"`
+		Description *string `json:"description" validate:"omitempty,max=2000"`
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if payload.Name != nil {
+		department.Name = strings.TrimSpace(*payload.Name)
+	}
+	if payload.Description != nil {
+		department.Description = strings.TrimSpace(*payload.Description)
+	}
+
+	v := validator.New()
+	if data.ValidateDepartment(v, department); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Departments.Update(r.Context(), department)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateDepartment):
+			v.AddError("name", err.Error())
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": department}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getSpecialtiesHandler(w http.ResponseWriter, r *http.Request) {
+	specialties, err := app.models.Specialties.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": specialties}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createSpecialtyHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Name string `json:"name" validate:"required,max=100"`
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	specialty := &data.Specialty{Name: strings.TrimSpace(payload.Name)}
+
+	v := validator.New()
+	if data.ValidateSpecialty(v, specialty); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Specialties.Insert(r.Context(), specialty)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateSpecialty):
+			v.AddError("name", err.Error())
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusCreated, envelope{"data": specialty}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getDoctorDirectoryHandler searches the doctors by name, specialty and department.
+// Specialties and departments can be given by name (a case-insensitive partial
+// match) or by ID.
+func (app *application) getDoctorDirectoryHandler(w http.ResponseWriter, r *http.Request) {
+	var queryDto struct {
+		Name         string
+		Specialty    string
+		SpecialtyID  int
+		Department   string
+		DepartmentID int
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	queryDto.Name = strings.TrimSpace(app.readString(qs, "name", ""))
+	queryDto.Specialty = strings.TrimSpace(app.readString(qs, "specialty", ""))
+	queryDto.SpecialtyID = app.readInt(qs, "specialty_id", 0, v)
+	queryDto.Department = strings.TrimSpace(app.readString(qs, "department", ""))
+	queryDto.DepartmentID = app.readInt(qs, "department_id", 0, v)
+
+	queryDto.Page = app.readInt(qs, "page", 1, v)
+	queryDto.PageSize = app.readInt(qs, "page_size", 20, v)
+	queryDto.Sort = app.readString(qs, "sort", "last_name")
+	queryDto.SortSafelist = []string{"last_name", "first_name", "id", "-last_name", "-first_name", "-id"}
+
+	if data.ValidateFilters(v, queryDto.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	doctors, metadata, err := app.models.Doctors.Directory(r.Context(), queryDto.Name, queryDto.Specialty,
+		int64(queryDto.SpecialtyID), queryDto.Department, int64(queryDto.DepartmentID), queryDto.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": doctors, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getDoctorHandler(w http.ResponseWriter, r *http.Request) {
+	doctor := getDoctorFromCtx(r)
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": doctor}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getMyProfileHandler(w http.ResponseWriter, r *http.Request) {
+	doctor := getDocUserFromContext(r)
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": doctor}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateMyProfileHandler lets a doctor change their own title, phone and bio. Their
+// department, specialties and license are kept by reception.
+func (app *application) updateMyProfileHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Title *string `json:"title" validate:"omitempty,max=100"`
+		Phone *string `json:"phone" validate:"omitempty,max=30"`
+		Bio   *string `json:"bio" validate:"omitempty,max=5000"`
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	app.updateDoctorProfile(w, r, getDocUserFromContext(r), DoctorProfilePayload{
+		Title: payload.Title,
+		Phone: payload.Phone,
+		Bio:   payload.Bio,
+	})
+}
+
+func (app *application) updateDoctorHandler(w http.ResponseWriter, r *http.Request) {
+	var payload DoctorProfilePayload
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	app.updateDoctorProfile(w, r, getDoctorFromCtx(r), payload)
+}
+
+func (app *application) updateDoctorProfile(w http.ResponseWriter, r *http.Request, doctor *data.Doctor, payload DoctorProfilePayload) {
+	ctx := r.Context()
+	v := validator.New()
+
+	if payload.Title != nil {
+		doctor.Title = strings.TrimSpace(*payload.Title)
+	}
+	if payload.LicenseNumber != nil {
+		doctor.LicenseNumber = strings.ToUpper(strings.TrimSpace(*payload.LicenseNumber))
+	}
+	if payload.LicenseExpiresOn != nil {
+		doctor.LicenseExpiresOn = payload.LicenseExpiresOn
+	}
+	if payload.Phone != nil {
+		doctor.Phone = strings.TrimSpace(*payload.Phone)
+	}
+	if payload.Bio != nil {
+		doctor.Bio = strings.TrimSpace(*payload.Bio)
+	}
+	// A department ID of 0 takes the doctor out of their department.
+	if payload.DepartmentID != nil {
+		doctor.DepartmentID = nil
+		if *payload.DepartmentID != 0 {
+			_, err := app.models.Departments.GetById(ctx, *payload.DepartmentID)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					v.AddError("departmentId", "department does not exist")
+				default:
+					app.serverErrorResponse(w, r, err)
+					return
+				}
+			}
+			doctor.DepartmentID = payload.DepartmentID
+		}
+	}
+
+	if data.ValidateDoctorProfile(v, doctor, payload.SpecialtyIDs); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Doctors.UpdateProfile(ctx, doctor, payload.SpecialtyIDs)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateLicense):
+			v.AddError("licenseNumber", err.Error())
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrUnknownSpecialty):
+			v.AddError("specialtyIds", err.Error())
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": doctor}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) departmentContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "departmentId"), 10, 64)
+		if err != nil || id < 1 {
+			app.notFoundResponse(w, r)
+			return
+		}
+		ctx := r.Context()
+
+		department, err := app.models.Departments.GetById(ctx, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, departmentCtx, department)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getDepartmentFromCtx(r *http.Request) *data.Department {
+	department, _ := r.Context().Value(departmentCtx).(*data.Department)
+	return department
+}
+
+func (app *application) doctorContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "doctorId"), 10, 64)
+		if err != nil || id < 1 {
+			app.notFoundResponse(w, r)
+			return
+		}
+		ctx := r.Context()
+
+		doctor, err := app.models.Doctors.GetById(ctx, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, doctorCtx, doctor)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getDoctorFromCtx(r *http.Request) *data.Doctor {
+	doctor, _ := r.Context().Value(doctorCtx).(*data.Doctor)
+	return doctor
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/muyiwadosunmu/hospital-management/internal/data"
+	"github.com/muyiwadosunmu/hospital-management/internal/documents"
+	"github.com/muyiwadosunmu/hospital-management/internal/mailer"
+	"github.com/muyiwadosunmu/hospital-management/internal/notify"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+type CreateDischargeSummaryPayload struct {
+	AdmissionID *int64 `json:"admissionId" validate:"omitempty,gt=0"`
+	NoteID      *int64 `json:"noteId" validate:"omitempty,gt=0"`
+	Email       bool   `json:"email"`
+}
+
+func (app *application) getDischargeSummariesHandler(w http.ResponseWriter, r *http.Request) {
+	patient := getPatientFromCtx(r)
+
+	summaries, err := app.models.Discharges.GetForPatient(r.Context(), patient.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": summaries}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createDischargeSummaryHandler renders the patient's discharge summary to PDF and
+// stores it. The final note is the one given, or else the latest signed discharge
+// note.
+func (app *application) createDischargeSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateDischargeSummaryPayload
+	patient := getPatientFromCtx(r)
+	doctor := getDocUserFromContext(r)
+	ctx := r.Context()
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	summary, err := app.buildDischargeSummary(ctx, v, patient, doctor, payload)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if payload.Email {
+		consented, err := app.models.Consents.HasActive(ctx, patient.ID, data.ConsentEmail)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		v.Check(patient.Email != "", "email", "patient has no email address")
+		v.Check(consented, "email", "patient has not consented to email communication")
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+
+	content, err := summary.Render()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	stored := &data.DischargeSummary{
+		PatientID: patient.ID,
+		NoteID:    summary.Note.ID,
+		DoctorID:  doctor.ID,
+		FileName:  fmt.Sprintf("discharge-summary-%d-%s.pdf", patient.ID, summary.GeneratedAt.Format("20060102")),
+		Content:   content,
+	}
+	if summary.Admission != nil {
+		stored.AdmissionID = &summary.Admission.ID
+	}
+
+	if err := app.models.Discharges.Insert(ctx, stored); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if payload.Email {
+		app.emailPatient(ctx, patient, tmplDischargeSummary, dischargeSummaryArgs{SummaryID: stored.ID})
+	}
+
+	if err := app.writeJSON(w, http.StatusCreated, envelope{"data": stored}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// buildDischargeSummary gathers what goes into the summary. Problems with the request
+// are reported on v.
+func (app *application) buildDischargeSummary(ctx context.Context, v *validator.Validator, patient *data.Patient, doctor *data.Doctor, payload CreateDischargeSummaryPayload) (*documents.DischargeSummary, error) {
+	summary := &documents.DischargeSummary{
+		Hospital:    app.config.hospitalName,
+		GeneratedAt: time.Now(),
+		Patient:     patient,
+		Doctor:      doctor,
+	}
+
+	var err error
+	if payload.NoteID != nil {
+		summary.Note, err = app.models.Notes.GetById(ctx, patient.ID, *payload.NoteID)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("noteId", "note not found for this patient")
+		case err != nil:
+			return nil, err
+		case !summary.Note.IsSigned():
+			v.AddError("noteId", "note must be signed before it can go in a discharge summary")
+		}
+	} else {
+		summary.Note, err = app.models.Notes.GetLatestSigned(ctx, patient.ID, data.NoteTypeDischarge)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("noteId", "patient has no signed discharge note")
+		case err != nil:
+			return nil, err
+		}
+	}
+
+	if payload.AdmissionID != nil {
+		summary.Admission, err = app.models.Admissions.GetById(ctx, patient.ID, *payload.AdmissionID)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("admissionId", "admission not found for this patient")
+		case err != nil:
+			return nil, err
+		}
+	} else {
+		admissions, err := app.models.Admissions.GetForPatient(ctx, patient.ID)
+		if err != nil {
+			return nil, err
+		}
+		if len(admissions) > 0 {
+			summary.Admission = admissions[0]
+		}
+	}
+	if !v.Valid() {
+		return summary, nil
+	}
+
+	summary.Problems, err = app.models.Problems.GetForPatient(ctx, patient.ID, data.ProblemStatusActive)
+	if err != nil {
+		return nil, err
+	}
+	summary.Prescriptions, err = app.models.Prescriptions.GetForPatient(ctx, patient.ID, true)
+	if err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+type dischargeSummaryArgs struct {
+	SummaryID int64 `json:"summaryId"`
+}
+
+// dischargeSummaryMessage attaches the stored PDF to the email.
+func (app *application) dischargeSummaryMessage(ctx context.Context, n *data.Notification) (notify.Message, error) {
+	var args dischargeSummaryArgs
+	if err := decodeNotificationArgs(n, &args); err != nil {
+		return notify.Message{}, err
+	}
+	patient, err := app.models.Patients.GetPatientById(ctx, *n.PatientID)
+	if err != nil {
+		return notify.Message{}, err
+	}
+	summary, err := app.models.Discharges.GetById(ctx, patient.ID, args.SummaryID)
+	if err != nil {
+		return notify.Message{}, err
+	}
+
+	return notify.Message{
+		Data: map[string]interface{}{
+			"firstName": patient.FirstName,
+			"lastName":  patient.LastName,
+			"hospital":  app.config.hospitalName,
+		},
+		Attachments: []mailer.Attachment{{
+			Filename:    summary.FileName,
+			ContentType: "application/pdf",
+			Data:        summary.Content,
+		}},
+	}, nil
+}
+
+// dischargeSummarySent records when the summary was emailed to the patient.
+func (app *application) dischargeSummarySent(ctx context.Context, n *data.Notification) error {
+	var args dischargeSummaryArgs
+	if err := decodeNotificationArgs(n, &args); err != nil {
+		return err
+	}
+	return app.models.Discharges.MarkEmailed(ctx, args.SummaryID)
+}
+
+// downloadDischargeSummaryHandler returns the stored PDF.
+func (app *application) downloadDischargeSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	patient := getPatientFromCtx(r)
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "summaryId"), 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	summary, err := app.models.Discharges.GetById(r.Context(), patient.ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Length", strconv.Itoa(len(summary.Content)))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", summary.FileName))
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(summary.Content); err != nil {
+		app.logError(r, err)
+	}
+}
@@ -69,18 +69,8 @@ func (app *application) registerDoctorHandler(w http.ResponseWriter, r *http.Req
 	}
 
 	// send welcome email
-	app.background(func() {
-		data := map[string]interface{}{
-			"userID":    user.ID,
-			"firstName": user.FirstName,
-			"lastName":  user.LastName,
-		}
-
-		err = app.mailer.Send(user.Email, "user_welcome.tmpl", data)
-		if err != nil {
-			app.logger.PrintError(err, nil)
-		}
-	})
+	args := welcomeArgs{UserID: user.ID, FirstName: user.FirstName, LastName: user.LastName}
+	app.notifyStaff(ctx, user.Email, tmplUserWelcome, args)
 
 	if err := app.writeJSON(w, http.StatusCreated, envelope{"data": user}, nil); err != nil {
 		app.serverErrorResponse(w, r, err)
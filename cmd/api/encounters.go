@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/muyiwadosunmu/hospital-management/internal/data"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+type encounterKey string
+
+const encounterCtx encounterKey = "encounter"
+
+type CreateEncounterPayload struct {
+	Type      string     `json:"type" validate:"required,oneof=outpatient inpatient emergency telehealth home_visit"`
+	Reason    string     `json:"reason" validate:"max=1000"`
+	Location  string     `json:"location" validate:"max=255"`
+	StartedAt *time.Time `json:"startedAt"`
+}
+
+type CreateEncounterEntryPayload struct {
+	Kind    string      `json:"kind" validate:"required,oneof=observation assessment plan procedure note"`
+	Content interface{} `json:"content" validate:"required"`
+}
+
+func (app *application) createEncounterHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateEncounterPayload
+	patient := getPatientFromCtx(r)
+	doctor := getDocUserFromContext(r)
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	encounter := &data.Encounter{
+		PatientID: patient.ID,
+		DoctorID:  doctor.ID,
+		Type:      payload.Type,
+		Reason:    payload.Reason,
+		Location:  payload.Location,
+	}
+	if payload.StartedAt != nil {
+		encounter.StartedAt = *payload.StartedAt
+	}
+
+	if err := app.models.Encounters.Create(r.Context(), encounter); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusCreated, envelope{"data": encounter}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getEncountersHandler(w http.ResponseWriter, r *http.Request) {
+	var queryDto struct {
+		data.EncounterQuery
+		data.Filters
+	}
+	patient := getPatientFromCtx(r)
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	queryDto.Type = app.readString(qs, "type", "")
+	queryDto.Status = app.readString(qs, "status", "")
+
+	var err error
+	if queryDto.From, err = app.readDateParam(qs, "from"); err != nil {
+		v.AddError("from", err.Error())
+	}
+	if queryDto.To, err = app.readDateParam(qs, "to"); err != nil {
+		v.AddError("to", err.Error())
+	}
+
+	queryDto.Page = app.readInt(qs, "page", 1, v)
+	queryDto.PageSize = app.readInt(qs, "page_size", 10, v)
+	queryDto.Sort = app.readString(qs, "sort", "-started_at")
+	queryDto.SortSafelist = []string{"id", "started_at", "type", "-id", "-started_at", "-type"}
+
+	if queryDto.Type != "" {
+		v.Check(validator.In(queryDto.Type, data.EncounterTypes...), "type", "invalid encounter type")
+	}
+	if queryDto.Status != "" {
+		v.Check(validator.In(queryDto.Status, data.EncounterStatusOpen, data.EncounterStatusClosed), "status", "must be open or closed")
+	}
+
+	if data.ValidateFilters(v, queryDto.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	encounters, metadata, err := app.models.Encounters.GetForPatient(r.Context(), patient.ID,
+		queryDto.EncounterQuery, queryDto.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": encounters, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getEncounterHandler(w http.ResponseWriter, r *http.Request) {
+	encounter := getEncounterFromCtx(r)
+
+	entries, err := app.models.Encounters.GetEntries(r.Context(), encounter.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	encounter.Entries = entries
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": encounter}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateEncounterHandler(w http.ResponseWriter, r *http.Request) {
+	encounter := getEncounterFromCtx(r)
+
+	var payload struct {
+		Type     *string `json:"type" validate:"omitempty,oneof=outpatient inpatient emergency telehealth home_visit"`
+		Reason   *string `json:"reason" validate:"omitempty,max=1000"`
+		Location *string `json:"location" validate:"omitempty,max=255"`
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if payload.Type != nil {
+		encounter.Type = *payload.Type
+	}
+	if payload.Reason != nil {
+		encounter.Reason = *payload.Reason
+	}
+	if payload.Location != nil {
+		encounter.Location = *payload.Location
+	}
+
+	err := app.models.Encounters.Update(r.Context(), encounter)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": encounter}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) closeEncounterHandler(w http.ResponseWriter, r *http.Request) {
+	encounter := getEncounterFromCtx(r)
+
+	err := app.models.Encounters.Close(r.Context(), encounter, time.Now())
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": encounter}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getEncounterEntriesHandler(w http.ResponseWriter, r *http.Request) {
+	encounter := getEncounterFromCtx(r)
+
+	entries, err := app.models.Encounters.GetEntries(r.Context(), encounter.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": entries}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createEncounterEntryHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateEncounterEntryPayload
+	encounter := getEncounterFromCtx(r)
+	doctor := getDocUserFromContext(r)
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	entry := &data.EncounterEntry{
+		EncounterID: encounter.ID,
+		DoctorID:    doctor.ID,
+		Kind:        payload.Kind,
+		Content:     payload.Content,
+	}
+
+	err := app.models.Encounters.AddEntry(r.Context(), entry)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEncounterClosed):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusCreated, envelope{"data": entry}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// requireOpenEncounter rejects any request that would change a closed encounter.
+func (app *application) requireOpenEncounter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if getEncounterFromCtx(r).IsClosed() {
+			app.errorResponse(w, r, http.StatusConflict, data.ErrEncounterClosed.Error())
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (app *application) encounterContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "encounterId"), 10, 64)
+		if err != nil || id < 1 {
+			app.notFoundResponse(w, r)
+			return
+		}
+		ctx := r.Context()
+		patient := getPatientFromCtx(r)
+
+		encounter, err := app.models.Encounters.GetById(ctx, patient.ID, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, encounterCtx, encounter)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getEncounterFromCtx(r *http.Request) *data.Encounter {
+	encounter, _ := r.Context().Value(encounterCtx).(*data.Encounter)
+	return encounter
+}
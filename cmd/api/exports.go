@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/muyiwadosunmu/hospital-management/internal/data"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+type exportKey string
+
+const exportCtx exportKey = "export"
+
+// exportProgressInterval is how many records are written between progress updates.
+const exportProgressInterval = 5000
+
+type CreateExportPayload struct {
+	// Types defaults to every type the receptionist or doctor may export.
+	Types []string   `json:"types"`
+	Since *time.Time `json:"since"`
+	Until *time.Time `json:"until"`
+}
+
+// createExportHandler starts a bulk export, in the style of a FHIR Bulk Data kick-off
+// request. The export runs in the background; its status is at Content-Location.
+func (app *application) createExportHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateExportPayload
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	export := &data.Export{
+		Types: payload.Types,
+		Since: payload.Since,
+		Until: payload.Until,
+	}
+	location := "/api/v1/receptionists/exports/%d"
+	if receptionist := getRecUserFromContext(r); receptionist != nil {
+		export.ReceptionistID = &receptionist.ID
+	}
+	if doctor := getDocUserFromContext(r); doctor != nil {
+		export.DoctorID = &doctor.ID
+		location = "/api/v1/doctors/exports/%d"
+	}
+	if len(export.Types) == 0 {
+		export.Types = append([]string{}, export.PermittedTypes()...)
+	}
+
+	v := validator.New()
+	if data.ValidateExport(v, export); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Clear out old exports before adding to them.
+	app.deleteExpiredExports(r.Context())
+
+	if err := app.models.Exports.Insert(r.Context(), export); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	_, err := app.enqueue(r.Context(), jobRunExport, exportArgs{ExportID: export.ID}, jobOptions{
+		uniqueKey: fmt.Sprintf("export:%d", export.ID),
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := http.Header{"Content-Location": {fmt.Sprintf(location, export.ID)}}
+	if err := app.writeJSON(w, http.StatusAccepted, envelope{"data": export}, headers); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+type exportArgs struct {
+	ExportID int64 `json:"exportId"`
+}
+
+// runExport writes each type to a temporary NDJSON file, then moves it into export
+// storage. A failed export leaves no files behind. The failure is recorded on the
+// export, so only failing to record it is returned as an error to retry the job.
+func (app *application) runExport(ctx context.Context, args exportArgs) error {
+	export, err := app.models.Exports.GetById(ctx, 0, 0, args.ExportID)
+	if err != nil {
+		return err
+	}
+	// The export was finished by an earlier attempt that died before the job was
+	// marked complete.
+	if export.Status != data.ExportPending && export.Status != data.ExportRunning {
+		return nil
+	}
+	properties := map[string]string{"export_id": strconv.FormatInt(export.ID, 10)}
+
+	if err := app.models.Exports.Start(ctx, export); err != nil {
+		return err
+	}
+
+	var runErr error
+	if export.StudyID != nil {
+		runErr = app.exportResearchBundle(ctx, export)
+	} else {
+		runErr = app.exportTypes(ctx, export)
+	}
+	// Cut short by shutdown; the job runs again and starts the export over.
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if runErr != nil {
+		app.logger.PrintError(runErr, properties)
+		for _, name := range export.FileNames() {
+			if err := app.exportStore.Delete(ctx, data.ExportFileKey(export.ID, name)); err != nil {
+				app.logger.PrintError(err, properties)
+			}
+		}
+	}
+	if err := app.models.Exports.Finish(ctx, export, runErr, app.config.export.retention); err != nil {
+		return err
+	}
+
+	properties["status"] = export.Status
+	properties["records"] = strconv.FormatInt(export.ExportedRecords, 10)
+	app.logger.PrintInfo("export finished", properties)
+	return nil
+}
+
+func (app *application) exportTypes(ctx context.Context, export *data.Export) error {
+	for _, exportType := range export.Types {
+		export.CurrentType = exportType
+		if err := app.models.Exports.Progress(ctx, export); err != nil {
+			return err
+		}
+		file, err := app.exportType(ctx, export, exportType)
+		if err != nil {
+			return fmt.Errorf("exporting %s: %w", exportType, err)
+		}
+		export.Output = append(export.Output, *file)
+		export.ExportedTypes++
+	}
+	return nil
+}
+
+func (app *application) exportType(ctx context.Context, export *data.Export, exportType string) (*data.ExportFile, error) {
+	tmp, err := os.CreateTemp("", "export-*.ndjson")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	file := &data.ExportFile{Type: exportType, Name: exportType + ".ndjson", ContentType: "application/x-ndjson"}
+	buf := bufio.NewWriter(tmp)
+	err = app.models.Exports.Stream(ctx, export, exportType, func(record []byte) error {
+		if _, err := buf.Write(record); err != nil {
+			return err
+		}
+		if err := buf.WriteByte('\n'); err != nil {
+			return err
+		}
+		file.Count++
+		export.ExportedRecords++
+		if export.ExportedRecords%exportProgressInterval == 0 {
+			return app.models.Exports.Progress(ctx, export)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := buf.Flush(); err != nil {
+		return nil, err
+	}
+
+	info, err := tmp.Stat()
+	if err != nil {
+		return nil, err
+	}
+	file.Size = info.Size()
+	if _, err := tmp.Seek(0, 0); err != nil {
+		return nil, err
+	}
+
+	err = app.exportStore.Put(ctx, data.ExportFileKey(export.ID, file.Name), tmp, file.Size, file.ContentType)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// deleteExpiredExports removes the files of exports past their retention.
+func (app *application) deleteExpiredExports(ctx context.Context) {
+	expired, err := app.models.Exports.GetExpired(ctx)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+	for _, export := range expired {
+		if err := app.deleteExportFiles(ctx, export); err != nil {
+			app.logger.PrintError(err, map[string]string{"export_id": strconv.FormatInt(export.ID, 10)})
+			continue
+		}
+		if err := app.models.Exports.MarkExpired(ctx, export.ID); err != nil {
+			app.logger.PrintError(err, map[string]string{"export_id": strconv.FormatInt(export.ID, 10)})
+		}
+	}
+}
+
+func (app *application) deleteExportFiles(ctx context.Context, export *data.Export) error {
+	for _, file := range export.Output {
+		if err := app.exportStore.Delete(ctx, data.ExportFileKey(export.ID, file.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (app *application) getExportsHandler(w http.ResponseWriter, r *http.Request) {
+	var queryDto struct {
+		Status string
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	queryDto.Status = app.readString(qs, "status", "")
+	queryDto.Page = app.readInt(qs, "page", 1, v)
+	queryDto.PageSize = app.readInt(qs, "page_size", 20, v)
+	queryDto.Sort = app.readString(qs, "sort", "-created_at")
+	queryDto.SortSafelist = []string{"created_at", "id", "-created_at", "-id"}
+
+	v.Check(queryDto.Status == "" || validator.In(queryDto.Status, data.ExportStatuses...), "status",
+		"must be pending, running, completed, failed or expired")
+	if data.ValidateFilters(v, queryDto.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	receptionistID, doctorID := exportRequester(r)
+	exports, metadata, err := app.models.Exports.GetAll(r.Context(), receptionistID, doctorID, queryDto.Status, 0,
+		queryDto.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": exports, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getExportHandler reports the status of an export. While it runs the response is
+// 202 Accepted with the progress in X-Progress; once complete each output file has a
+// signed download link that expires after a while.
+func (app *application) getExportHandler(w http.ResponseWriter, r *http.Request) {
+	export := getExportFromCtx(r)
+
+	if !export.Done() {
+		progress := fmt.Sprintf("%d of %d types, %d records", export.ExportedTypes, len(export.Types),
+			export.ExportedRecords)
+		if export.CurrentType != "" {
+			progress = fmt.Sprintf("exporting %s, %s", export.CurrentType, progress)
+		}
+		headers := http.Header{"X-Progress": {progress}, "Retry-After": {"5"}}
+		if err := app.writeJSON(w, http.StatusAccepted, envelope{"data": export}, headers); err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if export.Status == data.ExportCompleted {
+		expires := time.Now().Add(app.config.export.linkTTL)
+		if export.ExpiresAt != nil && export.ExpiresAt.Before(expires) {
+			expires = *export.ExpiresAt
+		}
+		for i := range export.Output {
+			export.Output[i].URL = app.exportFileURL(export.ID, export.Output[i].Type, expires)
+		}
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": export}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteExportHandler(w http.ResponseWriter, r *http.Request) {
+	export := getExportFromCtx(r)
+
+	err := app.models.Exports.Delete(r.Context(), export.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrExportRunning):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if err := app.deleteExportFiles(r.Context(), export); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"message": "export deleted"}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// exportSignature signs a download link for one file of an export until expires.
+func (app *application) exportSignature(exportID int64, exportType string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(app.config.export.signingSecret))
+	fmt.Fprintf(mac, "%d:%s:%d", exportID, exportType, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (app *application) exportFileURL(exportID int64, exportType string, expires time.Time) string {
+	qs := url.Values{}
+	qs.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	qs.Set("signature", app.exportSignature(exportID, exportType, expires.Unix()))
+	return fmt.Sprintf("/api/v1/exports/%d/files/%s?%s", exportID, url.PathEscape(exportType), qs.Encode())
+}
+
+// downloadExportFileHandler serves an export file to anyone holding an unexpired
+// link from the export's status, so analysts' tools can fetch files without a token.
+func (app *application) downloadExportFileHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "exportId"), 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+	exportType := chi.URLParam(r, "type")
+
+	qs := r.URL.Query()
+	expires, err := strconv.ParseInt(qs.Get("expires"), 10, 64)
+	signature, sigErr := hex.DecodeString(qs.Get("signature"))
+	expected, _ := hex.DecodeString(app.exportSignature(id, exportType, expires))
+	if err != nil || sigErr != nil || !hmac.Equal(signature, expected) {
+		app.errorResponse(w, r, http.StatusForbidden, "invalid download link")
+		return
+	}
+	if time.Now().Unix() > expires {
+		app.errorResponse(w, r, http.StatusForbidden, "download link has expired")
+		return
+	}
+
+	ctx := r.Context()
+	export, err := app.models.Exports.GetById(ctx, 0, 0, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	file, ok := export.File(exportType)
+	if export.Status != data.ExportCompleted || !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	object, err := app.exportStore.Open(ctx, data.ExportFileKey(export.ID, file.Name))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer object.Close()
+
+	fileName := fmt.Sprintf("export-%d-%s", export.ID, file.Name)
+	w.Header().Set("Content-Type", file.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, no-store")
+	http.ServeContent(w, r, fileName, *export.FinishedAt, object)
+}
+
+func (app *application) exportContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "exportId"), 10, 64)
+		if err != nil || id < 1 {
+			app.notFoundResponse(w, r)
+			return
+		}
+		ctx := r.Context()
+
+		// Receptionists and doctors only see the exports they started.
+		receptionistID, doctorID := exportRequester(r)
+		export, err := app.models.Exports.GetById(ctx, receptionistID, doctorID, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, exportCtx, export)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// exportRequester returns the ID of the receptionist or doctor making the request,
+// leaving the other 0.
+func exportRequester(r *http.Request) (receptionistID, doctorID int64) {
+	if receptionist := getRecUserFromContext(r); receptionist != nil {
+		receptionistID = receptionist.ID
+	}
+	if doctor := getDocUserFromContext(r); doctor != nil {
+		doctorID = doctor.ID
+	}
+	return receptionistID, doctorID
+}
+
+func getExportFromCtx(r *http.Request) *data.Export {
+	export, _ := r.Context().Value(exportCtx).(*data.Export)
+	return export
+}
@@ -0,0 +1,796 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/muyiwadosunmu/hospital-management/internal/data"
+	"github.com/muyiwadosunmu/hospital-management/internal/fhir"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+// Identifier systems for our own record IDs. Patients and practitioners can be
+// searched by these or by their FHIR id.
+const (
+	fhirPatientIDSystem      = "urn:hospital-management:patient-id"
+	fhirPractitionerIDSystem = "urn:hospital-management:doctor-id"
+)
+
+func (app *application) writeFHIR(w http.ResponseWriter, status int, resource any, headers http.Header) error {
+	js, err := json.MarshalIndent(resource, "", "\t")
+	if err != nil {
+		return err
+	}
+	js = append(js, '\n')
+
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+	w.Header().Set("Content-Type", fhir.ContentType)
+	w.WriteHeader(status)
+	w.Write(js)
+	return nil
+}
+
+// fhirErrorResponse is errorResponse for the FHIR routes, which send OperationOutcome
+// bodies instead of our error envelope.
+func (app *application) fhirErrorResponse(w http.ResponseWriter, r *http.Request, status int, outcome *fhir.OperationOutcome) {
+	if err := app.writeFHIR(w, status, outcome, nil); err != nil {
+		app.logError(r, err)
+		w.WriteHeader(500)
+	}
+}
+
+func (app *application) fhirError(w http.ResponseWriter, r *http.Request, status int, code, diagnostics string) {
+	app.fhirErrorResponse(w, r, status, fhir.NewOperationOutcome(fhir.SeverityError, code, diagnostics))
+}
+
+func (app *application) fhirServerError(w http.ResponseWriter, r *http.Request, err error) {
+	app.logError(r, err)
+	app.fhirError(w, r, http.StatusInternalServerError, fhir.IssueException,
+		"the server encountered a problem and could not process your request")
+}
+
+func (app *application) fhirNotFound(w http.ResponseWriter, r *http.Request) {
+	app.fhirError(w, r, http.StatusNotFound, fhir.IssueNotFound, "the requested resource could not be found")
+}
+
+func (app *application) fhirMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	app.fhirError(w, r, http.StatusMethodNotAllowed, fhir.IssueNotSupported,
+		fmt.Sprintf("the %s method is not supported for this resource", r.Method))
+}
+
+// fhirValidationResponse turns validator errors into an OperationOutcome with an issue
+// per element.
+func (app *application) fhirValidationResponse(w http.ResponseWriter, r *http.Request, status int, errors map[string]string) {
+	keys := make([]string, 0, len(errors))
+	for key := range errors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	outcome := &fhir.OperationOutcome{ResourceType: "OperationOutcome"}
+	for _, key := range keys {
+		outcome.AddIssue(fhir.IssueInvalid, key, errors[key])
+	}
+	app.fhirErrorResponse(w, r, status, outcome)
+}
+
+// fhirAuthMiddleware is AuthRecTokenMiddleware with OperationOutcome errors.
+func (app *application) fhirAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, err := app.bearerUserID(r)
+		if err != nil {
+			app.fhirError(w, r, http.StatusUnauthorized, fhir.IssueSecurity, err.Error())
+			return
+		}
+		ctx := r.Context()
+
+		user, err := app.getRecUser(ctx, userID)
+		if err != nil {
+			app.fhirError(w, r, http.StatusUnauthorized, fhir.IssueSecurity, err.Error())
+			return
+		}
+
+		ctx = context.WithValue(ctx, userCtx, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// readResource decodes a FHIR resource. Unlike readJSON, unknown elements are allowed,
+// since resources from other systems carry plenty we don't store.
+func (app *application) readResource(w http.ResponseWriter, r *http.Request, resourceType string, dst any) error {
+	const maxBytes = 1_048_576
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return fmt.Errorf("body must not be larger than %d bytes", maxBytes)
+	}
+
+	var header struct {
+		ResourceType string `json:"resourceType"`
+	}
+	if err := json.Unmarshal(body, &header); err != nil {
+		return errors.New("body must be a JSON " + resourceType + " resource")
+	}
+	if header.ResourceType != resourceType {
+		return fmt.Errorf("resourceType must be %s", resourceType)
+	}
+
+	if err := json.Unmarshal(body, dst); err != nil {
+		var unmarshalTypeError *json.UnmarshalTypeError
+		if errors.As(err, &unmarshalTypeError) && unmarshalTypeError.Field != "" {
+			return fmt.Errorf("body contains incorrect JSON type for element %q", unmarshalTypeError.Field)
+		}
+		return err
+	}
+	return nil
+}
+
+// randomPassword is given to accounts created over FHIR, which have nobody to choose
+// one.
+func randomPassword() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (app *application) fhirURL(parts ...string) string {
+	return app.config.fhir.baseURL + "/" + strings.Join(parts, "/")
+}
+
+// fhirResourceID reads the {id} URL parameter.
+func fhirResourceID(r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	return id, err == nil && id > 0
+}
+
+// readFHIRPaging reads _count and _page into Filters sorted by id.
+func (app *application) readFHIRPaging(qs url.Values, v *validator.Validator) data.Filters {
+	filters := data.Filters{
+		Page:         app.readInt(qs, "_page", 1, v),
+		PageSize:     app.readInt(qs, "_count", 20, v),
+		Sort:         "id",
+		SortSafelist: []string{"id"},
+	}
+	data.ValidateFilters(v, filters)
+	if msg, ok := v.Errors["page_size"]; ok {
+		delete(v.Errors, "page_size")
+		v.AddError("_count", msg)
+	}
+	if msg, ok := v.Errors["page"]; ok {
+		delete(v.Errors, "page")
+		v.AddError("_page", msg)
+	}
+	return filters
+}
+
+// searchBundle starts a searchset with self, first, previous, next and last links that
+// repeat the request's search parameters.
+func (app *application) searchBundle(r *http.Request, resourceType string, filters data.Filters, metadata data.Metadata) *fhir.Bundle {
+	bundle := fhir.NewSearchset(metadata.TotalRecords)
+
+	link := func(relation string, page int) {
+		qs := r.URL.Query()
+		qs.Set("_page", strconv.Itoa(page))
+		qs.Set("_count", strconv.Itoa(filters.PageSize))
+		bundle.Link = append(bundle.Link, fhir.BundleLink{
+			Relation: relation,
+			URL:      app.fhirURL(resourceType) + "?" + qs.Encode(),
+		})
+	}
+
+	link("self", filters.Page)
+	lastPage := max(metadata.LastPage, 1)
+	link("first", 1)
+	if filters.Page > 1 {
+		link("previous", min(filters.Page-1, lastPage))
+	}
+	if filters.Page < lastPage {
+		link("next", filters.Page+1)
+	}
+	link("last", lastPage)
+	return bundle
+}
+
+// fhirIdentifierSearch reads the identifier parameter. It returns false when the
+// identifier is in a system the resource has no identifiers in, so nothing can match.
+func fhirIdentifierSearch(qs url.Values, systems ...string) (system, value string, ok bool) {
+	raw := qs.Get("identifier")
+	if raw == "" {
+		return "", "", true
+	}
+	system, value, hasSystem := fhir.SplitToken(raw)
+	if !hasSystem || system == "" {
+		return "", value, true
+	}
+	return system, value, validator.In(system, systems...)
+}
+
+// versionMatches checks an If-Match header against the current version. A missing
+// header always matches.
+func versionMatches(r *http.Request, version int64) (bool, error) {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		return true, nil
+	}
+	tag, err := fhir.ParseETag(ifMatch)
+	if err != nil {
+		return false, err
+	}
+	return tag == strconv.FormatInt(version, 10), nil
+}
+
+func (app *application) fhirMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	statement := fhir.NewCapabilityStatement(app.config.hospitalName, app.config.fhir.baseURL,
+		fhir.CRUDResource("Patient",
+			fhir.SearchParam{Name: "identifier", Type: "token"},
+			fhir.SearchParam{Name: "name", Type: "string"},
+			fhir.SearchParam{Name: "family", Type: "string"},
+			fhir.SearchParam{Name: "given", Type: "string"},
+			fhir.SearchParam{Name: "email", Type: "token"},
+			fhir.SearchParam{Name: "birthdate", Type: "date"},
+		),
+		fhir.CRUDResource("Practitioner",
+			fhir.SearchParam{Name: "identifier", Type: "token",
+				Documentation: "Our doctor ID or a license number in " + app.config.fhir.licenseSystem},
+			fhir.SearchParam{Name: "name", Type: "string"},
+			fhir.SearchParam{Name: "family", Type: "string"},
+			fhir.SearchParam{Name: "given", Type: "string"},
+		),
+	)
+
+	if err := app.writeFHIR(w, http.StatusOK, statement, nil); err != nil {
+		app.fhirServerError(w, r, err)
+	}
+}
+
+func (app *application) fhirPatient(p *data.Patient) *fhir.Patient {
+	id := strconv.FormatInt(p.ID, 10)
+	active := true
+	resource := &fhir.Patient{
+		ResourceType: "Patient",
+		ID:           id,
+		Meta:         &fhir.Meta{VersionID: strconv.FormatInt(p.Version, 10), LastUpdated: &p.UpdatedAt},
+		Identifier:   []fhir.Identifier{{Use: "usual", System: fhirPatientIDSystem, Value: id}},
+		Active:       &active,
+		Name:         []fhir.HumanName{{Use: "official", Family: p.LastName, Given: []string{p.FirstName}}},
+		Telecom:      []fhir.ContactPoint{{System: "email", Value: p.Email}},
+	}
+	if p.DateOfBirth != nil {
+		resource.BirthDate = p.DateOfBirth.String()
+	}
+	return resource
+}
+
+// applyFHIRPatient copies the elements we store from a Patient resource. The email
+// address is only taken on create, as patients sign in with it.
+func applyFHIRPatient(v *validator.Validator, p *data.Patient, resource *fhir.Patient, create bool) {
+	name, ok := fhir.OfficialName(resource.Name)
+	v.Check(ok, "Patient.name", "must be provided")
+	if ok {
+		p.LastName = strings.TrimSpace(name.Family)
+		p.FirstName = ""
+		if len(name.Given) > 0 {
+			p.FirstName = strings.TrimSpace(name.Given[0])
+		}
+		v.Check(p.LastName != "", "Patient.name.family", "must be provided")
+		v.Check(p.FirstName != "", "Patient.name.given", "must be provided")
+		v.Check(len(p.LastName) <= 100, "Patient.name.family", "must not be more than 100 bytes long")
+		v.Check(len(p.FirstName) <= 100, "Patient.name.given", "must not be more than 100 bytes long")
+	}
+
+	if create {
+		p.Email = strings.ToLower(fhir.Telecom(resource.Telecom, "email"))
+		v.Check(p.Email != "", "Patient.telecom", "must include an email address")
+		v.Check(p.Email == "" || validator.Matches(p.Email, validator.EmailRX), "Patient.telecom",
+			"must include a valid email address")
+	}
+
+	p.DateOfBirth = nil
+	if resource.BirthDate != "" {
+		dob, err := data.ParseDate(resource.BirthDate)
+		if err != nil {
+			v.AddError("Patient.birthDate", "must be a date in the form YYYY-MM-DD")
+			return
+		}
+		p.DateOfBirth = &dob
+		dv := validator.New()
+		if data.ValidateDateOfBirth(dv, p.DateOfBirth); !dv.Valid() {
+			v.AddError("Patient.birthDate", dv.Errors["dateOfBirth"])
+		}
+	}
+}
+
+func (app *application) fhirReadPatientHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := fhirResourceID(r)
+	if !ok {
+		app.fhirNotFound(w, r)
+		return
+	}
+
+	patient, err := app.models.Patients.GetPatientById(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.fhirNotFound(w, r)
+		default:
+			app.fhirServerError(w, r, err)
+		}
+		return
+	}
+
+	headers := http.Header{"ETag": {fhir.ETag(patient.Version)}}
+	if err := app.writeFHIR(w, http.StatusOK, app.fhirPatient(patient), headers); err != nil {
+		app.fhirServerError(w, r, err)
+	}
+}
+
+func (app *application) fhirSearchPatientsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	search := data.PatientSearch{
+		Name:   strings.TrimSpace(qs.Get("name")),
+		Family: strings.TrimSpace(qs.Get("family")),
+		Given:  strings.TrimSpace(qs.Get("given")),
+	}
+	if _, email, _ := fhir.SplitToken(qs.Get("email")); email != "" {
+		search.Email = email
+	}
+	filters := app.readFHIRPaging(qs, v)
+
+	// _id and our own identifier both name the record ID. A value that can't be an ID
+	// matches nothing.
+	matchable := true
+	ids := []string{}
+	if raw := qs.Get("_id"); raw != "" {
+		ids = append(ids, raw)
+	}
+	_, value, ok := fhirIdentifierSearch(qs, fhirPatientIDSystem)
+	matchable = matchable && ok
+	if value != "" {
+		ids = append(ids, value)
+	}
+	for _, raw := range ids {
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || id < 1 || (search.ID != 0 && search.ID != id) {
+			matchable = false
+			break
+		}
+		search.ID = id
+	}
+
+	if raw := qs.Get("birthdate"); raw != "" {
+		param, err := fhir.ParseDateParam(raw)
+		if err != nil {
+			v.AddError("birthdate", err.Error())
+		} else {
+			search.BirthFrom, search.BirthTo = param.Bounds()
+		}
+	}
+
+	if !v.Valid() {
+		app.fhirValidationResponse(w, r, http.StatusBadRequest, v.Errors)
+		return
+	}
+
+	patients := []*data.Patient{}
+	metadata := data.Metadata{}
+	if matchable {
+		var err error
+		patients, metadata, err = app.models.Patients.Search(r.Context(), search, filters)
+		if err != nil {
+			app.fhirServerError(w, r, err)
+			return
+		}
+	}
+
+	bundle := app.searchBundle(r, "Patient", filters, metadata)
+	for _, p := range patients {
+		resource := app.fhirPatient(p)
+		bundle.AddMatch(app.fhirURL("Patient", resource.ID), resource)
+	}
+
+	if err := app.writeFHIR(w, http.StatusOK, bundle, nil); err != nil {
+		app.fhirServerError(w, r, err)
+	}
+}
+
+func (app *application) fhirCreatePatientHandler(w http.ResponseWriter, r *http.Request) {
+	var resource fhir.Patient
+	ctx := r.Context()
+	receptionist := getRecUserFromContext(r)
+
+	if err := app.readResource(w, r, "Patient", &resource); err != nil {
+		app.fhirError(w, r, http.StatusBadRequest, fhir.IssueInvalid, err.Error())
+		return
+	}
+
+	patient := &data.Patient{AddedBy: receptionist}
+	v := validator.New()
+	if applyFHIRPatient(v, patient, &resource, true); !v.Valid() {
+		app.fhirValidationResponse(w, r, http.StatusUnprocessableEntity, v.Errors)
+		return
+	}
+
+	password, err := randomPassword()
+	if err != nil {
+		app.fhirServerError(w, r, err)
+		return
+	}
+	if err := patient.Password.Set(password); err != nil {
+		app.fhirServerError(w, r, err)
+		return
+	}
+
+	err = app.models.Patients.CreatePatient(ctx, patient)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			app.fhirError(w, r, http.StatusConflict, fhir.IssueDuplicate, "a patient with this email address already exists")
+		default:
+			app.fhirServerError(w, r, err)
+		}
+		return
+	}
+
+	created := app.fhirPatient(patient)
+	headers := http.Header{
+		"Location": {app.fhirURL("Patient", created.ID, "_history", created.Meta.VersionID)},
+		"ETag":     {fhir.ETag(patient.Version)},
+	}
+	if err := app.writeFHIR(w, http.StatusCreated, created, headers); err != nil {
+		app.fhirServerError(w, r, err)
+	}
+}
+
+func (app *application) fhirUpdatePatientHandler(w http.ResponseWriter, r *http.Request) {
+	var resource fhir.Patient
+	ctx := r.Context()
+
+	id, ok := fhirResourceID(r)
+	if !ok {
+		app.fhirNotFound(w, r)
+		return
+	}
+
+	if err := app.readResource(w, r, "Patient", &resource); err != nil {
+		app.fhirError(w, r, http.StatusBadRequest, fhir.IssueInvalid, err.Error())
+		return
+	}
+	if resource.ID != chi.URLParam(r, "id") {
+		app.fhirError(w, r, http.StatusBadRequest, fhir.IssueInvalid, "Patient.id must match the id in the URL")
+		return
+	}
+
+	patient, err := app.models.Patients.GetPatientById(ctx, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.fhirNotFound(w, r)
+		default:
+			app.fhirServerError(w, r, err)
+		}
+		return
+	}
+
+	if matches, err := versionMatches(r, patient.Version); err != nil {
+		app.fhirError(w, r, http.StatusBadRequest, fhir.IssueInvalid, err.Error())
+		return
+	} else if !matches {
+		app.fhirError(w, r, http.StatusPreconditionFailed, fhir.IssueConflict,
+			"the resource has changed since version "+r.Header.Get("If-Match"))
+		return
+	}
+
+	v := validator.New()
+	if applyFHIRPatient(v, patient, &resource, false); !v.Valid() {
+		app.fhirValidationResponse(w, r, http.StatusUnprocessableEntity, v.Errors)
+		return
+	}
+
+	err = app.models.Patients.UpdatePatient(ctx, patient)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound), errors.Is(err, data.ErrEditConflict):
+			app.fhirError(w, r, http.StatusConflict, fhir.IssueConflict,
+				"unable to update the resource due to an edit conflict, please try again")
+		default:
+			app.fhirServerError(w, r, err)
+		}
+		return
+	}
+
+	headers := http.Header{"ETag": {fhir.ETag(patient.Version)}}
+	if err := app.writeFHIR(w, http.StatusOK, app.fhirPatient(patient), headers); err != nil {
+		app.fhirServerError(w, r, err)
+	}
+}
+
+func (app *application) fhirPractitioner(d *data.Doctor) *fhir.Practitioner {
+	id := strconv.FormatInt(d.ID, 10)
+	active := true
+	resource := &fhir.Practitioner{
+		ResourceType: "Practitioner",
+		ID:           id,
+		Meta:         &fhir.Meta{VersionID: strconv.FormatInt(d.Version, 10), LastUpdated: &d.UpdatedAt},
+		Identifier:   []fhir.Identifier{{Use: "usual", System: fhirPractitionerIDSystem, Value: id}},
+		Active:       &active,
+		Name:         []fhir.HumanName{{Use: "official", Family: d.LastName, Given: []string{d.FirstName}}},
+		Telecom:      []fhir.ContactPoint{{System: "email", Value: d.Email, Use: "work"}},
+	}
+	if d.Title != "" {
+		resource.Name[0].Text = d.Title + " " + d.FirstName + " " + d.LastName
+	}
+	if d.Phone != "" {
+		resource.Telecom = append(resource.Telecom, fhir.ContactPoint{System: "phone", Value: d.Phone, Use: "work"})
+	}
+	if d.LicenseNumber != "" {
+		license := fhir.Identifier{Use: "official", System: app.config.fhir.licenseSystem, Value: d.LicenseNumber}
+		resource.Identifier = append(resource.Identifier, license)
+		resource.Qualification = append(resource.Qualification, fhir.Qualification{
+			Identifier: []fhir.Identifier{license},
+			Code:       fhir.CodeableConcept{Text: "Medical license"},
+		})
+	}
+	for _, s := range d.Specialties {
+		resource.Qualification = append(resource.Qualification, fhir.Qualification{
+			Code: fhir.CodeableConcept{Text: s.Name},
+		})
+	}
+	return resource
+}
+
+// applyFHIRPractitioner copies the name, phone and license number from a
+// Practitioner resource. As with patients, the email address is only taken on create.
+func (app *application) applyFHIRPractitioner(v *validator.Validator, d *data.Doctor, resource *fhir.Practitioner, create bool) {
+	name, ok := fhir.OfficialName(resource.Name)
+	v.Check(ok, "Practitioner.name", "must be provided")
+	if ok {
+		d.LastName = strings.TrimSpace(name.Family)
+		d.FirstName = ""
+		if len(name.Given) > 0 {
+			d.FirstName = strings.TrimSpace(name.Given[0])
+		}
+		v.Check(d.LastName != "", "Practitioner.name.family", "must be provided")
+		v.Check(d.FirstName != "", "Practitioner.name.given", "must be provided")
+		v.Check(len(d.LastName) <= 100, "Practitioner.name.family", "must not be more than 100 bytes long")
+		v.Check(len(d.FirstName) <= 100, "Practitioner.name.given", "must not be more than 100 bytes long")
+	}
+
+	if create {
+		d.Email = strings.ToLower(fhir.Telecom(resource.Telecom, "email"))
+		v.Check(d.Email != "", "Practitioner.telecom", "must include an email address")
+		v.Check(d.Email == "" || validator.Matches(d.Email, validator.EmailRX), "Practitioner.telecom",
+			"must include a valid email address")
+	}
+
+	d.Phone = strings.TrimSpace(fhir.Telecom(resource.Telecom, "phone"))
+	d.LicenseNumber = strings.ToUpper(strings.TrimSpace(
+		fhir.IdentifierValue(resource.Identifier, app.config.fhir.licenseSystem)))
+
+	pv := validator.New()
+	data.ValidateDoctorProfile(pv, d, nil)
+	for key, message := range pv.Errors {
+		switch key {
+		case "phone":
+			v.AddError("Practitioner.telecom", "phone "+message)
+		case "licenseNumber":
+			v.AddError("Practitioner.identifier", "license number "+message)
+		}
+	}
+}
+
+func (app *application) fhirReadPractitionerHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := fhirResourceID(r)
+	if !ok {
+		app.fhirNotFound(w, r)
+		return
+	}
+
+	doctor, err := app.models.Doctors.GetById(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.fhirNotFound(w, r)
+		default:
+			app.fhirServerError(w, r, err)
+		}
+		return
+	}
+
+	headers := http.Header{"ETag": {fhir.ETag(doctor.Version)}}
+	if err := app.writeFHIR(w, http.StatusOK, app.fhirPractitioner(doctor), headers); err != nil {
+		app.fhirServerError(w, r, err)
+	}
+}
+
+func (app *application) fhirSearchPractitionersHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	name := strings.TrimSpace(qs.Get("name"))
+	family := strings.TrimSpace(qs.Get("family"))
+	given := strings.TrimSpace(qs.Get("given"))
+	filters := app.readFHIRPaging(qs, v)
+
+	if !v.Valid() {
+		app.fhirValidationResponse(w, r, http.StatusBadRequest, v.Errors)
+		return
+	}
+
+	// An identifier without a system is tried as a doctor ID when it is a number and
+	// as a license number otherwise.
+	var id int64
+	var license string
+	system, value, matchable := fhirIdentifierSearch(qs, fhirPractitionerIDSystem, app.config.fhir.licenseSystem)
+	if value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		switch {
+		case system == app.config.fhir.licenseSystem, system == "" && err != nil:
+			license = value
+		case err == nil && n > 0:
+			id = n
+		default:
+			matchable = false
+		}
+	}
+	if raw := qs.Get("_id"); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n < 1 || (id != 0 && id != n) {
+			matchable = false
+		}
+		id = n
+	}
+
+	doctors := []*data.Doctor{}
+	metadata := data.Metadata{}
+	if matchable {
+		var err error
+		doctors, metadata, err = app.models.Doctors.Search(r.Context(), id, license, name, family, given, filters)
+		if err != nil {
+			app.fhirServerError(w, r, err)
+			return
+		}
+	}
+
+	bundle := app.searchBundle(r, "Practitioner", filters, metadata)
+	for _, d := range doctors {
+		resource := app.fhirPractitioner(d)
+		bundle.AddMatch(app.fhirURL("Practitioner", resource.ID), resource)
+	}
+
+	if err := app.writeFHIR(w, http.StatusOK, bundle, nil); err != nil {
+		app.fhirServerError(w, r, err)
+	}
+}
+
+// fhirCreatePractitionerHandler adds a doctor. They are given a random password, so
+// the record serves as a directory entry until the doctor's account is set up.
+func (app *application) fhirCreatePractitionerHandler(w http.ResponseWriter, r *http.Request) {
+	var resource fhir.Practitioner
+
+	if err := app.readResource(w, r, "Practitioner", &resource); err != nil {
+		app.fhirError(w, r, http.StatusBadRequest, fhir.IssueInvalid, err.Error())
+		return
+	}
+
+	doctor := &data.Doctor{}
+	v := validator.New()
+	if app.applyFHIRPractitioner(v, doctor, &resource, true); !v.Valid() {
+		app.fhirValidationResponse(w, r, http.StatusUnprocessableEntity, v.Errors)
+		return
+	}
+
+	password, err := randomPassword()
+	if err != nil {
+		app.fhirServerError(w, r, err)
+		return
+	}
+	if err := doctor.Password.Set(password); err != nil {
+		app.fhirServerError(w, r, err)
+		return
+	}
+
+	err = app.models.Doctors.Create(r.Context(), doctor)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			app.fhirError(w, r, http.StatusConflict, fhir.IssueDuplicate, "a doctor with this email address already exists")
+		case errors.Is(err, data.ErrDuplicateLicense):
+			app.fhirError(w, r, http.StatusConflict, fhir.IssueDuplicate, err.Error())
+		default:
+			app.fhirServerError(w, r, err)
+		}
+		return
+	}
+
+	created := app.fhirPractitioner(doctor)
+	headers := http.Header{
+		"Location": {app.fhirURL("Practitioner", created.ID, "_history", created.Meta.VersionID)},
+		"ETag":     {fhir.ETag(doctor.Version)},
+	}
+	if err := app.writeFHIR(w, http.StatusCreated, created, headers); err != nil {
+		app.fhirServerError(w, r, err)
+	}
+}
+
+func (app *application) fhirUpdatePractitionerHandler(w http.ResponseWriter, r *http.Request) {
+	var resource fhir.Practitioner
+	ctx := r.Context()
+
+	id, ok := fhirResourceID(r)
+	if !ok {
+		app.fhirNotFound(w, r)
+		return
+	}
+
+	if err := app.readResource(w, r, "Practitioner", &resource); err != nil {
+		app.fhirError(w, r, http.StatusBadRequest, fhir.IssueInvalid, err.Error())
+		return
+	}
+	if resource.ID != chi.URLParam(r, "id") {
+		app.fhirError(w, r, http.StatusBadRequest, fhir.IssueInvalid, "Practitioner.id must match the id in the URL")
+		return
+	}
+
+	doctor, err := app.models.Doctors.GetById(ctx, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.fhirNotFound(w, r)
+		default:
+			app.fhirServerError(w, r, err)
+		}
+		return
+	}
+
+	if matches, err := versionMatches(r, doctor.Version); err != nil {
+		app.fhirError(w, r, http.StatusBadRequest, fhir.IssueInvalid, err.Error())
+		return
+	} else if !matches {
+		app.fhirError(w, r, http.StatusPreconditionFailed, fhir.IssueConflict,
+			"the resource has changed since version "+r.Header.Get("If-Match"))
+		return
+	}
+
+	v := validator.New()
+	if app.applyFHIRPractitioner(v, doctor, &resource, false); !v.Valid() {
+		app.fhirValidationResponse(w, r, http.StatusUnprocessableEntity, v.Errors)
+		return
+	}
+
+	err = app.models.Doctors.UpdateProfile(ctx, doctor, nil)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.fhirError(w, r, http.StatusConflict, fhir.IssueConflict,
+				"unable to update the resource due to an edit conflict, please try again")
+		case errors.Is(err, data.ErrDuplicateLicense):
+			app.fhirError(w, r, http.StatusConflict, fhir.IssueDuplicate, err.Error())
+		default:
+			app.fhirServerError(w, r, err)
+		}
+		return
+	}
+
+	headers := http.Header{"ETag": {fhir.ETag(doctor.Version)}}
+	if err := app.writeFHIR(w, http.StatusOK, app.fhirPractitioner(doctor), headers); err != nil {
+		app.fhirServerError(w, r, err)
+	}
+}
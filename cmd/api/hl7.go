@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/muyiwadosunmu/hospital-management/internal/data"
+	"github.com/muyiwadosunmu/hospital-management/internal/hl7"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+type hl7MessageKey string
+
+const hl7MessageCtx hl7MessageKey = "hl7Message"
+
+// ADT events we act on: admit, register and update patient information.
+var hl7ADTEvents = []string{"A01", "A04", "A08"}
+
+func (app *application) hl7Sender() hl7.Sender {
+	return hl7.Sender{Application: app.config.hl7.application, Facility: app.config.hl7.facility}
+}
+
+// handleMLLP logs a message received over MLLP, processes it and returns its
+// acknowledgement. A message the sender has already had accepted is acknowledged
+// again without being processed twice.
+func (app *application) handleMLLP(ctx context.Context, raw []byte) []byte {
+	entry := &data.HL7Message{Raw: string(raw), Status: data.HL7Received}
+
+	msg, err := hl7.Parse(raw)
+	if err != nil {
+		if _, err := app.models.HL7Messages.Receive(ctx, entry); err != nil {
+			app.logger.PrintError(err, nil)
+		}
+		return app.finishHL7(ctx, entry, nil, hl7.AckReject, err)
+	}
+
+	entry.SendingApplication = msg.SendingApplication()
+	entry.SendingFacility = msg.SendingFacility()
+	entry.ControlID = msg.ControlID()
+	entry.MessageType = hl7MessageType(msg)
+
+	duplicate, err := app.models.HL7Messages.Receive(ctx, entry)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"control_id": entry.ControlID})
+		return hl7.BuildAck(msg, app.hl7Sender(), hl7.AckError,
+			hl7.NewError(hl7.CodeInternalError, "the message could not be stored"), time.Now())
+	}
+	if duplicate && entry.Status == data.HL7Processed {
+		return hl7.BuildAck(msg, app.hl7Sender(), hl7.AckAccept, nil, time.Now())
+	}
+
+	return app.ingestHL7(ctx, entry, msg)
+}
+
+// replayHL7 processes a logged message again from its raw text.
+func (app *application) replayHL7(ctx context.Context, entry *data.HL7Message) []byte {
+	msg, err := hl7.Parse([]byte(entry.Raw))
+	if err != nil {
+		return app.finishHL7(ctx, entry, nil, hl7.AckReject, err)
+	}
+	return app.ingestHL7(ctx, entry, msg)
+}
+
+func (app *application) ingestHL7(ctx context.Context, entry *data.HL7Message, msg *hl7.Message) []byte {
+	entry.PatientID = nil
+	entry.Visit = nil
+
+	code, err := app.processADT(ctx, entry, msg)
+	var hl7Err *hl7.Error
+	if err != nil && !errors.As(err, &hl7Err) {
+		app.logger.PrintError(err, map[string]string{
+			"hl7_message_id": strconv.FormatInt(entry.ID, 10),
+			"control_id":     entry.ControlID,
+		})
+	}
+	return app.finishHL7(ctx, entry, msg, code, err)
+}
+
+// finishHL7 records the outcome of a message in the log and builds its
+// acknowledgement. msg is nil when the message couldn't be parsed.
+func (app *application) finishHL7(ctx context.Context, entry *data.HL7Message, msg *hl7.Message, code string,
+	err error) []byte {
+	entry.AckCode = code
+	entry.Error = ""
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	switch code {
+	case hl7.AckAccept:
+		entry.Status = data.HL7Processed
+	case hl7.AckReject:
+		entry.Status = data.HL7Rejected
+	default:
+		entry.Status = data.HL7Failed
+	}
+
+	if entry.ID > 0 {
+		if err := app.models.HL7Messages.Finish(ctx, entry); err != nil {
+			app.logger.PrintError(err, map[string]string{"hl7_message_id": strconv.FormatInt(entry.ID, 10)})
+		}
+	}
+	return hl7.BuildAck(msg, app.hl7Sender(), code, err, time.Now())
+}
+
+// processADT maps the PID and PV1 segments of an ADT^A01, A04 or A08 onto the
+// patient record, returning the acknowledgement code to send back.
+func (app *application) processADT(ctx context.Context, entry *data.HL7Message, msg *hl7.Message) (string, error) {
+	code, event := msg.Type()
+	if code != "ADT" {
+		return hl7.AckReject, hl7.NewError(hl7.CodeUnsupportedMessage, "unsupported message type "+code)
+	}
+	if !validator.In(event, hl7ADTEvents...) {
+		return hl7.AckReject, hl7.NewError(hl7.CodeUnsupportedEvent, "unsupported ADT event "+event)
+	}
+
+	pid, ok := msg.Segment("PID")
+	if !ok {
+		return hl7.AckError, hl7.NewError(hl7.CodeRequiredFieldMissing, "PID segment is missing")
+	}
+	person, err := hl7.ParsePID(pid)
+	if err != nil {
+		return hl7.AckError, err
+	}
+
+	if pv1, ok := msg.Segment("PV1"); ok {
+		visit, err := json.Marshal(hl7.ParsePV1(pv1))
+		if err != nil {
+			return hl7.AckError, err
+		}
+		entry.Visit = visit
+	}
+
+	patient, external, err := app.findHL7Patient(ctx, msg, person)
+	if err != nil {
+		return hl7.AckError, err
+	}
+
+	var dob *data.Date
+	if person.BirthDate != nil {
+		dob = &data.Date{Time: *person.BirthDate}
+		v := validator.New()
+		if data.ValidateDateOfBirth(v, dob); !v.Valid() {
+			return hl7.AckError, hl7.NewError(hl7.CodeDataTypeError, "PID-7 "+v.Errors["dateOfBirth"])
+		}
+	}
+
+	if patient != nil {
+		if person.Family != "" {
+			patient.LastName = person.Family
+		}
+		if person.Given != "" {
+			patient.FirstName = person.Given
+		}
+		if dob != nil {
+			patient.DateOfBirth = dob
+		}
+		if err := app.models.Patients.UpdateWithIdentifiers(ctx, patient, external); err != nil {
+			return hl7.AckError, err
+		}
+		entry.PatientID = &patient.ID
+		return hl7.AckAccept, nil
+	}
+
+	if event == "A08" {
+		return hl7.AckError, hl7.NewError(hl7.CodeUnknownKey, "no patient matches the identifiers in PID-3")
+	}
+
+	switch {
+	case person.Family == "" || person.Given == "":
+		return hl7.AckError, hl7.NewError(hl7.CodeRequiredFieldMissing,
+			"PID-5 must have a family and given name to register a patient")
+	case person.Email == "":
+		return hl7.AckError, hl7.NewError(hl7.CodeRequiredFieldMissing,
+			"PID-13 must have an email address to register a patient")
+	}
+	if !validator.Matches(person.Email, validator.EmailRX) {
+		return hl7.AckError, hl7.NewError(hl7.CodeDataTypeError, "PID-13 is not a valid email address")
+	}
+
+	receptionist, err := app.getRecUser(ctx, app.config.hl7.receptionistID)
+	if err != nil {
+		return hl7.AckError, err
+	}
+	password, err := randomPassword()
+	if err != nil {
+		return hl7.AckError, err
+	}
+
+	patient = &data.Patient{
+		FirstName:   person.Given,
+		LastName:    person.Family,
+		Email:       strings.ToLower(person.Email),
+		DateOfBirth: dob,
+		AddedBy:     receptionist,
+	}
+	if err := patient.Password.Set(password); err != nil {
+		return hl7.AckError, err
+	}
+
+	err = app.models.Patients.CreateWithIdentifiers(ctx, patient, external)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			return hl7.AckError, hl7.NewError(hl7.CodeDuplicateKey,
+				"a patient with this email address already exists")
+		default:
+			return hl7.AckError, err
+		}
+	}
+	entry.PatientID = &patient.ID
+	return hl7.AckAccept, nil
+}
+
+// findHL7Patient looks up the patient named by PID-3. Identifiers assigned by us are
+// our patient IDs; any others are looked up as identifiers of the system that
+// assigned them, or of the sending application when no authority is given. The
+// external identifiers are returned so they can be recorded against the patient.
+func (app *application) findHL7Patient(ctx context.Context, msg *hl7.Message,
+	person *hl7.Person) (*data.Patient, []data.PatientIdentifier, error) {
+	if len(person.Identifiers) == 0 {
+		return nil, nil, hl7.NewError(hl7.CodeRequiredFieldMissing, "PID-3 has no patient identifiers")
+	}
+
+	var patient *data.Patient
+	external := []data.PatientIdentifier{}
+	for _, id := range person.Identifiers {
+		if id.Authority == app.config.hl7.assigningAuthority {
+			patientID, err := strconv.ParseInt(id.Value, 10, 64)
+			if err != nil {
+				return nil, nil, hl7.NewError(hl7.CodeUnknownKey, "no patient with ID "+id.Value)
+			}
+			found, err := app.models.Patients.GetPatientById(ctx, patientID)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					return nil, nil, hl7.NewError(hl7.CodeUnknownKey, "no patient with ID "+id.Value)
+				default:
+					return nil, nil, err
+				}
+			}
+			patient = found
+			continue
+		}
+
+		system := id.Authority
+		if system == "" {
+			system = msg.SendingApplication()
+		}
+		external = append(external, data.PatientIdentifier{System: system, Value: id.Value})
+		if patient != nil {
+			continue
+		}
+		found, err := app.models.Patients.GetByIdentifier(ctx, system, id.Value)
+		switch {
+		case err == nil:
+			patient = found
+		case !errors.Is(err, data.ErrRecordNotFound):
+			return nil, nil, err
+		}
+	}
+	return patient, external, nil
+}
+
+func hl7MessageType(msg *hl7.Message) string {
+	code, event := msg.Type()
+	if event == "" {
+		return code
+	}
+	return code + "^" + event
+}
+
+func (app *application) getHL7MessagesHandler(w http.ResponseWriter, r *http.Request) {
+	var queryDto struct {
+		Status      string
+		MessageType string
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	queryDto.Status = app.readString(qs, "status", "")
+	queryDto.MessageType = app.readString(qs, "message_type", "")
+	queryDto.Page = app.readInt(qs, "page", 1, v)
+	queryDto.PageSize = app.readInt(qs, "page_size", 20, v)
+	queryDto.Sort = app.readString(qs, "sort", "-received_at")
+	queryDto.SortSafelist = []string{"received_at", "id", "-received_at", "-id"}
+
+	v.Check(queryDto.Status == "" || validator.In(queryDto.Status, data.HL7Statuses...), "status",
+		"must be received, processed, failed or rejected")
+	if data.ValidateFilters(v, queryDto.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	messages, metadata, err := app.models.HL7Messages.GetAll(r.Context(), queryDto.Status, queryDto.MessageType,
+		queryDto.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": messages, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getHL7MessageHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"data": getHL7MessageFromCtx(r)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// replayHL7MessageHandler processes a failed or rejected message again, once
+// whatever was wrong with it (such as a missing receptionist or a clashing email)
+// has been put right. The acknowledgement is returned but not sent to the sender.
+func (app *application) replayHL7MessageHandler(w http.ResponseWriter, r *http.Request) {
+	entry := getHL7MessageFromCtx(r)
+	if !entry.Replayable() {
+		app.errorResponse(w, r, http.StatusConflict, data.ErrHL7NotReplayable.Error())
+		return
+	}
+
+	ack := app.replayHL7(r.Context(), entry)
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"data": entry, "ack": string(ack)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) hl7MessageContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "messageId"), 10, 64)
+		if err != nil || id < 1 {
+			app.notFoundResponse(w, r)
+			return
+		}
+		ctx := r.Context()
+
+		entry, err := app.models.HL7Messages.GetById(ctx, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, hl7MessageCtx, entry)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getHL7MessageFromCtx(r *http.Request) *data.HL7Message {
+	entry, _ := r.Context().Value(hl7MessageCtx).(*data.HL7Message)
+	return entry
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/muyiwadosunmu/hospital-management/internal/data"
+	"github.com/muyiwadosunmu/hospital-management/internal/immunization"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+type RecordImmunizationPayload struct {
+	VaccineCode    string     `json:"vaccineCode" validate:"required,max=30"`
+	VaccineName    string     `json:"vaccineName" validate:"max=255"`
+	DoseNumber     int        `json:"doseNumber" validate:"required,gt=0"`
+	LotNumber      string     `json:"lotNumber" validate:"max=50"`
+	Site           string     `json:"site" validate:"required"`
+	AdministeredBy string     `json:"administeredBy" validate:"max=255"`
+	AdministeredOn *data.Date `json:"administeredOn"`
+	Notes          string     `json:"notes" validate:"max=2000"`
+}
+
+func (app *application) getImmunizationsHandler(w http.ResponseWriter, r *http.Request) {
+	patient := getPatientFromCtx(r)
+
+	immunizations, err := app.models.Immunizations.GetForPatient(r.Context(), patient.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": immunizations}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// recordImmunizationHandler records a dose, either given here or copied from the
+// patient's record elsewhere. Vaccines on the schedule take their name from it.
+func (app *application) recordImmunizationHandler(w http.ResponseWriter, r *http.Request) {
+	var payload RecordImmunizationPayload
+	patient := getPatientFromCtx(r)
+	doctor := getDocUserFromContext(r)
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	record := &data.Immunization{
+		PatientID:      patient.ID,
+		VaccineCode:    strings.ToUpper(strings.TrimSpace(payload.VaccineCode)),
+		VaccineName:    strings.TrimSpace(payload.VaccineName),
+		DoseNumber:     payload.DoseNumber,
+		LotNumber:      strings.TrimSpace(payload.LotNumber),
+		Site:           payload.Site,
+		AdministeredBy: strings.TrimSpace(payload.AdministeredBy),
+		AdministeredOn: data.NewDate(time.Now()),
+		Notes:          strings.TrimSpace(payload.Notes),
+		RecordedBy:     doctor.ID,
+	}
+	if payload.AdministeredOn != nil {
+		record.AdministeredOn = *payload.AdministeredOn
+	}
+	if record.AdministeredBy == "" {
+		record.AdministeredBy = fmt.Sprintf("Dr %s %s", doctor.FirstName, doctor.LastName)
+	}
+
+	v := validator.New()
+	if vaccine, ok := app.immunizationSchedule.Vaccine(record.VaccineCode); ok {
+		record.VaccineName = vaccine.Name
+		v.Check(record.DoseNumber <= len(vaccine.Doses), "doseNumber",
+			fmt.Sprintf("%s has %d doses on the schedule", vaccine.Code, len(vaccine.Doses)))
+	}
+	if data.ValidateImmunization(v, record, patient.DateOfBirth); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Immunizations.Insert(r.Context(), record)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateImmunization):
+			v.AddError("doseNumber", err.Error())
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusCreated, envelope{"data": record}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getDueImmunizationsHandler lists the overdue and due doses on the schedule, and the
+// doses coming up in the next within_days days (90 by default).
+func (app *application) getDueImmunizationsHandler(w http.ResponseWriter, r *http.Request) {
+	patient := getPatientFromCtx(r)
+
+	v := validator.New()
+	withinDays := app.readInt(r.URL.Query(), "within_days", 90, v)
+	v.Check(validator.Between(withinDays, 0, 3650), "within_days", "must be between 0 and 3650")
+	v.Check(patient.DateOfBirth != nil, "dateOfBirth", "patient has no date of birth recorded")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	history, err := app.models.Immunizations.GetForPatient(r.Context(), patient.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	given := make([]immunization.Given, len(history))
+	for i, h := range history {
+		given[i] = immunization.Given{VaccineCode: h.VaccineCode, DoseNumber: h.DoseNumber, On: h.AdministeredOn.Time}
+	}
+	due := app.immunizationSchedule.Due(patient.DateOfBirth.Time, given, time.Now(),
+		time.Duration(withinDays)*24*time.Hour)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": due, "schedule": app.immunizationSchedule.Name}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getImmunizationScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": app.immunizationSchedule}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,846 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/muyiwadosunmu/hospital-management/internal/data"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+	"github.com/muyiwadosunmu/hospital-management/internal/x12"
+)
+
+type policyKey string
+
+const policyCtx policyKey = "policy"
+
+type claimBatchKey string
+
+const claimBatchCtx claimBatchKey = "claimBatch"
+
+// maxRemittanceBytes caps the size of an uploaded 835 file.
+const maxRemittanceBytes = 10 << 20
+
+// policySequences maps policy priorities to the X12 payer responsibility codes.
+var policySequences = map[int]string{1: "P", 2: "S", 3: "T"}
+
+type InsurancePolicyPayload struct {
+	PayerName           *string    `json:"payerName" validate:"omitempty,max=255"`
+	PayerID             *string    `json:"payerId" validate:"omitempty,max=80"`
+	MemberID            *string    `json:"memberId" validate:"omitempty,max=80"`
+	GroupNumber         *string    `json:"groupNumber" validate:"omitempty,max=50"`
+	PlanName            *string    `json:"planName" validate:"omitempty,max=255"`
+	Priority            *int       `json:"priority"`
+	Relationship        *string    `json:"relationship"`
+	SubscriberFirstName *string    `json:"subscriberFirstName" validate:"omitempty,max=100"`
+	SubscriberLastName  *string    `json:"subscriberLastName" validate:"omitempty,max=100"`
+	SubscriberDOB       *data.Date `json:"subscriberDateOfBirth"`
+	SubscriberGender    *string    `json:"subscriberGender"`
+	Address             *string    `json:"address" validate:"omitempty,max=255"`
+	City                *string    `json:"city" validate:"omitempty,max=100"`
+	State               *string    `json:"state" validate:"omitempty,max=50"`
+	PostalCode          *string    `json:"postalCode" validate:"omitempty,max=20"`
+	ValidFrom           *data.Date `json:"validFrom"`
+	ValidTo             *data.Date `json:"validTo"`
+}
+
+// apply copies the fields that were sent onto the policy.
+func (p InsurancePolicyPayload) apply(policy *data.InsurancePolicy) {
+	trimmed := func(dst *string, src *string) {
+		if src != nil {
+			*dst = strings.TrimSpace(*src)
+		}
+	}
+	trimmed(&policy.PayerName, p.PayerName)
+	trimmed(&policy.PayerID, p.PayerID)
+	trimmed(&policy.MemberID, p.MemberID)
+	trimmed(&policy.GroupNumber, p.GroupNumber)
+	trimmed(&policy.PlanName, p.PlanName)
+	trimmed(&policy.Relationship, p.Relationship)
+	trimmed(&policy.SubscriberFirstName, p.SubscriberFirstName)
+	trimmed(&policy.SubscriberLastName, p.SubscriberLastName)
+	trimmed(&policy.Address, p.Address)
+	trimmed(&policy.City, p.City)
+	trimmed(&policy.State, p.State)
+	trimmed(&policy.PostalCode, p.PostalCode)
+	if p.SubscriberGender != nil {
+		policy.SubscriberGender = strings.ToUpper(strings.TrimSpace(*p.SubscriberGender))
+	}
+	if p.Priority != nil {
+		policy.Priority = *p.Priority
+	}
+	if p.SubscriberDOB != nil {
+		policy.SubscriberDOB = p.SubscriberDOB
+	}
+	if p.ValidFrom != nil {
+		policy.ValidFrom = *p.ValidFrom
+	}
+	if p.ValidTo != nil {
+		policy.ValidTo = p.ValidTo
+	}
+}
+
+type RecordEligibilityPayload struct {
+	PolicyID  int64  `json:"policyId" validate:"required,gt=0"`
+	Status    string `json:"status" validate:"required"`
+	Reference string `json:"reference" validate:"max=80"`
+	Notes     string `json:"notes" validate:"max=2000"`
+}
+
+type CreateClaimBatchPayload struct {
+	PayerID  string     `json:"payerId" validate:"required,max=80"`
+	From     *data.Date `json:"from"`
+	To       *data.Date `json:"to"`
+	Currency string     `json:"currency"`
+}
+
+func (app *application) getPoliciesHandler(w http.ResponseWriter, r *http.Request) {
+	patient := getPatientFromCtx(r)
+	currentOnly := app.readString(r.URL.Query(), "current", "") == "true"
+
+	policies, err := app.models.Policies.GetForPatient(r.Context(), patient.ID, currentOnly)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": policies}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createPolicyHandler adds a policy from the patient's insurance card. The patient is
+// taken to be the subscriber unless a relationship and the subscriber's details are
+// given.
+func (app *application) createPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	var payload InsurancePolicyPayload
+	patient := getPatientFromCtx(r)
+	receptionist := getRecUserFromContext(r)
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	policy := &data.InsurancePolicy{
+		PatientID:        patient.ID,
+		Priority:         1,
+		Relationship:     "self",
+		SubscriberGender: "U",
+		ValidFrom:        data.NewDate(time.Now()),
+		CreatedBy:        receptionist.ID,
+	}
+	payload.apply(policy)
+
+	v := validator.New()
+	if data.ValidateInsurancePolicy(v, policy); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.models.Policies.Insert(r.Context(), policy); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusCreated, envelope{"data": policy}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	policy := getPolicyFromCtx(r)
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": policy}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updatePolicyHandler(w http.ResponseWriter, r *http.Request) {
+	var payload InsurancePolicyPayload
+	policy := getPolicyFromCtx(r)
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	payload.apply(policy)
+
+	v := validator.New()
+	if data.ValidateInsurancePolicy(v, policy); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Policies.Update(r.Context(), policy)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": policy}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getEligibilityHandler(w http.ResponseWriter, r *http.Request) {
+	entry := getQueueEntryFromCtx(r)
+
+	checks, err := app.models.Eligibility.GetForQueueEntry(r.Context(), entry.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": checks}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// recordEligibilityHandler records the answer the payer gave when the patient's cover
+// was checked for the visit. A policy can't be eligible for a visit outside its
+// validity.
+func (app *application) recordEligibilityHandler(w http.ResponseWriter, r *http.Request) {
+	var payload RecordEligibilityPayload
+	entry := getQueueEntryFromCtx(r)
+	receptionist := getRecUserFromContext(r)
+	ctx := r.Context()
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	policy, err := app.models.Policies.GetById(ctx, entry.PatientID, payload.PolicyID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("policyId", "policy does not exist for this patient")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	check := &data.EligibilityCheck{
+		QueueEntryID: entry.ID,
+		PatientID:    entry.PatientID,
+		PolicyID:     policy.ID,
+		Status:       payload.Status,
+		Reference:    strings.TrimSpace(payload.Reference),
+		Notes:        strings.TrimSpace(payload.Notes),
+		CheckedBy:    receptionist.ID,
+	}
+
+	v.Check(check.Status != "eligible" || policy.ValidOn(entry.CheckedInAt), "policyId", data.ErrPolicyNotValid.Error())
+	if data.ValidateEligibilityCheck(v, check); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.models.Eligibility.Insert(ctx, check); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusCreated, envelope{"data": check}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getClaimsHandler(w http.ResponseWriter, r *http.Request) {
+	var filters data.Filters
+	v := validator.New()
+	qs := r.URL.Query()
+
+	status := app.readString(qs, "status", "")
+	patientID := app.readInt(qs, "patient_id", 0, v)
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "-created_at")
+	filters.SortSafelist = []string{"created_at", "adjudicated_at", "-created_at", "-adjudicated_at"}
+
+	if status != "" {
+		v.Check(validator.In(status, data.ClaimStatuses...), "status",
+			"must be submitted, paid, partially_paid, denied or reversed")
+	}
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	claims, metadata, err := app.models.Claims.GetAll(r.Context(), int64(patientID), status, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": claims, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getClaimBatchesHandler(w http.ResponseWriter, r *http.Request) {
+	var filters data.Filters
+	v := validator.New()
+	qs := r.URL.Query()
+
+	payerID := app.readString(qs, "payer_id", "")
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "-created_at")
+	filters.SortSafelist = []string{"created_at", "-created_at"}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	batches, metadata, err := app.models.Claims.GetBatches(r.Context(), payerID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": batches, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createClaimBatchHandler claims every unclaimed invoice issued between from and to
+// (the previous 30 days by default) for patients covered by the payer, and generates
+// the 837 file for them. Invoices that can't be claimed yet, such as those of
+// patients without a recorded diagnosis, are reported back and left for a later
+// batch.
+func (app *application) createClaimBatchHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateClaimBatchPayload
+	receptionist := getRecUserFromContext(r)
+	ctx := r.Context()
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	today := data.NewDate(time.Now())
+	from, to := data.NewDate(today.AddDate(0, 0, -30)), today
+	if payload.From != nil {
+		from = *payload.From
+	}
+	if payload.To != nil {
+		to = *payload.To
+	}
+	currency := data.NormalizeCurrency(payload.Currency)
+	if currency == "" {
+		currency = app.config.billing.currency
+	}
+
+	v := validator.New()
+	v.Check(!to.Before(from.Time), "to", "must not be before from")
+	data.ValidateCurrency(v, "currency", currency)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	candidates, err := app.models.Claims.Candidates(ctx, strings.TrimSpace(payload.PayerID), currency, from.Time,
+		to.AddDate(0, 0, 1))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	batch := &data.ClaimBatch{PayerID: strings.TrimSpace(payload.PayerID), CreatedBy: receptionist.ID}
+	claims := make([]x12.Claim, 0, len(candidates))
+	skipped := map[string]string{}
+	for _, candidate := range candidates {
+		claim, policy, err := app.buildClaim(ctx, candidate)
+		if err != nil {
+			var skip claimSkipError
+			switch {
+			case errors.As(err, &skip):
+				skipped[strconv.FormatInt(candidate.InvoiceID, 10)] = skip.reason
+				continue
+			default:
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+		batch.PayerName = policy.PayerName
+		batch.Claims = append(batch.Claims, &data.Claim{
+			InvoiceID: candidate.InvoiceID,
+			PatientID: candidate.PatientID,
+			PolicyID:  candidate.PolicyID,
+			Charge:    claim.charge,
+		})
+		claims = append(claims, claim.x12)
+	}
+
+	err = app.models.Claims.CreateBatch(ctx, batch, func(b *data.ClaimBatch) ([]byte, error) {
+		for i, c := range b.Claims {
+			claims[i].ControlNumber = c.ControlNumber
+		}
+		return app.render837(b, claims)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNothingToClaim):
+			app.errorResponse(w, r, http.StatusUnprocessableEntity, envelope{"message": err.Error(), "skipped": skipped})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"data": batch, "skipped": skipped}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// claimSkipError says why an invoice was left out of a claim batch.
+type claimSkipError struct {
+	reason string
+}
+
+func (e claimSkipError) Error() string {
+	return e.reason
+}
+
+type builtClaim struct {
+	x12    x12.Claim
+	charge data.Money
+}
+
+// buildClaim puts together the claim for an invoice from the patient, their policy
+// and their active problems.
+func (app *application) buildClaim(ctx context.Context, c data.ClaimCandidate) (*builtClaim, *data.InsurancePolicy, error) {
+	invoice, err := app.models.Invoices.GetById(ctx, c.PatientID, c.InvoiceID)
+	if err != nil {
+		return nil, nil, err
+	}
+	patient, err := app.models.Patients.GetPatientById(ctx, c.PatientID)
+	if err != nil {
+		return nil, nil, err
+	}
+	policy, err := app.models.Policies.GetById(ctx, c.PatientID, c.PolicyID)
+	if err != nil {
+		return nil, nil, err
+	}
+	problems, err := app.models.Problems.GetForPatient(ctx, c.PatientID, data.ProblemStatusActive)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(problems) == 0 {
+		return nil, nil, claimSkipError{"patient has no active diagnosis to claim for"}
+	}
+	if patient.DateOfBirth == nil {
+		return nil, nil, claimSkipError{"patient has no date of birth recorded"}
+	}
+
+	claim := x12.Claim{
+		Sequence:       policySequences[policy.Priority],
+		PayerName:      policy.PayerName,
+		PayerID:        policy.PayerID,
+		MemberID:       policy.MemberID,
+		GroupNumber:    policy.GroupNumber,
+		Relationship:   policy.Relationship,
+		Charge:         invoice.Total.Decimal(),
+		PlaceOfService: app.config.claims.placeOfService,
+		Patient: x12.Person{
+			FirstName:   patient.FirstName,
+			LastName:    patient.LastName,
+			DateOfBirth: patient.DateOfBirth.Time,
+			Gender:      "U",
+			Address:     policy.Address,
+			City:        policy.City,
+			State:       policy.State,
+			PostalCode:  policy.PostalCode,
+		},
+	}
+	claim.Subscriber = x12.Person{
+		FirstName:  policy.SubscriberFirstName,
+		LastName:   policy.SubscriberLastName,
+		Gender:     policy.SubscriberGender,
+		Address:    policy.Address,
+		City:       policy.City,
+		State:      policy.State,
+		PostalCode: policy.PostalCode,
+	}
+	if policy.SubscriberDOB != nil {
+		claim.Subscriber.DateOfBirth = policy.SubscriberDOB.Time
+	}
+	if policy.Relationship == "self" {
+		claim.Patient.Gender = policy.SubscriberGender
+		claim.Subscriber = claim.Patient
+	}
+
+	// An 837P carries at most 12 diagnosis codes.
+	for i, p := range problems {
+		if i == 12 {
+			break
+		}
+		claim.Diagnoses = append(claim.Diagnoses, p.Code)
+	}
+
+	codes := map[int64]string{}
+	for _, line := range invoice.Lines {
+		if line.ServiceID == nil {
+			return nil, nil, claimSkipError{"invoice has a line that isn't a catalogue service"}
+		}
+		code, ok := codes[*line.ServiceID]
+		if !ok {
+			service, err := app.models.Services.GetById(ctx, *line.ServiceID)
+			if err != nil {
+				return nil, nil, err
+			}
+			code = service.Code
+			codes[service.ID] = code
+		}
+		claim.Lines = append(claim.Lines, x12.ServiceLine{
+			ProcedureCode: code,
+			Charge:        line.Amount.Decimal(),
+			Units:         line.Quantity,
+			ServiceDate:   invoice.IssuedAt,
+		})
+	}
+
+	return &builtClaim{x12: claim, charge: invoice.Total}, policy, nil
+}
+
+func (app *application) render837(b *data.ClaimBatch, claims []x12.Claim) ([]byte, error) {
+	cfg := app.config.claims
+	env := x12.Envelope{
+		SenderID:      cfg.submitter.ID,
+		ReceiverID:    cfg.receiver.ID,
+		ControlNumber: b.ID,
+		Production:    cfg.production,
+		Time:          b.CreatedAt,
+	}
+
+	var buf bytes.Buffer
+	err := x12.Write837P(&buf, env, &x12.ProfessionalClaims{
+		Reference: fmt.Sprintf("BATCH%d", b.ID),
+		Created:   b.CreatedAt,
+		Submitter: cfg.submitter,
+		Receiver:  cfg.receiver,
+		Provider:  cfg.provider,
+		Claims:    claims,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (app *application) getClaimBatchHandler(w http.ResponseWriter, r *http.Request) {
+	batch := getClaimBatchFromCtx(r)
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": batch}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// downloadClaimBatchHandler returns the batch's 837 file, ready to upload to the
+// payer or clearinghouse.
+func (app *application) downloadClaimBatchHandler(w http.ResponseWriter, r *http.Request) {
+	batch := getClaimBatchFromCtx(r)
+
+	file, err := app.models.Claims.GetBatchFile(r.Context(), batch.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/edi-x12")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("claims-%d.837", batch.ID)))
+	w.Header().Set("Content-Length", strconv.Itoa(len(file)))
+	w.Write(file)
+}
+
+func (app *application) getRemittancesHandler(w http.ResponseWriter, r *http.Request) {
+	var filters data.Filters
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "-imported_at")
+	filters.SortSafelist = []string{"imported_at", "payment_date", "-imported_at", "-payment_date"}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	remittances, metadata, err := app.models.Remittances.GetAll(r.Context(), filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": remittances, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// importRemittanceHandler reads an 835 file uploaded in the "file" field of a
+// multipart form and updates the claims it settles. Amounts are read in the currency
+// given in the "currency" field, the billing currency by default. Remittances that
+// were imported before are skipped.
+func (app *application) importRemittanceHandler(w http.ResponseWriter, r *http.Request) {
+	receptionist := getRecUserFromContext(r)
+	ctx := r.Context()
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRemittanceBytes+64*1024)
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.errorResponse(w, r, http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("file must not be larger than %d bytes", maxRemittanceBytes))
+		default:
+			app.badRequestResponse(w, r, fmt.Errorf("body must be a multipart form: %w", err))
+		}
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	v := validator.New()
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		v.AddError("file", "must be provided")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	defer file.Close()
+
+	currency := data.NormalizeCurrency(r.FormValue("currency"))
+	if currency == "" {
+		currency = app.config.billing.currency
+	}
+	if data.ValidateCurrency(v, "currency", currency); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	remits, err := x12.Parse835(file)
+	if err != nil {
+		v.AddError("file", err.Error())
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	imported := []*data.Remittance{}
+	duplicates := []string{}
+	for i, remit := range remits {
+		remittance, outcomes, err := remittanceFrom(remit, currency)
+		if err != nil {
+			v.AddError(fmt.Sprintf("file[%d]", i), err.Error())
+			continue
+		}
+		remittance.ImportedBy = receptionist.ID
+
+		err = app.models.Remittances.Import(ctx, remittance, outcomes)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrDuplicateRemittance):
+				duplicates = append(duplicates, remittance.TraceNumber)
+				continue
+			default:
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+		imported = append(imported, remittance)
+	}
+	if !v.Valid() && len(imported) == 0 {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"data": imported, "duplicates": duplicates, "errors": v.Errors}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// remittanceFrom converts a parsed 835 into a remittance and the outcome of each
+// claim it covers.
+func remittanceFrom(remit *x12.Remittance, currency string) (*data.Remittance, []data.ClaimOutcome, error) {
+	amount, err := data.ParseMoney(remit.PaymentAmount, currency)
+	if err != nil {
+		return nil, nil, err
+	}
+	if remit.TraceNumber == "" {
+		return nil, nil, errors.New("remittance has no trace number")
+	}
+
+	remittance := &data.Remittance{
+		PayerID:       remit.PayerID,
+		PayerName:     remit.PayerName,
+		TraceNumber:   remit.TraceNumber,
+		PaymentAmount: amount,
+		PaymentMethod: remit.PaymentMethod,
+	}
+	if !remit.PaymentDate.IsZero() {
+		d := data.NewDate(remit.PaymentDate)
+		remittance.PaymentDate = &d
+	}
+
+	outcomes := make([]data.ClaimOutcome, 0, len(remit.Claims))
+	for _, c := range remit.Claims {
+		var charge, paid, responsibility data.Money
+		if charge, err = data.ParseMoney(orZero(c.Charge), currency); err != nil {
+			return nil, nil, err
+		}
+		if paid, err = data.ParseMoney(orZero(c.Paid), currency); err != nil {
+			return nil, nil, err
+		}
+		if responsibility, err = data.ParseMoney(orZero(c.PatientResponsibility), currency); err != nil {
+			return nil, nil, err
+		}
+
+		outcome := data.ClaimOutcome{
+			ControlNumber:         c.ControlNumber,
+			Status:                claimStatus(c.StatusCode, charge, paid),
+			Paid:                  paid,
+			PatientResponsibility: responsibility,
+			PayerClaimNumber:      c.PayerClaimNumber,
+			Adjustments:           []data.ClaimAdjustment{},
+		}
+		for _, a := range c.Adjustments {
+			adjusted, err := data.ParseMoney(orZero(a.Amount), currency)
+			if err != nil {
+				return nil, nil, err
+			}
+			outcome.Adjustments = append(outcome.Adjustments, data.ClaimAdjustment{
+				Group:  a.Group,
+				Reason: a.Reason,
+				Amount: adjusted,
+			})
+		}
+		outcomes = append(outcomes, outcome)
+	}
+	return remittance, outcomes, nil
+}
+
+// claimStatus works out a claim's status from the payer's status code and what it
+// paid.
+func claimStatus(code string, charge, paid data.Money) string {
+	switch {
+	case code == x12.ClaimStatusReversal:
+		return data.ClaimReversed
+	case code == x12.ClaimStatusDenied:
+		return data.ClaimDenied
+	case paid.Amount >= charge.Amount:
+		return data.ClaimPaid
+	default:
+		return data.ClaimPartiallyPaid
+	}
+}
+
+func orZero(amount string) string {
+	if amount == "" {
+		return "0"
+	}
+	return amount
+}
+
+func (app *application) policyContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "policyId"), 10, 64)
+		if err != nil || id < 1 {
+			app.notFoundResponse(w, r)
+			return
+		}
+		ctx := r.Context()
+		patient := getPatientFromCtx(r)
+
+		policy, err := app.models.Policies.GetById(ctx, patient.ID, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, policyCtx, policy)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getPolicyFromCtx(r *http.Request) *data.InsurancePolicy {
+	policy, _ := r.Context().Value(policyCtx).(*data.InsurancePolicy)
+	return policy
+}
+
+func (app *application) claimBatchContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "batchId"), 10, 64)
+		if err != nil || id < 1 {
+			app.notFoundResponse(w, r)
+			return
+		}
+		ctx := r.Context()
+
+		batch, err := app.models.Claims.GetBatch(ctx, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, claimBatchCtx, batch)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getClaimBatchFromCtx(r *http.Request) *data.ClaimBatch {
+	batch, _ := r.Context().Value(claimBatchCtx).(*data.ClaimBatch)
+	return batch
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/muyiwadosunmu/hospital-management/internal/data"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+type jobKey string
+
+const jobCtx jobKey = "job"
+
+// The kinds of background job, each run by the handler registered for it in
+// jobHandlers.
+const (
+	jobSendNotification = "notification.send"
+	jobRunExport        = "export.run"
+	jobRunPatientImport = "patient_import.run"
+)
+
+// jobLease is how long a claimed job is left alone before another worker may take
+// it over. Workers renew the lease while the job runs, so it only runs out when the
+// instance running the job has died.
+const jobLease = 5 * time.Minute
+
+// jobHandler runs a job from its raw arguments.
+type jobHandler func(ctx context.Context, args json.RawMessage) error
+
+// handleJob makes a jobHandler of a function taking the job's decoded arguments.
+func handleJob[T any](fn func(context.Context, T) error) jobHandler {
+	return func(ctx context.Context, raw json.RawMessage) error {
+		var args T
+		if err := json.Unmarshal(raw, &args); err != nil {
+			return fmt.Errorf("decoding job arguments: %w", err)
+		}
+		return fn(ctx, args)
+	}
+}
+
+func (app *application) jobHandlers() map[string]jobHandler {
+	return map[string]jobHandler{
+		jobSendNotification: handleJob(app.sendNotification),
+		jobRunExport:        handleJob(app.runExport),
+		jobRunPatientImport: handleJob(app.runPatientImport),
+	}
+}
+
+// jobOptions change how a job is queued. The zero value runs it straight away
+// with the configured number of attempts.
+type jobOptions struct {
+	runAt time.Time
+	// uniqueKey stops the job being queued while another with the same key is
+	// pending or running.
+	uniqueKey   string
+	maxAttempts int
+}
+
+// enqueue queues a job of the kind with args encoded as JSON. It returns
+// data.ErrDuplicateJob when opts.uniqueKey is already taken.
+func (app *application) enqueue(ctx context.Context, kind string, args any, opts jobOptions) (*data.Job, error) {
+	raw, err := json.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+	job := &data.Job{
+		Kind:        kind,
+		Args:        raw,
+		MaxAttempts: opts.maxAttempts,
+		RunAt:       opts.runAt,
+	}
+	if job.MaxAttempts < 1 {
+		job.MaxAttempts = app.config.jobs.maxAttempts
+	}
+	if opts.uniqueKey != "" {
+		job.UniqueKey = &opts.uniqueKey
+	}
+	if err := app.models.Jobs.Enqueue(ctx, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// runJobs works through queued jobs with up to the configured number at once
+// until ctx is done. Jobs already running are given the drain timeout to finish,
+// then cancelled and released back to the queue for the next instance.
+func (app *application) runJobs(ctx context.Context) {
+	handlers := app.jobHandlers()
+	kinds := slices.Sorted(maps.Keys(handlers))
+	concurrency := app.config.jobs.concurrency
+
+	slots := make(chan struct{}, concurrency)
+	// finished wakes the loop when a slot is freed, so the next job is claimed
+	// without waiting for the ticker.
+	finished := make(chan struct{}, 1)
+	var wg sync.WaitGroup
+	runCtx, cancelRun := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelRun()
+
+	ticker := time.NewTicker(app.config.jobs.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if free := concurrency - len(slots); free > 0 && ctx.Err() == nil {
+			jobs, err := app.models.Jobs.Claim(ctx, kinds, free, jobLease)
+			if err != nil && ctx.Err() == nil {
+				app.logger.PrintError(err, nil)
+			}
+			for _, job := range jobs {
+				slots <- struct{}{}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					defer func() {
+						<-slots
+						select {
+						case finished <- struct{}{}:
+						default:
+						}
+					}()
+					app.runJob(runCtx, handlers[job.Kind], job)
+				}()
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			drain := time.AfterFunc(app.config.jobs.drainTimeout, cancelRun)
+			wg.Wait()
+			drain.Stop()
+			return
+		case <-ticker.C:
+		case <-finished:
+		}
+	}
+}
+
+// runJob runs a claimed job, renewing its lease until it is done, and records the
+// outcome. A failed job is retried unless it is out of attempts or the record it
+// is for no longer exists. If the lease can't be renewed because another worker has
+// claimed the job, the handler is cancelled and the outcome left to that worker.
+func (app *application) runJob(ctx context.Context, handler jobHandler, job *data.Job) {
+	properties := map[string]string{
+		"job_id":   strconv.FormatInt(job.ID, 10),
+		"kind":     job.Kind,
+		"attempts": strconv.Itoa(job.Attempts),
+	}
+
+	jobCtx, cancelJob := context.WithCancelCause(ctx)
+	defer cancelJob(nil)
+	heartbeatCtx, stopHeartbeat := context.WithCancel(jobCtx)
+	go func() {
+		ticker := time.NewTicker(jobLease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-heartbeatCtx.Done():
+				return
+			case <-ticker.C:
+				err := app.models.Jobs.Extend(heartbeatCtx, job, jobLease)
+				switch {
+				case errors.Is(err, data.ErrJobLost):
+					cancelJob(err)
+					return
+				case err != nil && heartbeatCtx.Err() == nil:
+					app.logger.PrintError(err, properties)
+				}
+			}
+		}
+	}()
+
+	runErr := callJob(jobCtx, handler, job)
+	stopHeartbeat()
+
+	if errors.Is(context.Cause(jobCtx), data.ErrJobLost) {
+		app.logger.PrintError(data.ErrJobLost, properties)
+		return
+	}
+
+	// The outcome is recorded even when the job was cut short by shutdown.
+	recordCtx := context.WithoutCancel(ctx)
+	if runErr == nil {
+		if err := app.models.Jobs.Complete(recordCtx, job); err != nil {
+			app.logger.PrintError(err, properties)
+		}
+		return
+	}
+	if ctx.Err() != nil {
+		if err := app.models.Jobs.Release(recordCtx, job); err != nil {
+			app.logger.PrintError(err, properties)
+			return
+		}
+		app.logger.PrintInfo("job released at shutdown", properties)
+		return
+	}
+
+	var retryIn time.Duration
+	if job.Attempts < job.MaxAttempts && !errors.Is(runErr, data.ErrRecordNotFound) {
+		retryIn = app.jobRetryDelay(job.Attempts)
+		properties["retry_in"] = retryIn.String()
+		app.logger.PrintError(runErr, properties)
+	} else {
+		app.logger.PrintError(fmt.Errorf("job failed: %w", runErr), properties)
+	}
+	if err := app.models.Jobs.Fail(recordCtx, job, runErr, retryIn); err != nil {
+		app.logger.PrintError(err, properties)
+	}
+}
+
+// callJob runs the handler, turning a panic into an error so the job is retried
+// like any other failure.
+func callJob(ctx context.Context, handler jobHandler, job *data.Job) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	if handler == nil {
+		return fmt.Errorf("no handler for job kind %q", job.Kind)
+	}
+	return handler(ctx, job.Args)
+}
+
+// jobRetryDelay doubles the wait after each failed attempt, up to the maximum.
+func (app *application) jobRetryDelay(attempt int) time.Duration {
+	delay := app.config.jobs.retryBase
+	for i := 1; i < attempt && delay < app.config.jobs.retryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, app.config.jobs.retryMax)
+}
+
+func (app *application) getJobsHandler(w http.ResponseWriter, r *http.Request) {
+	var queryDto struct {
+		Status string
+		Kind   string
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	queryDto.Status = app.readString(qs, "status", "")
+	queryDto.Kind = app.readString(qs, "kind", "")
+	queryDto.Page = app.readInt(qs, "page", 1, v)
+	queryDto.PageSize = app.readInt(qs, "page_size", 20, v)
+	queryDto.Sort = app.readString(qs, "sort", "-created_at")
+	queryDto.SortSafelist = []string{"created_at", "run_at", "id", "-created_at", "-run_at", "-id"}
+
+	v.Check(queryDto.Status == "" || validator.In(queryDto.Status, data.JobStatuses...), "status",
+		"must be pending, running, completed or failed")
+	if data.ValidateFilters(v, queryDto.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	jobs, metadata, err := app.models.Jobs.GetAll(r.Context(), queryDto.Status, queryDto.Kind, queryDto.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": jobs, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getJobHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"data": getJobFromCtx(r)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// retryJobHandler queues a failed job to run again with a fresh set of attempts.
+func (app *application) retryJobHandler(w http.ResponseWriter, r *http.Request) {
+	job := getJobFromCtx(r)
+
+	err := app.models.Jobs.Retry(r.Context(), job)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrJobNotFailed), errors.Is(err, data.ErrDuplicateJob):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusAccepted, envelope{"data": job}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) jobContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "jobId"), 10, 64)
+		if err != nil || id < 1 {
+			app.notFoundResponse(w, r)
+			return
+		}
+		ctx := r.Context()
+
+		job, err := app.models.Jobs.GetById(ctx, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, jobCtx, job)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getJobFromCtx(r *http.Request) *data.Job {
+	job, _ := r.Context().Value(jobCtx).(*data.Job)
+	return job
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/muyiwadosunmu/hospital-management/internal/data"
+	"github.com/muyiwadosunmu/hospital-management/internal/notify"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+type labOrderKey string
+
+const labOrderCtx labOrderKey = "labOrder"
+
+type CreateLabOrderPayload struct {
+	TestCode    string `json:"testCode" validate:"required,max=50"`
+	TestName    string `json:"testName" validate:"required,max=255"`
+	Priority    string `json:"priority" validate:"omitempty,oneof=routine urgent stat"`
+	Notes       string `json:"notes" validate:"max=2000"`
+	EncounterID *int64 `json:"encounterId" validate:"omitempty,gt=0"`
+}
+
+type LabResultPayload struct {
+	Code          string     `json:"code" validate:"required,max=50"`
+	Name          string     `json:"name" validate:"required,max=255"`
+	Value         *float64   `json:"value"`
+	ValueText     string     `json:"valueText" validate:"max=2000"`
+	Unit          string     `json:"unit" validate:"max=50"`
+	ReferenceLow  *float64   `json:"referenceLow"`
+	ReferenceHigh *float64   `json:"referenceHigh"`
+	CriticalLow   *float64   `json:"criticalLow"`
+	CriticalHigh  *float64   `json:"criticalHigh"`
+	ObservedAt    *time.Time `json:"observedAt"`
+}
+
+type PostLabResultsPayload struct {
+	CollectedAt *time.Time         `json:"collectedAt"`
+	Results     []LabResultPayload `json:"results" validate:"required,min=1,dive"`
+}
+
+func (app *application) getLabOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	var filters data.Filters
+	patient := getPatientFromCtx(r)
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	status := app.readString(qs, "status", "")
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "-ordered_at")
+	filters.SortSafelist = []string{"ordered_at", "-ordered_at", "resulted_at", "-resulted_at"}
+
+	if status != "" {
+		v.Check(validator.In(status, data.LabStatuses...), "status", "must be ordered, collected, resulted or cancelled")
+	}
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	orders, metadata, err := app.models.LabOrders.GetForPatient(r.Context(), patient.ID, status, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": orders, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createLabOrderHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateLabOrderPayload
+	patient := getPatientFromCtx(r)
+	doctor := getDocUserFromContext(r)
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	order := &data.LabOrder{
+		PatientID:   patient.ID,
+		DoctorID:    doctor.ID,
+		EncounterID: payload.EncounterID,
+		TestCode:    strings.ToUpper(strings.TrimSpace(payload.TestCode)),
+		TestName:    strings.TrimSpace(payload.TestName),
+		Priority:    payload.Priority,
+		Notes:       payload.Notes,
+	}
+	if order.Priority == "" {
+		order.Priority = "routine"
+	}
+
+	v := validator.New()
+	if payload.EncounterID != nil {
+		encounter, err := app.models.Encounters.GetById(r.Context(), patient.ID, *payload.EncounterID)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("encounterId", "encounter not found for this patient")
+		case err != nil:
+			app.serverErrorResponse(w, r, err)
+			return
+		case encounter.IsClosed():
+			v.AddError("encounterId", data.ErrEncounterClosed.Error())
+		}
+	}
+	if data.ValidateLabOrder(v, order); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.models.LabOrders.Insert(r.Context(), order); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusCreated, envelope{"data": order}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getLabOrderHandler(w http.ResponseWriter, r *http.Request) {
+	order := getLabOrderFromCtx(r)
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": order}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateLabOrderStatusHandler marks an order as collected or cancels it.
+func (app *application) updateLabOrderStatusHandler(w http.ResponseWriter, r *http.Request) {
+	order := getLabOrderFromCtx(r)
+
+	var payload struct {
+		Status string `json:"status" validate:"required,oneof=collected cancelled"`
+		Reason string `json:"reason" validate:"max=1000"`
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateLabTransition(v, order.Status, payload.Status)
+	if payload.Status == data.LabStatusCancelled {
+		v.Check(strings.TrimSpace(payload.Reason) != "", "reason", "must be provided when cancelling an order")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	order.Status = payload.Status
+	order.CancelReason = strings.TrimSpace(payload.Reason)
+
+	err := app.models.LabOrders.UpdateStatus(r.Context(), order)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": order}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) acknowledgeLabOrderHandler(w http.ResponseWriter, r *http.Request) {
+	order := getLabOrderFromCtx(r)
+	doctor := getDocUserFromContext(r)
+
+	if !order.IsResulted() {
+		v := validator.New()
+		v.AddError("status", data.ErrLabOrderUnresulted.Error())
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// Acknowledging again is a no-op so that a double click doesn't conflict.
+	if order.AcknowledgedAt != nil {
+		if err := app.writeJSON(w, http.StatusOK, envelope{"data": order}, nil); err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err := app.models.LabOrders.Acknowledge(r.Context(), order, doctor.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": order}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// labInboxHandler lists the signed-in doctor's results that still need to be
+// acknowledged, critical results first.
+func (app *application) labInboxHandler(w http.ResponseWriter, r *http.Request) {
+	var filters data.Filters
+	doctor := getDocUserFromContext(r)
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = "resulted_at"
+	filters.SortSafelist = []string{"resulted_at"}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	orders, metadata, err := app.models.LabOrders.Inbox(r.Context(), doctor.ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": orders, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// postLabResultsHandler is called by the laboratory system when results for an order
+// are ready.
+func (app *application) postLabResultsHandler(w http.ResponseWriter, r *http.Request) {
+	var payload PostLabResultsPayload
+
+	orderID, err := strconv.ParseInt(chi.URLParam(r, "orderId"), 10, 64)
+	if err != nil || orderID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	results := make([]*data.LabResult, 0, len(payload.Results))
+	for _, p := range payload.Results {
+		result := &data.LabResult{
+			Code:          strings.TrimSpace(p.Code),
+			Name:          strings.TrimSpace(p.Name),
+			Value:         p.Value,
+			ValueText:     p.ValueText,
+			Unit:          p.Unit,
+			ReferenceLow:  p.ReferenceLow,
+			ReferenceHigh: p.ReferenceHigh,
+			CriticalLow:   p.CriticalLow,
+			CriticalHigh:  p.CriticalHigh,
+		}
+		if p.ObservedAt != nil {
+			result.ObservedAt = *p.ObservedAt
+		}
+		results = append(results, result)
+	}
+
+	v := validator.New()
+	if data.ValidateLabResults(v, results); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	order, err := app.models.LabOrders.PostResults(r.Context(), orderID, payload.CollectedAt, results)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrLabOrderNotResultable):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if order.Critical {
+		app.notifyCriticalLabResult(r.Context(), order)
+	}
+
+	if err := app.writeJSON(w, http.StatusCreated, envelope{"data": order}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+type criticalLabResultArgs struct {
+	OrderID int64 `json:"orderId"`
+}
+
+// notifyCriticalLabResult emails the ordering doctor about an order with critical
+// results.
+func (app *application) notifyCriticalLabResult(ctx context.Context, order *data.LabOrder) {
+	doctor, err := app.models.Doctors.GetById(ctx, order.DoctorID)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"template": tmplCriticalLabResult})
+		return
+	}
+	app.notifyStaff(ctx, doctor.Email, tmplCriticalLabResult, criticalLabResultArgs{OrderID: order.ID})
+}
+
+func (app *application) criticalLabResultMessage(ctx context.Context, n *data.Notification) (notify.Message, error) {
+	var args criticalLabResultArgs
+	if err := decodeNotificationArgs(n, &args); err != nil {
+		return notify.Message{}, err
+	}
+	order, err := app.models.LabOrders.GetById(ctx, 0, args.OrderID)
+	if err != nil {
+		return notify.Message{}, err
+	}
+	doctor, err := app.models.Doctors.GetById(ctx, order.DoctorID)
+	if err != nil {
+		return notify.Message{}, err
+	}
+	patient, err := app.models.Patients.GetPatientById(ctx, order.PatientID)
+	if err != nil {
+		return notify.Message{}, err
+	}
+
+	critical := []*data.LabResult{}
+	for _, result := range order.Results {
+		if result.Flag == data.FlagCritical {
+			critical = append(critical, result)
+		}
+	}
+
+	return notify.Message{Data: map[string]interface{}{
+		"doctorLastName": doctor.LastName,
+		"patientID":      patient.ID,
+		"patientName":    patient.FirstName + " " + patient.LastName,
+		"orderID":        order.ID,
+		"testCode":       order.TestCode,
+		"testName":       order.TestName,
+		"results":        critical,
+	}}, nil
+}
+
+func (app *application) labOrderContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "orderId"), 10, 64)
+		if err != nil || id < 1 {
+			app.notFoundResponse(w, r)
+			return
+		}
+		ctx := r.Context()
+		patient := getPatientFromCtx(r)
+
+		order, err := app.models.LabOrders.GetById(ctx, patient.ID, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, labOrderCtx, order)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getLabOrderFromCtx(r *http.Request) *data.LabOrder {
+	order, _ := r.Context().Value(labOrderCtx).(*data.LabOrder)
+	return order
+}
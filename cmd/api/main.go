@@ -3,15 +3,25 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	_ "github.com/lib/pq"
 	"github.com/muyiwadosunmu/hospital-management/internal/auth"
 	store "github.com/muyiwadosunmu/hospital-management/internal/data"
 	"github.com/muyiwadosunmu/hospital-management/internal/env"
+	"github.com/muyiwadosunmu/hospital-management/internal/immunization"
 	"github.com/muyiwadosunmu/hospital-management/internal/jsonlog"
 	"github.com/muyiwadosunmu/hospital-management/internal/mailer"
+	"github.com/muyiwadosunmu/hospital-management/internal/notify"
+	"github.com/muyiwadosunmu/hospital-management/internal/prescribing"
+	"github.com/muyiwadosunmu/hospital-management/internal/pubsub"
+	"github.com/muyiwadosunmu/hospital-management/internal/storage"
+	"github.com/muyiwadosunmu/hospital-management/internal/webhook"
+	"github.com/muyiwadosunmu/hospital-management/internal/x12"
 )
 
 const Version = "1.0.0"
@@ -28,7 +38,8 @@ func main() {
 			maxIdleConns: env.GetInt("DB_MAX_IDLE_CONNS", 25),
 			maxIdleTime:  env.GetString("DB_MAX_IDLE_TIME", "15m"),
 		},
-		env: env.GetString("ENV", "development"),
+		env:          env.GetString("ENV", "development"),
+		hospitalName: env.GetString("HOSPITAL_NAME", "Hospital Management"),
 		mail: mailConfig{
 			exp:      time.Hour * 24 * 3, //  3 days
 			username: env.GetString("SMTP_USERNAME", "dosunmuoluwamuyiwa98@gmail.com"),
@@ -37,7 +48,99 @@ func main() {
 			port:     env.GetInt("SMTP_PORT", 465),
 			sender:   env.GetString("SMTP_SENDER", "no-reply@struct.io"),
 		},
+		vitals: vitalsConfig{
+			thresholdsFile: env.GetString("VITALS_THRESHOLDS_FILE", ""),
+		},
+		prescribing: prescribingConfig{
+			interactionsFile: env.GetString("DRUG_INTERACTIONS_FILE", ""),
+		},
+		immunization: immunizationConfig{
+			scheduleFile: env.GetString("IMMUNIZATION_SCHEDULE_FILE", ""),
+		},
+		billing: billingConfig{
+			currency: store.NormalizeCurrency(env.GetString("BILLING_CURRENCY", "NGN")),
+		},
+		claims: claimsConfig{
+			submitter: x12.Submitter{
+				Name:         env.GetString("CLAIMS_SUBMITTER_NAME", ""),
+				ID:           env.GetString("CLAIMS_SUBMITTER_ID", ""),
+				ContactName:  env.GetString("CLAIMS_CONTACT_NAME", ""),
+				ContactPhone: env.GetString("CLAIMS_CONTACT_PHONE", ""),
+			},
+			receiver: x12.Receiver{
+				Name: env.GetString("CLAIMS_RECEIVER_NAME", ""),
+				ID:   env.GetString("CLAIMS_RECEIVER_ID", ""),
+			},
+			provider: x12.Provider{
+				Name:       env.GetString("CLAIMS_PROVIDER_NAME", env.GetString("HOSPITAL_NAME", "Hospital Management")),
+				NPI:        env.GetString("CLAIMS_PROVIDER_NPI", ""),
+				TaxID:      env.GetString("CLAIMS_PROVIDER_TAX_ID", ""),
+				Address:    env.GetString("CLAIMS_PROVIDER_ADDRESS", ""),
+				City:       env.GetString("CLAIMS_PROVIDER_CITY", ""),
+				State:      env.GetString("CLAIMS_PROVIDER_STATE", ""),
+				PostalCode: env.GetString("CLAIMS_PROVIDER_POSTAL_CODE", ""),
+			},
+			placeOfService: env.GetString("CLAIMS_PLACE_OF_SERVICE", "11"),
+			production:     env.GetBool("CLAIMS_PRODUCTION", false),
+		},
+		fhir: fhirConfig{
+			baseURL:       strings.TrimRight(env.GetString("FHIR_BASE_URL", "http://localhost:3000/fhir/r4"), "/"),
+			licenseSystem: env.GetString("FHIR_LICENSE_SYSTEM", "urn:hospital-management:license"),
+		},
+		hl7: hl7Config{
+			addr:               env.GetString("HL7_MLLP_ADDR", ""),
+			application:        env.GetString("HL7_APPLICATION", "HMS"),
+			facility:           env.GetString("HL7_FACILITY", env.GetString("HOSPITAL_NAME", "Hospital Management")),
+			assigningAuthority: env.GetString("HL7_ASSIGNING_AUTHORITY", "HMS"),
+			receptionistID:     int64(env.GetInt("HL7_RECEPTIONIST_ID", 0)),
+			idleTimeout:        time.Duration(env.GetInt("HL7_IDLE_TIMEOUT_SECONDS", 300)) * time.Second,
+		},
+		export: exportConfig{
+			dir:           env.GetString("EXPORT_DIR", "./exports"),
+			linkTTL:       time.Duration(env.GetInt("EXPORT_LINK_TTL_MINUTES", 60)) * time.Minute,
+			retention:     time.Duration(env.GetInt("EXPORT_RETENTION_HOURS", 24)) * time.Hour,
+			signingSecret: env.GetString("EXPORT_SIGNING_SECRET", env.GetString("AUTH_TOKEN_SECRET", "qwertyuioplkjhg")),
+		},
+		webhooks: webhookConfig{
+			pollInterval:  time.Duration(env.GetInt("WEBHOOK_POLL_INTERVAL_SECONDS", 2)) * time.Second,
+			timeout:       time.Duration(env.GetInt("WEBHOOK_TIMEOUT_SECONDS", 10)) * time.Second,
+			maxAttempts:   env.GetInt("WEBHOOK_MAX_ATTEMPTS", 10),
+			retryBase:     time.Duration(env.GetInt("WEBHOOK_RETRY_BASE_SECONDS", 30)) * time.Second,
+			retryMax:      time.Duration(env.GetInt("WEBHOOK_RETRY_MAX_MINUTES", 360)) * time.Minute,
+			insecureHosts: env.GetList("WEBHOOK_INSECURE_HOSTS", nil),
+		},
+		jobs: jobConfig{
+			concurrency:  env.GetInt("JOB_CONCURRENCY", 4),
+			pollInterval: time.Duration(env.GetInt("JOB_POLL_INTERVAL_SECONDS", 1)) * time.Second,
+			maxAttempts:  env.GetInt("JOB_MAX_ATTEMPTS", 5),
+			retryBase:    time.Duration(env.GetInt("JOB_RETRY_BASE_SECONDS", 10)) * time.Second,
+			retryMax:     time.Duration(env.GetInt("JOB_RETRY_MAX_MINUTES", 60)) * time.Minute,
+			drainTimeout: time.Duration(env.GetInt("JOB_DRAIN_TIMEOUT_SECONDS", 20)) * time.Second,
+		},
+		sms: smsConfig{
+			gatewayURL: env.GetString("SMS_GATEWAY_URL", ""),
+			apiKey:     env.GetString("SMS_GATEWAY_API_KEY", ""),
+			sender:     env.GetString("SMS_SENDER", "HMS"),
+			timeout:    time.Duration(env.GetInt("SMS_TIMEOUT_SECONDS", 10)) * time.Second,
+		},
+		storage: storageConfig{
+			backend:  env.GetString("STORAGE_BACKEND", "local"),
+			localDir: env.GetString("STORAGE_LOCAL_DIR", "./uploads"),
+			s3: storage.S3Config{
+				Endpoint:  env.GetString("S3_ENDPOINT", ""),
+				Region:    env.GetString("S3_REGION", "us-east-1"),
+				Bucket:    env.GetString("S3_BUCKET", ""),
+				AccessKey: env.GetString("S3_ACCESS_KEY", ""),
+				SecretKey: env.GetString("S3_SECRET_KEY", ""),
+				PathStyle: env.GetBool("S3_PATH_STYLE", false),
+			},
+			maxUploadBytes: int64(env.GetInt("MAX_UPLOAD_BYTES", 20<<20)), // 20 MB
+		},
 		auth: authConfig{
+			basic: basicConfig{
+				user: env.GetString("AUTH_BASIC_USER", ""),
+				pass: env.GetString("AUTH_BASIC_PASS", ""),
+			},
 			token: tokenConfig{
 				secret: env.GetString("AUTH_TOKEN_SECRET", "qwertyuioplkjhg"),
 				exp:    time.Hour * 24 * 3, // 3 days
@@ -48,6 +151,10 @@ func main() {
 	// Logger
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
 
+	if err := validateConfig(cfg); err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
 	go func() {
 
 	}()
@@ -66,12 +173,67 @@ func main() {
 
 	logger.PrintInfo("Database Connection Pool Established", nil)
 
+	vitalThresholds := &store.DefaultVitalThresholds
+	if cfg.vitals.thresholdsFile != "" {
+		vitalThresholds, err = store.LoadVitalThresholds(cfg.vitals.thresholdsFile)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	}
+
+	prescriptionChecker := prescribing.New(prescribing.Dataset{})
+	if cfg.prescribing.interactionsFile != "" {
+		prescriptionChecker, err = prescribing.Load(cfg.prescribing.interactionsFile)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	} else {
+		logger.PrintInfo("no drug interaction dataset configured, only allergy and duplicate checks will be made", nil)
+	}
+
+	immunizationSchedule := immunization.Default()
+	if cfg.immunization.scheduleFile != "" {
+		immunizationSchedule, err = immunization.Load(cfg.immunization.scheduleFile)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	} else {
+		logger.PrintInfo("no immunization schedule configured, using the built-in example schedule", nil)
+	}
+
+	fileStore, err := openStorage(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	exportStore, err := storage.NewLocal(cfg.export.dir)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	notifier := notify.New()
+	notifier.Register(store.ChannelEmail, notify.Email{
+		Mailer: mailer.New(cfg.mail.host, cfg.mail.port, cfg.mail.username, cfg.mail.password, cfg.mail.sender),
+	})
+	if cfg.sms.gatewayURL != "" {
+		notifier.Register(store.ChannelSMS, notify.NewSMSGateway(cfg.sms.gatewayURL, cfg.sms.apiKey, cfg.sms.sender, cfg.sms.timeout))
+	} else {
+		logger.PrintInfo("no sms gateway configured, notifications will only be sent by email", nil)
+	}
+
 	app := &application{
-		config:        cfg,
-		models:        store.NewModels(db),
-		logger:        logger,
-		mailer:        mailer.New(cfg.mail.host, cfg.mail.port, cfg.mail.username, cfg.mail.password, cfg.mail.sender),
-		authenticator: auth.NewJWTAuthenticator(cfg.auth.token.secret, cfg.auth.token.iss, cfg.auth.token.iss),
+		config:               cfg,
+		models:               store.NewModels(db),
+		logger:               logger,
+		notifier:             notifier,
+		authenticator:        auth.NewJWTAuthenticator(cfg.auth.token.secret, cfg.auth.token.iss, cfg.auth.token.iss),
+		queueBroker:          pubsub.New(16),
+		vitalThresholds:      vitalThresholds,
+		prescriptionChecker:  prescriptionChecker,
+		immunizationSchedule: immunizationSchedule,
+		storage:              fileStore,
+		exportStore:          exportStore,
+		webhookSender:        webhook.NewSender(cfg.webhooks.timeout, "hospital-management-webhooks/"+Version),
 
 		// logger2: logger2,
 	}
@@ -119,6 +281,44 @@ func openDB(cfg config) (*sql.DB, error) {
 	return db, nil
 }
 
+// validateConfig checks the settings the background workers and the MLLP listener
+// can't run without. A worker with no concurrency never runs anything, a ticker with
+// no interval panics, and ADT messages can't register patients without a receptionist
+// to add them.
+func validateConfig(cfg config) error {
+	var errs []error
+	positive := func(name string, ok bool) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s must be greater than zero", name))
+		}
+	}
+	positive("JOB_CONCURRENCY", cfg.jobs.concurrency > 0)
+	positive("JOB_POLL_INTERVAL_SECONDS", cfg.jobs.pollInterval > 0)
+	positive("JOB_MAX_ATTEMPTS", cfg.jobs.maxAttempts > 0)
+	positive("JOB_RETRY_BASE_SECONDS", cfg.jobs.retryBase > 0)
+	positive("JOB_RETRY_MAX_MINUTES", cfg.jobs.retryMax > 0)
+	positive("WEBHOOK_POLL_INTERVAL_SECONDS", cfg.webhooks.pollInterval > 0)
+	positive("WEBHOOK_MAX_ATTEMPTS", cfg.webhooks.maxAttempts > 0)
+	positive("WEBHOOK_RETRY_BASE_SECONDS", cfg.webhooks.retryBase > 0)
+	positive("WEBHOOK_RETRY_MAX_MINUTES", cfg.webhooks.retryMax > 0)
+	if cfg.hl7.addr != "" {
+		positive("HL7_RECEPTIONIST_ID", cfg.hl7.receptionistID > 0)
+	}
+	return errors.Join(errs...)
+}
+
+// openStorage sets up the configured backend for uploaded files.
+func openStorage(cfg config) (storage.Store, error) {
+	switch cfg.storage.backend {
+	case "local":
+		return storage.NewLocal(cfg.storage.localDir)
+	case "s3":
+		return storage.NewS3(cfg.storage.s3)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q, must be local or s3", cfg.storage.backend)
+	}
+}
+
 // alias air  ='$(go env GOPATH)/bin/air'
 // direnv allow .
 // migrate create -seq -ext sql -dir ./cmd/migrate/migrations
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -13,30 +14,57 @@ import (
 	store "github.com/muyiwadosunmu/hospital-management/internal/data"
 )
 
-func (app *application) AuthRecTokenMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			app.unauthorizedErrorResponse(w, r, fmt.Errorf("authorization header is missing"))
-			return
-		}
+// BasicAuthMiddleware protects the endpoints used by service integrations, such as the
+// laboratory system posting results, with the configured basic auth credentials.
+func (app *application) BasicAuthMiddleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, pass, ok := r.BasicAuth()
+			if !ok {
+				app.unauthorizedBasicErrorResponse(w, r, fmt.Errorf("authorization header is missing"))
+				return
+			}
 
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			app.unauthorizedErrorResponse(w, r, fmt.Errorf("authorization header is malformed"))
-			return
-		}
+			cfg := app.config.auth.basic
+			// Without configured credentials the integration endpoints stay closed.
+			if cfg.user == "" ||
+				subtle.ConstantTimeCompare([]byte(user), []byte(cfg.user)) != 1 ||
+				subtle.ConstantTimeCompare([]byte(pass), []byte(cfg.pass)) != 1 {
+				app.unauthorizedBasicErrorResponse(w, r, fmt.Errorf("invalid credentials"))
+				return
+			}
 
-		token := parts[1]
-		jwtToken, err := app.authenticator.ValidateToken(token)
-		if err != nil {
-			app.unauthorizedErrorResponse(w, r, err)
-			return
-		}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
 
-		claims, _ := jwtToken.Claims.(jwt.MapClaims)
+// bearerUserID validates the bearer token in the Authorization header and returns the
+// ID of the user it was issued to.
+func (app *application) bearerUserID(r *http.Request) (int64, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return 0, fmt.Errorf("authorization header is missing")
+	}
 
-		userID, err := strconv.ParseInt(fmt.Sprintf("%.f", claims["sub"]), 10, 64)
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return 0, fmt.Errorf("authorization header is malformed")
+	}
+
+	jwtToken, err := app.authenticator.ValidateToken(parts[1])
+	if err != nil {
+		return 0, err
+	}
+
+	claims, _ := jwtToken.Claims.(jwt.MapClaims)
+
+	return strconv.ParseInt(fmt.Sprintf("%.f", claims["sub"]), 10, 64)
+}
+
+func (app *application) AuthRecTokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, err := app.bearerUserID(r)
 		if err != nil {
 			app.unauthorizedErrorResponse(w, r, err)
 			return
@@ -58,28 +86,7 @@ func (app *application) AuthRecTokenMiddleware(next http.Handler) http.Handler {
 
 func (app *application) AuthDocTokenMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			app.unauthorizedErrorResponse(w, r, fmt.Errorf("authorization header is missing"))
-			return
-		}
-
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			app.unauthorizedErrorResponse(w, r, fmt.Errorf("authorization header is malformed"))
-			return
-		}
-
-		token := parts[1]
-		jwtToken, err := app.authenticator.ValidateToken(token)
-		if err != nil {
-			app.unauthorizedErrorResponse(w, r, err)
-			return
-		}
-
-		claims, _ := jwtToken.Claims.(jwt.MapClaims)
-
-		userID, err := strconv.ParseInt(fmt.Sprintf("%.f", claims["sub"]), 10, 64)
+		userID, err := app.bearerUserID(r)
 		if err != nil {
 			app.unauthorizedErrorResponse(w, r, err)
 			return
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/muyiwadosunmu/hospital-management/internal/data"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+type noteKey string
+
+const noteCtx noteKey = "note"
+
+type CreateNotePayload struct {
+	Type        string           `json:"type" validate:"required,oneof=soap progress discharge"`
+	Title       string           `json:"title" validate:"max=255"`
+	Content     data.NoteContent `json:"content"`
+	EncounterID *int64           `json:"encounterId" validate:"omitempty,gt=0"`
+}
+
+func (app *application) getNotesHandler(w http.ResponseWriter, r *http.Request) {
+	var queryDto struct {
+		data.NoteQuery
+		data.Filters
+	}
+	patient := getPatientFromCtx(r)
+	doctor := getDocUserFromContext(r)
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	queryDto.Type = app.readString(qs, "type", "")
+	queryDto.Status = app.readString(qs, "status", "")
+	if encounterID := app.readInt(qs, "encounter_id", 0, v); encounterID > 0 {
+		id := int64(encounterID)
+		queryDto.EncounterID = &id
+	}
+	queryDto.Page = app.readInt(qs, "page", 1, v)
+	queryDto.PageSize = app.readInt(qs, "page_size", 20, v)
+	queryDto.Sort = app.readString(qs, "sort", "-created_at")
+	queryDto.SortSafelist = []string{"created_at", "-created_at", "signed_at", "-signed_at"}
+
+	if queryDto.Type != "" {
+		v.Check(validator.In(queryDto.Type, data.NoteTypes...), "type", "must be soap, progress or discharge")
+	}
+	if queryDto.Status != "" {
+		v.Check(validator.In(queryDto.Status, data.NoteStatusDraft, data.NoteStatusSigned), "status", "must be draft or signed")
+	}
+	if data.ValidateFilters(v, queryDto.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	notes, metadata, err := app.models.Notes.GetForPatient(r.Context(), patient.ID, doctor.ID, queryDto.NoteQuery, queryDto.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": notes, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createNoteHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateNotePayload
+	patient := getPatientFromCtx(r)
+	doctor := getDocUserFromContext(r)
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	note := &data.Note{
+		PatientID:   patient.ID,
+		EncounterID: payload.EncounterID,
+		AuthorID:    doctor.ID,
+		Type:        payload.Type,
+		Title:       strings.TrimSpace(payload.Title),
+		Content:     payload.Content,
+	}
+
+	v := validator.New()
+	if err := app.checkNoteEncounter(r.Context(), v, patient.ID, note.EncounterID); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if data.ValidateNote(v, note); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.models.Notes.Insert(r.Context(), note); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusCreated, envelope{"data": note}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getNoteHandler(w http.ResponseWriter, r *http.Request) {
+	note := getNoteFromCtx(r)
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": note}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateNoteHandler(w http.ResponseWriter, r *http.Request) {
+	note := getNoteFromCtx(r)
+	patient := getPatientFromCtx(r)
+
+	var payload struct {
+		Title       *string           `json:"title" validate:"omitempty,max=255"`
+		Content     *data.NoteContent `json:"content"`
+		EncounterID *int64            `json:"encounterId" validate:"omitempty,gt=0"`
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if payload.Title != nil {
+		note.Title = strings.TrimSpace(*payload.Title)
+	}
+	if payload.Content != nil {
+		note.Content = *payload.Content
+	}
+
+	v := validator.New()
+	if payload.EncounterID != nil {
+		note.EncounterID = payload.EncounterID
+		if err := app.checkNoteEncounter(r.Context(), v, patient.ID, note.EncounterID); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	if data.ValidateNote(v, note); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Notes.Update(r.Context(), note)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": note}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteNoteHandler(w http.ResponseWriter, r *http.Request) {
+	note := getNoteFromCtx(r)
+
+	err := app.models.Notes.DeleteDraft(r.Context(), note.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"message": "note deleted successfully"}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) signNoteHandler(w http.ResponseWriter, r *http.Request) {
+	note := getNoteFromCtx(r)
+	doctor := getDocUserFromContext(r)
+
+	v := validator.New()
+	if data.ValidateNoteForSigning(v, note); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Notes.Sign(r.Context(), note, doctor.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": note}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createAddendumHandler(w http.ResponseWriter, r *http.Request) {
+	note := getNoteFromCtx(r)
+	doctor := getDocUserFromContext(r)
+
+	var payload struct {
+		Text string `json:"text" validate:"required,max=10000"`
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	addendum := &data.Addendum{
+		NoteID:   note.ID,
+		AuthorID: doctor.ID,
+		Text:     strings.TrimSpace(payload.Text),
+	}
+
+	err := app.models.Notes.AddAddendum(r.Context(), addendum)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrNoteNotSigned):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusCreated, envelope{"data": addendum}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// checkNoteEncounter reports on v when the encounter a note is linked to doesn't
+// belong to the patient.
+func (app *application) checkNoteEncounter(ctx context.Context, v *validator.Validator, patientID int64, encounterID *int64) error {
+	if encounterID == nil {
+		return nil
+	}
+	_, err := app.models.Encounters.GetById(ctx, patientID, *encounterID)
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		v.AddError("encounterId", "encounter not found for this patient")
+	case err != nil:
+		return err
+	}
+	return nil
+}
+
+// requireDraftNoteAuthor only lets the author of a draft through. Signed notes are
+// locked for everyone.
+func (app *application) requireDraftNoteAuthor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		note := getNoteFromCtx(r)
+		if note.IsSigned() {
+			app.errorResponse(w, r, http.StatusConflict, data.ErrNoteSigned.Error())
+			return
+		}
+		if note.AuthorID != getDocUserFromContext(r).ID {
+			app.notPermittedResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (app *application) noteContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "noteId"), 10, 64)
+		if err != nil || id < 1 {
+			app.notFoundResponse(w, r)
+			return
+		}
+		ctx := r.Context()
+		patient := getPatientFromCtx(r)
+		doctor := getDocUserFromContext(r)
+
+		note, err := app.models.Notes.GetById(ctx, patient.ID, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		// Other doctors' drafts are treated as if they don't exist.
+		if !note.IsSigned() && note.AuthorID != doctor.ID {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		ctx = context.WithValue(ctx, noteCtx, note)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getNoteFromCtx(r *http.Request) *data.Note {
+	note, _ := r.Context().Value(noteCtx).(*data.Note)
+	return note
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/muyiwadosunmu/hospital-management/internal/data"
+	"github.com/muyiwadosunmu/hospital-management/internal/notify"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+type notificationKey string
+
+const notificationCtx notificationKey = "notification"
+
+// The messages we send. Each channel renders them with its own template of the
+// same name.
+const (
+	tmplUserWelcome       = "user_welcome"
+	tmplDischargeSummary  = "discharge_summary"
+	tmplCriticalLabResult = "lab_critical_result"
+	tmplReferralSent      = "referral_sent"
+	tmplReferralAnswered  = "referral_answered"
+)
+
+// notificationTemplate makes the message of a notification from its arguments when
+// it is sent.
+type notificationTemplate struct {
+	build func(ctx context.Context, n *data.Notification) (notify.Message, error)
+	// sent, if set, is called once the notification has been sent.
+	sent func(ctx context.Context, n *data.Notification) error
+}
+
+func (app *application) notificationTemplates() map[string]notificationTemplate {
+	return map[string]notificationTemplate{
+		tmplUserWelcome:       {build: app.welcomeMessage},
+		tmplDischargeSummary:  {build: app.dischargeSummaryMessage, sent: app.dischargeSummarySent},
+		tmplCriticalLabResult: {build: app.criticalLabResultMessage},
+		tmplReferralSent:      {build: app.referralSentMessage},
+		tmplReferralAnswered:  {build: app.referralAnsweredMessage},
+	}
+}
+
+// channelConsents are the consents a patient must have given to be notified on each
+// channel.
+var channelConsents = map[string]string{
+	data.ChannelEmail: data.ConsentEmail,
+	data.ChannelSMS:   data.ConsentSMS,
+}
+
+// queueNotification records a notification and queues it to be sent once
+// n.SendAfter has passed. args are encoded as JSON for the template's builder.
+func (app *application) queueNotification(ctx context.Context, n *data.Notification, args any) error {
+	raw, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	n.Args = raw
+	if err := app.models.Notifications.Insert(ctx, n); err != nil {
+		return err
+	}
+
+	_, err = app.enqueue(ctx, jobSendNotification, sendNotificationArgs{NotificationID: n.ID}, jobOptions{
+		runAt:     n.SendAfter,
+		uniqueKey: fmt.Sprintf("notification:%d", n.ID),
+	})
+	if err != nil {
+		// Leave a trace in the delivery log of the notification that was never sent.
+		_ = app.models.Notifications.Failed(ctx, n, err)
+		return err
+	}
+	return nil
+}
+
+// notifyStaff emails a member of staff, or anyone else who isn't a patient, straight
+// away. Failing to queue the email doesn't fail the request that caused it.
+func (app *application) notifyStaff(ctx context.Context, email, template string, args any) {
+	n := &data.Notification{Channel: data.ChannelEmail, Recipient: email, Template: template}
+	if err := app.queueNotification(ctx, n, args); err != nil {
+		app.logger.PrintError(err, map[string]string{"template": template})
+	}
+}
+
+// patientChannels returns the channels the patient can be sent the template on:
+// those they want to be notified on, have consented to, and have an address and a
+// provider for.
+func (app *application) patientChannels(ctx context.Context, patient *data.Patient,
+	template string) ([]string, *data.NotificationPreferences, error) {
+	prefs, err := app.models.Preferences.Get(ctx, patient.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	channels := []string{}
+	for _, channel := range prefs.Channels {
+		if !app.notifier.Supports(channel, template) || patientAddress(patient, prefs, channel) == "" {
+			continue
+		}
+		consented, err := app.models.Consents.HasActive(ctx, patient.ID, channelConsents[channel])
+		if err != nil {
+			return nil, nil, err
+		}
+		if consented {
+			channels = append(channels, channel)
+		}
+	}
+	return channels, prefs, nil
+}
+
+func patientAddress(patient *data.Patient, prefs *data.NotificationPreferences, channel string) string {
+	switch channel {
+	case data.ChannelEmail:
+		return patient.Email
+	case data.ChannelSMS:
+		return prefs.Phone
+	default:
+		return ""
+	}
+}
+
+// notifyPatient queues the template to the patient on each channel they can be sent
+// it on, held until the end of their quiet hours if it is due within them. Failing
+// to queue it doesn't fail the request that caused it.
+func (app *application) notifyPatient(ctx context.Context, patient *data.Patient, template string, args any) {
+	properties := map[string]string{"patient_id": strconv.FormatInt(patient.ID, 10), "template": template}
+
+	channels, prefs, err := app.patientChannels(ctx, patient, template)
+	if err != nil {
+		app.logger.PrintError(err, properties)
+		return
+	}
+	for _, channel := range channels {
+		app.queuePatientNotification(ctx, patient, prefs, channel, template, args)
+	}
+}
+
+// emailPatient queues the template to the patient by email whatever channels they
+// prefer, for documents staff send on request. Callers check that the patient has
+// an email address and has consented to email.
+func (app *application) emailPatient(ctx context.Context, patient *data.Patient, template string, args any) {
+	prefs, err := app.models.Preferences.Get(ctx, patient.ID)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"patient_id": strconv.FormatInt(patient.ID, 10),
+			"template": template})
+		return
+	}
+	app.queuePatientNotification(ctx, patient, prefs, data.ChannelEmail, template, args)
+}
+
+func (app *application) queuePatientNotification(ctx context.Context, patient *data.Patient,
+	prefs *data.NotificationPreferences, channel, template string, args any) {
+	n := &data.Notification{
+		PatientID: &patient.ID,
+		Channel:   channel,
+		Recipient: patientAddress(patient, prefs, channel),
+		Template:  template,
+		SendAfter: prefs.NextSendTime(time.Now()),
+	}
+	if err := app.queueNotification(ctx, n, args); err != nil {
+		app.logger.PrintError(err, map[string]string{"patient_id": strconv.FormatInt(patient.ID, 10),
+			"template": template})
+	}
+}
+
+type sendNotificationArgs struct {
+	NotificationID int64 `json:"notificationId"`
+}
+
+// sendNotification is the job that renders and sends a queued notification,
+// recording the outcome in the delivery log.
+func (app *application) sendNotification(ctx context.Context, args sendNotificationArgs) error {
+	n, err := app.models.Notifications.GetById(ctx, 0, args.NotificationID)
+	if err != nil {
+		return err
+	}
+	// An earlier attempt sent it but died before the job was marked complete.
+	if n.Status == data.NotificationSent {
+		return nil
+	}
+
+	template, ok := app.notificationTemplates()[n.Template]
+	if !ok {
+		return fmt.Errorf("unknown notification template %q", n.Template)
+	}
+	msg, err := template.build(ctx, n)
+	if err != nil {
+		return app.notificationFailed(ctx, n, err)
+	}
+	msg.To = n.Recipient
+	msg.Template = n.Template
+
+	ref, err := app.notifier.Send(ctx, n.Channel, msg)
+	if err != nil {
+		return app.notificationFailed(ctx, n, err)
+	}
+	if err := app.models.Notifications.Sent(ctx, n, ref); err != nil {
+		return err
+	}
+	if template.sent != nil {
+		return template.sent(ctx, n)
+	}
+	return nil
+}
+
+// notificationFailed records a failed attempt in the delivery log and returns the
+// error, so the job is retried.
+func (app *application) notificationFailed(ctx context.Context, n *data.Notification, sendErr error) error {
+	if err := app.models.Notifications.Failed(ctx, n, sendErr); err != nil {
+		app.logger.PrintError(err, map[string]string{"notification_id": strconv.FormatInt(n.ID, 10)})
+	}
+	return sendErr
+}
+
+// decodeNotificationArgs decodes the arguments a notification was queued with.
+func decodeNotificationArgs(n *data.Notification, args any) error {
+	if err := json.Unmarshal(n.Args, args); err != nil {
+		return fmt.Errorf("decoding notification arguments: %w", err)
+	}
+	return nil
+}
+
+type welcomeArgs struct {
+	UserID    int64  `json:"userId"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+}
+
+func (app *application) welcomeMessage(ctx context.Context, n *data.Notification) (notify.Message, error) {
+	var args welcomeArgs
+	if err := decodeNotificationArgs(n, &args); err != nil {
+		return notify.Message{}, err
+	}
+	return notify.Message{Data: map[string]interface{}{
+		"userID":    args.UserID,
+		"firstName": args.FirstName,
+		"lastName":  args.LastName,
+		"hospital":  app.config.hospitalName,
+	}}, nil
+}
+
+func (app *application) getNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	patient := getPatientFromCtx(r)
+
+	prefs, err := app.models.Preferences.Get(r.Context(), patient.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": prefs}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+type UpdateNotificationPreferencesPayload struct {
+	Channels   []string `json:"channels" validate:"required"`
+	Phone      string   `json:"phone"`
+	QuietStart string   `json:"quietStart"`
+	QuietEnd   string   `json:"quietEnd"`
+	TimeZone   string   `json:"timeZone"`
+	// Version is that of the preferences being replaced, 0 if the patient has
+	// never set any.
+	Version int64 `json:"version"`
+}
+
+// updateNotificationPreferencesHandler replaces the patient's preferences. Channels
+// only take effect once the patient has given the matching consent.
+func (app *application) updateNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	patient := getPatientFromCtx(r)
+
+	var payload UpdateNotificationPreferencesPayload
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	prefs := &data.NotificationPreferences{
+		PatientID:  patient.ID,
+		Channels:   payload.Channels,
+		Phone:      strings.TrimSpace(payload.Phone),
+		QuietStart: payload.QuietStart,
+		QuietEnd:   payload.QuietEnd,
+		TimeZone:   payload.TimeZone,
+		Version:    payload.Version,
+	}
+	if prefs.TimeZone == "" {
+		prefs.TimeZone = "UTC"
+	}
+
+	v := validator.New()
+	if data.ValidateNotificationPreferences(v, prefs); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Preferences.Put(r.Context(), prefs)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": prefs}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getNotificationsHandler lists the delivery log, of the patient's notifications
+// under a patient's routes.
+func (app *application) getNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	var queryDto struct {
+		Status  string
+		Channel string
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	queryDto.Status = app.readString(qs, "status", "")
+	queryDto.Channel = app.readString(qs, "channel", "")
+	queryDto.Page = app.readInt(qs, "page", 1, v)
+	queryDto.PageSize = app.readInt(qs, "page_size", 20, v)
+	queryDto.Sort = app.readString(qs, "sort", "-created_at")
+	queryDto.SortSafelist = []string{"created_at", "send_after", "id", "-created_at", "-send_after", "-id"}
+
+	v.Check(queryDto.Status == "" || validator.In(queryDto.Status, data.NotificationStatuses...), "status",
+		"must be pending, sent or failed")
+	v.Check(queryDto.Channel == "" || validator.In(queryDto.Channel, data.NotificationChannels...), "channel",
+		"must be email or sms")
+	if data.ValidateFilters(v, queryDto.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var patientID int64
+	if patient := getPatientFromCtx(r); patient != nil {
+		patientID = patient.ID
+	}
+
+	notifications, metadata, err := app.models.Notifications.GetAll(r.Context(), patientID, queryDto.Status,
+		queryDto.Channel, queryDto.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": notifications, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getNotificationHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"data": getNotificationFromCtx(r)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) notificationContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "notificationId"), 10, 64)
+		if err != nil || id < 1 {
+			app.notFoundResponse(w, r)
+			return
+		}
+		ctx := r.Context()
+
+		var patientID int64
+		if patient := getPatientFromCtx(r); patient != nil {
+			patientID = patient.ID
+		}
+
+		notification, err := app.models.Notifications.GetById(ctx, patientID, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, notificationCtx, notification)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getNotificationFromCtx(r *http.Request) *data.Notification {
+	notification, _ := r.Context().Value(notificationCtx).(*data.Notification)
+	return notification
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/muyiwadosunmu/hospital-management/internal/data"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+type patientImportKey string
+
+const patientImportCtx patientImportKey = "patientImport"
+
+// importPatientsHandler accepts a multipart form with the CSV in "file". "mapping" is
+// an optional JSON object of patient fields to column headers, such as
+// {"lastName": "Surname"}. With "dryRun" set the file is only validated and the row
+// report returned. Otherwise the import runs in the background and its status can be
+// followed at the Location returned; rows with errors fail the import unless
+// "skipInvalid" is set.
+func (app *application) importPatientsHandler(w http.ResponseWriter, r *http.Request) {
+	receptionist := getRecUserFromContext(r)
+	maxBytes := app.config.storage.maxUploadBytes
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+64*1024)
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.errorResponse(w, r, http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("file must not be larger than %d bytes", maxBytes))
+		default:
+			app.badRequestResponse(w, r, fmt.Errorf("body must be a multipart form: %w", err))
+		}
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	v := validator.New()
+	mapping := map[string]string{}
+	if raw := strings.TrimSpace(r.FormValue("mapping")); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &mapping); err != nil {
+			v.AddError("mapping", "must be a JSON object of patient fields to column names")
+		}
+	}
+	data.ValidateImportMapping(v, mapping)
+	dryRun := readFormBool(v, r, "dryRun")
+	skipInvalid := readFormBool(v, r, "skipInvalid")
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		v.AddError("file", "must be provided")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	defer file.Close()
+
+	source, err := io.ReadAll(file)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	check, err := data.ReadPatientCSV(bytes.NewReader(source), mapping)
+	if err != nil {
+		v.AddError("file", err.Error())
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if err := app.models.Imports.CheckExisting(r.Context(), check); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if dryRun {
+		if err := app.writeJSON(w, http.StatusOK, envelope{"data": check}, nil); err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	switch {
+	case len(check.Errors) > 0 && !skipInvalid:
+		err = app.writeJSON(w, http.StatusUnprocessableEntity,
+			envelope{"error": data.ErrImportInvalidRows.Error(), "data": check}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	case check.ValidRows == 0:
+		err = app.writeJSON(w, http.StatusUnprocessableEntity,
+			envelope{"error": "the file has no patients to import", "data": check}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	job := &data.PatientImport{
+		ReceptionistID: receptionist.ID,
+		FileName:       uploadFileName(header.Filename),
+		Source:         string(source),
+		Mapping:        mapping,
+		SkipInvalid:    skipInvalid,
+	}
+	if err := app.models.Imports.Insert(r.Context(), job); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	job.TotalRows = check.TotalRows
+
+	_, err = app.enqueue(r.Context(), jobRunPatientImport, patientImportArgs{ImportID: job.ID}, jobOptions{
+		uniqueKey: fmt.Sprintf("patient_import:%d", job.ID),
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := http.Header{"Location": {fmt.Sprintf("/api/v1/receptionists/patient-imports/%d", job.ID)}}
+	if err := app.writeJSON(w, http.StatusAccepted, envelope{"data": job}, headers); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+type patientImportArgs struct {
+	ImportID int64 `json:"importId"`
+}
+
+// runPatientImport runs a queued import. Problems with the file are recorded on the
+// import, so only failing to record them is returned as an error to retry the job.
+func (app *application) runPatientImport(ctx context.Context, args patientImportArgs) error {
+	job, err := app.models.Imports.GetById(ctx, args.ImportID)
+	if err != nil {
+		return err
+	}
+	// The import was finished by an earlier attempt that died before the job was
+	// marked complete.
+	if job.Status != data.ImportPending && job.Status != data.ImportRunning {
+		return nil
+	}
+	if err := app.models.Imports.Run(ctx, job); err != nil {
+		return err
+	}
+	app.logger.PrintInfo("patient import finished", job.Summary())
+	return nil
+}
+
+// readFormBool reads an optional true/false form value.
+func readFormBool(v *validator.Validator, r *http.Request, key string) bool {
+	raw := r.FormValue(key)
+	if raw == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(raw)
+	if err != nil {
+		v.AddError(key, "must be true or false")
+	}
+	return b
+}
+
+func (app *application) getPatientImportsHandler(w http.ResponseWriter, r *http.Request) {
+	var queryDto struct {
+		Status string
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	queryDto.Status = app.readString(qs, "status", "")
+	queryDto.Page = app.readInt(qs, "page", 1, v)
+	queryDto.PageSize = app.readInt(qs, "page_size", 20, v)
+	queryDto.Sort = app.readString(qs, "sort", "-created_at")
+	queryDto.SortSafelist = []string{"created_at", "id", "-created_at", "-id"}
+
+	v.Check(queryDto.Status == "" || validator.In(queryDto.Status, data.ImportStatuses...), "status",
+		"must be pending, running, completed or failed")
+	if data.ValidateFilters(v, queryDto.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	jobs, metadata, err := app.models.Imports.GetAll(r.Context(), queryDto.Status, queryDto.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": jobs, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getPatientImportHandler returns the status and progress of an import, with the
+// report of rows that were not imported.
+func (app *application) getPatientImportHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"data": getPatientImportFromCtx(r)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) patientImportContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "importId"), 10, 64)
+		if err != nil || id < 1 {
+			app.notFoundResponse(w, r)
+			return
+		}
+		ctx := r.Context()
+
+		job, err := app.models.Imports.GetById(ctx, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, patientImportCtx, job)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getPatientImportFromCtx(r *http.Request) *data.PatientImport {
+	job, _ := r.Context().Value(patientImportCtx).(*data.PatientImport)
+	return job
+}
@@ -2,17 +2,27 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/muyiwadosunmu/hospital-management/internal/data"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
 )
 
 type patientKey string
 
 const patientCtx patientKey = "patient"
 
+type RegisterPatientPayload struct {
+	RegisterUserPayload
+	DateOfBirth *data.Date `json:"dateOfBirth"`
+	// Consents are the consents the patient gives at registration, witnessed by the
+	// receptionist registering them.
+	Consents []RecordConsentPayload `json:"consents" validate:"dive"`
+}
+
 func (app *application) registerPatientHandler(w http.ResponseWriter, r *http.Request) {
-	var payload RegisterUserPayload
+	var payload RegisterPatientPayload
 	ctx := r.Context()
 	receptionist := getRecUserFromContext(r)
 	// fmt.Println(receptionist)
@@ -27,11 +37,33 @@ func (app *application) registerPatientHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
+	v := validator.New()
+	data.ValidateDateOfBirth(v, payload.DateOfBirth)
+
+	consents := make([]*data.Consent, len(payload.Consents))
+	seen := map[string]bool{}
+	for i, p := range payload.Consents {
+		consents[i] = newConsent(0, receptionist.ID, p)
+
+		cv := validator.New()
+		data.ValidateConsent(cv, consents[i])
+		cv.Check(!seen[consents[i].Type], "type", "is given more than once")
+		for key, message := range cv.Errors {
+			v.AddError(fmt.Sprintf("consents[%d].%s", i, key), message)
+		}
+		seen[consents[i].Type] = true
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	user := &data.Patient{
-		FirstName: payload.FirstName,
-		LastName:  payload.LastName,
-		Email:     payload.Email,
-		AddedBy:   receptionist,
+		FirstName:   payload.FirstName,
+		LastName:    payload.LastName,
+		Email:       payload.Email,
+		DateOfBirth: payload.DateOfBirth,
+		AddedBy:     receptionist,
 	}
 
 	// hash the user password
@@ -43,7 +75,7 @@ func (app *application) registerPatientHandler(w http.ResponseWriter, r *http.Re
 	}
 
 	// store the user
-	err = app.models.Patients.CreatePatient(ctx, user)
+	err = app.models.Patients.CreateWithConsents(ctx, user, consents)
 	if err != nil {
 		switch err {
 		case data.ErrDuplicateEmail:
@@ -56,21 +88,11 @@ func (app *application) registerPatientHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	// send welcome email
-	app.background(func() {
-		data := map[string]interface{}{
-			"userID":    user.ID,
-			"firstName": user.FirstName,
-			"lastName":  user.LastName,
-		}
-
-		err = app.mailer.Send(user.Email, "user_welcome.tmpl", data)
-		if err != nil {
-			app.logger.PrintError(err, nil)
-		}
-	})
+	// The welcome is only sent on channels the patient agreed to be contacted on.
+	args := welcomeArgs{UserID: user.ID, FirstName: user.FirstName, LastName: user.LastName}
+	app.notifyPatient(ctx, user, tmplUserWelcome, args)
 
-	if err := app.writeJSON(w, http.StatusCreated, envelope{"data": user}, nil); err != nil {
+	if err := app.writeJSON(w, http.StatusCreated, envelope{"data": user, "consents": consents}, nil); err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 
@@ -116,8 +138,9 @@ func (app *application) updatePatientHandler(w http.ResponseWriter, r *http.Requ
 	ctx := r.Context()
 
 	var payload struct {
-		FirstName *string `json:"firstName" validate:"required,min=2,max=100"`
-		LastName  *string `json:"lastName" validate:"required,min=2,max=1000"`
+		FirstName   *string    `json:"firstName" validate:"required,min=2,max=100"`
+		LastName    *string    `json:"lastName" validate:"required,min=2,max=1000"`
+		DateOfBirth *data.Date `json:"dateOfBirth"`
 	}
 
 	err := app.readJSON(w, r, &payload)
@@ -138,6 +161,15 @@ func (app *application) updatePatientHandler(w http.ResponseWriter, r *http.Requ
 	if payload.LastName != nil {
 		patient.LastName = *payload.LastName
 	}
+	if payload.DateOfBirth != nil {
+		patient.DateOfBirth = payload.DateOfBirth
+	}
+
+	v := validator.New()
+	if data.ValidateDateOfBirth(v, patient.DateOfBirth); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 
 	err = app.models.Patients.UpdatePatient(ctx, patient)
 	if err != nil {
@@ -165,6 +197,9 @@ func (app *application) updatePatientDocHandler(w http.ResponseWriter, r *http.R
 		FirstName *string     `json:"firstName" validate:"required,min=2,max=100"`
 		LastName  *string     `json:"lastName" validate:"required,min=2,max=1000"`
 		Data      interface{} `json:"data"`
+		// Diagnoses made during this update. They are added to the
+		// patient's problem list.
+		Diagnoses []DiagnosisPayload `json:"diagnoses" validate:"omitempty,dive"`
 	}
 
 	err := app.readJSON(w, r, &payload)
@@ -190,7 +225,18 @@ func (app *application) updatePatientDocHandler(w http.ResponseWriter, r *http.R
 		patient.Data = payload.Data
 	}
 
-	err = app.models.Patients.UpdatePatientByDoc(ctx, patient)
+	v := validator.New()
+	diagnoses, err := app.buildDiagnoses(ctx, v, patient, getDocUserFromContext(r), payload.Diagnoses)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Patients.UpdatePatientByDoc(ctx, patient, diagnoses)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -201,7 +247,7 @@ func (app *application) updatePatientDocHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"data": patient}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": patient, "diagnoses": diagnoses}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/muyiwadosunmu/hospital-management/internal/data"
+	"github.com/muyiwadosunmu/hospital-management/internal/prescribing"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+type allergyKey string
+type prescriptionKey string
+
+const (
+	allergyCtx      allergyKey      = "allergy"
+	prescriptionCtx prescriptionKey = "prescription"
+)
+
+type CreateAllergyPayload struct {
+	Substance string `json:"substance" validate:"required,max=255"`
+	Reaction  string `json:"reaction" validate:"max=255"`
+	Severity  string `json:"severity" validate:"required,oneof=mild moderate severe"`
+}
+
+type PrescriptionPayload struct {
+	Drug           string     `json:"drug" validate:"required,max=255"`
+	Dose           string     `json:"dose" validate:"required,max=100"`
+	Route          string     `json:"route" validate:"required,max=50"`
+	Frequency      string     `json:"frequency" validate:"required,max=100"`
+	DurationDays   int        `json:"durationDays" validate:"required,gt=0"`
+	Refills        int        `json:"refills" validate:"gte=0"`
+	Instructions   string     `json:"instructions" validate:"max=2000"`
+	StartDate      *data.Date `json:"startDate"`
+	EncounterID    *int64     `json:"encounterId" validate:"omitempty,gt=0"`
+	OverrideReason string     `json:"overrideReason" validate:"max=1000"`
+}
+
+func (app *application) getAllergiesHandler(w http.ResponseWriter, r *http.Request) {
+	patient := getPatientFromCtx(r)
+	all := app.readString(r.URL.Query(), "status", "") == "all"
+
+	allergies, err := app.models.Allergies.GetForPatient(r.Context(), patient.ID, !all)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": allergies}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createAllergyHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateAllergyPayload
+	patient := getPatientFromCtx(r)
+	doctor := getDocUserFromContext(r)
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	allergy := &data.Allergy{
+		PatientID: patient.ID,
+		Substance: strings.TrimSpace(payload.Substance),
+		Reaction:  strings.TrimSpace(payload.Reaction),
+		Severity:  payload.Severity,
+		Status:    data.AllergyStatusActive,
+		DoctorID:  doctor.ID,
+	}
+
+	v := validator.New()
+	if data.ValidateAllergy(v, allergy); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.models.Allergies.Insert(r.Context(), allergy); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusCreated, envelope{"data": allergy}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateAllergyHandler(w http.ResponseWriter, r *http.Request) {
+	allergy := getAllergyFromCtx(r)
+
+	var payload struct {
+		Reaction *string `json:"reaction" validate:"omitempty,max=255"`
+		Severity *string `json:"severity" validate:"omitempty,oneof=mild moderate severe"`
+		Status   *string `json:"status" validate:"omitempty,oneof=active inactive"`
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if payload.Reaction != nil {
+		allergy.Reaction = strings.TrimSpace(*payload.Reaction)
+	}
+	if payload.Severity != nil {
+		allergy.Severity = *payload.Severity
+	}
+	if payload.Status != nil {
+		allergy.Status = *payload.Status
+	}
+
+	v := validator.New()
+	if data.ValidateAllergy(v, allergy); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Allergies.Update(r.Context(), allergy)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": allergy}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getPrescriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	patient := getPatientFromCtx(r)
+	current := app.readString(r.URL.Query(), "status", "") == "current"
+
+	prescriptions, err := app.models.Prescriptions.GetForPatient(r.Context(), patient.ID, current)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": prescriptions}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// checkPrescription runs the drug against the patient's current medications and
+// active allergies.
+func (app *application) checkPrescription(ctx context.Context, patientID int64, drug string) ([]data.PrescriptionWarning, error) {
+	current, err := app.models.Prescriptions.GetForPatient(ctx, patientID, true)
+	if err != nil {
+		return nil, err
+	}
+	allergies, err := app.models.Allergies.GetForPatient(ctx, patientID, true)
+	if err != nil {
+		return nil, err
+	}
+	return app.prescriptionWarnings(drug, current, allergies), nil
+}
+
+// prescriptionWarnings runs the prescribing checks on the patient's current
+// medications and active allergies.
+func (app *application) prescriptionWarnings(drug string, current []*data.Prescription, allergies []*data.Allergy) []data.PrescriptionWarning {
+	active := make([]string, 0, len(current))
+	for _, p := range current {
+		active = append(active, p.Drug)
+	}
+	known := make([]prescribing.Allergy, 0, len(allergies))
+	for _, a := range allergies {
+		known = append(known, prescribing.Allergy{Substance: a.Substance, Severity: a.Severity, Reaction: a.Reaction})
+	}
+
+	found := app.prescriptionChecker.Check(drug, active, known)
+	warnings := make([]data.PrescriptionWarning, 0, len(found))
+	for _, w := range found {
+		warnings = append(warnings, data.PrescriptionWarning(w))
+	}
+	return warnings
+}
+
+// buildPrescription validates the payload. Problems with it are reported on v.
+func (app *application) buildPrescription(ctx context.Context, v *validator.Validator, patient *data.Patient, doctor *data.Doctor, payload PrescriptionPayload) (*data.Prescription, error) {
+	prescription := &data.Prescription{
+		PatientID:      patient.ID,
+		DoctorID:       doctor.ID,
+		EncounterID:    payload.EncounterID,
+		Drug:           strings.TrimSpace(payload.Drug),
+		Dose:           strings.TrimSpace(payload.Dose),
+		Route:          strings.ToLower(payload.Route),
+		Frequency:      strings.TrimSpace(payload.Frequency),
+		DurationDays:   payload.DurationDays,
+		Refills:        payload.Refills,
+		Instructions:   payload.Instructions,
+		StartDate:      data.NewDate(time.Now()),
+		OverrideReason: strings.TrimSpace(payload.OverrideReason),
+	}
+	if payload.StartDate != nil {
+		prescription.StartDate = *payload.StartDate
+	}
+
+	if payload.EncounterID != nil {
+		encounter, err := app.models.Encounters.GetById(ctx, patient.ID, *payload.EncounterID)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("encounterId", "encounter not found for this patient")
+		case err != nil:
+			return nil, err
+		case encounter.IsClosed():
+			v.AddError("encounterId", data.ErrEncounterClosed.Error())
+		}
+	}
+
+	data.ValidatePrescription(v, prescription)
+	return prescription, nil
+}
+
+func (app *application) checkPrescriptionHandler(w http.ResponseWriter, r *http.Request) {
+	patient := getPatientFromCtx(r)
+
+	var payload struct {
+		Drug string `json:"drug" validate:"required,max=255"`
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	warnings, err := app.checkPrescription(r.Context(), patient.ID, strings.TrimSpace(payload.Drug))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": warnings}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createPrescriptionHandler(w http.ResponseWriter, r *http.Request) {
+	var payload PrescriptionPayload
+	patient := getPatientFromCtx(r)
+	doctor := getDocUserFromContext(r)
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	prescription, err := app.buildPrescription(r.Context(), v, patient, doctor, payload)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Prescriptions.Insert(r.Context(), prescription, app.prescriptionWarnings)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnacknowledgedWarnings):
+			// Unacknowledged warnings are a conflict with the patient's record rather
+			// than a malformed request, so they are returned with the warnings themselves.
+			app.errorResponse(w, r, http.StatusConflict, envelope{
+				"message":  "the prescription raised warnings that must be overridden with an overrideReason",
+				"warnings": prescription.Warnings,
+			})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if len(prescription.Warnings) > 0 {
+		app.logger.PrintInfo("prescribing warnings overridden", map[string]string{
+			"prescription_id": strconv.FormatInt(prescription.ID, 10),
+			"doctor_id":       strconv.FormatInt(doctor.ID, 10),
+			"warnings":        strconv.Itoa(len(prescription.Warnings)),
+		})
+	}
+
+	if err := app.writeJSON(w, http.StatusCreated, envelope{"data": prescription}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getPrescriptionHandler(w http.ResponseWriter, r *http.Request) {
+	prescription := getPrescriptionFromCtx(r)
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": prescription}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) discontinuePrescriptionHandler(w http.ResponseWriter, r *http.Request) {
+	prescription := getPrescriptionFromCtx(r)
+
+	var payload struct {
+		Reason string `json:"reason" validate:"required,max=1000"`
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if prescription.Status != data.PrescriptionStatusActive {
+		v := validator.New()
+		v.AddError("status", "prescription has already been discontinued")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Prescriptions.Discontinue(r.Context(), prescription, strings.TrimSpace(payload.Reason))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": prescription}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) allergyContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "allergyId"), 10, 64)
+		if err != nil || id < 1 {
+			app.notFoundResponse(w, r)
+			return
+		}
+		ctx := r.Context()
+		patient := getPatientFromCtx(r)
+
+		allergy, err := app.models.Allergies.GetById(ctx, patient.ID, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, allergyCtx, allergy)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (app *application) prescriptionContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "prescriptionId"), 10, 64)
+		if err != nil || id < 1 {
+			app.notFoundResponse(w, r)
+			return
+		}
+		ctx := r.Context()
+		patient := getPatientFromCtx(r)
+
+		prescription, err := app.models.Prescriptions.GetById(ctx, patient.ID, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, prescriptionCtx, prescription)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getAllergyFromCtx(r *http.Request) *data.Allergy {
+	allergy, _ := r.Context().Value(allergyCtx).(*data.Allergy)
+	return allergy
+}
+
+func getPrescriptionFromCtx(r *http.Request) *data.Prescription {
+	prescription, _ := r.Context().Value(prescriptionCtx).(*data.Prescription)
+	return prescription
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lib/pq"
+	"github.com/muyiwadosunmu/hospital-management/internal/data"
+	"github.com/muyiwadosunmu/hospital-management/internal/pubsub"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+type queueEntryKey string
+
+const queueEntryCtx queueEntryKey = "queueEntry"
+
+// queueEventResync is sent to subscribers when the LISTEN connection to Postgres has
+// been re-established and notifications may have been missed in between.
+const queueEventResync = "queue.resync"
+
+type CheckInPayload struct {
+	PatientID int64  `json:"patientId" validate:"required,gt=0"`
+	Priority  int    `json:"priority" validate:"required,min=1,max=5"`
+	Complaint string `json:"complaint" validate:"max=500"`
+	// DoctorID books the patient in to see a particular doctor, who must be on a
+	// published shift.
+	DoctorID *int64 `json:"doctorId" validate:"omitempty,gt=0"`
+}
+
+func (app *application) checkInPatientHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CheckInPayload
+	ctx := r.Context()
+	receptionist := getRecUserFromContext(r)
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if _, err := app.getPatient(ctx, payload.PatientID); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundRequestResponse(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if payload.DoctorID != nil {
+		v := validator.New()
+		rostered, err := app.models.Shifts.IsRostered(ctx, *payload.DoctorID, time.Now())
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if v.Check(rostered, "doctorId", data.ErrNotRostered.Error()); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+
+	entry := &data.QueueEntry{
+		PatientID:      payload.PatientID,
+		ReceptionistID: receptionist.ID,
+		DoctorID:       payload.DoctorID,
+		Priority:       data.TriagePriority{Level: payload.Priority},
+		Complaint:      payload.Complaint,
+	}
+
+	err := app.models.Queue.CheckIn(ctx, entry)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrAlreadyInQueue):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusCreated, envelope{"data": entry}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getQueueHandler(w http.ResponseWriter, r *http.Request) {
+	entries, err := app.models.Queue.GetActive(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": entries, "priorities": data.TriagePriorities}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getQueueEntryHandler(w http.ResponseWriter, r *http.Request) {
+	entry := getQueueEntryFromCtx(r)
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": entry}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) callNextPatientHandler(w http.ResponseWriter, r *http.Request) {
+	doctor := getDocUserFromContext(r)
+
+	entry, err := app.models.Queue.CallNext(r.Context(), doctor.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrQueueEmpty):
+			app.notFoundRequestResponse(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": entry}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateQueueEntryHandler(w http.ResponseWriter, r *http.Request) {
+	entry := getQueueEntryFromCtx(r)
+	ctx := r.Context()
+
+	var payload struct {
+		Priority *int    `json:"priority" validate:"omitempty,min=1,max=5"`
+		Status   *string `json:"status" validate:"omitempty,oneof=waiting called completed left"`
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if payload.Status != nil {
+		if data.ValidateQueueTransition(v, entry.Status, *payload.Status); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		entry.Status = *payload.Status
+	}
+	if payload.Priority != nil {
+		entry.Priority = data.TriagePriority{Level: *payload.Priority}
+	}
+
+	// Only a doctor taking the patient takes ownership of the entry.
+	var doctorID *int64
+	if doctor := getDocUserFromContext(r); doctor != nil && entry.Status == data.QueueStatusCalled {
+		doctorID = &doctor.ID
+	}
+
+	err := app.models.Queue.Update(ctx, entry, doctorID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": entry}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// queueStreamHandler streams queue changes to the client as Server-Sent Events. The
+// current queue is sent first as a "queue.snapshot" event, followed by an event for
+// every change made through any API instance.
+func (app *application) queueStreamHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	events, unsubscribe := app.queueBroker.Subscribe()
+	defer unsubscribe()
+
+	stream, err := app.startEventStream(w)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	sendSnapshot := func() error {
+		entries, err := app.models.Queue.GetActive(ctx)
+		if err != nil {
+			return err
+		}
+		js, err := json.Marshal(entries)
+		if err != nil {
+			return err
+		}
+		return stream.send("queue.snapshot", js)
+	}
+
+	if err := sendSnapshot(); err != nil {
+		app.logError(r, err)
+		return
+	}
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-events:
+			// The broker closes the channel when we fall too far behind or when the
+			// server is shutting down. Either way the client should reconnect.
+			if !ok {
+				return
+			}
+			if msg.Event == queueEventResync {
+				err = sendSnapshot()
+			} else {
+				err = stream.send(msg.Event, msg.Data)
+			}
+			if err != nil {
+				app.logError(r, err)
+				return
+			}
+		case <-heartbeat.C:
+			if err := stream.ping(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// relayNotifications LISTENs on a Postgres channel and publishes every notification
+// to the broker until ctx is cancelled. Notification payloads must be JSON objects
+// with a "type" field, which is used as the event name.
+func (app *application) relayNotifications(ctx context.Context, channel string, broker *pubsub.Broker, resyncEvent string) {
+	listener := pq.NewListener(app.config.db.addr, 10*time.Second, time.Minute,
+		func(event pq.ListenerEventType, err error) {
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{"channel": channel})
+			}
+		})
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	if err := listener.Listen(channel); err != nil {
+		if ctx.Err() == nil {
+			app.logger.PrintError(err, map[string]string{"channel": channel})
+		}
+		return
+	}
+
+	app.logger.PrintInfo("listening for notifications", map[string]string{"channel": channel})
+
+	ping := time.NewTicker(90 * time.Second)
+	defer ping.Stop()
+
+	for {
+		select {
+		case n, ok := <-listener.Notify:
+			if !ok {
+				return
+			}
+			// A nil notification means the connection was lost and re-established.
+			if n == nil {
+				broker.Publish(pubsub.Message{Event: resyncEvent})
+				continue
+			}
+
+			var event struct {
+				Type string `json:"type"`
+			}
+			if err := json.Unmarshal([]byte(n.Extra), &event); err != nil {
+				app.logger.PrintError(err, map[string]string{"channel": channel})
+				continue
+			}
+			broker.Publish(pubsub.Message{Event: event.Type, Data: []byte(n.Extra)})
+		case <-ping.C:
+			go listener.Ping()
+		}
+	}
+}
+
+func (app *application) queueEntryContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "entryId"), 10, 64)
+		if err != nil || id < 1 {
+			app.notFoundResponse(w, r)
+			return
+		}
+		ctx := r.Context()
+
+		entry, err := app.models.Queue.GetById(ctx, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, queueEntryCtx, entry)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getQueueEntryFromCtx(r *http.Request) *data.QueueEntry {
+	entry, _ := r.Context().Value(queueEntryCtx).(*data.QueueEntry)
+	return entry
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/muyiwadosunmu/hospital-management/internal/data"
+	"github.com/muyiwadosunmu/hospital-management/internal/notify"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+type referralKey string
+
+const referralCtx referralKey = "referral"
+
+type CreateReferralPayload struct {
+	RecipientID      *int64  `json:"recipientId" validate:"omitempty,gt=0"`
+	ExternalFacility string  `json:"externalFacility" validate:"max=255"`
+	ExternalEmail    string  `json:"externalEmail" validate:"omitempty,email,max=255"`
+	Urgency          string  `json:"urgency"`
+	Reason           string  `json:"reason" validate:"required,max=5000"`
+	AttachmentIDs    []int64 `json:"attachmentIds" validate:"max=20,dive,gt=0"`
+}
+
+// readReferralFilters reads the status, paging and sorting shared by the referral
+// lists.
+func (app *application) readReferralFilters(r *http.Request, v *validator.Validator) (string, data.Filters) {
+	var filters data.Filters
+	qs := r.URL.Query()
+
+	status := app.readString(qs, "status", "")
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "-sent_at")
+	filters.SortSafelist = []string{"sent_at", "-sent_at"}
+
+	if status != "" {
+		v.Check(validator.In(status, data.ReferralStatuses...), "status", "must be sent, accepted, declined or completed")
+	}
+	data.ValidateFilters(v, filters)
+	return status, filters
+}
+
+func (app *application) getReferralsHandler(w http.ResponseWriter, r *http.Request) {
+	patient := getPatientFromCtx(r)
+
+	v := validator.New()
+	status, filters := app.readReferralFilters(r, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	referrals, metadata, err := app.models.Referrals.GetForPatient(r.Context(), patient.ID, status, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": referrals, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createReferralHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateReferralPayload
+	patient := getPatientFromCtx(r)
+	doctor := getDocUserFromContext(r)
+	ctx := r.Context()
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	referral := &data.Referral{
+		PatientID:        patient.ID,
+		SenderID:         doctor.ID,
+		RecipientID:      payload.RecipientID,
+		ExternalFacility: strings.TrimSpace(payload.ExternalFacility),
+		ExternalEmail:    strings.TrimSpace(payload.ExternalEmail),
+		Urgency:          payload.Urgency,
+		Reason:           strings.TrimSpace(payload.Reason),
+		AttachmentIDs:    payload.AttachmentIDs,
+	}
+	if referral.Urgency == "" {
+		referral.Urgency = "routine"
+	}
+
+	v := validator.New()
+	data.ValidateReferral(v, referral)
+	if referral.RecipientID != nil && v.Valid() {
+		_, err := app.models.Doctors.GetById(ctx, *referral.RecipientID)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("recipientId", "doctor not found")
+		case err != nil:
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	// Emailing the referral outside the hospital shares the patient's details, which
+	// needs their consent.
+	if referral.ExternalEmail != "" && v.Valid() {
+		consented, err := app.models.Consents.HasActive(ctx, patient.ID, data.ConsentDataSharing)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		v.Check(consented, "externalEmail", "patient has not consented to their data being shared")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Referrals.Insert(ctx, referral)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrReferralAttachment):
+			v.AddError("attachmentIds", err.Error())
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.notifyReferralSent(ctx, referral)
+
+	if err := app.writeJSON(w, http.StatusCreated, envelope{"data": referral}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getReferralHandler(w http.ResponseWriter, r *http.Request) {
+	referral := getReferralFromCtx(r)
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": referral}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// referralInboxHandler lists the referrals sent to the doctor.
+func (app *application) referralInboxHandler(w http.ResponseWriter, r *http.Request) {
+	doctor := getDocUserFromContext(r)
+
+	v := validator.New()
+	status, filters := app.readReferralFilters(r, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	referrals, metadata, err := app.models.Referrals.Inbox(r.Context(), doctor.ID, status, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": referrals, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// sentReferralsHandler lists the referrals the doctor has sent.
+func (app *application) sentReferralsHandler(w http.ResponseWriter, r *http.Request) {
+	doctor := getDocUserFromContext(r)
+
+	v := validator.New()
+	status, filters := app.readReferralFilters(r, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	referrals, metadata, err := app.models.Referrals.Sent(r.Context(), doctor.ID, status, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": referrals, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateReferralStatusHandler answers a referral. Only the receiving doctor can answer
+// a referral to them; for external referrals the sender records the facility's
+// answer.
+func (app *application) updateReferralStatusHandler(w http.ResponseWriter, r *http.Request) {
+	referral := getReferralFromCtx(r)
+	doctor := getDocUserFromContext(r)
+
+	var payload struct {
+		Status string `json:"status" validate:"required"`
+		Note   string `json:"note" validate:"max=5000"`
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	answerer := referral.SenderID
+	if !referral.IsExternal() {
+		answerer = *referral.RecipientID
+	}
+	if doctor.ID != answerer {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	note := strings.TrimSpace(payload.Note)
+	v := validator.New()
+	data.ValidateReferralTransition(v, referral.Status, payload.Status)
+	if payload.Status == data.ReferralDeclined {
+		v.Check(note != "", "note", "a reason must be given when declining a referral")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Referrals.UpdateStatus(r.Context(), referral, payload.Status, note)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if doctor.ID != referral.SenderID {
+		app.notifyReferralAnswered(r.Context(), referral, doctor.ID)
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": referral}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+type referralArgs struct {
+	PatientID  int64 `json:"patientId"`
+	ReferralID int64 `json:"referralId"`
+	// AnsweredBy is the doctor who changed the referral's status.
+	AnsweredBy int64 `json:"answeredBy,omitempty"`
+}
+
+// notifyReferralSent emails the receiving doctor, or the external facility when an
+// address was given.
+func (app *application) notifyReferralSent(ctx context.Context, referral *data.Referral) {
+	email := referral.ExternalEmail
+	if !referral.IsExternal() {
+		recipient, err := app.models.Doctors.GetById(ctx, *referral.RecipientID)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"template": tmplReferralSent})
+			return
+		}
+		email = recipient.Email
+	}
+	if email == "" {
+		return
+	}
+	args := referralArgs{PatientID: referral.PatientID, ReferralID: referral.ID}
+	app.notifyStaff(ctx, email, tmplReferralSent, args)
+}
+
+// notifyReferralAnswered emails the referring doctor when the referral is accepted,
+// declined or completed.
+func (app *application) notifyReferralAnswered(ctx context.Context, referral *data.Referral, answeredBy int64) {
+	sender, err := app.models.Doctors.GetById(ctx, referral.SenderID)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"template": tmplReferralAnswered})
+		return
+	}
+	args := referralArgs{PatientID: referral.PatientID, ReferralID: referral.ID, AnsweredBy: answeredBy}
+	app.notifyStaff(ctx, sender.Email, tmplReferralAnswered, args)
+}
+
+func (app *application) referralSentMessage(ctx context.Context, n *data.Notification) (notify.Message, error) {
+	var args referralArgs
+	if err := decodeNotificationArgs(n, &args); err != nil {
+		return notify.Message{}, err
+	}
+	referral, err := app.models.Referrals.GetById(ctx, args.PatientID, args.ReferralID)
+	if err != nil {
+		return notify.Message{}, err
+	}
+	sender, err := app.models.Doctors.GetById(ctx, referral.SenderID)
+	if err != nil {
+		return notify.Message{}, err
+	}
+	patient, err := app.models.Patients.GetPatientById(ctx, referral.PatientID)
+	if err != nil {
+		return notify.Message{}, err
+	}
+
+	recipientName := referral.ExternalFacility
+	if !referral.IsExternal() {
+		recipient, err := app.models.Doctors.GetById(ctx, *referral.RecipientID)
+		if err != nil {
+			return notify.Message{}, err
+		}
+		recipientName = "Dr " + recipient.LastName
+	}
+
+	return notify.Message{Data: map[string]interface{}{
+		"recipientName": recipientName,
+		"senderName":    "Dr " + sender.FirstName + " " + sender.LastName,
+		"hospital":      app.config.hospitalName,
+		"referralID":    referral.ID,
+		"patientName":   patient.FirstName + " " + patient.LastName,
+		"dateOfBirth":   patient.DateOfBirth,
+		"urgency":       referral.Urgency,
+		"reason":        referral.Reason,
+		"external":      referral.IsExternal(),
+	}}, nil
+}
+
+func (app *application) referralAnsweredMessage(ctx context.Context, n *data.Notification) (notify.Message, error) {
+	var args referralArgs
+	if err := decodeNotificationArgs(n, &args); err != nil {
+		return notify.Message{}, err
+	}
+	referral, err := app.models.Referrals.GetById(ctx, args.PatientID, args.ReferralID)
+	if err != nil {
+		return notify.Message{}, err
+	}
+	sender, err := app.models.Doctors.GetById(ctx, referral.SenderID)
+	if err != nil {
+		return notify.Message{}, err
+	}
+	answeredBy, err := app.models.Doctors.GetById(ctx, args.AnsweredBy)
+	if err != nil {
+		return notify.Message{}, err
+	}
+	patient, err := app.models.Patients.GetPatientById(ctx, referral.PatientID)
+	if err != nil {
+		return notify.Message{}, err
+	}
+
+	return notify.Message{Data: map[string]interface{}{
+		"senderLastName": sender.LastName,
+		"answeredBy":     "Dr " + answeredBy.FirstName + " " + answeredBy.LastName,
+		"referralID":     referral.ID,
+		"patientName":    patient.FirstName + " " + patient.LastName,
+		"status":         referral.Status,
+		"note":           referral.ResponseNote,
+	}}, nil
+}
+
+// referralContextMiddleware loads the referral in the URL. Under a patient's routes
+// any doctor may see the patient's referrals; elsewhere only the sender and the
+// receiving doctor can.
+func (app *application) referralContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "referralId"), 10, 64)
+		if err != nil || id < 1 {
+			app.notFoundResponse(w, r)
+			return
+		}
+		ctx := r.Context()
+
+		var patientID int64
+		if patient := getPatientFromCtx(r); patient != nil {
+			patientID = patient.ID
+		}
+
+		referral, err := app.models.Referrals.GetById(ctx, patientID, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if patientID == 0 {
+			doctor := getDocUserFromContext(r)
+			isRecipient := referral.RecipientID != nil && *referral.RecipientID == doctor.ID
+			if referral.SenderID != doctor.ID && !isRecipient {
+				app.notFoundResponse(w, r)
+				return
+			}
+		}
+
+		ctx = context.WithValue(ctx, referralCtx, referral)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getReferralFromCtx(r *http.Request) *data.Referral {
+	referral, _ := r.Context().Value(referralCtx).(*data.Referral)
+	return referral
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/muyiwadosunmu/hospital-management/internal/data"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+type researchStudyKey string
+
+const researchStudyCtx researchStudyKey = "researchStudy"
+
+type CreateResearchStudyPayload struct {
+	Name        string   `json:"name" validate:"required,max=255"`
+	Description string   `json:"description" validate:"max=2000"`
+	Types       []string `json:"types"`
+	DataFields  []string `json:"dataFields"`
+}
+
+func (app *application) createResearchStudyHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateResearchStudyPayload
+	receptionist := getRecUserFromContext(r)
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	study := &data.ResearchStudy{
+		Name:        strings.TrimSpace(payload.Name),
+		Description: strings.TrimSpace(payload.Description),
+		Types:       payload.Types,
+		DataFields:  payload.DataFields,
+		CreatedBy:   receptionist.ID,
+	}
+	if study.DataFields == nil {
+		study.DataFields = []string{}
+	}
+
+	v := validator.New()
+	if data.ValidateResearchStudy(v, study); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Studies.Insert(r.Context(), study)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateStudy):
+			v.AddError("name", err.Error())
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusCreated, envelope{"data": study}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getResearchStudiesHandler(w http.ResponseWriter, r *http.Request) {
+	var queryDto struct {
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	queryDto.Page = app.readInt(qs, "page", 1, v)
+	queryDto.PageSize = app.readInt(qs, "page_size", 20, v)
+	queryDto.Sort = app.readString(qs, "sort", "name")
+	queryDto.SortSafelist = []string{"name", "created_at", "id", "-name", "-created_at", "-id"}
+
+	if data.ValidateFilters(v, queryDto.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	studies, metadata, err := app.models.Studies.GetAll(r.Context(), queryDto.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": studies, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getResearchStudyHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"data": getResearchStudyFromCtx(r)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateResearchStudyHandler changes what later exports for the study contain.
+// Exports already written are unaffected.
+func (app *application) updateResearchStudyHandler(w http.ResponseWriter, r *http.Request) {
+	study := getResearchStudyFromCtx(r)
+
+	var payload struct {
+		Name        *string  `json:"name" validate:"omitempty,max=255"`
+		Description *string  `json:"description" validate:"omitempty,max=2000"`
+		Types       []string `json:"types"`
+		DataFields  []string `json:"dataFields"`
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if payload.Name != nil {
+		study.Name = strings.TrimSpace(*payload.Name)
+	}
+	if payload.Description != nil {
+		study.Description = strings.TrimSpace(*payload.Description)
+	}
+	if payload.Types != nil {
+		study.Types = payload.Types
+	}
+	if payload.DataFields != nil {
+		study.DataFields = payload.DataFields
+	}
+
+	v := validator.New()
+	if data.ValidateResearchStudy(v, study); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Studies.Update(r.Context(), study)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateStudy):
+			v.AddError("name", err.Error())
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": study}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+type CreateResearchExportPayload struct {
+	Since *time.Time `json:"since"`
+	Until *time.Time `json:"until"`
+}
+
+// createResearchExportHandler starts a de-identified export of the study's types for
+// patients with active research consent. It runs like any other export, and its
+// status is at Content-Location.
+func (app *application) createResearchExportHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateResearchExportPayload
+	receptionist := getRecUserFromContext(r)
+	study := getResearchStudyFromCtx(r)
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	export := &data.Export{
+		ReceptionistID: &receptionist.ID,
+		StudyID:        &study.ID,
+		Types:          study.Types,
+		Since:          payload.Since,
+		Until:          payload.Until,
+	}
+
+	v := validator.New()
+	if data.ValidateExport(v, export); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	app.deleteExpiredExports(r.Context())
+
+	if err := app.models.Exports.Insert(r.Context(), export); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	_, err := app.enqueue(r.Context(), jobRunExport, exportArgs{ExportID: export.ID}, jobOptions{
+		uniqueKey: fmt.Sprintf("export:%d", export.ID),
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := http.Header{"Content-Location": {fmt.Sprintf("/api/v1/receptionists/exports/%d", export.ID)}}
+	if err := app.writeJSON(w, http.StatusAccepted, envelope{"data": export}, headers); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getResearchExportsHandler(w http.ResponseWriter, r *http.Request) {
+	study := getResearchStudyFromCtx(r)
+
+	var queryDto struct {
+		Status string
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	queryDto.Status = app.readString(qs, "status", "")
+	queryDto.Page = app.readInt(qs, "page", 1, v)
+	queryDto.PageSize = app.readInt(qs, "page_size", 20, v)
+	queryDto.Sort = app.readString(qs, "sort", "-created_at")
+	queryDto.SortSafelist = []string{"created_at", "id", "-created_at", "-id"}
+
+	v.Check(queryDto.Status == "" || validator.In(queryDto.Status, data.ExportStatuses...), "status",
+		"must be pending, running, completed, failed or expired")
+	if data.ValidateFilters(v, queryDto.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	exports, metadata, err := app.models.Exports.GetAll(r.Context(), getRecUserFromContext(r).ID, 0, queryDto.Status, study.ID,
+		queryDto.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": exports, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// exportResearchBundle writes a zip of a de-identified CSV file per type and a data
+// dictionary describing every column, then moves it into export storage.
+func (app *application) exportResearchBundle(ctx context.Context, export *data.Export) error {
+	study, err := app.models.Studies.GetById(ctx, *export.StudyID)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp("", "export-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	zw := zip.NewWriter(tmp)
+	for _, exportType := range export.Types {
+		export.CurrentType = exportType
+		if err := app.models.Exports.Progress(ctx, export); err != nil {
+			return err
+		}
+		if err := app.writeResearchTable(ctx, zw, study, export, exportType); err != nil {
+			return fmt.Errorf("exporting %s: %w", exportType, err)
+		}
+		export.ExportedTypes++
+	}
+	dictionary := data.ResearchDictionary(export.Types, study.DataFields)
+	if err := writeCSVEntry(zw, "data_dictionary.csv", dictionary); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	info, err := tmp.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, 0); err != nil {
+		return err
+	}
+
+	file := data.ExportFile{
+		Type:        data.ResearchBundle,
+		Name:        data.ResearchBundleName,
+		ContentType: "application/zip",
+		Count:       export.ExportedRecords,
+		Size:        info.Size(),
+	}
+	err = app.exportStore.Put(ctx, data.ExportFileKey(export.ID, file.Name), tmp, file.Size, file.ContentType)
+	if err != nil {
+		return err
+	}
+	export.Output = append(export.Output, file)
+	return nil
+}
+
+func (app *application) writeResearchTable(ctx context.Context, zw *zip.Writer, study *data.ResearchStudy,
+	export *data.Export, exportType string) error {
+	header, err := data.ResearchTableHeader(exportType)
+	if err != nil {
+		return err
+	}
+	entry, err := zw.Create(exportType + ".csv")
+	if err != nil {
+		return err
+	}
+
+	cw := csv.NewWriter(entry)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	err = app.models.Studies.Stream(ctx, study, export, exportType, func(record []string) error {
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+		export.ExportedRecords++
+		if export.ExportedRecords%exportProgressInterval == 0 {
+			return app.models.Exports.Progress(ctx, export)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeCSVEntry(zw *zip.Writer, name string, records [][]string) error {
+	entry, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(entry)
+	if err := cw.WriteAll(records); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+func (app *application) researchStudyContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "studyId"), 10, 64)
+		if err != nil || id < 1 {
+			app.notFoundResponse(w, r)
+			return
+		}
+		ctx := r.Context()
+
+		study, err := app.models.Studies.GetById(ctx, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, researchStudyCtx, study)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getResearchStudyFromCtx(r *http.Request) *data.ResearchStudy {
+	study, _ := r.Context().Value(researchStudyCtx).(*data.ResearchStudy)
+	return study
+}
@@ -0,0 +1,673 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/muyiwadosunmu/hospital-management/internal/data"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+type shiftKey string
+
+const shiftCtx shiftKey = "shift"
+
+type swapKey string
+
+const swapCtx swapKey = "swap"
+
+type ShiftPayload struct {
+	StaffType    string    `json:"staffType" validate:"required,oneof=doctor receptionist"`
+	StaffID      int64     `json:"staffId" validate:"required,gt=0"`
+	DepartmentID int64     `json:"departmentId" validate:"required,gt=0"`
+	Type         string    `json:"type" validate:"required"`
+	StartsAt     time.Time `json:"startsAt" validate:"required"`
+	EndsAt       time.Time `json:"endsAt" validate:"required"`
+	Notes        string    `json:"notes" validate:"max=2000"`
+}
+
+// startOfWeek returns midnight UTC on the Monday of t's week.
+func startOfWeek(t time.Time) time.Time {
+	t = t.UTC()
+	offset := (int(t.Weekday()) + 6) % 7
+	return time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, time.UTC)
+}
+
+// checkShiftReferences adds validation errors for a shift whose member of staff or
+// department doesn't exist.
+func (app *application) checkShiftReferences(ctx context.Context, v *validator.Validator, shift *data.Shift) error {
+	var err error
+	switch shift.StaffType {
+	case data.StaffDoctor:
+		_, err = app.models.Doctors.GetById(ctx, shift.StaffID)
+	case data.StaffReceptionist:
+		_, err = app.models.Receptionists.GetById(ctx, shift.StaffID)
+	}
+	if err != nil {
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			return err
+		}
+		v.AddError("staffId", shift.StaffType+" does not exist")
+	}
+
+	if _, err := app.models.Departments.GetById(ctx, shift.DepartmentID); err != nil {
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			return err
+		}
+		v.AddError("departmentId", "department does not exist")
+	}
+	return nil
+}
+
+// getRotaHandler lists shifts, drafts included, for the week starting on `from`
+// unless `to` says otherwise.
+func (app *application) getRotaHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	query := data.ShiftQuery{
+		DepartmentID:  int64(app.readInt(qs, "department_id", 0, v)),
+		StaffType:     app.readString(qs, "staff_type", ""),
+		StaffID:       int64(app.readInt(qs, "staff_id", 0, v)),
+		From:          startOfWeek(time.Now()),
+		PublishedOnly: app.readString(qs, "published", "") == "true",
+	}
+	if t, err := app.readDateParam(qs, "from"); err != nil {
+		v.AddError("from", err.Error())
+	} else if t != nil {
+		query.From = *t
+	}
+	query.To = query.From.AddDate(0, 0, 7)
+	if t, err := app.readDateParam(qs, "to"); err != nil {
+		v.AddError("to", err.Error())
+	} else if t != nil {
+		query.To = t.AddDate(0, 0, 1)
+	}
+
+	v.Check(query.StaffType == "" || validator.In(query.StaffType, data.StaffTypes...), "staff_type",
+		"must be doctor or receptionist")
+	v.Check(query.StaffID == 0 || query.StaffType != "", "staff_type", "must be provided with staff_id")
+	v.Check(query.To.After(query.From), "to", "must not be before from")
+	v.Check(query.To.Sub(query.From) <= 62*24*time.Hour, "to", "must be within 62 days of from")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	shifts, err := app.models.Shifts.GetAll(r.Context(), query)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": shifts, "from": query.From, "to": query.To}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createShiftHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ShiftPayload
+	ctx := r.Context()
+	receptionist := getRecUserFromContext(r)
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	shift := &data.Shift{
+		StaffType:    payload.StaffType,
+		StaffID:      payload.StaffID,
+		DepartmentID: payload.DepartmentID,
+		Type:         payload.Type,
+		StartsAt:     payload.StartsAt,
+		EndsAt:       payload.EndsAt,
+		Notes:        strings.TrimSpace(payload.Notes),
+		CreatedBy:    receptionist.ID,
+	}
+
+	v := validator.New()
+	if err := app.checkShiftReferences(ctx, v, shift); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if data.ValidateShift(v, shift); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Shifts.Insert(ctx, shift)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrShiftOverlap):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusCreated, envelope{"data": shift}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getShiftHandler(w http.ResponseWriter, r *http.Request) {
+	shift := getShiftFromCtx(r)
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": shift}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateShiftHandler(w http.ResponseWriter, r *http.Request) {
+	shift := getShiftFromCtx(r)
+	ctx := r.Context()
+
+	var payload struct {
+		StaffType    *string    `json:"staffType" validate:"omitempty,oneof=doctor receptionist"`
+		StaffID      *int64     `json:"staffId" validate:"omitempty,gt=0"`
+		DepartmentID *int64     `json:"departmentId" validate:"omitempty,gt=0"`
+		Type         *string    `json:"type"`
+		StartsAt     *time.Time `json:"startsAt"`
+		EndsAt       *time.Time `json:"endsAt"`
+		Notes        *string    `json:"notes" validate:"omitempty,max=2000"`
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if payload.StaffType != nil {
+		shift.StaffType = *payload.StaffType
+	}
+	if payload.StaffID != nil {
+		shift.StaffID = *payload.StaffID
+	}
+	if payload.DepartmentID != nil {
+		shift.DepartmentID = *payload.DepartmentID
+	}
+	if payload.Type != nil {
+		shift.Type = *payload.Type
+	}
+	if payload.StartsAt != nil {
+		shift.StartsAt = *payload.StartsAt
+	}
+	if payload.EndsAt != nil {
+		shift.EndsAt = *payload.EndsAt
+	}
+	if payload.Notes != nil {
+		shift.Notes = strings.TrimSpace(*payload.Notes)
+	}
+
+	v := validator.New()
+	if err := app.checkShiftReferences(ctx, v, shift); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if data.ValidateShift(v, shift); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Shifts.Update(ctx, shift)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrShiftOverlap):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Re-read the shift so that the staff name follows a change of staff.
+	updated, err := app.models.Shifts.GetById(ctx, shift.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": updated}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteShiftHandler(w http.ResponseWriter, r *http.Request) {
+	shift := getShiftFromCtx(r)
+
+	err := app.models.Shifts.Delete(r.Context(), shift.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"message": "shift deleted"}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// publishRotaHandler publishes a department's week. Shifts added to the week later
+// are published as they are created.
+func (app *application) publishRotaHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		DepartmentID int64     `json:"departmentId" validate:"required,gt=0"`
+		WeekStart    data.Date `json:"weekStart" validate:"required"`
+	}
+	ctx := r.Context()
+	receptionist := getRecUserFromContext(r)
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(payload.WeekStart.Weekday() == time.Monday, "weekStart", "must be a Monday")
+	if _, err := app.models.Departments.GetById(ctx, payload.DepartmentID); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("departmentId", "department does not exist")
+		default:
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	published, err := app.models.Shifts.Publish(ctx, payload.DepartmentID, payload.WeekStart, receptionist.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": envelope{
+		"departmentId": payload.DepartmentID,
+		"weekStart":    payload.WeekStart,
+		"published":    published,
+	}}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getOnCallHandler returns who is working in the department right now, with the
+// on-call shifts first.
+func (app *application) getOnCallHandler(w http.ResponseWriter, r *http.Request) {
+	department := getDepartmentFromCtx(r)
+	now := time.Now()
+
+	shifts, err := app.models.Shifts.OnDuty(r.Context(), department.ID, now)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	onCall := []*data.Shift{}
+	onDuty := []*data.Shift{}
+	for _, s := range shifts {
+		if s.Type == data.ShiftOnCall {
+			onCall = append(onCall, s)
+		} else {
+			onDuty = append(onDuty, s)
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": envelope{
+		"department": department,
+		"at":         now,
+		"onCall":     onCall,
+		"onDuty":     onDuty,
+	}}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getMyShiftsHandler lists the signed in doctor's published shifts, from this
+// week's Monday for four weeks unless from and to are given.
+func (app *application) getMyShiftsHandler(w http.ResponseWriter, r *http.Request) {
+	doctor := getDocUserFromContext(r)
+	v := validator.New()
+	qs := r.URL.Query()
+
+	query := data.ShiftQuery{
+		StaffType:     data.StaffDoctor,
+		StaffID:       doctor.ID,
+		From:          startOfWeek(time.Now()),
+		PublishedOnly: true,
+	}
+	if t, err := app.readDateParam(qs, "from"); err != nil {
+		v.AddError("from", err.Error())
+	} else if t != nil {
+		query.From = *t
+	}
+	query.To = query.From.AddDate(0, 0, 28)
+	if t, err := app.readDateParam(qs, "to"); err != nil {
+		v.AddError("to", err.Error())
+	} else if t != nil {
+		query.To = t.AddDate(0, 0, 1)
+	}
+
+	v.Check(query.To.After(query.From), "to", "must not be before from")
+	v.Check(query.To.Sub(query.From) <= 62*24*time.Hour, "to", "must be within 62 days of from")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	shifts, err := app.models.Shifts.GetAll(r.Context(), query)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": shifts}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// requestSwapHandler asks for one of the signed in doctor's published shifts to be
+// given to another doctor, optionally in exchange for one of theirs.
+func (app *application) requestSwapHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		TargetStaffID int64  `json:"targetStaffId" validate:"required,gt=0"`
+		TargetShiftID *int64 `json:"targetShiftId" validate:"omitempty,gt=0"`
+		Reason        string `json:"reason" validate:"max=2000"`
+	}
+	ctx := r.Context()
+	doctor := getDocUserFromContext(r)
+	shift := getShiftFromCtx(r)
+
+	if shift.StaffType != data.StaffDoctor || shift.StaffID != doctor.ID || shift.PublishedAt == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if _, err := app.models.Doctors.GetById(ctx, payload.TargetStaffID); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("targetStaffId", "doctor does not exist")
+		default:
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	var target *data.Shift
+	if payload.TargetShiftID != nil {
+		var err error
+		target, err = app.models.Shifts.GetById(ctx, *payload.TargetShiftID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("targetShiftId", "shift does not exist")
+			default:
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+	}
+
+	swap := &data.ShiftSwap{
+		ShiftID:       shift.ID,
+		StaffType:     shift.StaffType,
+		RequesterID:   doctor.ID,
+		TargetStaffID: payload.TargetStaffID,
+		TargetShiftID: payload.TargetShiftID,
+		Reason:        strings.TrimSpace(payload.Reason),
+	}
+
+	if data.ValidateShiftSwap(v, swap, shift, target); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Swaps.Insert(ctx, swap)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrSwapPending):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusCreated, envelope{"data": swap}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getSwapsHandler(w http.ResponseWriter, r *http.Request) {
+	app.listSwaps(w, r, "", 0)
+}
+
+// getMySwapsHandler lists the swaps the signed in doctor has asked for or been asked
+// to take.
+func (app *application) getMySwapsHandler(w http.ResponseWriter, r *http.Request) {
+	app.listSwaps(w, r, data.StaffDoctor, getDocUserFromContext(r).ID)
+}
+
+func (app *application) listSwaps(w http.ResponseWriter, r *http.Request, staffType string, staffID int64) {
+	var queryDto struct {
+		Status string
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	queryDto.Status = app.readString(qs, "status", "")
+	queryDto.Page = app.readInt(qs, "page", 1, v)
+	queryDto.PageSize = app.readInt(qs, "page_size", 20, v)
+	queryDto.Sort = app.readString(qs, "sort", "-requested_at")
+	queryDto.SortSafelist = []string{"requested_at", "id", "-requested_at", "-id"}
+
+	v.Check(queryDto.Status == "" || validator.In(queryDto.Status, data.SwapStatuses...), "status",
+		"must be pending, approved, rejected or canceled")
+	if data.ValidateFilters(v, queryDto.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	swaps, metadata, err := app.models.Swaps.GetAll(r.Context(), queryDto.Status, staffType, staffID,
+		queryDto.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": swaps, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+type SwapDecisionPayload struct {
+	Note string `json:"note" validate:"max=2000"`
+}
+
+func (app *application) approveSwapHandler(w http.ResponseWriter, r *http.Request) {
+	var payload SwapDecisionPayload
+	swap := getSwapFromCtx(r)
+	receptionist := getRecUserFromContext(r)
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	err := app.models.Swaps.Approve(r.Context(), swap, receptionist.ID, strings.TrimSpace(payload.Note))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrSwapNotPending), errors.Is(err, data.ErrSwapStale),
+			errors.Is(err, data.ErrShiftOverlap):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": swap}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) rejectSwapHandler(w http.ResponseWriter, r *http.Request) {
+	var payload SwapDecisionPayload
+	receptionist := getRecUserFromContext(r)
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	app.decideSwap(w, r, data.SwapRejected, &receptionist.ID, strings.TrimSpace(payload.Note))
+}
+
+// cancelSwapHandler lets the doctor who asked for a swap withdraw it.
+func (app *application) cancelSwapHandler(w http.ResponseWriter, r *http.Request) {
+	if getSwapFromCtx(r).RequesterID != getDocUserFromContext(r).ID {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	app.decideSwap(w, r, data.SwapCanceled, nil, "")
+}
+
+func (app *application) decideSwap(w http.ResponseWriter, r *http.Request, status string, decidedBy *int64, note string) {
+	swap := getSwapFromCtx(r)
+
+	err := app.models.Swaps.Decide(r.Context(), swap, status, decidedBy, note)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrSwapNotPending):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": swap}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) shiftContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "shiftId"), 10, 64)
+		if err != nil || id < 1 {
+			app.notFoundResponse(w, r)
+			return
+		}
+		ctx := r.Context()
+
+		shift, err := app.models.Shifts.GetById(ctx, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, shiftCtx, shift)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getShiftFromCtx(r *http.Request) *data.Shift {
+	shift, _ := r.Context().Value(shiftCtx).(*data.Shift)
+	return shift
+}
+
+func (app *application) swapContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "swapId"), 10, 64)
+		if err != nil || id < 1 {
+			app.notFoundResponse(w, r)
+			return
+		}
+		ctx := r.Context()
+
+		swap, err := app.models.Swaps.GetById(ctx, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, swapCtx, swap)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getSwapFromCtx(r *http.Request) *data.ShiftSwap {
+	swap, _ := r.Context().Value(swapCtx).(*data.ShiftSwap)
+	return swap
+}
@@ -14,11 +14,15 @@ func (app *application) routes() *chi.Mux {
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
 	r.Use(middleware.RealIP)
-	r.Use(middleware.Timeout(60 * time.Second))
 	// r.Use(middleware.Compress(5, "application/json"))
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
-	r.Route("/api/v1", func(r chi.Router) {
+	// Server-Sent Events streams stay open for as long as the client listens, so they
+	// are served outside the request timeout every other route has.
+	timeout := middleware.Timeout(60 * time.Second)
+	r.With(app.AuthDocTokenMiddleware).Get("/api/v1/doctors/queue/stream", app.queueStreamHandler)
+	r.With(app.AuthRecTokenMiddleware).Get("/api/v1/receptionists/queue/stream", app.queueStreamHandler)
+	r.With(timeout).Route("/api/v1", func(r chi.Router) {
 		// r.With(app.BasicAuthMiddleware()).Get("/health", app.healthCheckHandler)
 		docsURL := fmt.Sprintf("%s/swagger/doc.json", app.config.addr)
 		r.Get("/swagger/*", httpSwagger.Handler(
@@ -31,17 +35,348 @@ func (app *application) routes() *chi.Mux {
 				r.Use(app.patientContextMiddleware)
 				r.Get("/", app.getPatientDocHandler)
 				r.Patch("/", app.updatePatientDocHandler)
+				r.Get("/vitals", app.getVitalsHandler)
+				r.Post("/vitals", app.recordVitalsHandler)
+				r.Get("/vitals/trends", app.getVitalsTrendHandler)
+				r.Get("/problems", app.getProblemsHandler)
+				r.Post("/problems", app.createProblemHandler)
+				r.Route("/problems/{problemId}", func(r chi.Router) {
+					r.Use(app.problemContextMiddleware)
+					r.Get("/", app.getProblemHandler)
+					r.Patch("/", app.updateProblemHandler)
+				})
+				r.Get("/diagnoses", app.getDiagnosesHandler)
+				r.Get("/admissions", app.getAdmissionsHandler)
+				r.With(app.admissionContextMiddleware).Get("/admissions/{admissionId}", app.getAdmissionHandler)
+				r.Get("/discharge-summaries", app.getDischargeSummariesHandler)
+				r.Post("/discharge-summaries", app.createDischargeSummaryHandler)
+				r.Get("/discharge-summaries/{summaryId}", app.downloadDischargeSummaryHandler)
+				r.Route("/attachments", func(r chi.Router) {
+					r.Get("/", app.getAttachmentsHandler)
+					r.Post("/", app.uploadAttachmentHandler)
+					r.Route("/{attachmentId}", func(r chi.Router) {
+						r.Use(app.attachmentContextMiddleware)
+						r.Get("/", app.getAttachmentHandler)
+						r.Get("/content", app.downloadAttachmentHandler)
+						r.Delete("/", app.deleteAttachmentHandler)
+					})
+				})
+				r.Get("/immunizations", app.getImmunizationsHandler)
+				r.Post("/immunizations", app.recordImmunizationHandler)
+				r.Get("/immunizations/due", app.getDueImmunizationsHandler)
+				r.Get("/consents", app.getConsentsHandler)
+				r.With(app.consentContextMiddleware).Get("/consents/{consentId}", app.getConsentHandler)
+				r.Get("/allergies", app.getAllergiesHandler)
+				r.Post("/allergies", app.createAllergyHandler)
+				r.With(app.allergyContextMiddleware).Patch("/allergies/{allergyId}", app.updateAllergyHandler)
+				r.Route("/notes", func(r chi.Router) {
+					r.Get("/", app.getNotesHandler)
+					r.Post("/", app.createNoteHandler)
+					r.Route("/{noteId}", func(r chi.Router) {
+						r.Use(app.noteContextMiddleware)
+						r.Get("/", app.getNoteHandler)
+						r.Post("/addenda", app.createAddendumHandler)
+						r.Group(func(r chi.Router) {
+							r.Use(app.requireDraftNoteAuthor)
+							r.Patch("/", app.updateNoteHandler)
+							r.Delete("/", app.deleteNoteHandler)
+							r.Post("/sign", app.signNoteHandler)
+						})
+					})
+				})
+				r.Route("/referrals", func(r chi.Router) {
+					r.Get("/", app.getReferralsHandler)
+					r.Post("/", app.createReferralHandler)
+					r.With(app.referralContextMiddleware).Get("/{referralId}", app.getReferralHandler)
+				})
+				r.Route("/lab-orders", func(r chi.Router) {
+					r.Get("/", app.getLabOrdersHandler)
+					r.Post("/", app.createLabOrderHandler)
+					r.Route("/{orderId}", func(r chi.Router) {
+						r.Use(app.labOrderContextMiddleware)
+						r.Get("/", app.getLabOrderHandler)
+						r.Patch("/status", app.updateLabOrderStatusHandler)
+						r.Post("/acknowledge", app.acknowledgeLabOrderHandler)
+					})
+				})
+				r.Route("/prescriptions", func(r chi.Router) {
+					r.Get("/", app.getPrescriptionsHandler)
+					r.Post("/", app.createPrescriptionHandler)
+					r.Post("/check", app.checkPrescriptionHandler)
+					r.Route("/{prescriptionId}", func(r chi.Router) {
+						r.Use(app.prescriptionContextMiddleware)
+						r.Get("/", app.getPrescriptionHandler)
+						r.Post("/discontinue", app.discontinuePrescriptionHandler)
+					})
+				})
+				r.Route("/encounters", func(r chi.Router) {
+					r.Get("/", app.getEncountersHandler)
+					r.Post("/", app.createEncounterHandler)
+					r.Route("/{encounterId}", func(r chi.Router) {
+						r.Use(app.encounterContextMiddleware)
+						r.Get("/", app.getEncounterHandler)
+						r.Get("/entries", app.getEncounterEntriesHandler)
+						r.Group(func(r chi.Router) {
+							r.Use(app.requireOpenEncounter)
+							r.Patch("/", app.updateEncounterHandler)
+							r.Post("/close", app.closeEncounterHandler)
+							r.Post("/entries", app.createEncounterEntryHandler)
+						})
+					})
+				})
+			})
+			r.Get("/lab-results/inbox", app.labInboxHandler)
+			r.Route("/exports", func(r chi.Router) {
+				r.Get("/", app.getExportsHandler)
+				r.Post("/", app.createExportHandler)
+				r.Route("/{exportId}", func(r chi.Router) {
+					r.Use(app.exportContextMiddleware)
+					r.Get("/", app.getExportHandler)
+					r.Delete("/", app.deleteExportHandler)
+				})
+			})
+			r.Route("/referrals", func(r chi.Router) {
+				r.Get("/inbox", app.referralInboxHandler)
+				r.Get("/sent", app.sentReferralsHandler)
+				r.Route("/{referralId}", func(r chi.Router) {
+					r.Use(app.referralContextMiddleware)
+					r.Get("/", app.getReferralHandler)
+					r.Post("/status", app.updateReferralStatusHandler)
+				})
+			})
+			r.Get("/me", app.getMyProfileHandler)
+			r.Patch("/me", app.updateMyProfileHandler)
+			r.Get("/directory", app.getDoctorDirectoryHandler)
+			r.Get("/shifts", app.getMyShiftsHandler)
+			r.With(app.shiftContextMiddleware).Post("/shifts/{shiftId}/swaps", app.requestSwapHandler)
+			r.Get("/swaps", app.getMySwapsHandler)
+			r.With(app.swapContextMiddleware).Post("/swaps/{swapId}/cancel", app.cancelSwapHandler)
+			r.With(app.departmentContextMiddleware).Get("/departments/{departmentId}/on-call", app.getOnCallHandler)
+			r.Get("/bed-board", app.bedBoardHandler)
+			r.Get("/immunization-schedule", app.getImmunizationScheduleHandler)
+			r.Get("/icd10", app.searchICD10Handler)
+			r.Get("/icd10/{code}", app.getICD10CodeHandler)
+			r.Route("/queue", func(r chi.Router) {
+				r.Get("/", app.getQueueHandler)
+				r.Post("/next", app.callNextPatientHandler)
+				r.Route("/{entryId}", func(r chi.Router) {
+					r.Use(app.queueEntryContextMiddleware)
+					r.Get("/", app.getQueueEntryHandler)
+					r.Patch("/", app.updateQueueEntryHandler)
+				})
 			})
 		})
+		// Export download links are signed, so they are served without a token.
+		r.Get("/exports/{exportId}/files/{type}", app.downloadExportFileHandler)
+		r.Route("/integrations", func(r chi.Router) {
+			r.Use(app.BasicAuthMiddleware())
+			r.Post("/lab/orders/{orderId}/results", app.postLabResultsHandler)
+		})
 		r.Route("/receptionists", func(r chi.Router) {
 			r.Use(app.AuthRecTokenMiddleware)
 			r.Get("/patients", app.getPatientsHandler)
 			r.Post("/patients", app.registerPatientHandler)
+			r.Route("/exports", func(r chi.Router) {
+				r.Get("/", app.getExportsHandler)
+				r.Post("/", app.createExportHandler)
+				r.Route("/{exportId}", func(r chi.Router) {
+					r.Use(app.exportContextMiddleware)
+					r.Get("/", app.getExportHandler)
+					r.Delete("/", app.deleteExportHandler)
+				})
+			})
+			r.Route("/research-studies", func(r chi.Router) {
+				r.Get("/", app.getResearchStudiesHandler)
+				r.Post("/", app.createResearchStudyHandler)
+				r.Route("/{studyId}", func(r chi.Router) {
+					r.Use(app.researchStudyContextMiddleware)
+					r.Get("/", app.getResearchStudyHandler)
+					r.Patch("/", app.updateResearchStudyHandler)
+					r.Get("/exports", app.getResearchExportsHandler)
+					r.Post("/exports", app.createResearchExportHandler)
+				})
+			})
+			r.Route("/patient-imports", func(r chi.Router) {
+				r.Get("/", app.getPatientImportsHandler)
+				r.Post("/", app.importPatientsHandler)
+				r.With(app.patientImportContextMiddleware).Get("/{importId}", app.getPatientImportHandler)
+			})
 			r.Route("/patients/{patientId}", func(r chi.Router) {
 				r.Use(app.patientContextMiddleware)
 				r.Get("/", app.getPatientHandler)
 				r.Patch("/", app.updatePatientHandler)
 				r.Delete("/", app.deletePatientHandler)
+				r.Get("/admissions", app.getAdmissionsHandler)
+				r.Post("/admissions", app.admitPatientHandler)
+				r.Route("/admissions/{admissionId}", func(r chi.Router) {
+					r.Use(app.admissionContextMiddleware)
+					r.Get("/", app.getAdmissionHandler)
+					r.Post("/transfer", app.transferPatientHandler)
+					r.Post("/discharge", app.dischargePatientHandler)
+				})
+				r.Route("/consents", func(r chi.Router) {
+					r.Get("/", app.getConsentsHandler)
+					r.Post("/", app.recordConsentHandler)
+					r.Route("/{consentId}", func(r chi.Router) {
+						r.Use(app.consentContextMiddleware)
+						r.Get("/", app.getConsentHandler)
+						r.Post("/withdraw", app.withdrawConsentHandler)
+					})
+				})
+				r.Route("/attachments", func(r chi.Router) {
+					r.Get("/", app.getAttachmentsHandler)
+					r.Post("/", app.uploadAttachmentHandler)
+					r.Route("/{attachmentId}", func(r chi.Router) {
+						r.Use(app.attachmentContextMiddleware)
+						r.Get("/", app.getAttachmentHandler)
+						r.Get("/content", app.downloadAttachmentHandler)
+						r.Delete("/", app.deleteAttachmentHandler)
+					})
+				})
+				r.Route("/invoices", func(r chi.Router) {
+					r.Get("/", app.getInvoicesHandler)
+					r.Post("/", app.createInvoiceHandler)
+					r.Route("/{invoiceId}", func(r chi.Router) {
+						r.Use(app.invoiceContextMiddleware)
+						r.Get("/", app.getInvoiceHandler)
+						r.Post("/void", app.voidInvoiceHandler)
+						r.Get("/payments", app.getPaymentsHandler)
+						r.Post("/payments", app.recordPaymentHandler)
+					})
+				})
+				r.Get("/balance", app.getBalanceHandler)
+				r.Get("/statement", app.getStatementHandler)
+				r.Get("/notification-preferences", app.getNotificationPreferencesHandler)
+				r.Put("/notification-preferences", app.updateNotificationPreferencesHandler)
+				r.Get("/notifications", app.getNotificationsHandler)
+				r.With(app.notificationContextMiddleware).Get("/notifications/{notificationId}", app.getNotificationHandler)
+				r.Route("/insurance-policies", func(r chi.Router) {
+					r.Get("/", app.getPoliciesHandler)
+					r.Post("/", app.createPolicyHandler)
+					r.Route("/{policyId}", func(r chi.Router) {
+						r.Use(app.policyContextMiddleware)
+						r.Get("/", app.getPolicyHandler)
+						r.Patch("/", app.updatePolicyHandler)
+					})
+				})
+			})
+			r.Route("/departments", func(r chi.Router) {
+				r.Get("/", app.getDepartmentsHandler)
+				r.Post("/", app.createDepartmentHandler)
+				r.Route("/{departmentId}", func(r chi.Router) {
+					r.Use(app.departmentContextMiddleware)
+					r.Get("/", app.getDepartmentHandler)
+					r.Patch("/", app.updateDepartmentHandler)
+					r.Get("/on-call", app.getOnCallHandler)
+				})
+			})
+			r.Route("/rota", func(r chi.Router) {
+				r.Get("/", app.getRotaHandler)
+				r.Post("/publish", app.publishRotaHandler)
+				r.Post("/shifts", app.createShiftHandler)
+				r.Route("/shifts/{shiftId}", func(r chi.Router) {
+					r.Use(app.shiftContextMiddleware)
+					r.Get("/", app.getShiftHandler)
+					r.Patch("/", app.updateShiftHandler)
+					r.Delete("/", app.deleteShiftHandler)
+				})
+				r.Get("/swaps", app.getSwapsHandler)
+				r.Route("/swaps/{swapId}", func(r chi.Router) {
+					r.Use(app.swapContextMiddleware)
+					r.Post("/approve", app.approveSwapHandler)
+					r.Post("/reject", app.rejectSwapHandler)
+				})
+			})
+			r.Route("/webhooks", func(r chi.Router) {
+				r.Get("/", app.getWebhooksHandler)
+				r.Post("/", app.createWebhookHandler)
+				r.Route("/{webhookId}", func(r chi.Router) {
+					r.Use(app.webhookContextMiddleware)
+					r.Get("/", app.getWebhookHandler)
+					r.Patch("/", app.updateWebhookHandler)
+					r.Delete("/", app.deleteWebhookHandler)
+					r.Get("/deliveries", app.getWebhookDeliveriesHandler)
+					r.Route("/deliveries/{deliveryId}", func(r chi.Router) {
+						r.Use(app.webhookDeliveryContextMiddleware)
+						r.Get("/", app.getWebhookDeliveryHandler)
+						r.Post("/redeliver", app.redeliverWebhookHandler)
+					})
+				})
+			})
+			r.Route("/notifications", func(r chi.Router) {
+				r.Get("/", app.getNotificationsHandler)
+				r.With(app.notificationContextMiddleware).Get("/{notificationId}", app.getNotificationHandler)
+			})
+			r.Route("/jobs", func(r chi.Router) {
+				r.Get("/", app.getJobsHandler)
+				r.Route("/{jobId}", func(r chi.Router) {
+					r.Use(app.jobContextMiddleware)
+					r.Get("/", app.getJobHandler)
+					r.Post("/retry", app.retryJobHandler)
+				})
+			})
+			r.Route("/hl7/messages", func(r chi.Router) {
+				r.Get("/", app.getHL7MessagesHandler)
+				r.Route("/{messageId}", func(r chi.Router) {
+					r.Use(app.hl7MessageContextMiddleware)
+					r.Get("/", app.getHL7MessageHandler)
+					r.Post("/replay", app.replayHL7MessageHandler)
+				})
+			})
+			r.Get("/specialties", app.getSpecialtiesHandler)
+			r.Post("/specialties", app.createSpecialtyHandler)
+			r.Get("/doctors", app.getDoctorDirectoryHandler)
+			r.Route("/doctors/{doctorId}", func(r chi.Router) {
+				r.Use(app.doctorContextMiddleware)
+				r.Get("/", app.getDoctorHandler)
+				r.Patch("/", app.updateDoctorHandler)
+			})
+			r.Get("/claims", app.getClaimsHandler)
+			r.Route("/claim-batches", func(r chi.Router) {
+				r.Get("/", app.getClaimBatchesHandler)
+				r.Post("/", app.createClaimBatchHandler)
+				r.Route("/{batchId}", func(r chi.Router) {
+					r.Use(app.claimBatchContextMiddleware)
+					r.Get("/", app.getClaimBatchHandler)
+					r.Get("/837", app.downloadClaimBatchHandler)
+				})
+			})
+			r.Route("/remittances", func(r chi.Router) {
+				r.Get("/", app.getRemittancesHandler)
+				r.Post("/", app.importRemittanceHandler)
+			})
+			r.Route("/billing/services", func(r chi.Router) {
+				r.Get("/", app.getBillingServicesHandler)
+				r.Post("/", app.createBillingServiceHandler)
+				r.Route("/{serviceId}", func(r chi.Router) {
+					r.Use(app.billingServiceContextMiddleware)
+					r.Get("/", app.getBillingServiceHandler)
+					r.Patch("/", app.updateBillingServiceHandler)
+				})
+			})
+			r.Get("/bed-board", app.bedBoardHandler)
+			r.Route("/wards", func(r chi.Router) {
+				r.Get("/", app.getWardsHandler)
+				r.Post("/", app.createWardHandler)
+				r.Route("/{wardId}", func(r chi.Router) {
+					r.Use(app.wardContextMiddleware)
+					r.Get("/", app.getWardHandler)
+					r.Patch("/", app.updateWardHandler)
+					r.Post("/rooms", app.createRoomHandler)
+					r.Post("/rooms/{roomId}/beds", app.createBedHandler)
+				})
+			})
+			r.With(app.bedContextMiddleware).Patch("/beds/{bedId}", app.updateBedHandler)
+			r.Route("/queue", func(r chi.Router) {
+				r.Get("/", app.getQueueHandler)
+				r.Post("/", app.checkInPatientHandler)
+				r.Route("/{entryId}", func(r chi.Router) {
+					r.Use(app.queueEntryContextMiddleware)
+					r.Get("/", app.getQueueEntryHandler)
+					r.Patch("/", app.updateQueueEntryHandler)
+					r.Get("/eligibility", app.getEligibilityHandler)
+					r.Post("/eligibility", app.recordEligibilityHandler)
+				})
 			})
 			r.Group(func(r chi.Router) {
 				r.Use(app.AuthRecTokenMiddleware)
@@ -57,6 +392,22 @@ func (app *application) routes() *chi.Mux {
 		})
 
 	})
+	r.With(timeout).Route("/fhir/r4", func(r chi.Router) {
+		r.NotFound(app.fhirNotFound)
+		r.MethodNotAllowed(app.fhirMethodNotAllowed)
+		r.Get("/metadata", app.fhirMetadataHandler)
+		r.Group(func(r chi.Router) {
+			r.Use(app.fhirAuthMiddleware)
+			r.Get("/Patient", app.fhirSearchPatientsHandler)
+			r.Post("/Patient", app.fhirCreatePatientHandler)
+			r.Get("/Patient/{id}", app.fhirReadPatientHandler)
+			r.Put("/Patient/{id}", app.fhirUpdatePatientHandler)
+			r.Get("/Practitioner", app.fhirSearchPractitionersHandler)
+			r.Post("/Practitioner", app.fhirCreatePractitionerHandler)
+			r.Get("/Practitioner/{id}", app.fhirReadPractitionerHandler)
+			r.Put("/Practitioner/{id}", app.fhirUpdatePractitionerHandler)
+		})
+	})
 	return r
 
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type eventStream struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+// startEventStream writes the Server-Sent Events headers and lifts the server's write
+// timeout for this response, since a stream stays open for as long as the client
+// keeps listening.
+func (app *application) startEventStream(w http.ResponseWriter) (*eventStream, error) {
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Stop reverse proxies such as nginx from buffering the stream.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	s := &eventStream{w: w, rc: rc}
+	return s, rc.Flush()
+}
+
+// send writes a single event. Multi-line data is split over several data fields as
+// required by the SSE format.
+func (s *eventStream) send(event string, data []byte) error {
+	if _, err := fmt.Fprintf(s.w, "event: %s\n", event); err != nil {
+		return err
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if _, err := fmt.Fprintf(s.w, "data: %s\n", line); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprint(s.w, "\n"); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// ping writes an SSE comment, which keeps idle connections from being closed by
+// proxies and lets us notice clients that have gone away.
+func (s *eventStream) ping() error {
+	if _, err := fmt.Fprint(s.w, ": ping\n\n"); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/muyiwadosunmu/hospital-management/internal/data"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+// Quantity is a measurement together with the UCUM unit it was taken in. The unit may
+// be left out when the value is already in the canonical unit.
+type Quantity struct {
+	Value float64 `json:"value" validate:"required"`
+	Unit  string  `json:"unit"`
+}
+
+type RecordVitalsPayload struct {
+	EncounterID     *int64     `json:"encounterId" validate:"omitempty,gt=0"`
+	RecordedAt      *time.Time `json:"recordedAt"`
+	Systolic        *int       `json:"systolic"`
+	Diastolic       *int       `json:"diastolic"`
+	HeartRate       *int       `json:"heartRate"`
+	RespiratoryRate *int       `json:"respiratoryRate"`
+	SpO2            *int       `json:"spo2"`
+	Temperature     *Quantity  `json:"temperature"`
+	Weight          *Quantity  `json:"weight"`
+	Height          *Quantity  `json:"height"`
+}
+
+func (app *application) recordVitalsHandler(w http.ResponseWriter, r *http.Request) {
+	var payload RecordVitalsPayload
+	ctx := r.Context()
+	patient := getPatientFromCtx(r)
+	doctor := getDocUserFromContext(r)
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	vs := &data.VitalSigns{
+		PatientID:       patient.ID,
+		DoctorID:        doctor.ID,
+		EncounterID:     payload.EncounterID,
+		RecordedAt:      time.Now(),
+		Systolic:        payload.Systolic,
+		Diastolic:       payload.Diastolic,
+		HeartRate:       payload.HeartRate,
+		RespiratoryRate: payload.RespiratoryRate,
+		SpO2:            payload.SpO2,
+	}
+	if payload.RecordedAt != nil {
+		vs.RecordedAt = *payload.RecordedAt
+	}
+
+	v := validator.New()
+	quantities := map[string]struct {
+		in  *Quantity
+		out **float64
+	}{
+		"temperature": {payload.Temperature, &vs.Temperature},
+		"weight":      {payload.Weight, &vs.Weight},
+		"height":      {payload.Height, &vs.Height},
+	}
+	for measure, q := range quantities {
+		if q.in == nil {
+			continue
+		}
+		value, err := data.ToCanonicalUnit(measure, q.in.Value, q.in.Unit)
+		if err != nil {
+			v.AddError(measure, err.Error())
+			continue
+		}
+		*q.out = &value
+	}
+	vs.ComputeBMI()
+
+	if data.ValidateVitalSigns(v, vs); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if vs.EncounterID != nil {
+		encounter, err := app.models.Encounters.GetById(ctx, patient.ID, *vs.EncounterID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("encounterId", "encounter not found for this patient")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		if encounter.IsClosed() {
+			app.errorResponse(w, r, http.StatusConflict, data.ErrEncounterClosed.Error())
+			return
+		}
+	}
+
+	if err := app.models.VitalSigns.Insert(ctx, vs); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	vs.Flag(app.vitalThresholds, patient.DateOfBirth)
+
+	if err := app.writeJSON(w, http.StatusCreated, envelope{"data": vs, "units": data.VitalUnits}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getVitalsHandler(w http.ResponseWriter, r *http.Request) {
+	var queryDto struct {
+		From *time.Time
+		To   *time.Time
+		data.Filters
+	}
+	patient := getPatientFromCtx(r)
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	var err error
+	if queryDto.From, err = app.readDateParam(qs, "from"); err != nil {
+		v.AddError("from", err.Error())
+	}
+	if queryDto.To, err = app.readDateParam(qs, "to"); err != nil {
+		v.AddError("to", err.Error())
+	}
+
+	queryDto.Page = app.readInt(qs, "page", 1, v)
+	queryDto.PageSize = app.readInt(qs, "page_size", 20, v)
+	queryDto.Sort = app.readString(qs, "sort", "-recorded_at")
+	queryDto.SortSafelist = []string{"recorded_at", "-recorded_at"}
+
+	if data.ValidateFilters(v, queryDto.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	readings, metadata, err := app.models.VitalSigns.GetForPatient(r.Context(), patient.ID,
+		queryDto.From, queryDto.To, queryDto.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	for _, vs := range readings {
+		vs.Flag(app.vitalThresholds, patient.DateOfBirth)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": readings, "units": data.VitalUnits, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getVitalsTrendHandler returns a time series for each requested measure, e.g.
+// ?measures=systolic,diastolic&interval=day&from=2025-01-01. The normal range for the
+// patient's current age is included so that clients can shade it.
+func (app *application) getVitalsTrendHandler(w http.ResponseWriter, r *http.Request) {
+	var query data.TrendQuery
+	patient := getPatientFromCtx(r)
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	query.Measures = app.readCSV(qs, "measures", []string{})
+	query.Interval = app.readString(qs, "interval", "day")
+
+	var err error
+	if query.From, err = app.readDateParam(qs, "from"); err != nil {
+		v.AddError("from", err.Error())
+	}
+	if query.To, err = app.readDateParam(qs, "to"); err != nil {
+		v.AddError("to", err.Error())
+	}
+
+	if data.ValidateTrendQuery(v, query); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	trends, err := app.models.VitalSigns.GetTrend(r.Context(), patient.ID, query)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	ranges := app.vitalThresholds.For(patient.DateOfBirth, time.Now())
+	for _, trend := range trends {
+		trend.NormalRange = ranges[trend.Measure]
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": trends}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/muyiwadosunmu/hospital-management/internal/data"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+type wardKey string
+type bedKey string
+
+const (
+	wardCtx wardKey = "ward"
+	bedCtx  bedKey  = "bed"
+)
+
+type CreateWardPayload struct {
+	Name      string `json:"name" validate:"required,max=100"`
+	Specialty string `json:"specialty" validate:"max=100"`
+}
+
+type CreateBedPayload struct {
+	Label        string `json:"label" validate:"required,max=50"`
+	OutOfService bool   `json:"outOfService"`
+}
+
+func (app *application) getWardsHandler(w http.ResponseWriter, r *http.Request) {
+	wards, err := app.models.Wards.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": wards}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createWardHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateWardPayload
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ward := &data.Ward{
+		Name:      strings.TrimSpace(payload.Name),
+		Specialty: strings.TrimSpace(payload.Specialty),
+	}
+
+	v := validator.New()
+	if data.ValidateWard(v, ward); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Wards.Insert(r.Context(), ward)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateWard):
+			v.AddError("name", err.Error())
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusCreated, envelope{"data": ward}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getWardHandler(w http.ResponseWriter, r *http.Request) {
+	ward := getWardFromCtx(r)
+
+	board, err := app.models.Wards.BedBoard(r.Context(), &ward.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if len(board) == 0 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": board[0]}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateWardHandler(w http.ResponseWriter, r *http.Request) {
+	ward := getWardFromCtx(r)
+
+	var payload struct {
+		Name      *string `json:"name" validate:"omitempty,max=100"`
+		Specialty *string `json:"specialty" validate:"omitempty,max=100"`
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if payload.Name != nil {
+		ward.Name = strings.TrimSpace(*payload.Name)
+	}
+	if payload.Specialty != nil {
+		ward.Specialty = strings.TrimSpace(*payload.Specialty)
+	}
+
+	v := validator.New()
+	if data.ValidateWard(v, ward); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Wards.Update(r.Context(), ward)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateWard):
+			v.AddError("name", err.Error())
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": ward}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createRoomHandler(w http.ResponseWriter, r *http.Request) {
+	ward := getWardFromCtx(r)
+
+	var payload struct {
+		Name string `json:"name" validate:"required,max=100"`
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	room := &data.Room{WardID: ward.ID, Name: strings.TrimSpace(payload.Name)}
+
+	v := validator.New()
+	v.Check(room.Name != "", "name", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Wards.InsertRoom(r.Context(), room)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateRoom):
+			v.AddError("name", err.Error())
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusCreated, envelope{"data": room}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createBedHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateBedPayload
+	ward := getWardFromCtx(r)
+
+	roomID, err := strconv.ParseInt(chi.URLParam(r, "roomId"), 10, 64)
+	if err != nil || roomID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+	room, err := app.models.Wards.GetRoom(r.Context(), roomID)
+	if err != nil || room.WardID != ward.ID {
+		switch {
+		case err == nil, errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	bed := &data.Bed{
+		RoomID:         room.ID,
+		Label:          strings.TrimSpace(payload.Label),
+		CleaningStatus: data.BedClean,
+		OutOfService:   payload.OutOfService,
+	}
+
+	v := validator.New()
+	if data.ValidateBed(v, bed); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Wards.InsertBed(r.Context(), bed)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateBed):
+			v.AddError("label", err.Error())
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusCreated, envelope{"data": bed}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateBedHandler is used by housekeeping to record cleaning and to take beds in and
+// out of service.
+func (app *application) updateBedHandler(w http.ResponseWriter, r *http.Request) {
+	bed := getBedFromCtx(r)
+
+	var payload struct {
+		Label          *string `json:"label" validate:"omitempty,max=50"`
+		CleaningStatus *string `json:"cleaningStatus" validate:"omitempty,oneof=clean dirty cleaning"`
+		OutOfService   *bool   `json:"outOfService"`
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if payload.Label != nil {
+		bed.Label = strings.TrimSpace(*payload.Label)
+	}
+	if payload.CleaningStatus != nil {
+		bed.CleaningStatus = *payload.CleaningStatus
+	}
+	if payload.OutOfService != nil {
+		bed.OutOfService = *payload.OutOfService
+	}
+
+	v := validator.New()
+	if data.ValidateBed(v, bed); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Wards.UpdateBed(r.Context(), bed)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateBed):
+			v.AddError("label", err.Error())
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": bed}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// bedBoardHandler shows every bed with its occupant and cleaning status, optionally
+// for a single ward.
+func (app *application) bedBoardHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	var wardID *int64
+	if id := app.readInt(r.URL.Query(), "ward_id", 0, v); id > 0 {
+		wid := int64(id)
+		wardID = &wid
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	board, err := app.models.Wards.BedBoard(r.Context(), wardID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": board}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) wardContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "wardId"), 10, 64)
+		if err != nil || id < 1 {
+			app.notFoundResponse(w, r)
+			return
+		}
+		ctx := r.Context()
+
+		ward, err := app.models.Wards.GetById(ctx, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, wardCtx, ward)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (app *application) bedContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "bedId"), 10, 64)
+		if err != nil || id < 1 {
+			app.notFoundResponse(w, r)
+			return
+		}
+		ctx := r.Context()
+
+		bed, err := app.models.Wards.GetBed(ctx, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, bedCtx, bed)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getWardFromCtx(r *http.Request) *data.Ward {
+	ward, _ := r.Context().Value(wardCtx).(*data.Ward)
+	return ward
+}
+
+func getBedFromCtx(r *http.Request) *data.Bed {
+	bed, _ := r.Context().Value(bedCtx).(*data.Bed)
+	return bed
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/muyiwadosunmu/hospital-management/internal/data"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+	"github.com/muyiwadosunmu/hospital-management/internal/webhook"
+)
+
+type webhookKey string
+
+const (
+	webhookCtx         webhookKey = "webhook"
+	webhookDeliveryCtx webhookKey = "webhookDelivery"
+)
+
+// webhookBatchSize is how many events are dispatched, and deliveries sent, at a time.
+const webhookBatchSize = 50
+
+// dispatchWebhooks delivers outbox events to webhook subscribers until ctx is done.
+// Deliveries already being sent are finished first.
+func (app *application) dispatchWebhooks(ctx context.Context) {
+	ticker := time.NewTicker(app.config.webhooks.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		app.dispatchOutbox(ctx)
+		app.sendDueWebhooks(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatchOutbox turns new outbox events into deliveries to their subscribers.
+func (app *application) dispatchOutbox(ctx context.Context) {
+	for ctx.Err() == nil {
+		n, err := app.models.Deliveries.Dispatch(ctx, webhookBatchSize)
+		if err != nil {
+			if ctx.Err() == nil {
+				app.logger.PrintError(err, nil)
+			}
+			return
+		}
+		if n < webhookBatchSize {
+			return
+		}
+	}
+}
+
+func (app *application) sendDueWebhooks(ctx context.Context) {
+	// A claimed delivery is left alone for a while after the request would have
+	// timed out, in case this instance dies before recording the attempt.
+	lease := app.config.webhooks.timeout + time.Minute
+
+	for ctx.Err() == nil {
+		deliveries, err := app.models.Deliveries.ClaimDue(ctx, webhookBatchSize, lease)
+		if err != nil {
+			if ctx.Err() == nil {
+				app.logger.PrintError(err, nil)
+			}
+			return
+		}
+
+		var wg sync.WaitGroup
+		for _, d := range deliveries {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				app.sendWebhook(context.WithoutCancel(ctx), d)
+			}()
+		}
+		wg.Wait()
+
+		if len(deliveries) < webhookBatchSize {
+			return
+		}
+	}
+}
+
+func (app *application) sendWebhook(ctx context.Context, d *data.WebhookDelivery) {
+	properties := map[string]string{
+		"delivery_id": strconv.FormatInt(d.ID, 10),
+		"event_type":  d.EventType,
+	}
+
+	// Subscriptions registered before plain http was refused are dead-lettered rather
+	// than sent patient details unencrypted.
+	if !data.WebhookURLAllowed(d.URL, app.config.webhooks.insecureHosts) {
+		app.logger.PrintError(data.ErrInsecureWebhookURL, properties)
+		if err := app.models.Deliveries.Failed(ctx, d, 0, data.ErrInsecureWebhookURL, 0); err != nil {
+			app.logger.PrintError(err, properties)
+		}
+		return
+	}
+
+	status, sendErr := app.webhookSender.Send(ctx, webhook.Request{
+		URL:       d.URL,
+		Secret:    d.Secret,
+		ID:        strconv.FormatInt(d.ID, 10),
+		EventType: d.EventType,
+		Body:      d.Body,
+	})
+	if sendErr == nil {
+		if err := app.models.Deliveries.Delivered(ctx, d, status); err != nil {
+			app.logger.PrintError(err, properties)
+		}
+		return
+	}
+
+	attempt := d.Attempts + 1
+	var retryIn time.Duration
+	if attempt < app.config.webhooks.maxAttempts {
+		retryIn = app.webhookRetryDelay(attempt)
+	} else {
+		properties["attempts"] = strconv.Itoa(attempt)
+		app.logger.PrintError(fmt.Errorf("webhook delivery dead-lettered: %w", sendErr), properties)
+	}
+	if err := app.models.Deliveries.Failed(ctx, d, status, sendErr, retryIn); err != nil {
+		app.logger.PrintError(err, properties)
+	}
+}
+
+// webhookRetryDelay doubles the wait after each failed attempt, up to the maximum.
+func (app *application) webhookRetryDelay(attempt int) time.Duration {
+	delay := app.config.webhooks.retryBase
+	for i := 1; i < attempt && delay < app.config.webhooks.retryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, app.config.webhooks.retryMax)
+}
+
+type CreateWebhookPayload struct {
+	URL         string   `json:"url" validate:"required"`
+	Description string   `json:"description"`
+	EventTypes  []string `json:"eventTypes"`
+	Active      *bool    `json:"active"`
+}
+
+// createWebhookHandler registers a subscription. The signing secret is only returned
+// here, so the subscriber must keep it.
+func (app *application) createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateWebhookPayload
+	receptionist := getRecUserFromContext(r)
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	subscription := &data.WebhookSubscription{
+		URL:         strings.TrimSpace(payload.URL),
+		Description: strings.TrimSpace(payload.Description),
+		EventTypes:  payload.EventTypes,
+		Active:      true,
+		CreatedBy:   receptionist.ID,
+	}
+	if subscription.EventTypes == nil {
+		subscription.EventTypes = []string{}
+	}
+	if payload.Active != nil {
+		subscription.Active = *payload.Active
+	}
+
+	v := validator.New()
+	if data.ValidateWebhookSubscription(v, subscription, app.config.webhooks.insecureHosts); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	secret, err := webhook.NewSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	subscription.Secret = secret
+
+	if err := app.models.Webhooks.Insert(r.Context(), subscription); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"data": subscription, "secret": subscription.Secret}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	var queryDto struct {
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	queryDto.Page = app.readInt(qs, "page", 1, v)
+	queryDto.PageSize = app.readInt(qs, "page_size", 20, v)
+	queryDto.Sort = app.readString(qs, "sort", "id")
+	queryDto.SortSafelist = []string{"id", "created_at", "-id", "-created_at"}
+
+	if data.ValidateFilters(v, queryDto.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	subscriptions, metadata, err := app.models.Webhooks.GetAll(r.Context(), queryDto.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": subscriptions, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"data": getWebhookFromCtx(r)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	subscription := getWebhookFromCtx(r)
+
+	var payload struct {
+		URL         *string  `json:"url"`
+		Description *string  `json:"description"`
+		EventTypes  []string `json:"eventTypes"`
+		Active      *bool    `json:"active"`
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if payload.URL != nil {
+		subscription.URL = strings.TrimSpace(*payload.URL)
+	}
+	if payload.Description != nil {
+		subscription.Description = strings.TrimSpace(*payload.Description)
+	}
+	if payload.EventTypes != nil {
+		subscription.EventTypes = payload.EventTypes
+	}
+	if payload.Active != nil {
+		subscription.Active = *payload.Active
+	}
+
+	v := validator.New()
+	if data.ValidateWebhookSubscription(v, subscription, app.config.webhooks.insecureHosts); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Webhooks.Update(r.Context(), subscription)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"data": subscription}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	subscription := getWebhookFromCtx(r)
+
+	err := app.models.Webhooks.Delete(r.Context(), subscription.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"message": "webhook deleted"}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getWebhookDeliveriesHandler lists a subscription's deliveries; status=dead lists
+// the dead letters.
+func (app *application) getWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	subscription := getWebhookFromCtx(r)
+
+	var queryDto struct {
+		Status string
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	queryDto.Status = app.readString(qs, "status", "")
+	queryDto.Page = app.readInt(qs, "page", 1, v)
+	queryDto.PageSize = app.readInt(qs, "page_size", 20, v)
+	queryDto.Sort = app.readString(qs, "sort", "-created_at")
+	queryDto.SortSafelist = []string{"created_at", "id", "-created_at", "-id"}
+
+	v.Check(queryDto.Status == "" || validator.In(queryDto.Status, data.DeliveryStatuses...), "status",
+		"must be pending, delivered or dead")
+	if data.ValidateFilters(v, queryDto.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	deliveries, metadata, err := app.models.Deliveries.GetAll(r.Context(), subscription.ID, queryDto.Status,
+		queryDto.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": deliveries, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getWebhookDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"data": getWebhookDeliveryFromCtx(r)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// redeliverWebhookHandler sends a delivered or dead-lettered event again.
+func (app *application) redeliverWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	delivery := getWebhookDeliveryFromCtx(r)
+
+	err := app.models.Deliveries.Redeliver(r.Context(), delivery)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDeliveryPending):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusAccepted, envelope{"data": delivery}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) webhookContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "webhookId"), 10, 64)
+		if err != nil || id < 1 {
+			app.notFoundResponse(w, r)
+			return
+		}
+		ctx := r.Context()
+
+		subscription, err := app.models.Webhooks.GetById(ctx, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, webhookCtx, subscription)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getWebhookFromCtx(r *http.Request) *data.WebhookSubscription {
+	subscription, _ := r.Context().Value(webhookCtx).(*data.WebhookSubscription)
+	return subscription
+}
+
+func (app *application) webhookDeliveryContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "deliveryId"), 10, 64)
+		if err != nil || id < 1 {
+			app.notFoundResponse(w, r)
+			return
+		}
+		ctx := r.Context()
+
+		delivery, err := app.models.Deliveries.GetById(ctx, getWebhookFromCtx(r).ID, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, webhookDeliveryCtx, delivery)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getWebhookDeliveryFromCtx(r *http.Request) *data.WebhookDelivery {
+	delivery, _ := r.Context().Value(webhookDeliveryCtx).(*data.WebhookDelivery)
+	return delivery
+}
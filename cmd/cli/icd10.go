@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/muyiwadosunmu/hospital-management/internal/data"
+)
+
+// importICD10 loads the ICD-10 catalogue from a CSV file. The file must have a header
+// row with a "code" column and a "description" column; any other columns are ignored.
+// Codes may be given with or without the dot, e.g. "E1165" or "E11.65".
+func importICD10(app *cli, args []string) error {
+	fs := newFlagSet("icd10-import")
+	file := fs.String("file", "", "path to the ICD-10 CSV file")
+	fs.Parse(args)
+
+	if *file == "" {
+		return errors.New("-file must be provided")
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	codes, err := readICD10CSV(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", *file, err)
+	}
+
+	imported, err := app.models.ICD10.Import(app.ctx, codes)
+	if err != nil {
+		return err
+	}
+
+	app.logger.PrintInfo("imported ICD-10 codes", map[string]string{
+		"file":     *file,
+		"rows":     strconv.Itoa(len(codes)),
+		"imported": strconv.FormatInt(imported, 10),
+	})
+	return nil
+}
+
+func readICD10CSV(r io.Reader) ([]data.ICD10Code, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	codeCol, descCol := -1, -1
+	for i, name := range header {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "code":
+			codeCol = i
+		case "description":
+			descCol = i
+		}
+	}
+	if codeCol < 0 || descCol < 0 {
+		return nil, errors.New(`header must contain "code" and "description" columns`)
+	}
+
+	var codes []data.ICD10Code
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) <= codeCol || len(record) <= descCol {
+			return nil, fmt.Errorf("line %d: missing columns", line)
+		}
+
+		code := data.NormalizeICD10Code(record[codeCol])
+		if !data.ICD10CodeRX.MatchString(code) {
+			return nil, fmt.Errorf("line %d: %q is not a valid ICD-10 code", line, record[codeCol])
+		}
+		description := strings.TrimSpace(record[descCol])
+		if description == "" {
+			return nil, fmt.Errorf("line %d: description must not be empty", line)
+		}
+
+		codes = append(codes, data.ICD10Code{Code: code, Description: description})
+	}
+	if len(codes) == 0 {
+		return nil, errors.New("file contains no codes")
+	}
+	return codes, nil
+}
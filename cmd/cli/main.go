@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+
+	_ "github.com/lib/pq"
+	"github.com/muyiwadosunmu/hospital-management/internal/data"
+	"github.com/muyiwadosunmu/hospital-management/internal/db"
+	"github.com/muyiwadosunmu/hospital-management/internal/env"
+	"github.com/muyiwadosunmu/hospital-management/internal/jsonlog"
+)
+
+// command is a single CLI subcommand. run receives the arguments that follow the
+// subcommand name.
+type command struct {
+	usage string
+	run   func(app *cli, args []string) error
+}
+
+var commands = map[string]command{
+	"icd10-import": {
+		usage: "icd10-import -file=codes.csv   load the ICD-10 catalogue from a CSV file",
+		run:   importICD10,
+	},
+	"patient-import": {
+		usage: "patient-import -file=patients.csv -receptionist=ID   register patients from a CSV file (see -map, -dry-run)",
+		run:   importPatients,
+	},
+}
+
+type cli struct {
+	db     *sql.DB
+	models data.Models
+	logger *jsonlog.Logger
+	ctx    context.Context
+}
+
+func main() {
+	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	conn, err := db.New(
+		env.GetString("HOSPITAL_MGT_DSN", ""),
+		env.GetInt("DB_MAX_OPEN_CONNS", 5),
+		env.GetInt("DB_MAX_IDLE_CONNS", 5),
+		env.GetString("DB_MAX_IDLE_TIME", "15m"),
+	)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	defer db.Close(conn)
+
+	app := &cli{
+		db:     conn,
+		models: data.NewModels(conn),
+		logger: logger,
+		ctx:    context.Background(),
+	}
+
+	if err := cmd.run(app, os.Args[2:]); err != nil {
+		logger.PrintFatal(err, map[string]string{"command": os.Args[1]})
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: cli <command> [flags]")
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintln(os.Stderr, "  "+cmd.usage)
+	}
+}
+
+// newFlagSet returns a flag set for a subcommand which exits with usage information on
+// a parse error.
+func newFlagSet(name string) *flag.FlagSet {
+	return flag.NewFlagSet(name, flag.ExitOnError)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/muyiwadosunmu/hospital-management/internal/data"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+// importPatients registers patients from a CSV file, as the patient import endpoint
+// does but without waiting on a background job. Columns are found by their usual
+// names unless -map says otherwise, e.g. -map="lastName=Surname,dateOfBirth=DOB".
+// With -dry-run the file is only validated and the row report printed.
+func importPatients(app *cli, args []string) error {
+	fs := newFlagSet("patient-import")
+	file := fs.String("file", "", "path to the patients CSV file")
+	receptionistID := fs.Int64("receptionist", 0, "ID of the receptionist the patients are added by")
+	columns := fs.String("map", "", "comma-separated field=column pairs")
+	dryRun := fs.Bool("dry-run", false, "validate the file without importing it")
+	skipInvalid := fs.Bool("skip-invalid", false, "import the valid rows even if some are invalid")
+	fs.Parse(args)
+
+	if *file == "" {
+		return errors.New("-file must be provided")
+	}
+	if *receptionistID < 1 && !*dryRun {
+		return errors.New("-receptionist must be provided")
+	}
+
+	mapping, err := parseImportMapping(*columns)
+	if err != nil {
+		return err
+	}
+
+	source, err := os.ReadFile(*file)
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		check, err := data.ReadPatientCSV(strings.NewReader(string(source)), mapping)
+		if err != nil {
+			return fmt.Errorf("%s: %w", *file, err)
+		}
+		if err := app.models.Imports.CheckExisting(app.ctx, check); err != nil {
+			return err
+		}
+		if err := printReport(check.Errors); err != nil {
+			return err
+		}
+		app.logger.PrintInfo("checked patient import", map[string]string{
+			"file":       *file,
+			"total_rows": strconv.Itoa(check.TotalRows),
+			"valid_rows": strconv.Itoa(check.ValidRows),
+		})
+		return nil
+	}
+
+	job := &data.PatientImport{
+		ReceptionistID: *receptionistID,
+		FileName:       filepath.Base(*file),
+		Source:         string(source),
+		Mapping:        mapping,
+		SkipInvalid:    *skipInvalid,
+	}
+	if err := app.models.Imports.Insert(app.ctx, job); err != nil {
+		return err
+	}
+	if err := app.models.Imports.Run(app.ctx, job); err != nil {
+		return err
+	}
+	if err := printReport(job.Report); err != nil {
+		return err
+	}
+	if job.Status == data.ImportFailed {
+		return fmt.Errorf("patient import %d failed: %s", job.ID, job.Error)
+	}
+
+	app.logger.PrintInfo("imported patients", job.Summary())
+	return nil
+}
+
+func parseImportMapping(s string) (map[string]string, error) {
+	mapping := map[string]string{}
+	if strings.TrimSpace(s) == "" {
+		return mapping, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		field, column, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("-map: %q must be field=column", pair)
+		}
+		mapping[strings.TrimSpace(field)] = strings.TrimSpace(column)
+	}
+
+	v := validator.New()
+	if data.ValidateImportMapping(v, mapping); !v.Valid() {
+		return nil, fmt.Errorf("-map: %s", v.Errors["mapping"])
+	}
+	return mapping, nil
+}
+
+// printReport writes the rows that were not imported to stdout as JSON.
+func printReport(report []data.ImportRowError) error {
+	if len(report) == 0 {
+		return nil
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "\t")
+	return enc.Encode(report)
+}
@@ -0,0 +1,412 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+const (
+	AdmissionStatusAdmitted   = "admitted"
+	AdmissionStatusDischarged = "discharged"
+
+	MovementAdmit     = "admit"
+	MovementTransfer  = "transfer"
+	MovementDischarge = "discharge"
+)
+
+var DischargeDispositions = []string{"home", "transferred", "care_facility", "against_advice", "deceased"}
+
+var (
+	ErrAlreadyAdmitted = errors.New("patient is already admitted")
+	ErrNotAdmitted     = errors.New("patient is not currently admitted")
+	ErrBedOccupied     = errors.New("bed is already occupied")
+	ErrBedNotReady     = errors.New("bed is out of service or has not been cleaned")
+	ErrSameBed         = errors.New("patient is already in this bed")
+)
+
+// Admission is an inpatient stay. BedID is the bed the patient is in now, or was in
+// when discharged.
+type Admission struct {
+	ID                   int64      `json:"id"`
+	PatientID            int64      `json:"patientId"`
+	BedID                int64      `json:"bedId"`
+	BedLabel             string     `json:"bedLabel"`
+	RoomName             string     `json:"roomName"`
+	WardID               int64      `json:"wardId"`
+	WardName             string     `json:"wardName"`
+	DoctorID             *int64     `json:"doctorId"`
+	ReceptionistID       int64      `json:"receptionistId"`
+	Reason               string     `json:"reason"`
+	Status               string     `json:"status"`
+	AdmittedAt           time.Time  `json:"admittedAt"`
+	DischargedAt         *time.Time `json:"dischargedAt"`
+	DischargeDisposition string     `json:"dischargeDisposition,omitempty"`
+	Version              int64      `json:"version"`
+}
+
+// BedMovement is one step in the history of an admission.
+type BedMovement struct {
+	ID             int64     `json:"id"`
+	AdmissionID    int64     `json:"admissionId"`
+	Type           string    `json:"type"`
+	FromBedID      *int64    `json:"fromBedId"`
+	ToBedID        *int64    `json:"toBedId"`
+	ReceptionistID int64     `json:"receptionistId"`
+	Reason         string    `json:"reason"`
+	MovedAt        time.Time `json:"movedAt"`
+}
+
+func (a *Admission) IsActive() bool {
+	return a.Status == AdmissionStatusAdmitted
+}
+
+func ValidateDischarge(v *validator.Validator, disposition string) {
+	v.Check(validator.In(disposition, DischargeDispositions...), "disposition",
+		"must be home, transferred, care_facility, against_advice or deceased")
+}
+
+type AdmissionModel struct {
+	DB *sql.DB
+}
+
+const admissionColumns = `a.id, a.patient_id, a.bed_id, b.label, r.name, w.id, w.name, a.doctor_id,
+	a.receptionist_id, a.reason, a.status, a.admitted_at, a.discharged_at,
+	a.discharge_disposition, a.version`
+
+const admissionTables = `admissions a
+	JOIN beds b ON b.id = a.bed_id
+	JOIN rooms r ON r.id = b.room_id
+	JOIN wards w ON w.id = r.ward_id`
+
+func scanAdmission(row rowScanner) (*Admission, error) {
+	var a Admission
+	err := row.Scan(&a.ID, &a.PatientID, &a.BedID, &a.BedLabel, &a.RoomName, &a.WardID, &a.WardName,
+		&a.DoctorID, &a.ReceptionistID, &a.Reason, &a.Status, &a.AdmittedAt, &a.DischargedAt,
+		&a.DischargeDisposition, &a.Version)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (m *AdmissionModel) get(ctx context.Context, q queryer, patientID, id int64) (*Admission, error) {
+	query := `SELECT ` + admissionColumns + `
+	FROM ` + admissionTables + `
+	WHERE a.id = $1 AND a.patient_id = $2`
+
+	a, err := scanAdmission(q.QueryRowContext(ctx, query, id, patientID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+func (m *AdmissionModel) GetById(ctx context.Context, patientID, id int64) (*Admission, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return m.get(ctx, m.DB, patientID, id)
+}
+
+// GetForPatient returns all of a patient's admissions, the current one first.
+func (m *AdmissionModel) GetForPatient(ctx context.Context, patientID int64) ([]*Admission, error) {
+	query := `SELECT ` + admissionColumns + `
+	FROM ` + admissionTables + `
+	WHERE a.patient_id = $1
+	ORDER BY a.admitted_at DESC, a.id DESC`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, patientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	admissions := []*Admission{}
+	for rows.Next() {
+		a, err := scanAdmission(rows)
+		if err != nil {
+			return nil, err
+		}
+		admissions = append(admissions, a)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return admissions, nil
+}
+
+// lockBed locks a bed for the rest of the transaction and checks a patient can be put
+// in it.
+func lockBed(ctx context.Context, tx *sql.Tx, bedID int64) error {
+	var cleaning string
+	var outOfService, occupied bool
+	err := tx.QueryRowContext(ctx, `
+	SELECT cleaning_status, out_of_service,
+		EXISTS (SELECT 1 FROM admissions WHERE bed_id = beds.id AND status = 'admitted')
+	FROM beds WHERE id = $1 FOR UPDATE`, bedID).Scan(&cleaning, &outOfService, &occupied)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	switch {
+	case occupied:
+		return ErrBedOccupied
+	case outOfService || cleaning != BedClean:
+		return ErrBedNotReady
+	}
+	return nil
+}
+
+func insertMovement(ctx context.Context, tx *sql.Tx, mv *BedMovement) error {
+	query := `INSERT INTO bed_movements (admission_id, type, from_bed_id, to_bed_id, receptionist_id, reason)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, moved_at`
+	return tx.QueryRowContext(ctx, query, mv.AdmissionID, mv.Type, mv.FromBedID, mv.ToBedID,
+		mv.ReceptionistID, mv.Reason).Scan(&mv.ID, &mv.MovedAt)
+}
+
+// Admit admits a patient to a bed. It fails with ErrAlreadyAdmitted when the patient
+// already has an active admission, and ErrBedOccupied or ErrBedNotReady when the bed
+// can't take them.
+func (m *AdmissionModel) Admit(ctx context.Context, a *Admission) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := withTx(m.DB, ctx, func(tx *sql.Tx) error {
+		// Serialise admissions for the same patient.
+		_, err := tx.ExecContext(ctx, `SELECT id FROM patients WHERE id = $1 FOR UPDATE`, a.PatientID)
+		if err != nil {
+			return err
+		}
+		var admitted bool
+		err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM admissions WHERE patient_id = $1 AND status = 'admitted')`,
+			a.PatientID).Scan(&admitted)
+		if err != nil {
+			return err
+		}
+		if admitted {
+			return ErrAlreadyAdmitted
+		}
+		if err := lockBed(ctx, tx, a.BedID); err != nil {
+			return err
+		}
+
+		query := `INSERT INTO admissions (patient_id, bed_id, doctor_id, receptionist_id, reason)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id`
+		err = tx.QueryRowContext(ctx, query, a.PatientID, a.BedID, a.DoctorID, a.ReceptionistID, a.Reason).
+			Scan(&a.ID)
+		if err != nil {
+			return err
+		}
+
+		err = insertMovement(ctx, tx, &BedMovement{
+			AdmissionID:    a.ID,
+			Type:           MovementAdmit,
+			ToBedID:        &a.BedID,
+			ReceptionistID: a.ReceptionistID,
+			Reason:         a.Reason,
+		})
+		if err != nil {
+			return err
+		}
+
+		admission, err := m.get(ctx, tx, a.PatientID, a.ID)
+		if err != nil {
+			return err
+		}
+		*a = *admission
+		return insertEvent(ctx, tx, EventPatientAdmitted, a)
+	})
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "admissions_active_patient_idx"`:
+			return ErrAlreadyAdmitted
+		case err.Error() == `pq: duplicate key value violates unique constraint "admissions_active_bed_idx"`:
+			return ErrBedOccupied
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// lockAdmission locks an active admission for the rest of the transaction.
+func lockAdmission(ctx context.Context, tx *sql.Tx, a *Admission) error {
+	var status string
+	var version int64
+	err := tx.QueryRowContext(ctx, `SELECT status, version FROM admissions WHERE id = $1 FOR UPDATE`, a.ID).
+		Scan(&status, &version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	if version != a.Version {
+		return ErrEditConflict
+	}
+	if status != AdmissionStatusAdmitted {
+		return ErrNotAdmitted
+	}
+	return nil
+}
+
+// Transfer moves an admitted patient to another bed. The bed they leave is marked as
+// needing cleaning.
+func (m *AdmissionModel) Transfer(ctx context.Context, a *Admission, toBedID, receptionistID int64, reason string) (*BedMovement, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	if toBedID == a.BedID {
+		return nil, ErrSameBed
+	}
+
+	fromBedID := a.BedID
+	movement := &BedMovement{
+		AdmissionID:    a.ID,
+		Type:           MovementTransfer,
+		FromBedID:      &fromBedID,
+		ToBedID:        &toBedID,
+		ReceptionistID: receptionistID,
+		Reason:         reason,
+	}
+
+	err := withTx(m.DB, ctx, func(tx *sql.Tx) error {
+		if err := lockAdmission(ctx, tx, a); err != nil {
+			return err
+		}
+		if err := lockBed(ctx, tx, toBedID); err != nil {
+			return err
+		}
+
+		_, err := tx.ExecContext(ctx, `UPDATE admissions SET bed_id = $1, version = version + 1 WHERE id = $2`,
+			toBedID, a.ID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE beds SET cleaning_status = 'dirty', version = version + 1 WHERE id = $1`,
+			fromBedID)
+		if err != nil {
+			return err
+		}
+		if err := insertMovement(ctx, tx, movement); err != nil {
+			return err
+		}
+
+		admission, err := m.get(ctx, tx, a.PatientID, a.ID)
+		if err != nil {
+			return err
+		}
+		*a = *admission
+		return insertEvent(ctx, tx, EventPatientTransferred, a)
+	})
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "admissions_active_bed_idx"`:
+			return nil, ErrBedOccupied
+		default:
+			return nil, err
+		}
+	}
+	return movement, nil
+}
+
+// Discharge ends an admission. The bed is marked as needing cleaning.
+func (m *AdmissionModel) Discharge(ctx context.Context, a *Admission, receptionistID int64, disposition, reason string) (*BedMovement, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	fromBedID := a.BedID
+	movement := &BedMovement{
+		AdmissionID:    a.ID,
+		Type:           MovementDischarge,
+		FromBedID:      &fromBedID,
+		ReceptionistID: receptionistID,
+		Reason:         reason,
+	}
+
+	err := withTx(m.DB, ctx, func(tx *sql.Tx) error {
+		if err := lockAdmission(ctx, tx, a); err != nil {
+			return err
+		}
+
+		_, err := tx.ExecContext(ctx, `UPDATE admissions
+		SET status = 'discharged', discharged_at = NOW(), discharge_disposition = $1, version = version + 1
+		WHERE id = $2`, disposition, a.ID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE beds SET cleaning_status = 'dirty', version = version + 1 WHERE id = $1`,
+			fromBedID)
+		if err != nil {
+			return err
+		}
+		if err := insertMovement(ctx, tx, movement); err != nil {
+			return err
+		}
+
+		admission, err := m.get(ctx, tx, a.PatientID, a.ID)
+		if err != nil {
+			return err
+		}
+		*a = *admission
+		return insertEvent(ctx, tx, EventPatientDischarged, a)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return movement, nil
+}
+
+func (m *AdmissionModel) GetMovements(ctx context.Context, admissionID int64) ([]*BedMovement, error) {
+	query := `SELECT id, admission_id, type, from_bed_id, to_bed_id, receptionist_id, reason, moved_at
+	FROM bed_movements
+	WHERE admission_id = $1
+	ORDER BY moved_at ASC, id ASC`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, admissionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movements := []*BedMovement{}
+	for rows.Next() {
+		var mv BedMovement
+		err := rows.Scan(&mv.ID, &mv.AdmissionID, &mv.Type, &mv.FromBedID, &mv.ToBedID,
+			&mv.ReceptionistID, &mv.Reason, &mv.MovedAt)
+		if err != nil {
+			return nil, err
+		}
+		movements = append(movements, &mv)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return movements, nil
+}
@@ -0,0 +1,143 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+var AttachmentCategories = []string{"referral_letter", "id_card", "consent_form", "image", "other"}
+
+// Attachment is a file uploaded against a patient, such as a scanned referral letter
+// or ID card. The file itself lives in object storage under StorageKey.
+type Attachment struct {
+	ID                       int64     `json:"id"`
+	PatientID                int64     `json:"patientId"`
+	Category                 string    `json:"category"`
+	Title                    string    `json:"title"`
+	FileName                 string    `json:"fileName"`
+	ContentType              string    `json:"contentType"`
+	SizeBytes                int64     `json:"sizeBytes"`
+	Checksum                 string    `json:"checksumSha256"`
+	StorageKey               string    `json:"-"`
+	UploadedByDoctorID       *int64    `json:"uploadedByDoctorId"`
+	UploadedByReceptionistID *int64    `json:"uploadedByReceptionistId"`
+	CreatedAt                time.Time `json:"createdAt"`
+}
+
+func ValidateAttachment(v *validator.Validator, a *Attachment) {
+	v.Check(validator.In(a.Category, AttachmentCategories...), "category",
+		"must be referral_letter, id_card, consent_form, image or other")
+	v.Check(len(a.Title) <= 255, "title", "must not be more than 255 bytes long")
+	v.Check(a.FileName != "", "file", "must have a file name")
+	v.Check(len(a.FileName) <= 255, "file", "file name must not be more than 255 bytes long")
+	v.Check(a.SizeBytes > 0, "file", "must not be empty")
+}
+
+type AttachmentModel struct {
+	DB *sql.DB
+}
+
+const attachmentColumns = `id, patient_id, category, title, file_name, content_type, size_bytes, checksum_sha256,
+	storage_key, uploaded_by_doctor_id, uploaded_by_receptionist_id, created_at`
+
+func scanAttachment(row rowScanner) (*Attachment, error) {
+	var a Attachment
+	err := row.Scan(&a.ID, &a.PatientID, &a.Category, &a.Title, &a.FileName, &a.ContentType, &a.SizeBytes,
+		&a.Checksum, &a.StorageKey, &a.UploadedByDoctorID, &a.UploadedByReceptionistID, &a.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (m *AttachmentModel) Insert(ctx context.Context, a *Attachment) error {
+	query := `INSERT INTO patient_attachments (patient_id, category, title, file_name, content_type, size_bytes,
+	checksum_sha256, storage_key, uploaded_by_doctor_id, uploaded_by_receptionist_id)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, a.PatientID, a.Category, a.Title, a.FileName, a.ContentType,
+		a.SizeBytes, a.Checksum, a.StorageKey, a.UploadedByDoctorID, a.UploadedByReceptionistID).
+		Scan(&a.ID, &a.CreatedAt)
+}
+
+func (m *AttachmentModel) GetById(ctx context.Context, patientID, id int64) (*Attachment, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `SELECT ` + attachmentColumns + `
+	FROM patient_attachments
+	WHERE id = $1 AND patient_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	a, err := scanAttachment(m.DB.QueryRowContext(ctx, query, id, patientID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// GetForPatient lists a patient's attachments, newest first, optionally of a single
+// category.
+func (m *AttachmentModel) GetForPatient(ctx context.Context, patientID int64, category string) ([]*Attachment, error) {
+	query := `SELECT ` + attachmentColumns + `
+	FROM patient_attachments
+	WHERE patient_id = $1 AND (category = $2 OR $2 = '')
+	ORDER BY created_at DESC, id DESC`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, patientID, category)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := []*Attachment{}
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, a)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+func (m *AttachmentModel) Delete(ctx context.Context, patientID, id int64) error {
+	query := `DELETE FROM patient_attachments WHERE id = $1 AND patient_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, patientID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,612 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+const (
+	InvoiceOpen = "open"
+	InvoicePaid = "paid"
+	InvoiceVoid = "void"
+)
+
+var (
+	InvoiceStatuses = []string{InvoiceOpen, InvoicePaid, InvoiceVoid}
+	PaymentMethods  = []string{"cash", "card", "bank_transfer", "mobile_money", "cheque", "other"}
+)
+
+var (
+	ErrDuplicateServiceCode = errors.New("a service with this code already exists")
+	ErrDuplicateReceipt     = errors.New("a payment with this receipt number has already been recorded")
+	ErrInvoiceVoid          = errors.New("invoice has been voided")
+	ErrInvoicePaid          = errors.New("invoice has already been paid in full")
+	ErrInvoiceHasPayments   = errors.New("invoices with payments cannot be voided")
+	ErrOverpayment          = errors.New("payment is more than the outstanding balance")
+)
+
+// BillingService is a chargeable service in the price catalogue.
+type BillingService struct {
+	ID        int64     `json:"id"`
+	Code      string    `json:"code"`
+	Name      string    `json:"name"`
+	UnitPrice Money     `json:"unitPrice"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"createdAt"`
+	Version   int64     `json:"version"`
+}
+
+func ValidateBillingService(v *validator.Validator, s *BillingService) {
+	v.Check(s.Code != "", "code", "must be provided")
+	v.Check(len(s.Code) <= 30, "code", "must not be more than 30 bytes long")
+	v.Check(s.Name != "", "name", "must be provided")
+	v.Check(len(s.Name) <= 255, "name", "must not be more than 255 bytes long")
+	v.Check(validator.Between(s.UnitPrice.Amount, 0, 1_000_000_000_000), "unitPrice", "must be between 0 and 1000000000000")
+	ValidateCurrency(v, "currency", s.UnitPrice.Currency)
+}
+
+type BillingServiceModel struct {
+	DB *sql.DB
+}
+
+const billingServiceColumns = `id, code, name, unit_price, currency, active, created_at, version`
+
+func scanBillingService(row rowScanner) (*BillingService, error) {
+	var s BillingService
+	err := row.Scan(&s.ID, &s.Code, &s.Name, &s.UnitPrice.Amount, &s.UnitPrice.Currency, &s.Active,
+		&s.CreatedAt, &s.Version)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (m *BillingServiceModel) Insert(ctx context.Context, s *BillingService) error {
+	query := `INSERT INTO billing_services (code, name, unit_price, currency, active)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, s.Code, s.Name, s.UnitPrice.Amount, s.UnitPrice.Currency, s.Active).
+		Scan(&s.ID, &s.CreatedAt, &s.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "billing_services_code_key"`:
+			return ErrDuplicateServiceCode
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *BillingServiceModel) GetById(ctx context.Context, id int64) (*BillingService, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `SELECT ` + billingServiceColumns + ` FROM billing_services WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	s, err := scanBillingService(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// GetAll returns the catalogue ordered by code, optionally only the services that
+// can still be charged for and matching a search on code or name.
+func (m *BillingServiceModel) GetAll(ctx context.Context, search string, activeOnly bool) ([]*BillingService, error) {
+	query := `SELECT ` + billingServiceColumns + `
+	FROM billing_services
+	WHERE (code ILIKE '%' || $1 || '%' OR name ILIKE '%' || $1 || '%')
+	AND (active OR NOT $2)
+	ORDER BY code`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, search, activeOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	services := []*BillingService{}
+	for rows.Next() {
+		s, err := scanBillingService(rows)
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return services, nil
+}
+
+// Update changes a service. Invoices keep the price they were raised with.
+func (m *BillingServiceModel) Update(ctx context.Context, s *BillingService) error {
+	query := `UPDATE billing_services
+	SET code = $1, name = $2, unit_price = $3, currency = $4, active = $5, version = version + 1
+	WHERE id = $6 AND version = $7
+	RETURNING version`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, s.Code, s.Name, s.UnitPrice.Amount, s.UnitPrice.Currency, s.Active,
+		s.ID, s.Version).Scan(&s.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case err.Error() == `pq: duplicate key value violates unique constraint "billing_services_code_key"`:
+			return ErrDuplicateServiceCode
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Invoice is a bill raised for a patient. All of its lines and payments are in the
+// invoice's currency.
+type Invoice struct {
+	ID         int64          `json:"id"`
+	Number     string         `json:"number"`
+	PatientID  int64          `json:"patientId"`
+	Total      Money          `json:"total"`
+	Paid       Money          `json:"paid"`
+	Balance    Money          `json:"balance"`
+	Status     string         `json:"status"`
+	Notes      string         `json:"notes"`
+	DueOn      *Date          `json:"dueOn"`
+	CreatedBy  int64          `json:"createdBy"`
+	IssuedAt   time.Time      `json:"issuedAt"`
+	VoidedAt   *time.Time     `json:"voidedAt"`
+	VoidReason string         `json:"voidReason,omitempty"`
+	Lines      []*InvoiceLine `json:"lines,omitempty"`
+	Version    int64          `json:"version"`
+}
+
+type InvoiceLine struct {
+	ID          int64  `json:"id"`
+	InvoiceID   int64  `json:"invoiceId"`
+	ServiceID   *int64 `json:"serviceId"`
+	Description string `json:"description"`
+	Quantity    int    `json:"quantity"`
+	UnitPrice   Money  `json:"unitPrice"`
+	Amount      Money  `json:"amount"`
+}
+
+// AddLine adds a line for quantity of the service to the invoice and updates its
+// total.
+func (inv *Invoice) AddLine(s *BillingService, quantity int, description string) {
+	if description == "" {
+		description = s.Name
+	}
+	line := &InvoiceLine{
+		ServiceID:   &s.ID,
+		Description: description,
+		Quantity:    quantity,
+		UnitPrice:   s.UnitPrice,
+		Amount:      NewMoney(s.UnitPrice.Amount*int64(quantity), s.UnitPrice.Currency),
+	}
+	inv.Lines = append(inv.Lines, line)
+	inv.Total.Amount += line.Amount.Amount
+	inv.Balance.Amount = inv.Total.Amount - inv.Paid.Amount
+}
+
+func ValidateInvoice(v *validator.Validator, inv *Invoice) {
+	v.Check(len(inv.Lines) > 0, "lines", "must contain at least one line")
+	for i, line := range inv.Lines {
+		v.Check(line.UnitPrice.Currency == inv.Total.Currency, fmt.Sprintf("lines[%d]", i),
+			fmt.Sprintf("is charged in %s but the invoice is in %s", line.UnitPrice.Currency, inv.Total.Currency))
+		v.Check(validator.Between(line.Quantity, 1, 1000), fmt.Sprintf("lines[%d].quantity", i),
+			"must be between 1 and 1000")
+	}
+	v.Check(len(inv.Notes) <= 2000, "notes", "must not be more than 2000 bytes long")
+}
+
+// Payment is money received against an invoice.
+type Payment struct {
+	ID            int64     `json:"id"`
+	InvoiceID     int64     `json:"invoiceId"`
+	PatientID     int64     `json:"patientId"`
+	Amount        Money     `json:"amount"`
+	Method        string    `json:"method"`
+	ReceiptNumber string    `json:"receiptNumber"`
+	Notes         string    `json:"notes"`
+	ReceivedBy    int64     `json:"receivedBy"`
+	ReceivedAt    time.Time `json:"receivedAt"`
+}
+
+func ValidatePayment(v *validator.Validator, p *Payment) {
+	v.Check(p.Amount.Amount > 0, "amount", "must be greater than zero")
+	v.Check(validator.In(p.Method, PaymentMethods...), "method",
+		"must be cash, card, bank_transfer, mobile_money, cheque or other")
+	v.Check(p.ReceiptNumber != "", "receiptNumber", "must be provided")
+	v.Check(len(p.ReceiptNumber) <= 50, "receiptNumber", "must not be more than 50 bytes long")
+}
+
+type InvoiceModel struct {
+	DB *sql.DB
+}
+
+const invoiceColumns = `id, number, patient_id, currency, total, paid, status, notes, due_on, created_by,
+	issued_at, voided_at, void_reason, version`
+
+func scanInvoice(row rowScanner, extra ...any) (*Invoice, error) {
+	var inv Invoice
+	var currency string
+	dest := append(extra, &inv.ID, &inv.Number, &inv.PatientID, &currency, &inv.Total.Amount, &inv.Paid.Amount,
+		&inv.Status, &inv.Notes, &inv.DueOn, &inv.CreatedBy, &inv.IssuedAt, &inv.VoidedAt, &inv.VoidReason,
+		&inv.Version)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	inv.setCurrency(currency)
+	return &inv, nil
+}
+
+func (inv *Invoice) setCurrency(currency string) {
+	inv.Total.Currency = currency
+	inv.Paid.Currency = currency
+	inv.Balance = NewMoney(inv.Total.Amount-inv.Paid.Amount, currency)
+}
+
+// Insert raises the invoice together with its lines.
+func (m *InvoiceModel) Insert(ctx context.Context, inv *Invoice) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(m.DB, ctx, func(tx *sql.Tx) error {
+		query := `INSERT INTO invoices (patient_id, currency, total, notes, due_on, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, number, status, issued_at, version`
+
+		err := tx.QueryRowContext(ctx, query, inv.PatientID, inv.Total.Currency, inv.Total.Amount, inv.Notes,
+			inv.DueOn, inv.CreatedBy).Scan(&inv.ID, &inv.Number, &inv.Status, &inv.IssuedAt, &inv.Version)
+		if err != nil {
+			return err
+		}
+		inv.setCurrency(inv.Total.Currency)
+
+		for _, line := range inv.Lines {
+			line.InvoiceID = inv.ID
+			err := tx.QueryRowContext(ctx, `INSERT INTO invoice_lines (invoice_id, service_id, description,
+			quantity, unit_price, amount)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id`, line.InvoiceID, line.ServiceID, line.Description, line.Quantity,
+				line.UnitPrice.Amount, line.Amount.Amount).Scan(&line.ID)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetById returns an invoice with its lines.
+func (m *InvoiceModel) GetById(ctx context.Context, patientID, id int64) (*Invoice, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `SELECT ` + invoiceColumns + ` FROM invoices WHERE id = $1 AND patient_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	inv, err := scanInvoice(m.DB.QueryRowContext(ctx, query, id, patientID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT id, invoice_id, service_id, description, quantity, unit_price, amount
+	FROM invoice_lines
+	WHERE invoice_id = $1
+	ORDER BY id`, inv.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var line InvoiceLine
+		err := rows.Scan(&line.ID, &line.InvoiceID, &line.ServiceID, &line.Description, &line.Quantity,
+			&line.UnitPrice.Amount, &line.Amount.Amount)
+		if err != nil {
+			return nil, err
+		}
+		line.UnitPrice.Currency = inv.Total.Currency
+		line.Amount.Currency = inv.Total.Currency
+		inv.Lines = append(inv.Lines, &line)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// GetForPatient lists a patient's invoices without their lines.
+func (m *InvoiceModel) GetForPatient(ctx context.Context, patientID int64, status string, filters Filters) ([]*Invoice, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), `+invoiceColumns+`
+	FROM invoices
+	WHERE patient_id = $1 AND (status = $2 OR $2 = '')
+	ORDER BY %s %s, id DESC
+	LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, patientID, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	invoices := []*Invoice{}
+	for rows.Next() {
+		inv, err := scanInvoice(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		invoices = append(invoices, inv)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return invoices, metadata, nil
+}
+
+// Void cancels an invoice that nothing has been paid against.
+func (m *InvoiceModel) Void(ctx context.Context, inv *Invoice, reason string) error {
+	switch {
+	case inv.Status == InvoiceVoid:
+		return ErrInvoiceVoid
+	case inv.Paid.Amount > 0:
+		return ErrInvoiceHasPayments
+	}
+	query := `UPDATE invoices
+	SET status = 'void', voided_at = NOW(), void_reason = $1, version = version + 1
+	WHERE id = $2 AND version = $3 AND paid = 0
+	RETURNING status, voided_at, version`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, reason, inv.ID, inv.Version).Scan(&inv.Status, &inv.VoidedAt, &inv.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	inv.VoidReason = reason
+	return nil
+}
+
+// AddPayment records a payment against the invoice. The invoice row is locked so
+// that payments taken at the same time can't together exceed the balance.
+func (m *InvoiceModel) AddPayment(ctx context.Context, inv *Invoice, p *Payment) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(m.DB, ctx, func(tx *sql.Tx) error {
+		locked, err := scanInvoice(tx.QueryRowContext(ctx, `SELECT `+invoiceColumns+`
+		FROM invoices WHERE id = $1 FOR UPDATE`, inv.ID))
+		if err != nil {
+			return err
+		}
+		switch {
+		case locked.Status == InvoiceVoid:
+			return ErrInvoiceVoid
+		case locked.Status == InvoicePaid:
+			return ErrInvoicePaid
+		case p.Amount.Amount > locked.Balance.Amount:
+			return ErrOverpayment
+		}
+
+		p.InvoiceID = locked.ID
+		p.PatientID = locked.PatientID
+		p.Amount.Currency = locked.Total.Currency
+		err = tx.QueryRowContext(ctx, `INSERT INTO payments (invoice_id, patient_id, amount, currency, method,
+		receipt_number, notes, received_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, received_at`, p.InvoiceID, p.PatientID, p.Amount.Amount, p.Amount.Currency, p.Method,
+			p.ReceiptNumber, p.Notes, p.ReceivedBy).Scan(&p.ID, &p.ReceivedAt)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "payments_receipt_number_key"`:
+				return ErrDuplicateReceipt
+			default:
+				return err
+			}
+		}
+
+		locked.Paid.Amount += p.Amount.Amount
+		status := InvoiceOpen
+		if locked.Paid.Amount == locked.Total.Amount {
+			status = InvoicePaid
+		}
+		err = tx.QueryRowContext(ctx, `UPDATE invoices
+		SET paid = $1, status = $2, version = version + 1
+		WHERE id = $3
+		RETURNING status, version`, locked.Paid.Amount, status, locked.ID).Scan(&locked.Status, &locked.Version)
+		if err != nil {
+			return err
+		}
+
+		locked.setCurrency(locked.Total.Currency)
+		locked.Lines = inv.Lines
+		*inv = *locked
+		return nil
+	})
+}
+
+func (m *InvoiceModel) GetPayments(ctx context.Context, invoiceID int64) ([]*Payment, error) {
+	query := `SELECT id, invoice_id, patient_id, amount, currency, method, receipt_number, notes, received_by,
+	received_at
+	FROM payments
+	WHERE invoice_id = $1
+	ORDER BY received_at, id`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := []*Payment{}
+	for rows.Next() {
+		var p Payment
+		err := rows.Scan(&p.ID, &p.InvoiceID, &p.PatientID, &p.Amount.Amount, &p.Amount.Currency, &p.Method,
+			&p.ReceiptNumber, &p.Notes, &p.ReceivedBy, &p.ReceivedAt)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, &p)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return payments, nil
+}
+
+// Balances returns what the patient owes in each currency they have been billed in.
+func (m *InvoiceModel) Balances(ctx context.Context, patientID int64) ([]Money, error) {
+	query := `SELECT currency, COALESCE(SUM(total - paid), 0)
+	FROM invoices
+	WHERE patient_id = $1 AND status <> 'void'
+	GROUP BY currency
+	ORDER BY currency`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, patientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balances := []Money{}
+	for rows.Next() {
+		var b Money
+		if err := rows.Scan(&b.Currency, &b.Amount); err != nil {
+			return nil, err
+		}
+		balances = append(balances, b)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return balances, nil
+}
+
+// StatementEntry is an invoice (a debit) or a payment (a credit) on a statement.
+type StatementEntry struct {
+	Date        time.Time `json:"date"`
+	Type        string    `json:"type"`
+	Reference   string    `json:"reference"`
+	Description string    `json:"description"`
+	Debit       Money     `json:"debit"`
+	Credit      Money     `json:"credit"`
+	Balance     Money     `json:"balance"`
+}
+
+// Statement is a patient's account in one currency over a period.
+type Statement struct {
+	PatientID      int64             `json:"patientId"`
+	Currency       string            `json:"currency"`
+	From           time.Time         `json:"from"`
+	To             time.Time         `json:"to"`
+	OpeningBalance Money             `json:"openingBalance"`
+	Entries        []*StatementEntry `json:"entries"`
+	ClosingBalance Money             `json:"closingBalance"`
+}
+
+// Statement lists the invoices and payments in [from, to) with a running balance.
+// Voided invoices are left out.
+func (m *InvoiceModel) Statement(ctx context.Context, patientID int64, currency string, from, to time.Time) (*Statement, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	s := &Statement{PatientID: patientID, Currency: currency, From: from, To: to, Entries: []*StatementEntry{}}
+
+	var opening int64
+	err := m.DB.QueryRowContext(ctx, `SELECT
+		COALESCE((SELECT SUM(total) FROM invoices
+			WHERE patient_id = $1 AND currency = $2 AND status <> 'void' AND issued_at < $3), 0) -
+		COALESCE((SELECT SUM(amount) FROM payments
+			WHERE patient_id = $1 AND currency = $2 AND received_at < $3), 0)`,
+		patientID, currency, from).Scan(&opening)
+	if err != nil {
+		return nil, err
+	}
+	s.OpeningBalance = NewMoney(opening, currency)
+
+	query := `
+	SELECT issued_at, 'invoice', number, 'Invoice ' || number, total, 0
+	FROM invoices
+	WHERE patient_id = $1 AND currency = $2 AND status <> 'void' AND issued_at >= $3 AND issued_at < $4
+	UNION ALL
+	SELECT p.received_at, 'payment', p.receipt_number, 'Payment (' || p.method || ') for ' || i.number, 0, p.amount
+	FROM payments p
+	JOIN invoices i ON i.id = p.invoice_id
+	WHERE p.patient_id = $1 AND p.currency = $2 AND p.received_at >= $3 AND p.received_at < $4
+	ORDER BY 1, 2`
+
+	rows, err := m.DB.QueryContext(ctx, query, patientID, currency, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balance := opening
+	for rows.Next() {
+		e := StatementEntry{Debit: NewMoney(0, currency), Credit: NewMoney(0, currency)}
+		err := rows.Scan(&e.Date, &e.Type, &e.Reference, &e.Description, &e.Debit.Amount, &e.Credit.Amount)
+		if err != nil {
+			return nil, err
+		}
+		balance += e.Debit.Amount - e.Credit.Amount
+		e.Balance = NewMoney(balance, currency)
+		s.Entries = append(s.Entries, &e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	s.ClosingBalance = NewMoney(balance, currency)
+	return s, nil
+}
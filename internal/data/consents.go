@@ -0,0 +1,204 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+const (
+	ConsentTreatment   = "treatment"
+	ConsentDataSharing = "data_sharing"
+	ConsentResearch    = "research"
+	ConsentEmail       = "email"
+	ConsentSMS         = "sms"
+)
+
+var ConsentTypes = []string{ConsentTreatment, ConsentDataSharing, ConsentResearch, ConsentEmail, ConsentSMS}
+
+var ErrConsentWithdrawn = errors.New("consent has already been withdrawn or replaced")
+
+// Consent records a patient agreeing to one version of a consent text, witnessed by a
+// receptionist. Recording a new consent of the same type supersedes the old one; a
+// consent stops being active when it is superseded or withdrawn.
+type Consent struct {
+	ID                 int64      `json:"id"`
+	PatientID          int64      `json:"patientId"`
+	Type               string     `json:"type"`
+	TextVersion        string     `json:"textVersion"`
+	ConsentedOn        Date       `json:"consentedOn"`
+	WitnessID          int64      `json:"witnessId"`
+	Active             bool       `json:"active"`
+	SupersededAt       *time.Time `json:"supersededAt"`
+	WithdrawnAt        *time.Time `json:"withdrawnAt"`
+	WithdrawnWitnessID *int64     `json:"withdrawnWitnessId"`
+	WithdrawalReason   string     `json:"withdrawalReason,omitempty"`
+	CreatedAt          time.Time  `json:"createdAt"`
+	Version            int64      `json:"version"`
+}
+
+func ValidateConsent(v *validator.Validator, c *Consent) {
+	v.Check(validator.In(c.Type, ConsentTypes...), "type", "must be treatment, data_sharing, research, email or sms")
+	v.Check(c.TextVersion != "", "textVersion", "must be provided")
+	v.Check(len(c.TextVersion) <= 50, "textVersion", "must not be more than 50 bytes long")
+	v.Check(!c.ConsentedOn.After(time.Now()), "consentedOn", "must not be in the future")
+}
+
+type ConsentModel struct {
+	DB *sql.DB
+}
+
+const consentColumns = `id, patient_id, type, text_version, consented_on, witness_id,
+	superseded_at IS NULL AND withdrawn_at IS NULL, superseded_at, withdrawn_at, withdrawn_witness_id,
+	withdrawal_reason, created_at, version`
+
+func scanConsent(row rowScanner) (*Consent, error) {
+	var c Consent
+	err := row.Scan(&c.ID, &c.PatientID, &c.Type, &c.TextVersion, &c.ConsentedOn, &c.WitnessID, &c.Active,
+		&c.SupersededAt, &c.WithdrawnAt, &c.WithdrawnWitnessID, &c.WithdrawalReason, &c.CreatedAt, &c.Version)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Insert records a consent, superseding the patient's active consent of the same
+// type if they have one.
+func (m *ConsentModel) Insert(ctx context.Context, c *Consent) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(m.DB, ctx, func(tx *sql.Tx) error {
+		return insertConsent(ctx, tx, c)
+	})
+}
+
+// insertConsent records the consent, superseding the patient's active consent of the
+// same type.
+func insertConsent(ctx context.Context, tx *sql.Tx, c *Consent) error {
+	_, err := tx.ExecContext(ctx, `UPDATE patient_consents
+	SET superseded_at = NOW(), version = version + 1
+	WHERE patient_id = $1 AND type = $2 AND superseded_at IS NULL AND withdrawn_at IS NULL`,
+		c.PatientID, c.Type)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO patient_consents (patient_id, type, text_version, consented_on, witness_id)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at, version`
+
+	err = tx.QueryRowContext(ctx, query, c.PatientID, c.Type, c.TextVersion, c.ConsentedOn, c.WitnessID).
+		Scan(&c.ID, &c.CreatedAt, &c.Version)
+	if err != nil {
+		switch {
+		// Someone else recorded a consent of this type at the same time.
+		case err.Error() == `pq: duplicate key value violates unique constraint "patient_consents_active_idx"`:
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	c.Active = true
+	return nil
+}
+
+func (m *ConsentModel) GetById(ctx context.Context, patientID, id int64) (*Consent, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `SELECT ` + consentColumns + `
+	FROM patient_consents
+	WHERE id = $1 AND patient_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	c, err := scanConsent(m.DB.QueryRowContext(ctx, query, id, patientID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// GetForPatient returns a patient's consents, newest first. With activeOnly set the
+// superseded and withdrawn ones are left out.
+func (m *ConsentModel) GetForPatient(ctx context.Context, patientID int64, activeOnly bool) ([]*Consent, error) {
+	query := `SELECT ` + consentColumns + `
+	FROM patient_consents
+	WHERE patient_id = $1 AND (NOT $2 OR (superseded_at IS NULL AND withdrawn_at IS NULL))
+	ORDER BY created_at DESC, id DESC`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, patientID, activeOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	consents := []*Consent{}
+	for rows.Next() {
+		c, err := scanConsent(rows)
+		if err != nil {
+			return nil, err
+		}
+		consents = append(consents, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return consents, nil
+}
+
+// HasActive reports whether the patient currently consents to consentType.
+func (m *ConsentModel) HasActive(ctx context.Context, patientID int64, consentType string) (bool, error) {
+	query := `SELECT EXISTS (
+		SELECT 1 FROM patient_consents
+		WHERE patient_id = $1 AND type = $2 AND superseded_at IS NULL AND withdrawn_at IS NULL
+	)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var active bool
+	err := m.DB.QueryRowContext(ctx, query, patientID, consentType).Scan(&active)
+	return active, err
+}
+
+// Withdraw records the patient withdrawing an active consent.
+func (m *ConsentModel) Withdraw(ctx context.Context, c *Consent, witnessID int64, reason string) error {
+	if !c.Active {
+		return ErrConsentWithdrawn
+	}
+	query := `UPDATE patient_consents
+	SET withdrawn_at = NOW(), withdrawn_witness_id = $1, withdrawal_reason = $2, version = version + 1
+	WHERE id = $3 AND version = $4 AND superseded_at IS NULL AND withdrawn_at IS NULL
+	RETURNING withdrawn_at, version`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, witnessID, reason, c.ID, c.Version).Scan(&c.WithdrawnAt, &c.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	c.Active = false
+	c.WithdrawnWitnessID = &witnessID
+	c.WithdrawalReason = reason
+	return nil
+}
@@ -0,0 +1,194 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+var (
+	ErrDuplicateDepartment = errors.New("a department with this name already exists")
+	ErrDuplicateSpecialty  = errors.New("a specialty with this name already exists")
+)
+
+type Department struct {
+	ID          int64     `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	DoctorCount int       `json:"doctorCount"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Version     int64     `json:"version"`
+}
+
+func ValidateDepartment(v *validator.Validator, d *Department) {
+	v.Check(d.Name != "", "name", "must be provided")
+	v.Check(len(d.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(len(d.Description) <= 2000, "description", "must not be more than 2000 bytes long")
+}
+
+type DepartmentModel struct {
+	DB *sql.DB
+}
+
+const departmentColumns = `d.id, d.name, d.description,
+	(SELECT count(*) FROM doctors doc WHERE doc.department_id = d.id), d.created_at, d.version`
+
+func scanDepartment(row rowScanner) (*Department, error) {
+	var d Department
+	err := row.Scan(&d.ID, &d.Name, &d.Description, &d.DoctorCount, &d.CreatedAt, &d.Version)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (m *DepartmentModel) Insert(ctx context.Context, d *Department) error {
+	query := `INSERT INTO departments (name, description)
+	VALUES ($1, $2)
+	RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, d.Name, d.Description).Scan(&d.ID, &d.CreatedAt, &d.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "departments_name_key"`:
+			return ErrDuplicateDepartment
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *DepartmentModel) GetById(ctx context.Context, id int64) (*Department, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `SELECT ` + departmentColumns + ` FROM departments d WHERE d.id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	d, err := scanDepartment(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+func (m *DepartmentModel) GetAll(ctx context.Context) ([]*Department, error) {
+	query := `SELECT ` + departmentColumns + ` FROM departments d ORDER BY d.name`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	departments := []*Department{}
+	for rows.Next() {
+		d, err := scanDepartment(rows)
+		if err != nil {
+			return nil, err
+		}
+		departments = append(departments, d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return departments, nil
+}
+
+func (m *DepartmentModel) Update(ctx context.Context, d *Department) error {
+	query := `UPDATE departments
+	SET name = $1, description = $2, version = version + 1
+	WHERE id = $3 AND version = $4
+	RETURNING version`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, d.Name, d.Description, d.ID, d.Version).Scan(&d.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case err.Error() == `pq: duplicate key value violates unique constraint "departments_name_key"`:
+			return ErrDuplicateDepartment
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+type Specialty struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+func ValidateSpecialty(v *validator.Validator, s *Specialty) {
+	v.Check(s.Name != "", "name", "must be provided")
+	v.Check(len(s.Name) <= 100, "name", "must not be more than 100 bytes long")
+}
+
+type SpecialtyModel struct {
+	DB *sql.DB
+}
+
+func (m *SpecialtyModel) Insert(ctx context.Context, s *Specialty) error {
+	query := `INSERT INTO specialties (name) VALUES ($1) RETURNING id`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, s.Name).Scan(&s.ID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "specialties_name_key"`:
+			return ErrDuplicateSpecialty
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *SpecialtyModel) GetAll(ctx context.Context) ([]*Specialty, error) {
+	query := `SELECT id, name FROM specialties ORDER BY name`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	specialties := []*Specialty{}
+	for rows.Next() {
+		var s Specialty
+		if err := rows.Scan(&s.ID, &s.Name); err != nil {
+			return nil, err
+		}
+		specialties = append(specialties, &s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return specialties, nil
+}
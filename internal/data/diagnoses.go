@@ -0,0 +1,419 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+const (
+	ProblemStatusActive   = "active"
+	ProblemStatusResolved = "resolved"
+	ProblemStatusRuledOut = "ruled-out"
+)
+
+var ProblemStatuses = []string{ProblemStatusActive, ProblemStatusResolved, ProblemStatusRuledOut}
+
+// ICD10CodeRX matches an ICD-10 code in its dotted form, e.g. "E11" or "E11.65".
+var ICD10CodeRX = regexp.MustCompile(`^[A-Z][0-9][0-9A-Z](\.[0-9A-Z]{1,4})?$`)
+
+var ErrUnknownICD10Code = errors.New("unknown ICD-10 code")
+
+type ICD10Code struct {
+	Code        string `json:"code"`
+	Description string `json:"description"`
+}
+
+// NormalizeICD10Code upper-cases a code and inserts the dot after the category if it
+// was left out, so "e1165" becomes "E11.65".
+func NormalizeICD10Code(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if len(code) > 3 && !strings.Contains(code, ".") {
+		code = code[:3] + "." + code[3:]
+	}
+	return code
+}
+
+type ICD10Model struct {
+	DB *sql.DB
+}
+
+// Import loads codes into the catalogue, replacing the description of codes that are
+// already present. The rows are streamed in with COPY and merged in a single
+// transaction, so a failed import leaves the catalogue untouched.
+func (m *ICD10Model) Import(ctx context.Context, codes []ICD10Code) (int64, error) {
+	var imported int64
+	err := withTx(m.DB, ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `CREATE TEMP TABLE icd10_import (code VARCHAR(8), description TEXT) ON COMMIT DROP`)
+		if err != nil {
+			return err
+		}
+
+		stmt, err := tx.PrepareContext(ctx, pq.CopyIn("icd10_import", "code", "description"))
+		if err != nil {
+			return err
+		}
+		for _, c := range codes {
+			if _, err := stmt.ExecContext(ctx, c.Code, c.Description); err != nil {
+				stmt.Close()
+				return err
+			}
+		}
+		if _, err := stmt.ExecContext(ctx); err != nil {
+			stmt.Close()
+			return err
+		}
+		if err := stmt.Close(); err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, `
+		INSERT INTO icd10_codes (code, description)
+		SELECT DISTINCT ON (code) code, description FROM icd10_import
+		ON CONFLICT (code) DO UPDATE SET description = EXCLUDED.description`)
+		if err != nil {
+			return err
+		}
+		imported, err = res.RowsAffected()
+		return err
+	})
+	return imported, err
+}
+
+func (m *ICD10Model) Get(ctx context.Context, code string) (*ICD10Code, error) {
+	query := `SELECT code, description FROM icd10_codes WHERE code = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var c ICD10Code
+	err := m.DB.QueryRowContext(ctx, query, NormalizeICD10Code(code)).Scan(&c.Code, &c.Description)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &c, nil
+}
+
+// Search matches the term against the start of the code or the words of the
+// description. Code matches are listed first.
+func (m *ICD10Model) Search(ctx context.Context, term string, filters Filters) ([]*ICD10Code, Metadata, error) {
+	query := `
+	SELECT count(*) OVER(), code, description
+	FROM icd10_codes
+	WHERE code LIKE $1 || '%'
+	OR to_tsvector('english', description) @@ plainto_tsquery('english', $2)
+	ORDER BY (code LIKE $1 || '%') DESC, code ASC
+	LIMIT $3 OFFSET $4`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, NormalizeICD10Code(term), term, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	codes := []*ICD10Code{}
+	for rows.Next() {
+		var c ICD10Code
+		if err := rows.Scan(&totalRecords, &c.Code, &c.Description); err != nil {
+			return nil, Metadata{}, err
+		}
+		codes = append(codes, &c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return codes, metadata, nil
+}
+
+// Missing returns the codes that aren't in the catalogue.
+func (m *ICD10Model) Missing(ctx context.Context, codes []string) ([]string, error) {
+	query := `SELECT c FROM unnest($1::text[]) AS c
+	WHERE NOT EXISTS (SELECT 1 FROM icd10_codes WHERE code = c)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(codes))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	missing := []string{}
+	for rows.Next() {
+		var c string
+		if err := rows.Scan(&c); err != nil {
+			return nil, err
+		}
+		missing = append(missing, c)
+	}
+	return missing, rows.Err()
+}
+
+// Problem is an entry on a patient's problem list.
+type Problem struct {
+	ID           int64     `json:"id"`
+	PatientID    int64     `json:"patientId"`
+	Code         string    `json:"code"`
+	Description  string    `json:"description"`
+	Status       string    `json:"status"`
+	OnsetDate    *Date     `json:"onsetDate"`
+	ResolvedDate *Date     `json:"resolvedDate"`
+	Notes        string    `json:"notes"`
+	DoctorID     int64     `json:"doctorId"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+	Version      int64     `json:"version"`
+}
+
+func ValidateProblem(v *validator.Validator, p *Problem) {
+	v.Check(validator.In(p.Status, ProblemStatuses...), "status", "must be active, resolved or ruled-out")
+	if p.OnsetDate != nil {
+		v.Check(!p.OnsetDate.After(time.Now()), "onsetDate", "must not be in the future")
+	}
+	if p.Status == ProblemStatusActive {
+		v.Check(p.ResolvedDate == nil, "resolvedDate", "must not be set on an active problem")
+	}
+	if p.ResolvedDate != nil && p.OnsetDate != nil {
+		v.Check(!p.ResolvedDate.Before(p.OnsetDate.Time), "resolvedDate", "must not be before the onset date")
+	}
+}
+
+type ProblemModel struct {
+	DB *sql.DB
+}
+
+const problemColumns = `p.id, p.patient_id, p.code, c.description, p.status, p.onset_date,
+	p.resolved_date, p.notes, p.doctor_id, p.created_at, p.updated_at, p.version`
+
+func scanProblem(row rowScanner) (*Problem, error) {
+	var p Problem
+	err := row.Scan(&p.ID, &p.PatientID, &p.Code, &p.Description, &p.Status, &p.OnsetDate,
+		&p.ResolvedDate, &p.Notes, &p.DoctorID, &p.CreatedAt, &p.UpdatedAt, &p.Version)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (m *ProblemModel) Insert(ctx context.Context, p *Problem) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(m.DB, ctx, func(tx *sql.Tx) error {
+		return insertProblem(ctx, tx, p)
+	})
+}
+
+func insertProblem(ctx context.Context, tx *sql.Tx, p *Problem) error {
+	query := `INSERT INTO problems (patient_id, code, status, onset_date, resolved_date, notes, doctor_id)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING id, created_at, updated_at, version,
+	(SELECT description FROM icd10_codes WHERE code = $2)`
+
+	err := tx.QueryRowContext(ctx, query, p.PatientID, p.Code, p.Status, p.OnsetDate,
+		p.ResolvedDate, p.Notes, p.DoctorID).
+		Scan(&p.ID, &p.CreatedAt, &p.UpdatedAt, &p.Version, &p.Description)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: insert or update on table "problems" violates foreign key constraint "problems_code_fkey"`:
+			return ErrUnknownICD10Code
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *ProblemModel) GetById(ctx context.Context, patientID, id int64) (*Problem, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `SELECT ` + problemColumns + `
+	FROM problems p
+	JOIN icd10_codes c ON c.code = p.code
+	WHERE p.id = $1 AND p.patient_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	p, err := scanProblem(m.DB.QueryRowContext(ctx, query, id, patientID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// GetForPatient returns the patient's problem list, active problems first. An empty
+// status returns every problem.
+func (m *ProblemModel) GetForPatient(ctx context.Context, patientID int64, status string) ([]*Problem, error) {
+	query := `SELECT ` + problemColumns + `
+	FROM problems p
+	JOIN icd10_codes c ON c.code = p.code
+	WHERE p.patient_id = $1 AND (p.status = $2 OR $2 = '')
+	ORDER BY (p.status = 'active') DESC, p.onset_date DESC NULLS LAST, p.id DESC`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, patientID, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	problems := []*Problem{}
+	for rows.Next() {
+		p, err := scanProblem(rows)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, p)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return problems, nil
+}
+
+func (m *ProblemModel) Update(ctx context.Context, p *Problem) error {
+	query := `UPDATE problems
+	SET status = $1, onset_date = $2, resolved_date = $3, notes = $4,
+	updated_at = NOW(), version = version + 1
+	WHERE id = $5 AND version = $6
+	RETURNING updated_at, version`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, p.Status, p.OnsetDate, p.ResolvedDate, p.Notes,
+		p.ID, p.Version).Scan(&p.UpdatedAt, &p.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Diagnosis records that a doctor made a diagnosis, optionally during an encounter.
+// Every diagnosis is linked to the problem list entry for its code.
+type Diagnosis struct {
+	ID          int64     `json:"id"`
+	PatientID   int64     `json:"patientId"`
+	ProblemID   int64     `json:"problemId"`
+	EncounterID *int64    `json:"encounterId"`
+	DoctorID    int64     `json:"doctorId"`
+	Code        string    `json:"code"`
+	Description string    `json:"description"`
+	Notes       string    `json:"notes"`
+	DiagnosedAt time.Time `json:"diagnosedAt"`
+}
+
+type DiagnosisModel struct {
+	DB *sql.DB
+}
+
+// recordDiagnoses saves the diagnoses and adds any code that isn't already an active
+// problem to the patient's problem list.
+func recordDiagnoses(ctx context.Context, tx *sql.Tx, diagnoses []*Diagnosis) error {
+	for _, d := range diagnoses {
+		err := tx.QueryRowContext(ctx, `
+		SELECT id FROM problems
+		WHERE patient_id = $1 AND code = $2 AND status = 'active'
+		ORDER BY id DESC LIMIT 1
+		FOR UPDATE`, d.PatientID, d.Code).Scan(&d.ProblemID)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			onset := NewDate(d.DiagnosedAt)
+			problem := &Problem{
+				PatientID: d.PatientID,
+				Code:      d.Code,
+				Status:    ProblemStatusActive,
+				OnsetDate: &onset,
+				Notes:     d.Notes,
+				DoctorID:  d.DoctorID,
+			}
+			if err := insertProblem(ctx, tx, problem); err != nil {
+				return err
+			}
+			d.ProblemID = problem.ID
+		case err != nil:
+			return err
+		}
+
+		query := `INSERT INTO diagnoses (patient_id, problem_id, encounter_id, doctor_id, code, notes, diagnosed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, (SELECT description FROM icd10_codes WHERE code = $5)`
+		err = tx.QueryRowContext(ctx, query, d.PatientID, d.ProblemID, d.EncounterID, d.DoctorID,
+			d.Code, d.Notes, d.DiagnosedAt).Scan(&d.ID, &d.Description)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *DiagnosisModel) GetForPatient(ctx context.Context, patientID int64, filters Filters) ([]*Diagnosis, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), d.id, d.patient_id, d.problem_id, d.encounter_id, d.doctor_id,
+	d.code, c.description, d.notes, d.diagnosed_at
+	FROM diagnoses d
+	JOIN icd10_codes c ON c.code = d.code
+	WHERE d.patient_id = $1
+	ORDER BY %s %s, d.id DESC
+	LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, patientID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	diagnoses := []*Diagnosis{}
+	for rows.Next() {
+		var d Diagnosis
+		err := rows.Scan(&totalRecords, &d.ID, &d.PatientID, &d.ProblemID, &d.EncounterID,
+			&d.DoctorID, &d.Code, &d.Description, &d.Notes, &d.DiagnosedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		diagnoses = append(diagnoses, &d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return diagnoses, metadata, nil
+}
@@ -0,0 +1,109 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// DischargeSummary is a rendered discharge summary. Content holds the PDF and is only
+// loaded when the document itself is asked for.
+type DischargeSummary struct {
+	ID          int64      `json:"id"`
+	PatientID   int64      `json:"patientId"`
+	AdmissionID *int64     `json:"admissionId"`
+	NoteID      int64      `json:"noteId"`
+	DoctorID    int64      `json:"doctorId"`
+	FileName    string     `json:"fileName"`
+	SizeBytes   int        `json:"sizeBytes"`
+	EmailedAt   *time.Time `json:"emailedAt"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	Content     []byte     `json:"-"`
+}
+
+type DischargeSummaryModel struct {
+	DB *sql.DB
+}
+
+func (m *DischargeSummaryModel) Insert(ctx context.Context, s *DischargeSummary) error {
+	query := `INSERT INTO discharge_summaries (patient_id, admission_id, note_id, doctor_id, file_name, content, size_bytes)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	s.SizeBytes = len(s.Content)
+	return m.DB.QueryRowContext(ctx, query, s.PatientID, s.AdmissionID, s.NoteID, s.DoctorID, s.FileName,
+		s.Content, s.SizeBytes).Scan(&s.ID, &s.CreatedAt)
+}
+
+// GetById returns a summary including its PDF.
+func (m *DischargeSummaryModel) GetById(ctx context.Context, patientID, id int64) (*DischargeSummary, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `SELECT id, patient_id, admission_id, note_id, doctor_id, file_name, size_bytes, emailed_at,
+	created_at, content
+	FROM discharge_summaries
+	WHERE id = $1 AND patient_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var s DischargeSummary
+	err := m.DB.QueryRowContext(ctx, query, id, patientID).Scan(&s.ID, &s.PatientID, &s.AdmissionID,
+		&s.NoteID, &s.DoctorID, &s.FileName, &s.SizeBytes, &s.EmailedAt, &s.CreatedAt, &s.Content)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &s, nil
+}
+
+// GetForPatient lists a patient's summaries, newest first, without their PDFs.
+func (m *DischargeSummaryModel) GetForPatient(ctx context.Context, patientID int64) ([]*DischargeSummary, error) {
+	query := `SELECT id, patient_id, admission_id, note_id, doctor_id, file_name, size_bytes, emailed_at, created_at
+	FROM discharge_summaries
+	WHERE patient_id = $1
+	ORDER BY created_at DESC, id DESC`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, patientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summaries := []*DischargeSummary{}
+	for rows.Next() {
+		var s DischargeSummary
+		err := rows.Scan(&s.ID, &s.PatientID, &s.AdmissionID, &s.NoteID, &s.DoctorID, &s.FileName,
+			&s.SizeBytes, &s.EmailedAt, &s.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, &s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return summaries, nil
+}
+
+func (m *DischargeSummaryModel) MarkEmailed(ctx context.Context, id int64) error {
+	query := `UPDATE discharge_summaries SET emailed_at = NOW() WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
@@ -5,19 +5,49 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
 	"time"
+
+	"github.com/lib/pq"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+var (
+	ErrDuplicateLicense = errors.New("a doctor with this license number already exists")
+	ErrUnknownSpecialty = errors.New("specialty does not exist")
 )
 
 type Doctor struct {
-	ID        int64     `json:"id"`
-	FirstName string    `json:"firstName"`
-	LastName  string    `json:"lastName"`
-	Email     string    `json:"email"`
-	Password  password  `json:"-"`
-	CreatedAt time.Time `json:"createdAt"`
-	UpdatedAt time.Time `json:"-"`
+	ID               int64        `json:"id"`
+	FirstName        string       `json:"firstName"`
+	LastName         string       `json:"lastName"`
+	Email            string       `json:"email"`
+	Password         password     `json:"-"`
+	Title            string       `json:"title"`
+	DepartmentID     *int64       `json:"departmentId"`
+	Department       string       `json:"department,omitempty"`
+	Specialties      []*Specialty `json:"specialties"`
+	LicenseNumber    string       `json:"licenseNumber"`
+	LicenseExpiresOn *Date        `json:"licenseExpiresOn"`
+	Phone            string       `json:"phone"`
+	Bio              string       `json:"bio"`
+	CreatedAt        time.Time    `json:"createdAt"`
+	UpdatedAt        time.Time    `json:"-"`
+	Version          int64        `json:"version"`
+}
+
+// ValidateDoctorProfile checks the profile fields of a doctor. SpecialtyIDs are the
+// specialties being given to the doctor.
+func ValidateDoctorProfile(v *validator.Validator, d *Doctor, specialtyIDs []int64) {
+	v.Check(len(d.Title) <= 100, "title", "must not be more than 100 bytes long")
+	v.Check(len(d.LicenseNumber) <= 50, "licenseNumber", "must not be more than 50 bytes long")
+	v.Check(len(d.Phone) <= 30, "phone", "must not be more than 30 bytes long")
+	v.Check(len(d.Bio) <= 5000, "bio", "must not be more than 5000 bytes long")
+	v.Check(len(specialtyIDs) <= 10, "specialtyIds", "must not contain more than 10 specialties")
+	v.Check(validator.Unique(specialtyIDs), "specialtyIds", "must not contain duplicates")
 }
 
 type DoctorModel struct {
@@ -25,46 +55,68 @@ type DoctorModel struct {
 }
 
 func (s *DoctorModel) Create(ctx context.Context, user *Doctor) error {
-	query := `INSERT INTO doctors (first_name,last_name, email, password) 
-	VALUES ($1, $2, $3, $4) RETURNING id, first_name, last_name, created_at`
+	query := `INSERT INTO doctors (first_name,last_name, email, password, title, license_number, phone) 
+	VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, first_name, last_name, created_at, updated_at`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	err := s.DB.QueryRowContext(ctx, query, user.FirstName, user.LastName, user.Email, user.Password.hash).
-		Scan(&user.ID, &user.FirstName, &user.LastName, &user.CreatedAt)
+	err := s.DB.QueryRowContext(ctx, query, user.FirstName, user.LastName, user.Email, user.Password.hash,
+		user.Title, user.LicenseNumber, user.Phone).
+		Scan(&user.ID, &user.FirstName, &user.LastName, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`,
+			err.Error() == `pq: duplicate key value violates unique constraint "doctors_email_key"`:
 			return ErrDuplicateEmail
+		case err.Error() == `pq: duplicate key value violates unique constraint "doctors_license_number_idx"`:
+			return ErrDuplicateLicense
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_username_key"`:
 			return ErrDuplicateUsername
 		default:
 			return err
 		}
 	}
+	user.Specialties = []*Specialty{}
+	user.Version = 1
 
 	return nil
 }
 
+const doctorProfileColumns = `d.id, d.first_name, d.last_name, d.email, d.title, d.department_id,
+	COALESCE(dep.name, ''),
+	COALESCE((SELECT json_agg(json_build_object('id', sp.id, 'name', sp.name) ORDER BY sp.name)
+		FROM doctor_specialties ds JOIN specialties sp ON sp.id = ds.specialty_id
+		WHERE ds.doctor_id = d.id), '[]'),
+	d.license_number, d.license_expires_on, d.phone, d.bio, d.created_at, d.updated_at, d.version`
+
+func scanDoctorProfile(row rowScanner, extra ...any) (*Doctor, error) {
+	var d Doctor
+	var specialties []byte
+	dest := append(extra, &d.ID, &d.FirstName, &d.LastName, &d.Email, &d.Title, &d.DepartmentID, &d.Department,
+		&specialties, &d.LicenseNumber, &d.LicenseExpiresOn, &d.Phone, &d.Bio, &d.CreatedAt, &d.UpdatedAt,
+		&d.Version)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(specialties, &d.Specialties); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
 func (s *DoctorModel) GetById(ctx context.Context, id int64) (*Doctor, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
-	query := `SELECT id, first_name, last_name, email, created_at, updated_at 
-    FROM doctors 
-    WHERE id = $1`
+	query := `SELECT ` + doctorProfileColumns + `
+	FROM doctors d
+	LEFT JOIN departments dep ON dep.id = d.department_id
+	WHERE d.id = $1`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	user := &Doctor{}
-	err := s.DB.QueryRowContext(ctx, query, id).
-		Scan(&user.ID,
-			&user.FirstName,
-			&user.LastName,
-			&user.Email,
-			&user.CreatedAt,
-			&user.UpdatedAt)
+	user, err := scanDoctorProfile(s.DB.QueryRowContext(ctx, query, id))
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -77,6 +129,150 @@ func (s *DoctorModel) GetById(ctx context.Context, id int64) (*Doctor, error) {
 	return user, nil
 }
 
+// Directory searches doctors by name, and by the name or ID of their specialty and
+// department. Empty names and zero IDs match every doctor.
+func (s *DoctorModel) Directory(ctx context.Context, name, specialty string, specialtyID int64, department string,
+	departmentID int64, filters Filters) ([]*Doctor, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), `+doctorProfileColumns+`
+	FROM doctors d
+	LEFT JOIN departments dep ON dep.id = d.department_id
+	WHERE (d.first_name || ' ' || d.last_name ILIKE '%%' || $1 || '%%' OR $1 = '')
+	AND (dep.name ILIKE '%%' || $2 || '%%' OR $2 = '')
+	AND (d.department_id = $3 OR $3 = 0)
+	AND (($4 = '' AND $5 = 0) OR EXISTS (
+		SELECT 1 FROM doctor_specialties ds JOIN specialties sp ON sp.id = ds.specialty_id
+		WHERE ds.doctor_id = d.id
+		AND (sp.name ILIKE '%%' || $4 || '%%' OR $4 = '')
+		AND (sp.id = $5 OR $5 = 0)))
+	ORDER BY d.%s %s, d.id ASC
+	LIMIT $6 OFFSET $7`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, query, name, department, departmentID, specialty, specialtyID,
+		filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	doctors := []*Doctor{}
+	for rows.Next() {
+		d, err := scanDoctorProfile(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		doctors = append(doctors, d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return doctors, metadata, nil
+}
+
+// Search finds doctors by ID, license number and name for the FHIR facade. Names
+// match case-insensitively from the start of the first or last name.
+func (s *DoctorModel) Search(ctx context.Context, id int64, license, name, family, given string,
+	filters Filters) ([]*Doctor, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), `+doctorProfileColumns+`
+	FROM doctors d
+	LEFT JOIN departments dep ON dep.id = d.department_id
+	WHERE (d.id = $1 OR $1 = 0)
+	AND (d.license_number = upper($2) OR $2 = '')
+	AND (d.first_name ILIKE $3 || '%%' OR d.last_name ILIKE $3 || '%%' OR $3 = '')
+	AND (d.last_name ILIKE $4 || '%%' OR $4 = '')
+	AND (d.first_name ILIKE $5 || '%%' OR $5 = '')
+	ORDER BY d.%s %s, d.id ASC
+	LIMIT $6 OFFSET $7`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, query, id, license, name, family, given, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	doctors := []*Doctor{}
+	for rows.Next() {
+		d, err := scanDoctorProfile(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		doctors = append(doctors, d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return doctors, metadata, nil
+}
+
+// UpdateProfile saves the doctor's name and profile fields. When specialtyIDs is not nil the
+// doctor's specialties are replaced with them.
+func (s *DoctorModel) UpdateProfile(ctx context.Context, d *Doctor, specialtyIDs []int64) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(s.DB, ctx, func(tx *sql.Tx) error {
+		query := `UPDATE doctors
+		SET title = $1, department_id = $2, license_number = $3, license_expires_on = $4, phone = $5, bio = $6,
+			first_name = $7, last_name = $8, updated_at = NOW(), version = version + 1
+		WHERE id = $9 AND version = $10
+		RETURNING updated_at, version`
+
+		err := tx.QueryRowContext(ctx, query, d.Title, d.DepartmentID, d.LicenseNumber, d.LicenseExpiresOn, d.Phone,
+			d.Bio, d.FirstName, d.LastName, d.ID, d.Version).Scan(&d.UpdatedAt, &d.Version)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflict
+			case err.Error() == `pq: duplicate key value violates unique constraint "doctors_license_number_idx"`:
+				return ErrDuplicateLicense
+			default:
+				return err
+			}
+		}
+
+		if specialtyIDs != nil {
+			_, err = tx.ExecContext(ctx, `DELETE FROM doctor_specialties WHERE doctor_id = $1`, d.ID)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, `INSERT INTO doctor_specialties (doctor_id, specialty_id)
+			SELECT $1, unnest($2::bigint[])`, d.ID, pq.Array(specialtyIDs))
+			if err != nil {
+				switch {
+				case err.Error() == `pq: insert or update on table "doctor_specialties" violates foreign key constraint "doctor_specialties_specialty_id_fkey"`:
+					return ErrUnknownSpecialty
+				default:
+					return err
+				}
+			}
+		}
+
+		updated, err := scanDoctorProfile(tx.QueryRowContext(ctx, `SELECT `+doctorProfileColumns+`
+		FROM doctors d
+		LEFT JOIN departments dep ON dep.id = d.department_id
+		WHERE d.id = $1`, d.ID))
+		if err != nil {
+			return err
+		}
+		d.Department = updated.Department
+		d.Specialties = updated.Specialties
+		return nil
+	})
+}
+
 func (s *DoctorModel) delete(ctx context.Context, tx *sql.Tx, id int64) error {
 	query := `DELETE FROM users WHERE id = $1`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
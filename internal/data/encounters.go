@@ -0,0 +1,275 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	EncounterStatusOpen   = "open"
+	EncounterStatusClosed = "closed"
+)
+
+var (
+	EncounterTypes      = []string{"outpatient", "inpatient", "emergency", "telehealth", "home_visit"}
+	EncounterEntryKinds = []string{"observation", "assessment", "plan", "procedure", "note"}
+)
+
+var ErrEncounterClosed = errors.New("encounter is closed and can no longer be changed")
+
+type Encounter struct {
+	ID        int64             `json:"id"`
+	PatientID int64             `json:"patientId"`
+	DoctorID  int64             `json:"doctorId"`
+	Type      string            `json:"type"`
+	Status    string            `json:"status"`
+	Reason    string            `json:"reason"`
+	Location  string            `json:"location"`
+	StartedAt time.Time         `json:"startedAt"`
+	EndedAt   *time.Time        `json:"endedAt"`
+	CreatedAt time.Time         `json:"createdAt"`
+	Version   int64             `json:"version"`
+	Entries   []*EncounterEntry `json:"entries,omitempty"`
+}
+
+// EncounterEntry is a single piece of clinical information recorded during an
+// encounter.
+type EncounterEntry struct {
+	ID          int64       `json:"id"`
+	EncounterID int64       `json:"encounterId"`
+	DoctorID    int64       `json:"doctorId"`
+	Kind        string      `json:"kind"`
+	Content     interface{} `json:"content"`
+	CreatedAt   time.Time   `json:"createdAt"`
+}
+
+// EncounterQuery holds the optional filters for listing a patient's encounters.
+type EncounterQuery struct {
+	Type   string
+	Status string
+	From   *time.Time
+	To     *time.Time
+}
+
+func (e *Encounter) IsClosed() bool {
+	return e.Status == EncounterStatusClosed
+}
+
+type EncounterModel struct {
+	DB *sql.DB
+}
+
+func (m *EncounterModel) Create(ctx context.Context, encounter *Encounter) error {
+	query := `INSERT INTO encounters (patient_id, doctor_id, type, reason, location, started_at)
+	VALUES ($1, $2, $3, $4, $5, COALESCE($6, NOW()))
+	RETURNING id, status, started_at, created_at, version`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var startedAt *time.Time
+	if !encounter.StartedAt.IsZero() {
+		startedAt = &encounter.StartedAt
+	}
+
+	return m.DB.QueryRowContext(ctx, query, encounter.PatientID, encounter.DoctorID,
+		encounter.Type, encounter.Reason, encounter.Location, startedAt).
+		Scan(&encounter.ID, &encounter.Status, &encounter.StartedAt, &encounter.CreatedAt, &encounter.Version)
+}
+
+// GetById returns the encounter only if it belongs to the given patient.
+func (m *EncounterModel) GetById(ctx context.Context, patientID, id int64) (*Encounter, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `SELECT id, patient_id, doctor_id, type, status, reason, location,
+	started_at, ended_at, created_at, version
+	FROM encounters
+	WHERE id = $1 AND patient_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	encounter, err := scanEncounter(m.DB.QueryRowContext(ctx, query, id, patientID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return encounter, nil
+}
+
+func (m *EncounterModel) GetForPatient(ctx context.Context, patientID int64, q EncounterQuery, filters Filters) ([]*Encounter, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, patient_id, doctor_id, type, status, reason, location,
+	started_at, ended_at, created_at, version
+	FROM encounters
+	WHERE patient_id = $1
+	AND (type = $2 OR $2 = '')
+	AND (status = $3 OR $3 = '')
+	AND (started_at >= $4 OR $4 IS NULL)
+	AND (started_at < $5 OR $5 IS NULL)
+	ORDER BY %s %s, id ASC
+	LIMIT $6 OFFSET $7`, filters.sortColumn(), filters.sortDirection())
+
+	args := []interface{}{patientID, q.Type, q.Status, q.From, endOfDay(q.To), filters.limit(), filters.offset()}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	encounters := []*Encounter{}
+	for rows.Next() {
+		var e Encounter
+		err := rows.Scan(&totalRecords, &e.ID, &e.PatientID, &e.DoctorID, &e.Type, &e.Status,
+			&e.Reason, &e.Location, &e.StartedAt, &e.EndedAt, &e.CreatedAt, &e.Version)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		encounters = append(encounters, &e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return encounters, metadata, nil
+}
+
+// Update saves the descriptive fields of an open encounter. Closed encounters are
+// read-only, which is enforced here as well as by the caller so that a concurrent
+// close can't slip in between.
+func (m *EncounterModel) Update(ctx context.Context, encounter *Encounter) error {
+	query := `UPDATE encounters
+	SET type = $1, reason = $2, location = $3, version = version + 1
+	WHERE id = $4 AND version = $5 AND status = 'open'
+	RETURNING version`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, encounter.Type, encounter.Reason, encounter.Location,
+		encounter.ID, encounter.Version).Scan(&encounter.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *EncounterModel) Close(ctx context.Context, encounter *Encounter, endedAt time.Time) error {
+	query := `UPDATE encounters
+	SET status = 'closed', ended_at = $1, version = version + 1
+	WHERE id = $2 AND version = $3 AND status = 'open'
+	RETURNING status, ended_at, version`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, endedAt, encounter.ID, encounter.Version).
+		Scan(&encounter.Status, &encounter.EndedAt, &encounter.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// AddEntry attaches a clinical entry to an encounter. The encounter row is locked so
+// that entries can't be added while it is being closed.
+func (m *EncounterModel) AddEntry(ctx context.Context, entry *EncounterEntry) error {
+	content, err := json.Marshal(entry.Content)
+	if err != nil {
+		return fmt.Errorf("error marshaling entry content: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(m.DB, ctx, func(tx *sql.Tx) error {
+		var status string
+		err := tx.QueryRowContext(ctx, `SELECT status FROM encounters WHERE id = $1 FOR UPDATE`,
+			entry.EncounterID).Scan(&status)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+		if status == EncounterStatusClosed {
+			return ErrEncounterClosed
+		}
+
+		query := `INSERT INTO encounter_entries (encounter_id, doctor_id, kind, content)
+		VALUES ($1, $2, $3, $4) RETURNING id, created_at`
+		return tx.QueryRowContext(ctx, query, entry.EncounterID, entry.DoctorID, entry.Kind, content).
+			Scan(&entry.ID, &entry.CreatedAt)
+	})
+}
+
+func (m *EncounterModel) GetEntries(ctx context.Context, encounterID int64) ([]*EncounterEntry, error) {
+	query := `SELECT id, encounter_id, doctor_id, kind, content, created_at
+	FROM encounter_entries
+	WHERE encounter_id = $1
+	ORDER BY created_at ASC, id ASC`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, encounterID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*EncounterEntry{}
+	for rows.Next() {
+		var entry EncounterEntry
+		var content []byte
+		err := rows.Scan(&entry.ID, &entry.EncounterID, &entry.DoctorID, &entry.Kind, &content, &entry.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(content, &entry.Content); err != nil {
+			return nil, fmt.Errorf("error unmarshaling entry content: %w", err)
+		}
+		entries = append(entries, &entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func scanEncounter(row rowScanner) (*Encounter, error) {
+	var e Encounter
+	err := row.Scan(&e.ID, &e.PatientID, &e.DoctorID, &e.Type, &e.Status, &e.Reason,
+		&e.Location, &e.StartedAt, &e.EndedAt, &e.CreatedAt, &e.Version)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
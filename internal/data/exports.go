@@ -0,0 +1,426 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+const (
+	ExportPending   = "pending"
+	ExportRunning   = "running"
+	ExportCompleted = "completed"
+	ExportFailed    = "failed"
+	ExportExpired   = "expired"
+)
+
+var ExportStatuses = []string{ExportPending, ExportRunning, ExportCompleted, ExportFailed, ExportExpired}
+
+var ErrExportRunning = errors.New("the export is still running")
+
+// exportQueries select each exported type as one JSON object per row, with the
+// table's own column names, within the since ($1) and until ($2) bounds of the
+// type's date column. Either bound may be NULL.
+var exportQueries = map[string]string{
+	"Patient": `SELECT to_jsonb(p) - 'password' FROM patients p
+		WHERE ($1::timestamptz IS NULL OR p.updated_at >= $1) AND ($2::timestamptz IS NULL OR p.updated_at < $2)
+		ORDER BY p.id`,
+	"Encounter": `SELECT to_jsonb(e) FROM encounters e
+		WHERE ($1::timestamptz IS NULL OR e.started_at >= $1) AND ($2::timestamptz IS NULL OR e.started_at < $2)
+		ORDER BY e.id`,
+	"VitalSigns": `SELECT to_jsonb(v) FROM vital_signs v
+		WHERE ($1::timestamptz IS NULL OR v.recorded_at >= $1) AND ($2::timestamptz IS NULL OR v.recorded_at < $2)
+		ORDER BY v.id`,
+	"Problem": `SELECT to_jsonb(p) FROM problems p
+		WHERE ($1::timestamptz IS NULL OR p.updated_at >= $1) AND ($2::timestamptz IS NULL OR p.updated_at < $2)
+		ORDER BY p.id`,
+	"Diagnosis": `SELECT to_jsonb(d) FROM diagnoses d
+		WHERE ($1::timestamptz IS NULL OR d.diagnosed_at >= $1) AND ($2::timestamptz IS NULL OR d.diagnosed_at < $2)
+		ORDER BY d.id`,
+	"Allergy": `SELECT to_jsonb(a) FROM allergies a
+		WHERE ($1::timestamptz IS NULL OR a.created_at >= $1) AND ($2::timestamptz IS NULL OR a.created_at < $2)
+		ORDER BY a.id`,
+	"Prescription": `SELECT to_jsonb(p) FROM prescriptions p
+		WHERE ($1::timestamptz IS NULL OR p.created_at >= $1) AND ($2::timestamptz IS NULL OR p.created_at < $2)
+		ORDER BY p.id`,
+	"LabOrder": `SELECT to_jsonb(o) FROM lab_orders o
+		WHERE ($1::timestamptz IS NULL OR o.ordered_at >= $1) AND ($2::timestamptz IS NULL OR o.ordered_at < $2)
+		ORDER BY o.id`,
+	"LabResult": `SELECT to_jsonb(r) || jsonb_build_object('patient_id', o.patient_id)
+		FROM lab_results r
+		JOIN lab_orders o ON o.id = r.order_id
+		WHERE ($1::timestamptz IS NULL OR r.observed_at >= $1) AND ($2::timestamptz IS NULL OR r.observed_at < $2)
+		ORDER BY r.id`,
+	"Immunization": `SELECT to_jsonb(i) FROM immunizations i
+		WHERE ($1::timestamptz IS NULL OR i.administered_on >= $1) AND ($2::timestamptz IS NULL OR i.administered_on < $2)
+		ORDER BY i.id`,
+	"Admission": `SELECT to_jsonb(a) FROM admissions a
+		WHERE ($1::timestamptz IS NULL OR a.admitted_at >= $1) AND ($2::timestamptz IS NULL OR a.admitted_at < $2)
+		ORDER BY a.id`,
+}
+
+// ExportTypes are the types that can be exported, in the order they are written.
+var ExportTypes = []string{"Patient", "Encounter", "VitalSigns", "Problem", "Diagnosis", "Allergy",
+	"Prescription", "LabOrder", "LabResult", "Immunization", "Admission"}
+
+// ReceptionistExportTypes are the types a receptionist can bulk export. Clinical
+// records are only exported in full by doctors, or de-identified for a research study.
+var ReceptionistExportTypes = []string{"Patient"}
+
+// ResearchBundle is the type of the one file written by a research export: a zip of
+// a CSV file per type and a data dictionary.
+const (
+	ResearchBundle     = "ResearchBundle"
+	ResearchBundleName = "research-bundle.zip"
+)
+
+// ExportFile is a file written by an export: the NDJSON file for one type, or a
+// research bundle. URL is only set on exports handed back to the receptionist or
+// doctor who can download them.
+type ExportFile struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	ContentType string `json:"contentType"`
+	Count       int64  `json:"count"`
+	Size        int64  `json:"size"`
+	URL         string `json:"url,omitempty"`
+}
+
+// ExportFileKey is where the named file of an export is kept in storage.
+func ExportFileKey(exportID int64, name string) string {
+	return fmt.Sprintf("exports/%d/%s", exportID, name)
+}
+
+// Export is a bulk export of patient data. An export for a research study is
+// de-identified for the study and written as a research bundle.
+type Export struct {
+	ID              int64        `json:"id"`
+	ReceptionistID  *int64       `json:"receptionistId"`
+	DoctorID        *int64       `json:"doctorId"`
+	StudyID         *int64       `json:"studyId"`
+	Types           []string     `json:"types"`
+	Since           *time.Time   `json:"since"`
+	Until           *time.Time   `json:"until"`
+	Status          string       `json:"status"`
+	CurrentType     string       `json:"currentType"`
+	ExportedTypes   int          `json:"exportedTypes"`
+	ExportedRecords int64        `json:"exportedRecords"`
+	Output          []ExportFile `json:"output"`
+	Error           string       `json:"error"`
+	CreatedAt       time.Time    `json:"createdAt"`
+	StartedAt       *time.Time   `json:"startedAt"`
+	FinishedAt      *time.Time   `json:"finishedAt"`
+	ExpiresAt       *time.Time   `json:"expiresAt"`
+}
+
+// Done reports whether the export has stopped running.
+func (e *Export) Done() bool {
+	return e.Status != ExportPending && e.Status != ExportRunning
+}
+
+// File returns the file written for the type.
+func (e *Export) File(exportType string) (*ExportFile, bool) {
+	for i := range e.Output {
+		if e.Output[i].Type == exportType {
+			return &e.Output[i], true
+		}
+	}
+	return nil, false
+}
+
+// FileNames returns the names of every file the export may write.
+func (e *Export) FileNames() []string {
+	if e.StudyID != nil {
+		return []string{ResearchBundleName}
+	}
+	names := make([]string, len(e.Types))
+	for i, t := range e.Types {
+		names[i] = t + ".ndjson"
+	}
+	return names
+}
+
+// PermittedTypes returns the types the export may hold: every type for a doctor or
+// a research study, and only patients' demographics for a receptionist.
+func (e *Export) PermittedTypes() []string {
+	if e.DoctorID == nil && e.StudyID == nil {
+		return ReceptionistExportTypes
+	}
+	return ExportTypes
+}
+
+func ValidateExport(v *validator.Validator, e *Export) {
+	v.Check(len(e.Types) > 0, "types", "must contain at least one type")
+	v.Check(validator.Unique(e.Types), "types", "must not contain duplicate values")
+	permitted := e.PermittedTypes()
+	for _, t := range e.Types {
+		if !validator.In(t, ExportTypes...) {
+			v.AddError("types", fmt.Sprintf("%q is not an export type", t))
+			continue
+		}
+		v.Check(validator.In(t, permitted...), "types", fmt.Sprintf("%q can only be exported by a doctor", t))
+	}
+	if e.Since != nil {
+		v.Check(!e.Since.After(time.Now()), "since", "must not be in the future")
+	}
+	if e.Since != nil && e.Until != nil {
+		v.Check(e.Until.After(*e.Since), "until", "must be after since")
+	}
+}
+
+type ExportModel struct {
+	DB *sql.DB
+}
+
+func (m *ExportModel) Insert(ctx context.Context, e *Export) error {
+	query := `INSERT INTO exports (receptionist_id, doctor_id, study_id, types, since, until, status)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	e.Status = ExportPending
+	e.Output = []ExportFile{}
+	return m.DB.QueryRowContext(ctx, query, e.ReceptionistID, e.DoctorID, e.StudyID, pq.Array(e.Types), e.Since, e.Until,
+		e.Status).
+		Scan(&e.ID, &e.CreatedAt)
+}
+
+const exportColumns = `id, receptionist_id, doctor_id, study_id, types, since, until, status, current_type, exported_types,
+	exported_records, output, error, created_at, started_at, finished_at, expires_at`
+
+func scanExport(row rowScanner, extra ...any) (*Export, error) {
+	var e Export
+	var output []byte
+	dest := append(extra, &e.ID, &e.ReceptionistID, &e.DoctorID, &e.StudyID, pq.Array(&e.Types), &e.Since, &e.Until, &e.Status,
+		&e.CurrentType, &e.ExportedTypes, &e.ExportedRecords, &output, &e.Error, &e.CreatedAt, &e.StartedAt,
+		&e.FinishedAt, &e.ExpiresAt)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(output, &e.Output); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// GetById returns the export, only if the receptionist or doctor started it when
+// receptionistID or doctorID is set.
+func (m *ExportModel) GetById(ctx context.Context, receptionistID, doctorID, id int64) (*Export, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `SELECT ` + exportColumns + ` FROM exports
+	WHERE id = $1 AND (receptionist_id = $2 OR doctor_id = $3 OR ($2 = 0 AND $3 = 0))`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	e, err := scanExport(m.DB.QueryRowContext(ctx, query, id, receptionistID, doctorID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// GetAll lists the exports the receptionist or doctor started, only those for the
+// study if studyID is set.
+func (m *ExportModel) GetAll(ctx context.Context, receptionistID, doctorID int64, status string, studyID int64,
+	filters Filters) ([]*Export, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), `+exportColumns+`
+	FROM exports
+	WHERE (receptionist_id = $1 OR doctor_id = $2)
+	AND (status = $3 OR $3 = '')
+	AND (study_id = $4 OR $4 = 0)
+	ORDER BY %s %s, id DESC
+	LIMIT $5 OFFSET $6`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, receptionistID, doctorID, status, studyID, filters.limit(),
+		filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	exports := []*Export{}
+	for rows.Next() {
+		e, err := scanExport(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		exports = append(exports, e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return exports, metadata, nil
+}
+
+// Stream calls fn with each record of the type in the export's date range. The
+// query runs for as long as it takes to write every record, so it is bounded by ctx
+// alone rather than the usual query timeout.
+func (m *ExportModel) Stream(ctx context.Context, e *Export, exportType string, fn func(record []byte) error) error {
+	query, ok := exportQueries[exportType]
+	if !ok {
+		return fmt.Errorf("unknown export type %q", exportType)
+	}
+
+	rows, err := m.DB.QueryContext(ctx, query, e.Since, e.Until)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var record []byte
+		if err := rows.Scan(&record); err != nil {
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Start marks the export running, clearing the progress of any earlier attempt.
+func (m *ExportModel) Start(ctx context.Context, e *Export) error {
+	query := `UPDATE exports
+	SET status = $1, started_at = NOW(), current_type = '', exported_types = 0, exported_records = 0, output = '[]'
+	WHERE id = $2
+	RETURNING started_at`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	e.Status = ExportRunning
+	e.CurrentType = ""
+	e.ExportedTypes = 0
+	e.ExportedRecords = 0
+	e.Output = []ExportFile{}
+	return m.DB.QueryRowContext(ctx, query, e.Status, e.ID).Scan(&e.StartedAt)
+}
+
+// Progress records the type being exported and how far the export has got.
+func (m *ExportModel) Progress(ctx context.Context, e *Export) error {
+	output, err := json.Marshal(e.Output)
+	if err != nil {
+		return err
+	}
+	query := `UPDATE exports
+	SET current_type = $1, exported_types = $2, exported_records = $3, output = $4
+	WHERE id = $5`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, e.CurrentType, e.ExportedTypes, e.ExportedRecords, output, e.ID)
+	return err
+}
+
+// Finish records the outcome of the export. A completed export's files can be
+// downloaded until retention has passed.
+func (m *ExportModel) Finish(ctx context.Context, e *Export, runErr error, retention time.Duration) error {
+	e.Status = ExportCompleted
+	e.Error = ""
+	if runErr != nil {
+		e.Status = ExportFailed
+		e.Error = runErr.Error()
+		e.Output = []ExportFile{}
+	}
+	output, err := json.Marshal(e.Output)
+	if err != nil {
+		return err
+	}
+
+	query := `UPDATE exports
+	SET status = $1, error = $2, output = $3, current_type = '', exported_types = $4, exported_records = $5,
+		finished_at = NOW(),
+		expires_at = CASE WHEN $1 = 'completed' THEN NOW() + $6 * INTERVAL '1 second' END
+	WHERE id = $7
+	RETURNING finished_at, expires_at`
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), QueryTimeoutDuration)
+	defer cancel()
+
+	e.CurrentType = ""
+	return m.DB.QueryRowContext(ctx, query, e.Status, e.Error, output, e.ExportedTypes, e.ExportedRecords,
+		retention.Seconds(), e.ID).Scan(&e.FinishedAt, &e.ExpiresAt)
+}
+
+// GetExpired returns completed exports whose files are past their retention.
+func (m *ExportModel) GetExpired(ctx context.Context) ([]*Export, error) {
+	query := `SELECT ` + exportColumns + ` FROM exports
+	WHERE status = $1 AND expires_at <= NOW()
+	ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, ExportCompleted)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exports := []*Export{}
+	for rows.Next() {
+		e, err := scanExport(rows)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, e)
+	}
+	return exports, rows.Err()
+}
+
+// MarkExpired records that an export's files have been deleted.
+func (m *ExportModel) MarkExpired(ctx context.Context, id int64) error {
+	query := `UPDATE exports SET status = $1, output = '[]' WHERE id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, ExportExpired, id)
+	return err
+}
+
+// Delete removes an export that has stopped running.
+func (m *ExportModel) Delete(ctx context.Context, id int64) error {
+	query := `DELETE FROM exports WHERE id = $1 AND status NOT IN ($2, $3)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, ExportPending, ExportRunning)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrExportRunning
+	}
+	return nil
+}
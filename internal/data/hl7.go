@@ -0,0 +1,182 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	HL7Received  = "received"
+	HL7Processed = "processed"
+	HL7Failed    = "failed"
+	HL7Rejected  = "rejected"
+)
+
+var HL7Statuses = []string{HL7Received, HL7Processed, HL7Failed, HL7Rejected}
+
+var ErrHL7NotReplayable = errors.New("only failed and rejected messages can be replayed")
+
+// HL7Message is an entry in the log of HL7 v2 messages received over MLLP.
+type HL7Message struct {
+	ID                 int64           `json:"id"`
+	SendingApplication string          `json:"sendingApplication"`
+	SendingFacility    string          `json:"sendingFacility"`
+	ControlID          string          `json:"controlId"`
+	MessageType        string          `json:"messageType"`
+	Raw                string          `json:"raw"`
+	Status             string          `json:"status"`
+	AckCode            string          `json:"ackCode"`
+	Error              string          `json:"error"`
+	PatientID          *int64          `json:"patientId"`
+	Visit              json.RawMessage `json:"visit"`
+	Attempts           int             `json:"attempts"`
+	ReceivedAt         time.Time       `json:"receivedAt"`
+	ProcessedAt        *time.Time      `json:"processedAt"`
+}
+
+// Replayable reports whether the message can be processed again.
+func (m *HL7Message) Replayable() bool {
+	return m.Status == HL7Failed || m.Status == HL7Rejected
+}
+
+type HL7MessageModel struct {
+	DB *sql.DB
+}
+
+const hl7MessageColumns = `id, sending_application, sending_facility, control_id, message_type, raw, status,
+	ack_code, error, patient_id, visit, attempts, received_at, processed_at`
+
+func scanHL7Message(row rowScanner, extra ...any) (*HL7Message, error) {
+	var m HL7Message
+	var visit []byte
+	dest := append(extra, &m.ID, &m.SendingApplication, &m.SendingFacility, &m.ControlID, &m.MessageType, &m.Raw,
+		&m.Status, &m.AckCode, &m.Error, &m.PatientID, &visit, &m.Attempts, &m.ReceivedAt, &m.ProcessedAt)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	if visit != nil {
+		m.Visit = visit
+	}
+	return &m, nil
+}
+
+// Receive logs a newly received message. When the sender has already sent a message
+// with the same control ID, msg is filled in from the earlier one and duplicate is
+// true.
+func (m *HL7MessageModel) Receive(ctx context.Context, msg *HL7Message) (duplicate bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, `INSERT INTO hl7_messages (sending_application, sending_facility, control_id,
+		message_type, raw, status)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT (sending_application, sending_facility, control_id) WHERE control_id <> '' DO NOTHING
+	RETURNING id, received_at`, msg.SendingApplication, msg.SendingFacility, msg.ControlID, msg.MessageType,
+		msg.Raw, msg.Status).Scan(&msg.ID, &msg.ReceivedAt)
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
+
+	existing, err := scanHL7Message(m.DB.QueryRowContext(ctx, `SELECT `+hl7MessageColumns+`
+	FROM hl7_messages
+	WHERE sending_application = $1 AND sending_facility = $2 AND control_id = $3`,
+		msg.SendingApplication, msg.SendingFacility, msg.ControlID))
+	if err != nil {
+		return false, err
+	}
+	*msg = *existing
+	return true, nil
+}
+
+// Finish records the outcome of processing the message.
+func (m *HL7MessageModel) Finish(ctx context.Context, msg *HL7Message) error {
+	query := `UPDATE hl7_messages
+	SET status = $1, ack_code = $2, error = $3, patient_id = $4, visit = $5, message_type = $6,
+		attempts = attempts + 1, processed_at = NOW()
+	WHERE id = $7
+	RETURNING attempts, processed_at`
+
+	var visit any
+	if len(msg.Visit) > 0 {
+		visit = []byte(msg.Visit)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, msg.Status, msg.AckCode, msg.Error, msg.PatientID, visit,
+		msg.MessageType, msg.ID).Scan(&msg.Attempts, &msg.ProcessedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *HL7MessageModel) GetById(ctx context.Context, id int64) (*HL7Message, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `SELECT ` + hl7MessageColumns + ` FROM hl7_messages WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	msg, err := scanHL7Message(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+// GetAll lists logged messages by status and message type, such as "ADT^A08".
+func (m *HL7MessageModel) GetAll(ctx context.Context, status, messageType string, filters Filters) ([]*HL7Message, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), `+hl7MessageColumns+`
+	FROM hl7_messages
+	WHERE (status = $1 OR $1 = '')
+	AND (message_type = $2 OR $2 = '')
+	ORDER BY %s %s, id DESC
+	LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, status, messageType, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	messages := []*HL7Message{}
+	for rows.Next() {
+		msg, err := scanHL7Message(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		messages = append(messages, msg)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return messages, metadata, nil
+}
@@ -0,0 +1,106 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+var ImmunizationSites = []string{
+	"left_arm", "right_arm", "left_thigh", "right_thigh", "oral", "intranasal", "intradermal", "other",
+}
+
+var ErrDuplicateImmunization = errors.New("this dose has already been recorded for the patient")
+
+// Immunization is a vaccine dose given to a patient. AdministeredBy is a name rather
+// than a doctor, since doses given elsewhere are recorded from the patient's card.
+type Immunization struct {
+	ID             int64     `json:"id"`
+	PatientID      int64     `json:"patientId"`
+	VaccineCode    string    `json:"vaccineCode"`
+	VaccineName    string    `json:"vaccineName"`
+	DoseNumber     int       `json:"doseNumber"`
+	LotNumber      string    `json:"lotNumber"`
+	Site           string    `json:"site"`
+	AdministeredBy string    `json:"administeredBy"`
+	AdministeredOn Date      `json:"administeredOn"`
+	Notes          string    `json:"notes"`
+	RecordedBy     int64     `json:"recordedBy"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+func ValidateImmunization(v *validator.Validator, i *Immunization, dob *Date) {
+	v.Check(i.VaccineCode != "", "vaccineCode", "must be provided")
+	v.Check(len(i.VaccineCode) <= 30, "vaccineCode", "must not be more than 30 bytes long")
+	v.Check(i.VaccineName != "", "vaccineName", "must be provided")
+	v.Check(i.DoseNumber > 0, "doseNumber", "must be greater than zero")
+	v.Check(len(i.LotNumber) <= 50, "lotNumber", "must not be more than 50 bytes long")
+	v.Check(validator.In(i.Site, ImmunizationSites...), "site", "invalid site")
+	v.Check(i.AdministeredBy != "", "administeredBy", "must be provided")
+	v.Check(!i.AdministeredOn.After(time.Now()), "administeredOn", "must not be in the future")
+	if dob != nil {
+		v.Check(!i.AdministeredOn.Before(dob.Time), "administeredOn", "must not be before the patient's date of birth")
+	}
+}
+
+type ImmunizationModel struct {
+	DB *sql.DB
+}
+
+func (m *ImmunizationModel) Insert(ctx context.Context, i *Immunization) error {
+	query := `INSERT INTO immunizations (patient_id, vaccine_code, vaccine_name, dose_number, lot_number, site,
+	administered_by, administered_on, notes, recorded_by)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, i.PatientID, i.VaccineCode, i.VaccineName, i.DoseNumber, i.LotNumber,
+		i.Site, i.AdministeredBy, i.AdministeredOn, i.Notes, i.RecordedBy).Scan(&i.ID, &i.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "immunizations_patient_dose_key"`:
+			return ErrDuplicateImmunization
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// GetForPatient returns a patient's immunization history, most recent first.
+func (m *ImmunizationModel) GetForPatient(ctx context.Context, patientID int64) ([]*Immunization, error) {
+	query := `SELECT id, patient_id, vaccine_code, vaccine_name, dose_number, lot_number, site, administered_by,
+	administered_on, notes, recorded_by, created_at
+	FROM immunizations
+	WHERE patient_id = $1
+	ORDER BY administered_on DESC, vaccine_code, dose_number DESC`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, patientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	immunizations := []*Immunization{}
+	for rows.Next() {
+		var i Immunization
+		err := rows.Scan(&i.ID, &i.PatientID, &i.VaccineCode, &i.VaccineName, &i.DoseNumber, &i.LotNumber,
+			&i.Site, &i.AdministeredBy, &i.AdministeredOn, &i.Notes, &i.RecordedBy, &i.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		immunizations = append(immunizations, &i)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return immunizations, nil
+}
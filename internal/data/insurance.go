@@ -0,0 +1,679 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+const (
+	ClaimSubmitted     = "submitted"
+	ClaimPaid          = "paid"
+	ClaimPartiallyPaid = "partially_paid"
+	ClaimDenied        = "denied"
+	ClaimReversed      = "reversed"
+)
+
+var (
+	PolicyRelationships = []string{"self", "spouse", "child", "other"}
+	Genders             = []string{"F", "M", "U"}
+	EligibilityStatuses = []string{"eligible", "not_eligible", "unknown"}
+	ClaimStatuses       = []string{ClaimSubmitted, ClaimPaid, ClaimPartiallyPaid, ClaimDenied, ClaimReversed}
+)
+
+var (
+	ErrPolicyNotValid      = errors.New("policy is not valid on the visit date")
+	ErrDuplicateRemittance = errors.New("this remittance has already been imported")
+	ErrNothingToClaim      = errors.New("there are no invoices to claim")
+)
+
+// InsurancePolicy is a patient's cover with a payer. The subscriber is the policy
+// holder, who is the patient themselves unless Relationship says otherwise.
+type InsurancePolicy struct {
+	ID                  int64     `json:"id"`
+	PatientID           int64     `json:"patientId"`
+	PayerName           string    `json:"payerName"`
+	PayerID             string    `json:"payerId"`
+	MemberID            string    `json:"memberId"`
+	GroupNumber         string    `json:"groupNumber"`
+	PlanName            string    `json:"planName"`
+	Priority            int       `json:"priority"`
+	Relationship        string    `json:"relationship"`
+	SubscriberFirstName string    `json:"subscriberFirstName"`
+	SubscriberLastName  string    `json:"subscriberLastName"`
+	SubscriberDOB       *Date     `json:"subscriberDateOfBirth"`
+	SubscriberGender    string    `json:"subscriberGender"`
+	Address             string    `json:"address"`
+	City                string    `json:"city"`
+	State               string    `json:"state"`
+	PostalCode          string    `json:"postalCode"`
+	ValidFrom           Date      `json:"validFrom"`
+	ValidTo             *Date     `json:"validTo"`
+	CreatedBy           int64     `json:"createdBy"`
+	CreatedAt           time.Time `json:"createdAt"`
+	Version             int64     `json:"version"`
+}
+
+// ValidOn reports whether the policy covers care given on day.
+func (p *InsurancePolicy) ValidOn(day time.Time) bool {
+	d := NewDate(day)
+	return !d.Before(p.ValidFrom.Time) && (p.ValidTo == nil || !d.After(p.ValidTo.Time))
+}
+
+func ValidateInsurancePolicy(v *validator.Validator, p *InsurancePolicy) {
+	v.Check(p.PayerName != "", "payerName", "must be provided")
+	v.Check(len(p.PayerName) <= 255, "payerName", "must not be more than 255 bytes long")
+	v.Check(p.PayerID != "", "payerId", "must be provided")
+	v.Check(len(p.PayerID) <= 80, "payerId", "must not be more than 80 bytes long")
+	v.Check(p.MemberID != "", "memberId", "must be provided")
+	v.Check(len(p.MemberID) <= 80, "memberId", "must not be more than 80 bytes long")
+	v.Check(validator.Between(p.Priority, 1, 3), "priority", "must be 1 (primary), 2 (secondary) or 3 (tertiary)")
+	v.Check(validator.In(p.Relationship, PolicyRelationships...), "relationship", "must be self, spouse, child or other")
+	v.Check(validator.In(p.SubscriberGender, Genders...), "subscriberGender", "must be F, M or U")
+	if p.Relationship != "self" {
+		v.Check(p.SubscriberFirstName != "", "subscriberFirstName", "must be provided when the patient is not the subscriber")
+		v.Check(p.SubscriberLastName != "", "subscriberLastName", "must be provided when the patient is not the subscriber")
+	}
+	v.Check(p.ValidTo == nil || !p.ValidTo.Before(p.ValidFrom.Time), "validTo", "must not be before validFrom")
+}
+
+type InsurancePolicyModel struct {
+	DB *sql.DB
+}
+
+const policyColumns = `id, patient_id, payer_name, payer_id, member_id, group_number, plan_name, priority,
+	relationship, subscriber_first_name, subscriber_last_name, subscriber_date_of_birth, subscriber_gender,
+	address, city, state, postal_code, valid_from, valid_to, created_by, created_at, version`
+
+func scanPolicy(row rowScanner) (*InsurancePolicy, error) {
+	var p InsurancePolicy
+	err := row.Scan(&p.ID, &p.PatientID, &p.PayerName, &p.PayerID, &p.MemberID, &p.GroupNumber, &p.PlanName,
+		&p.Priority, &p.Relationship, &p.SubscriberFirstName, &p.SubscriberLastName, &p.SubscriberDOB,
+		&p.SubscriberGender, &p.Address, &p.City, &p.State, &p.PostalCode, &p.ValidFrom, &p.ValidTo,
+		&p.CreatedBy, &p.CreatedAt, &p.Version)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (m *InsurancePolicyModel) Insert(ctx context.Context, p *InsurancePolicy) error {
+	query := `INSERT INTO insurance_policies (patient_id, payer_name, payer_id, member_id, group_number,
+	plan_name, priority, relationship, subscriber_first_name, subscriber_last_name, subscriber_date_of_birth,
+	subscriber_gender, address, city, state, postal_code, valid_from, valid_to, created_by)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)
+	RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, p.PatientID, p.PayerName, p.PayerID, p.MemberID, p.GroupNumber,
+		p.PlanName, p.Priority, p.Relationship, p.SubscriberFirstName, p.SubscriberLastName, p.SubscriberDOB,
+		p.SubscriberGender, p.Address, p.City, p.State, p.PostalCode, p.ValidFrom, p.ValidTo, p.CreatedBy).
+		Scan(&p.ID, &p.CreatedAt, &p.Version)
+}
+
+func (m *InsurancePolicyModel) GetById(ctx context.Context, patientID, id int64) (*InsurancePolicy, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `SELECT ` + policyColumns + ` FROM insurance_policies WHERE id = $1 AND patient_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	p, err := scanPolicy(m.DB.QueryRowContext(ctx, query, id, patientID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// GetForPatient returns the patient's policies, primary first, optionally only those
+// valid today.
+func (m *InsurancePolicyModel) GetForPatient(ctx context.Context, patientID int64, currentOnly bool) ([]*InsurancePolicy, error) {
+	query := `SELECT ` + policyColumns + `
+	FROM insurance_policies
+	WHERE patient_id = $1
+	AND (NOT $2 OR (valid_from <= CURRENT_DATE AND (valid_to IS NULL OR valid_to >= CURRENT_DATE)))
+	ORDER BY priority, valid_from DESC, id DESC`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, patientID, currentOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := []*InsurancePolicy{}
+	for rows.Next() {
+		p, err := scanPolicy(rows)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, p)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+func (m *InsurancePolicyModel) Update(ctx context.Context, p *InsurancePolicy) error {
+	query := `UPDATE insurance_policies
+	SET payer_name = $1, payer_id = $2, member_id = $3, group_number = $4, plan_name = $5, priority = $6,
+		relationship = $7, subscriber_first_name = $8, subscriber_last_name = $9,
+		subscriber_date_of_birth = $10, subscriber_gender = $11, address = $12, city = $13, state = $14,
+		postal_code = $15, valid_from = $16, valid_to = $17, version = version + 1
+	WHERE id = $18 AND version = $19
+	RETURNING version`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, p.PayerName, p.PayerID, p.MemberID, p.GroupNumber, p.PlanName,
+		p.Priority, p.Relationship, p.SubscriberFirstName, p.SubscriberLastName, p.SubscriberDOB,
+		p.SubscriberGender, p.Address, p.City, p.State, p.PostalCode, p.ValidFrom, p.ValidTo, p.ID, p.Version).
+		Scan(&p.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// EligibilityCheck records what the payer said about a policy for a visit.
+type EligibilityCheck struct {
+	ID           int64     `json:"id"`
+	QueueEntryID int64     `json:"queueEntryId"`
+	PatientID    int64     `json:"patientId"`
+	PolicyID     int64     `json:"policyId"`
+	Status       string    `json:"status"`
+	Reference    string    `json:"reference"`
+	Notes        string    `json:"notes"`
+	CheckedBy    int64     `json:"checkedBy"`
+	CheckedAt    time.Time `json:"checkedAt"`
+}
+
+func ValidateEligibilityCheck(v *validator.Validator, e *EligibilityCheck) {
+	v.Check(validator.In(e.Status, EligibilityStatuses...), "status", "must be eligible, not_eligible or unknown")
+	v.Check(len(e.Reference) <= 80, "reference", "must not be more than 80 bytes long")
+	v.Check(len(e.Notes) <= 2000, "notes", "must not be more than 2000 bytes long")
+}
+
+type EligibilityModel struct {
+	DB *sql.DB
+}
+
+func (m *EligibilityModel) Insert(ctx context.Context, e *EligibilityCheck) error {
+	query := `INSERT INTO eligibility_checks (queue_entry_id, patient_id, policy_id, status, reference, notes,
+	checked_by)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING id, checked_at`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, e.QueueEntryID, e.PatientID, e.PolicyID, e.Status, e.Reference,
+		e.Notes, e.CheckedBy).Scan(&e.ID, &e.CheckedAt)
+}
+
+// GetForQueueEntry returns the checks made for a visit, latest first.
+func (m *EligibilityModel) GetForQueueEntry(ctx context.Context, entryID int64) ([]*EligibilityCheck, error) {
+	query := `SELECT id, queue_entry_id, patient_id, policy_id, status, reference, notes, checked_by, checked_at
+	FROM eligibility_checks
+	WHERE queue_entry_id = $1
+	ORDER BY checked_at DESC, id DESC`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, entryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	checks := []*EligibilityCheck{}
+	for rows.Next() {
+		var e EligibilityCheck
+		err := rows.Scan(&e.ID, &e.QueueEntryID, &e.PatientID, &e.PolicyID, &e.Status, &e.Reference, &e.Notes,
+			&e.CheckedBy, &e.CheckedAt)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, &e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return checks, nil
+}
+
+// ClaimAdjustment explains part of a claim the payer didn't pay.
+type ClaimAdjustment struct {
+	Group  string `json:"group"`
+	Reason string `json:"reason"`
+	Amount Money  `json:"amount"`
+}
+
+// Claim asks a payer to pay an invoice under a policy.
+type Claim struct {
+	ID                    int64             `json:"id"`
+	ControlNumber         string            `json:"controlNumber"`
+	BatchID               int64             `json:"batchId"`
+	InvoiceID             int64             `json:"invoiceId"`
+	PatientID             int64             `json:"patientId"`
+	PolicyID              int64             `json:"policyId"`
+	Charge                Money             `json:"charge"`
+	Status                string            `json:"status"`
+	Paid                  Money             `json:"paid"`
+	PatientResponsibility Money             `json:"patientResponsibility"`
+	PayerClaimNumber      string            `json:"payerClaimNumber"`
+	Adjustments           []ClaimAdjustment `json:"adjustments"`
+	RemittanceID          *int64            `json:"remittanceId"`
+	AdjudicatedAt         *time.Time        `json:"adjudicatedAt"`
+	CreatedAt             time.Time         `json:"createdAt"`
+	Version               int64             `json:"version"`
+}
+
+// ClaimBatch is the set of claims sent to a payer in one 837 file.
+type ClaimBatch struct {
+	ID          int64     `json:"id"`
+	PayerID     string    `json:"payerId"`
+	PayerName   string    `json:"payerName"`
+	TotalCharge Money     `json:"totalCharge"`
+	ClaimCount  int       `json:"claimCount"`
+	CreatedBy   int64     `json:"createdBy"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Claims      []*Claim  `json:"claims,omitempty"`
+}
+
+// ClaimCandidate is an invoice that can be claimed under one of the patient's policies.
+type ClaimCandidate struct {
+	InvoiceID int64
+	PatientID int64
+	PolicyID  int64
+}
+
+type ClaimModel struct {
+	DB *sql.DB
+}
+
+const claimColumns = `id, control_number, batch_id, invoice_id, patient_id, policy_id, charge, currency, status,
+	paid, patient_responsibility, payer_claim_number, adjustments, remittance_id, adjudicated_at, created_at,
+	version`
+
+func scanClaim(row rowScanner, extra ...any) (*Claim, error) {
+	var c Claim
+	var currency string
+	var adjustments []byte
+	dest := append(extra, &c.ID, &c.ControlNumber, &c.BatchID, &c.InvoiceID, &c.PatientID, &c.PolicyID,
+		&c.Charge.Amount, &currency, &c.Status, &c.Paid.Amount, &c.PatientResponsibility.Amount,
+		&c.PayerClaimNumber, &adjustments, &c.RemittanceID, &c.AdjudicatedAt, &c.CreatedAt, &c.Version)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(adjustments, &c.Adjustments); err != nil {
+		return nil, err
+	}
+	c.Charge.Currency = currency
+	c.Paid.Currency = currency
+	c.PatientResponsibility.Currency = currency
+	return &c, nil
+}
+
+// Candidates finds the invoices issued in [from, to) in the currency that haven't
+// been claimed yet and that a policy with the payer covered on the day they were
+// issued. When a patient has more than one such policy the primary is used.
+func (m *ClaimModel) Candidates(ctx context.Context, payerID, currency string, from, to time.Time) ([]ClaimCandidate, error) {
+	query := `SELECT DISTINCT ON (i.id) i.id, i.patient_id, p.id
+	FROM invoices i
+	JOIN insurance_policies p ON p.patient_id = i.patient_id
+		AND p.payer_id = $1
+		AND p.valid_from <= i.issued_at::date
+		AND (p.valid_to IS NULL OR p.valid_to >= i.issued_at::date)
+	WHERE i.status <> 'void' AND i.currency = $2 AND i.issued_at >= $3 AND i.issued_at < $4
+	AND NOT EXISTS (SELECT 1 FROM claims c WHERE c.invoice_id = i.id AND c.status <> 'denied')
+	ORDER BY i.id, p.priority, p.id`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, payerID, currency, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candidates []ClaimCandidate
+	for rows.Next() {
+		var c ClaimCandidate
+		if err := rows.Scan(&c.InvoiceID, &c.PatientID, &c.PolicyID); err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return candidates, nil
+}
+
+// CreateBatch saves the batch and its claims, then calls render with the control
+// numbers filled in and keeps the file it returns. Nothing is saved if render fails.
+func (m *ClaimModel) CreateBatch(ctx context.Context, b *ClaimBatch, render func(*ClaimBatch) ([]byte, error)) error {
+	if len(b.Claims) == 0 {
+		return ErrNothingToClaim
+	}
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(m.DB, ctx, func(tx *sql.Tx) error {
+		b.ClaimCount = len(b.Claims)
+		b.TotalCharge = NewMoney(0, b.Claims[0].Charge.Currency)
+		for _, c := range b.Claims {
+			b.TotalCharge.Amount += c.Charge.Amount
+		}
+
+		err := tx.QueryRowContext(ctx, `INSERT INTO claim_batches (payer_id, payer_name, currency, claim_count,
+		total_charge, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`, b.PayerID, b.PayerName, b.TotalCharge.Currency, b.ClaimCount,
+			b.TotalCharge.Amount, b.CreatedBy).Scan(&b.ID, &b.CreatedAt)
+		if err != nil {
+			return err
+		}
+
+		for _, c := range b.Claims {
+			c.BatchID = b.ID
+			err := tx.QueryRowContext(ctx, `INSERT INTO claims (batch_id, invoice_id, patient_id, policy_id,
+			charge, currency)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id, control_number, status, created_at, version`, c.BatchID, c.InvoiceID, c.PatientID,
+				c.PolicyID, c.Charge.Amount, c.Charge.Currency).
+				Scan(&c.ID, &c.ControlNumber, &c.Status, &c.CreatedAt, &c.Version)
+			if err != nil {
+				return err
+			}
+			c.Paid = NewMoney(0, c.Charge.Currency)
+			c.PatientResponsibility = NewMoney(0, c.Charge.Currency)
+			c.Adjustments = []ClaimAdjustment{}
+		}
+
+		file, err := render(b)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE claim_batches SET file = $1 WHERE id = $2`, string(file), b.ID)
+		return err
+	})
+}
+
+func (m *ClaimModel) GetBatch(ctx context.Context, id int64) (*ClaimBatch, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `SELECT id, payer_id, payer_name, currency, claim_count, total_charge, created_by, created_at
+	FROM claim_batches
+	WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var b ClaimBatch
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&b.ID, &b.PayerID, &b.PayerName, &b.TotalCharge.Currency,
+		&b.ClaimCount, &b.TotalCharge.Amount, &b.CreatedBy, &b.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT `+claimColumns+` FROM claims WHERE batch_id = $1 ORDER BY id`, b.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c, err := scanClaim(rows)
+		if err != nil {
+			return nil, err
+		}
+		b.Claims = append(b.Claims, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// GetBatchFile returns the 837 interchange generated for the batch.
+func (m *ClaimModel) GetBatchFile(ctx context.Context, id int64) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var file string
+	err := m.DB.QueryRowContext(ctx, `SELECT file FROM claim_batches WHERE id = $1`, id).Scan(&file)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return []byte(file), nil
+}
+
+func (m *ClaimModel) GetBatches(ctx context.Context, payerID string, filters Filters) ([]*ClaimBatch, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, payer_id, payer_name, currency, claim_count, total_charge, created_by, created_at
+	FROM claim_batches
+	WHERE (payer_id = $1 OR $1 = '')
+	ORDER BY %s %s, id DESC
+	LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, payerID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	batches := []*ClaimBatch{}
+	for rows.Next() {
+		var b ClaimBatch
+		err := rows.Scan(&totalRecords, &b.ID, &b.PayerID, &b.PayerName, &b.TotalCharge.Currency, &b.ClaimCount,
+			&b.TotalCharge.Amount, &b.CreatedBy, &b.CreatedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		batches = append(batches, &b)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return batches, metadata, nil
+}
+
+// GetAll lists claims, optionally for one patient or in one status.
+func (m *ClaimModel) GetAll(ctx context.Context, patientID int64, status string, filters Filters) ([]*Claim, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), `+claimColumns+`
+	FROM claims
+	WHERE (patient_id = $1 OR $1 = 0) AND (status = $2 OR $2 = '')
+	ORDER BY %s %s, id DESC
+	LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, patientID, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	claims := []*Claim{}
+	for rows.Next() {
+		c, err := scanClaim(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		claims = append(claims, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return claims, metadata, nil
+}
+
+// Remittance is an imported 835: one payment from a payer covering some claims.
+type Remittance struct {
+	ID              int64     `json:"id"`
+	PayerID         string    `json:"payerId"`
+	PayerName       string    `json:"payerName"`
+	TraceNumber     string    `json:"traceNumber"`
+	PaymentAmount   Money     `json:"paymentAmount"`
+	PaymentMethod   string    `json:"paymentMethod"`
+	PaymentDate     *Date     `json:"paymentDate"`
+	ClaimsMatched   int       `json:"claimsMatched"`
+	ClaimsUnmatched int       `json:"claimsUnmatched"`
+	ImportedBy      int64     `json:"importedBy"`
+	ImportedAt      time.Time `json:"importedAt"`
+	// Unmatched lists the control numbers in the file that no claim here has.
+	Unmatched []string `json:"unmatched,omitempty"`
+}
+
+// ClaimOutcome is how a remittance settled one claim.
+type ClaimOutcome struct {
+	ControlNumber         string
+	Status                string
+	Paid                  Money
+	PatientResponsibility Money
+	PayerClaimNumber      string
+	Adjustments           []ClaimAdjustment
+}
+
+type RemittanceModel struct {
+	DB *sql.DB
+}
+
+// Import records the remittance and updates the claims it settles. A remittance with
+// the same payer and trace number can only be imported once.
+func (m *RemittanceModel) Import(ctx context.Context, r *Remittance, outcomes []ClaimOutcome) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(m.DB, ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, `INSERT INTO remittances (payer_id, payer_name, trace_number,
+		payment_amount, currency, payment_method, payment_date, imported_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, imported_at`, r.PayerID, r.PayerName, r.TraceNumber, r.PaymentAmount.Amount,
+			r.PaymentAmount.Currency, r.PaymentMethod, r.PaymentDate, r.ImportedBy).Scan(&r.ID, &r.ImportedAt)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "remittances_trace_key"`:
+				return ErrDuplicateRemittance
+			default:
+				return err
+			}
+		}
+
+		r.Unmatched = []string{}
+		for _, o := range outcomes {
+			adjustments, err := json.Marshal(o.Adjustments)
+			if err != nil {
+				return err
+			}
+			result, err := tx.ExecContext(ctx, `UPDATE claims
+			SET status = $1, paid = $2, patient_responsibility = $3, payer_claim_number = $4, adjustments = $5,
+				remittance_id = $6, adjudicated_at = NOW(), version = version + 1
+			WHERE control_number = $7 AND currency = $8`, o.Status, o.Paid.Amount, o.PatientResponsibility.Amount,
+				o.PayerClaimNumber, adjustments, r.ID, o.ControlNumber, o.Paid.Currency)
+			if err != nil {
+				return err
+			}
+			n, err := result.RowsAffected()
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				r.Unmatched = append(r.Unmatched, o.ControlNumber)
+				continue
+			}
+			r.ClaimsMatched++
+		}
+		r.ClaimsUnmatched = len(r.Unmatched)
+
+		_, err = tx.ExecContext(ctx, `UPDATE remittances SET claims_matched = $1, claims_unmatched = $2 WHERE id = $3`,
+			r.ClaimsMatched, r.ClaimsUnmatched, r.ID)
+		return err
+	})
+}
+
+func (m *RemittanceModel) GetAll(ctx context.Context, filters Filters) ([]*Remittance, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, payer_id, payer_name, trace_number, payment_amount, currency, payment_method,
+		payment_date, claims_matched, claims_unmatched, imported_by, imported_at
+	FROM remittances
+	ORDER BY %s %s, id DESC
+	LIMIT $1 OFFSET $2`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	remittances := []*Remittance{}
+	for rows.Next() {
+		var r Remittance
+		err := rows.Scan(&totalRecords, &r.ID, &r.PayerID, &r.PayerName, &r.TraceNumber, &r.PaymentAmount.Amount,
+			&r.PaymentAmount.Currency, &r.PaymentMethod, &r.PaymentDate, &r.ClaimsMatched, &r.ClaimsUnmatched,
+			&r.ImportedBy, &r.ImportedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		remittances = append(remittances, &r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return remittances, metadata, nil
+}
@@ -0,0 +1,315 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+)
+
+var JobStatuses = []string{JobPending, JobRunning, JobCompleted, JobFailed}
+
+var (
+	ErrDuplicateJob = errors.New("a job with this unique key is already queued")
+	ErrJobNotFailed = errors.New("only failed jobs can be retried")
+	// ErrJobLost is returned to a worker whose job has been claimed again since it
+	// claimed it, after its lease ran out.
+	ErrJobLost = errors.New("the job has been claimed by another worker")
+)
+
+// Job is a unit of background work. Args are decoded by the handler registered for
+// the job's kind. A job that fails is retried with increasing delays until it has
+// been attempted MaxAttempts times, after which it stays failed until retried by
+// hand.
+type Job struct {
+	ID          int64           `json:"id"`
+	Kind        string          `json:"kind"`
+	Args        json.RawMessage `json:"args"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"maxAttempts"`
+	RunAt       time.Time       `json:"runAt"`
+	UniqueKey   *string         `json:"uniqueKey"`
+	LockedUntil *time.Time      `json:"lockedUntil"`
+	LastError   string          `json:"lastError"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	StartedAt   *time.Time      `json:"startedAt"`
+	FinishedAt  *time.Time      `json:"finishedAt"`
+}
+
+type JobModel struct {
+	DB *sql.DB
+}
+
+// Enqueue adds a job to run at j.RunAt, or straight away when it is zero. It returns
+// ErrDuplicateJob when a job with the same unique key is already pending or running.
+func (m *JobModel) Enqueue(ctx context.Context, j *Job) error {
+	if j.RunAt.IsZero() {
+		j.RunAt = time.Now()
+	}
+	query := `INSERT INTO jobs (kind, args, status, max_attempts, run_at, unique_key)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT (unique_key) WHERE unique_key IS NOT NULL AND status IN ('pending', 'running') DO NOTHING
+	RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	j.Status = JobPending
+	err := m.DB.QueryRowContext(ctx, query, j.Kind, []byte(j.Args), j.Status, j.MaxAttempts, j.RunAt, j.UniqueKey).
+		Scan(&j.ID, &j.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrDuplicateJob
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+const jobColumns = `id, kind, args, status, attempts, max_attempts, run_at, unique_key, locked_until, last_error,
+	created_at, started_at, finished_at`
+
+func scanJob(row rowScanner, extra ...any) (*Job, error) {
+	var j Job
+	var args []byte
+	dest := append(extra, &j.ID, &j.Kind, &args, &j.Status, &j.Attempts, &j.MaxAttempts, &j.RunAt, &j.UniqueKey,
+		&j.LockedUntil, &j.LastError, &j.CreatedAt, &j.StartedAt, &j.FinishedAt)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	j.Args = args
+	return &j, nil
+}
+
+// Claim locks up to limit due jobs of the given kinds for lease and marks them
+// running. Jobs whose worker stopped without finishing them are claimed again once
+// their lease has run out, or failed if they have no attempts left. Jobs are locked
+// while they are claimed, so several instances can claim at once without running a
+// job twice.
+func (m *JobModel) Claim(ctx context.Context, kinds []string, limit int, lease time.Duration) ([]*Job, error) {
+	abandoned := `UPDATE jobs
+	SET status = $1, locked_until = NULL, finished_at = NOW(), last_error = 'the worker running the job stopped'
+	WHERE status = $2 AND locked_until < NOW() AND attempts >= max_attempts`
+
+	query := `
+	UPDATE jobs
+	SET status = $1, attempts = attempts + 1, locked_until = NOW() + $2 * INTERVAL '1 second', started_at = NOW()
+	WHERE id IN (
+		SELECT id FROM jobs
+		WHERE kind = ANY($3)
+		AND ((status = $4 AND run_at <= NOW()) OR (status = $1 AND locked_until < NOW()))
+		ORDER BY run_at, id
+		LIMIT $5
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING ` + jobColumns
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	if _, err := m.DB.ExecContext(ctx, abandoned, JobFailed, JobRunning); err != nil {
+		return nil, err
+	}
+
+	rows, err := m.DB.QueryContext(ctx, query, JobRunning, lease.Seconds(), pq.Array(kinds), JobPending, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []*Job{}
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// jobLost turns finding no job to update into ErrJobLost. Each claim counts an
+// attempt, so a job that is no longer running with the attempts the worker claimed
+// it with has been taken over.
+func jobLost(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrJobLost
+	}
+	return err
+}
+
+// Extend renews the lease on a running job, so it is not claimed again while it is
+// still being worked on.
+func (m *JobModel) Extend(ctx context.Context, j *Job, lease time.Duration) error {
+	query := `UPDATE jobs SET locked_until = NOW() + $1 * INTERVAL '1 second'
+	WHERE id = $2 AND status = $3 AND attempts = $4
+	RETURNING locked_until`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return jobLost(m.DB.QueryRowContext(ctx, query, lease.Seconds(), j.ID, JobRunning, j.Attempts).
+		Scan(&j.LockedUntil))
+}
+
+func (m *JobModel) Complete(ctx context.Context, j *Job) error {
+	query := `UPDATE jobs SET status = $1, locked_until = NULL, last_error = '', finished_at = NOW()
+	WHERE id = $2 AND status = $3 AND attempts = $4
+	RETURNING finished_at`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, JobCompleted, j.ID, JobRunning, j.Attempts).Scan(&j.FinishedAt)
+	if err != nil {
+		return jobLost(err)
+	}
+	j.Status = JobCompleted
+	j.LockedUntil = nil
+	j.LastError = ""
+	return nil
+}
+
+// Release puts a running job back in the queue without counting the attempt, for a
+// worker that is stopping before the job is done.
+func (m *JobModel) Release(ctx context.Context, j *Job) error {
+	query := `UPDATE jobs SET status = $1, attempts = attempts - 1, locked_until = NULL, run_at = NOW()
+	WHERE id = $2 AND status = $3 AND attempts = $4
+	RETURNING attempts, run_at`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, JobPending, j.ID, JobRunning, j.Attempts).Scan(&j.Attempts, &j.RunAt)
+	if err != nil {
+		return jobLost(err)
+	}
+	j.Status = JobPending
+	j.LockedUntil = nil
+	return nil
+}
+
+// Fail records a failed attempt. The job runs again after retryIn, or is marked
+// failed for good when retryIn is not positive.
+func (m *JobModel) Fail(ctx context.Context, j *Job, runErr error, retryIn time.Duration) error {
+	status := JobPending
+	if retryIn <= 0 {
+		status = JobFailed
+	}
+	query := `UPDATE jobs
+	SET status = $1, locked_until = NULL, last_error = $2, run_at = NOW() + $3 * INTERVAL '1 second',
+		finished_at = CASE WHEN $1 = 'failed' THEN NOW() END
+	WHERE id = $4 AND status = $5 AND attempts = $6
+	RETURNING run_at, finished_at`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, status, runErr.Error(), max(retryIn.Seconds(), 0), j.ID, JobRunning,
+		j.Attempts).Scan(&j.RunAt, &j.FinishedAt)
+	if err != nil {
+		return jobLost(err)
+	}
+	j.Status = status
+	j.LockedUntil = nil
+	j.LastError = runErr.Error()
+	return nil
+}
+
+func (m *JobModel) GetById(ctx context.Context, id int64) (*Job, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `SELECT ` + jobColumns + ` FROM jobs WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	j, err := scanJob(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return j, nil
+}
+
+func (m *JobModel) GetAll(ctx context.Context, status, kind string, filters Filters) ([]*Job, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), `+jobColumns+`
+	FROM jobs
+	WHERE (status = $1 OR $1 = '')
+	AND (kind = $2 OR $2 = '')
+	ORDER BY %s %s, id DESC
+	LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, status, kind, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	jobs := []*Job{}
+	for rows.Next() {
+		j, err := scanJob(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		jobs = append(jobs, j)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return jobs, metadata, nil
+}
+
+// Retry queues a failed job to run again straight away with its attempts reset. It
+// returns ErrJobNotFailed for jobs that have not failed, and ErrDuplicateJob when a
+// job with the same unique key has been queued since.
+func (m *JobModel) Retry(ctx context.Context, j *Job) error {
+	query := `UPDATE jobs
+	SET status = $1, attempts = 0, run_at = NOW(), last_error = '', started_at = NULL, finished_at = NULL
+	WHERE id = $2 AND status = $3
+	RETURNING status, attempts, run_at, started_at, finished_at`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, JobPending, j.ID, JobFailed).
+		Scan(&j.Status, &j.Attempts, &j.RunAt, &j.StartedAt, &j.FinishedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "jobs_unique_key"`:
+			return ErrDuplicateJob
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrJobNotFailed
+		default:
+			return err
+		}
+	}
+	j.LastError = ""
+	return nil
+}
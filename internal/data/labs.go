@@ -0,0 +1,395 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+const (
+	LabStatusOrdered   = "ordered"
+	LabStatusCollected = "collected"
+	LabStatusResulted  = "resulted"
+	LabStatusCancelled = "cancelled"
+
+	FlagCritical = "critical"
+)
+
+var (
+	LabStatuses   = []string{LabStatusOrdered, LabStatusCollected, LabStatusResulted, LabStatusCancelled}
+	LabPriorities = []string{"routine", "urgent", "stat"}
+)
+
+var (
+	ErrLabOrderNotResultable = errors.New("results can only be posted for orders that are ordered or collected")
+	ErrLabOrderUnresulted    = errors.New("lab order has no results to acknowledge")
+)
+
+// labTransitions lists the statuses an order may be moved to by hand. An order only
+// becomes resulted when its results are posted.
+var labTransitions = map[string][]string{
+	LabStatusOrdered:   {LabStatusCollected, LabStatusCancelled},
+	LabStatusCollected: {LabStatusCancelled},
+}
+
+type LabOrder struct {
+	ID             int64        `json:"id"`
+	PatientID      int64        `json:"patientId"`
+	DoctorID       int64        `json:"doctorId"`
+	EncounterID    *int64       `json:"encounterId"`
+	TestCode       string       `json:"testCode"`
+	TestName       string       `json:"testName"`
+	Priority       string       `json:"priority"`
+	Notes          string       `json:"notes"`
+	Status         string       `json:"status"`
+	OrderedAt      time.Time    `json:"orderedAt"`
+	CollectedAt    *time.Time   `json:"collectedAt"`
+	ResultedAt     *time.Time   `json:"resultedAt"`
+	CancelledAt    *time.Time   `json:"cancelledAt"`
+	CancelReason   string       `json:"cancelReason,omitempty"`
+	AcknowledgedAt *time.Time   `json:"acknowledgedAt"`
+	AcknowledgedBy *int64       `json:"acknowledgedBy"`
+	Critical       bool         `json:"critical"`
+	Version        int64        `json:"version"`
+	Results        []*LabResult `json:"results,omitempty"`
+}
+
+// LabResult is a single measured analyte. Numeric results are flagged against the
+// reference and critical ranges sent with them; text results are never flagged.
+type LabResult struct {
+	ID            int64     `json:"id"`
+	OrderID       int64     `json:"orderId"`
+	Code          string    `json:"code"`
+	Name          string    `json:"name"`
+	Value         *float64  `json:"value"`
+	ValueText     string    `json:"valueText,omitempty"`
+	Unit          string    `json:"unit"`
+	ReferenceLow  *float64  `json:"referenceLow"`
+	ReferenceHigh *float64  `json:"referenceHigh"`
+	CriticalLow   *float64  `json:"criticalLow,omitempty"`
+	CriticalHigh  *float64  `json:"criticalHigh,omitempty"`
+	Flag          string    `json:"flag"`
+	ObservedAt    time.Time `json:"observedAt"`
+}
+
+// FlagLabResult works out the flag for a result: critical when outside the critical
+// range, low or high when outside the reference range, and empty when normal.
+func FlagLabResult(r *LabResult) string {
+	if r.Value == nil {
+		return ""
+	}
+	v := *r.Value
+	switch {
+	case r.CriticalLow != nil && v <= *r.CriticalLow, r.CriticalHigh != nil && v >= *r.CriticalHigh:
+		return FlagCritical
+	case r.ReferenceLow != nil && v < *r.ReferenceLow:
+		return FlagLow
+	case r.ReferenceHigh != nil && v > *r.ReferenceHigh:
+		return FlagHigh
+	}
+	return ""
+}
+
+func (o *LabOrder) IsResulted() bool {
+	return o.Status == LabStatusResulted
+}
+
+func ValidateLabOrder(v *validator.Validator, o *LabOrder) {
+	v.Check(o.TestCode != "", "testCode", "must be provided")
+	v.Check(o.TestName != "", "testName", "must be provided")
+	v.Check(validator.In(o.Priority, LabPriorities...), "priority", "must be routine, urgent or stat")
+}
+
+func ValidateLabTransition(v *validator.Validator, from, to string) {
+	v.Check(validator.In(to, labTransitions[from]...), "status", "cannot move a "+from+" order to "+to)
+}
+
+func ValidateLabResults(v *validator.Validator, results []*LabResult) {
+	v.Check(len(results) > 0, "results", "must contain at least one result")
+	for i, r := range results {
+		key := fmt.Sprintf("results[%d]", i)
+		v.Check(r.Code != "", key+".code", "must be provided")
+		v.Check(r.Name != "", key+".name", "must be provided")
+		v.Check(r.Value != nil || r.ValueText != "", key+".value", "a value or valueText must be provided")
+		if r.ReferenceLow != nil && r.ReferenceHigh != nil {
+			v.Check(*r.ReferenceLow <= *r.ReferenceHigh, key+".referenceLow", "must not be above referenceHigh")
+		}
+		if r.CriticalLow != nil && r.CriticalHigh != nil {
+			v.Check(*r.CriticalLow < *r.CriticalHigh, key+".criticalLow", "must be below criticalHigh")
+		}
+	}
+}
+
+type LabOrderModel struct {
+	DB *sql.DB
+}
+
+const labOrderColumns = `o.id, o.patient_id, o.doctor_id, o.encounter_id, o.test_code, o.test_name,
+	o.priority, o.notes, o.status, o.ordered_at, o.collected_at, o.resulted_at, o.cancelled_at,
+	o.cancel_reason, o.acknowledged_at, o.acknowledged_by,
+	EXISTS (SELECT 1 FROM lab_results r WHERE r.order_id = o.id AND r.flag = 'critical') AS critical,
+	o.version`
+
+func scanLabOrder(row rowScanner, extra ...any) (*LabOrder, error) {
+	var o LabOrder
+	dest := append(extra, &o.ID, &o.PatientID, &o.DoctorID, &o.EncounterID, &o.TestCode, &o.TestName,
+		&o.Priority, &o.Notes, &o.Status, &o.OrderedAt, &o.CollectedAt, &o.ResultedAt, &o.CancelledAt,
+		&o.CancelReason, &o.AcknowledgedAt, &o.AcknowledgedBy, &o.Critical, &o.Version)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+func (m *LabOrderModel) Insert(ctx context.Context, o *LabOrder) error {
+	query := `INSERT INTO lab_orders (patient_id, doctor_id, encounter_id, test_code, test_name, priority, notes)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING id, status, ordered_at, version`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, o.PatientID, o.DoctorID, o.EncounterID, o.TestCode,
+		o.TestName, o.Priority, o.Notes).Scan(&o.ID, &o.Status, &o.OrderedAt, &o.Version)
+}
+
+// GetById looks an order up by id. A patientID of zero matches any patient; it is used
+// by the lab integration, which only knows the order number.
+func (m *LabOrderModel) GetById(ctx context.Context, patientID, id int64) (*LabOrder, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `SELECT ` + labOrderColumns + `
+	FROM lab_orders o
+	WHERE o.id = $1 AND (o.patient_id = $2 OR $2 = 0)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	o, err := scanLabOrder(m.DB.QueryRowContext(ctx, query, id, patientID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	o.Results, err = m.GetResults(ctx, o.ID)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func (m *LabOrderModel) GetForPatient(ctx context.Context, patientID int64, status string, filters Filters) ([]*LabOrder, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), `+labOrderColumns+`
+	FROM lab_orders o
+	WHERE o.patient_id = $1
+	AND (o.status = $2 OR $2 = '')
+	ORDER BY o.%s %s, o.id DESC
+	LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	return m.list(ctx, query, filters, patientID, status, filters.limit(), filters.offset())
+}
+
+// Inbox returns the resulted orders a doctor placed that they have not acknowledged
+// yet. Orders with critical results come first.
+func (m *LabOrderModel) Inbox(ctx context.Context, doctorID int64, filters Filters) ([]*LabOrder, Metadata, error) {
+	query := `
+	SELECT count(*) OVER(), ` + labOrderColumns + `
+	FROM lab_orders o
+	WHERE o.doctor_id = $1 AND o.status = 'resulted' AND o.acknowledged_at IS NULL
+	ORDER BY critical DESC, o.resulted_at ASC, o.id ASC
+	LIMIT $2 OFFSET $3`
+
+	return m.list(ctx, query, filters, doctorID, filters.limit(), filters.offset())
+}
+
+func (m *LabOrderModel) list(ctx context.Context, query string, filters Filters, args ...any) ([]*LabOrder, Metadata, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	orders := []*LabOrder{}
+	ids := []int64{}
+	for rows.Next() {
+		o, err := scanLabOrder(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		orders = append(orders, o)
+		ids = append(ids, o.ID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	results, err := m.resultsFor(ctx, ids)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	for _, o := range orders {
+		o.Results = results[o.ID]
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return orders, metadata, nil
+}
+
+func (m *LabOrderModel) GetResults(ctx context.Context, orderID int64) ([]*LabResult, error) {
+	results, err := m.resultsFor(ctx, []int64{orderID})
+	if err != nil {
+		return nil, err
+	}
+	return results[orderID], nil
+}
+
+func (m *LabOrderModel) resultsFor(ctx context.Context, orderIDs []int64) (map[int64][]*LabResult, error) {
+	results := make(map[int64][]*LabResult)
+	if len(orderIDs) == 0 {
+		return results, nil
+	}
+
+	query := `SELECT id, order_id, code, name, value, value_text, unit, reference_low, reference_high,
+	critical_low, critical_high, flag, observed_at
+	FROM lab_results
+	WHERE order_id = ANY($1)
+	ORDER BY order_id, id`
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(orderIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r LabResult
+		err := rows.Scan(&r.ID, &r.OrderID, &r.Code, &r.Name, &r.Value, &r.ValueText, &r.Unit,
+			&r.ReferenceLow, &r.ReferenceHigh, &r.CriticalLow, &r.CriticalHigh, &r.Flag, &r.ObservedAt)
+		if err != nil {
+			return nil, err
+		}
+		results[r.OrderID] = append(results[r.OrderID], &r)
+	}
+	return results, rows.Err()
+}
+
+// UpdateStatus moves an order to collected or cancelled.
+func (m *LabOrderModel) UpdateStatus(ctx context.Context, o *LabOrder) error {
+	query := `UPDATE lab_orders
+	SET status = $1,
+		collected_at = CASE WHEN $1 = 'collected' THEN NOW() ELSE collected_at END,
+		cancelled_at = CASE WHEN $1 = 'cancelled' THEN NOW() ELSE cancelled_at END,
+		cancel_reason = $2,
+		version = version + 1
+	WHERE id = $3 AND version = $4
+	RETURNING collected_at, cancelled_at, version`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, o.Status, o.CancelReason, o.ID, o.Version).
+		Scan(&o.CollectedAt, &o.CancelledAt, &o.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// PostResults records the results for an order, flagging each one, and marks the
+// order as resulted. collectedAt is used when the order was not already marked as
+// collected.
+func (m *LabOrderModel) PostResults(ctx context.Context, orderID int64, collectedAt *time.Time, results []*LabResult) (*LabOrder, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := withTx(m.DB, ctx, func(tx *sql.Tx) error {
+		var status string
+		err := tx.QueryRowContext(ctx, `SELECT status FROM lab_orders WHERE id = $1 FOR UPDATE`, orderID).
+			Scan(&status)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+		if status != LabStatusOrdered && status != LabStatusCollected {
+			return ErrLabOrderNotResultable
+		}
+
+		query := `INSERT INTO lab_results (order_id, code, name, value, value_text, unit,
+		reference_low, reference_high, critical_low, critical_high, flag, observed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, COALESCE($12, NOW()))
+		RETURNING id, observed_at`
+
+		for _, r := range results {
+			r.OrderID = orderID
+			r.Flag = FlagLabResult(r)
+			var observedAt *time.Time
+			if !r.ObservedAt.IsZero() {
+				observedAt = &r.ObservedAt
+			}
+			err := tx.QueryRowContext(ctx, query, orderID, r.Code, r.Name, r.Value, r.ValueText, r.Unit,
+				r.ReferenceLow, r.ReferenceHigh, r.CriticalLow, r.CriticalHigh, r.Flag, observedAt).
+				Scan(&r.ID, &r.ObservedAt)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE lab_orders
+		SET status = 'resulted', resulted_at = NOW(),
+			collected_at = COALESCE(collected_at, $1, NOW()),
+			version = version + 1
+		WHERE id = $2`, collectedAt, orderID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return m.GetById(ctx, 0, orderID)
+}
+
+func (m *LabOrderModel) Acknowledge(ctx context.Context, o *LabOrder, doctorID int64) error {
+	query := `UPDATE lab_orders
+	SET acknowledged_at = NOW(), acknowledged_by = $1, version = version + 1
+	WHERE id = $2 AND version = $3 AND status = 'resulted'
+	RETURNING acknowledged_at, acknowledged_by, version`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, doctorID, o.ID, o.Version).
+		Scan(&o.AcknowledgedAt, &o.AcknowledgedBy, &o.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
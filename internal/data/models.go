@@ -18,6 +18,42 @@ type Models struct {
 	Doctors       DoctorModel
 	Patients      PatientModel
 	Roles         RoleModel
+	Queue         QueueModel
+	Encounters    EncounterModel
+	VitalSigns    VitalSignsModel
+	ICD10         ICD10Model
+	Problems      ProblemModel
+	Diagnoses     DiagnosisModel
+	Allergies     AllergyModel
+	Prescriptions PrescriptionModel
+	LabOrders     LabOrderModel
+	Notes         NoteModel
+	Wards         WardModel
+	Admissions    AdmissionModel
+	Discharges    DischargeSummaryModel
+	Attachments   AttachmentModel
+	Consents      ConsentModel
+	Immunizations ImmunizationModel
+	Referrals     ReferralModel
+	Services      BillingServiceModel
+	Invoices      InvoiceModel
+	Policies      InsurancePolicyModel
+	Eligibility   EligibilityModel
+	Claims        ClaimModel
+	Remittances   RemittanceModel
+	Departments   DepartmentModel
+	Specialties   SpecialtyModel
+	Shifts        ShiftModel
+	Swaps         ShiftSwapModel
+	HL7Messages   HL7MessageModel
+	Imports       PatientImportModel
+	Exports       ExportModel
+	Studies       ResearchStudyModel
+	Webhooks      WebhookModel
+	Deliveries    WebhookDeliveryModel
+	Jobs          JobModel
+	Preferences   NotificationPreferenceModel
+	Notifications NotificationModel
 }
 
 func NewModels(db *sql.DB) Models {
@@ -26,6 +62,42 @@ func NewModels(db *sql.DB) Models {
 		Doctors:       DoctorModel{db},
 		Patients:      PatientModel{db},
 		Roles:         RoleModel{db},
+		Queue:         QueueModel{db},
+		Encounters:    EncounterModel{db},
+		VitalSigns:    VitalSignsModel{db},
+		ICD10:         ICD10Model{db},
+		Problems:      ProblemModel{db},
+		Diagnoses:     DiagnosisModel{db},
+		Allergies:     AllergyModel{db},
+		Prescriptions: PrescriptionModel{db},
+		LabOrders:     LabOrderModel{db},
+		Notes:         NoteModel{db},
+		Wards:         WardModel{db},
+		Admissions:    AdmissionModel{db},
+		Discharges:    DischargeSummaryModel{db},
+		Attachments:   AttachmentModel{db},
+		Consents:      ConsentModel{db},
+		Immunizations: ImmunizationModel{db},
+		Referrals:     ReferralModel{db},
+		Services:      BillingServiceModel{db},
+		Invoices:      InvoiceModel{db},
+		Policies:      InsurancePolicyModel{db},
+		Eligibility:   EligibilityModel{db},
+		Claims:        ClaimModel{db},
+		Remittances:   RemittanceModel{db},
+		Departments:   DepartmentModel{db},
+		Specialties:   SpecialtyModel{db},
+		Shifts:        ShiftModel{db},
+		Swaps:         ShiftSwapModel{db},
+		HL7Messages:   HL7MessageModel{db},
+		Imports:       PatientImportModel{db},
+		Exports:       ExportModel{db},
+		Studies:       ResearchStudyModel{db},
+		Webhooks:      WebhookModel{db},
+		Deliveries:    WebhookDeliveryModel{db},
+		Jobs:          JobModel{db},
+		Preferences:   NotificationPreferenceModel{db},
+		Notifications: NotificationModel{db},
 	}
 }
 
@@ -0,0 +1,101 @@
+package data
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+var currencyRX = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// currencyExponents lists the currencies whose minor unit isn't a hundredth.
+var currencyExponents = map[string]int{
+	"JPY": 0, "KRW": 0, "XAF": 0, "XOF": 0, "RWF": 0, "UGX": 0,
+	"BHD": 3, "JOD": 3, "KWD": 3, "OMR": 3, "TND": 3,
+}
+
+// Money is an amount in the minor units of its currency, e.g. kobo or cents, so that
+// amounts are never rounded. Amounts in different currencies are never added up.
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+func NewMoney(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// String formats the amount in major units, e.g. "NGN 1500.00".
+func (m Money) String() string {
+	return m.Currency + " " + m.Decimal()
+}
+
+// Decimal formats the amount in major units without the currency, e.g. "1500.00".
+func (m Money) Decimal() string {
+	exp := currencyExponent(m.Currency)
+	sign := ""
+	amount := m.Amount
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	if exp == 0 {
+		return fmt.Sprintf("%s%d", sign, amount)
+	}
+	unit := pow10(exp)
+	return fmt.Sprintf("%s%d.%0*d", sign, amount/unit, exp, amount%unit)
+}
+
+// ParseMoney reads a decimal amount in major units, e.g. "1500.5", exactly into minor
+// units. Amounts with more decimal places than the currency has are rejected.
+func ParseMoney(amount, currency string) (Money, error) {
+	exp := currencyExponent(currency)
+	s := strings.TrimSpace(amount)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" && frac == "" || len(frac) > exp {
+		return Money{}, fmt.Errorf("invalid %s amount %q", currency, amount)
+	}
+	frac += strings.Repeat("0", exp-len(frac))
+
+	var minor int64
+	for _, r := range whole + frac {
+		if r < '0' || r > '9' || minor > (math.MaxInt64-9)/10 {
+			return Money{}, fmt.Errorf("invalid %s amount %q", currency, amount)
+		}
+		minor = minor*10 + int64(r-'0')
+	}
+	if negative {
+		minor = -minor
+	}
+	return NewMoney(minor, currency), nil
+}
+
+func currencyExponent(currency string) int {
+	if exp, ok := currencyExponents[currency]; ok {
+		return exp
+	}
+	return 2
+}
+
+func pow10(n int) int64 {
+	unit := int64(1)
+	for i := 0; i < n; i++ {
+		unit *= 10
+	}
+	return unit
+}
+
+func ValidateCurrency(v *validator.Validator, key, currency string) {
+	v.Check(validator.Matches(currency, currencyRX), key, "must be a three letter ISO 4217 currency code")
+}
+
+// NormalizeCurrency upper cases a currency code.
+func NormalizeCurrency(currency string) string {
+	return strings.ToUpper(strings.TrimSpace(currency))
+}
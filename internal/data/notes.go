@@ -0,0 +1,365 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+const (
+	NoteTypeSOAP      = "soap"
+	NoteTypeProgress  = "progress"
+	NoteTypeDischarge = "discharge"
+
+	NoteStatusDraft  = "draft"
+	NoteStatusSigned = "signed"
+)
+
+var NoteTypes = []string{NoteTypeSOAP, NoteTypeProgress, NoteTypeDischarge}
+
+var (
+	ErrNoteSigned    = errors.New("note has been signed and can no longer be changed")
+	ErrNoteNotSigned = errors.New("addenda can only be added to signed notes")
+)
+
+// NoteContent is the text of a note. SOAP notes use the four SOAP sections; progress
+// and discharge notes use Text.
+type NoteContent struct {
+	Subjective string `json:"subjective,omitempty"`
+	Objective  string `json:"objective,omitempty"`
+	Assessment string `json:"assessment,omitempty"`
+	Plan       string `json:"plan,omitempty"`
+	Text       string `json:"text,omitempty"`
+}
+
+type Note struct {
+	ID          int64       `json:"id"`
+	PatientID   int64       `json:"patientId"`
+	EncounterID *int64      `json:"encounterId"`
+	AuthorID    int64       `json:"authorId"`
+	Type        string      `json:"type"`
+	Title       string      `json:"title"`
+	Content     NoteContent `json:"content"`
+	Status      string      `json:"status"`
+	SignedBy    *int64      `json:"signedBy"`
+	SignedAt    *time.Time  `json:"signedAt"`
+	CreatedAt   time.Time   `json:"createdAt"`
+	UpdatedAt   time.Time   `json:"updatedAt"`
+	Version     int64       `json:"version"`
+	Addenda     []*Addendum `json:"addenda,omitempty"`
+}
+
+// Addendum is text appended to a signed note. The note itself is never changed.
+type Addendum struct {
+	ID        int64     `json:"id"`
+	NoteID    int64     `json:"noteId"`
+	AuthorID  int64     `json:"authorId"`
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// NoteQuery holds the optional filters for listing a patient's notes.
+type NoteQuery struct {
+	Type        string
+	Status      string
+	EncounterID *int64
+}
+
+func (n *Note) IsSigned() bool {
+	return n.Status == NoteStatusSigned
+}
+
+func ValidateNote(v *validator.Validator, n *Note) {
+	v.Check(validator.In(n.Type, NoteTypes...), "type", "must be soap, progress or discharge")
+	v.Check(len(n.Title) <= 255, "title", "must not be more than 255 bytes long")
+
+	c := n.Content
+	if n.Type == NoteTypeSOAP {
+		v.Check(c.Text == "", "content.text", "is not used by SOAP notes, use the SOAP sections")
+		v.Check(c.Subjective != "" || c.Objective != "" || c.Assessment != "" || c.Plan != "",
+			"content", "at least one SOAP section must be provided")
+		return
+	}
+	v.Check(c.Subjective == "" && c.Objective == "" && c.Assessment == "" && c.Plan == "",
+		"content", "SOAP sections can only be used by SOAP notes")
+}
+
+// ValidateNoteForSigning checks the note is complete enough to be signed.
+func ValidateNoteForSigning(v *validator.Validator, n *Note) {
+	c := n.Content
+	if n.Type == NoteTypeSOAP {
+		v.Check(c.Assessment != "", "content.assessment", "must be provided before the note is signed")
+		v.Check(c.Plan != "", "content.plan", "must be provided before the note is signed")
+		return
+	}
+	v.Check(c.Text != "", "content.text", "must be provided before the note is signed")
+}
+
+type NoteModel struct {
+	DB *sql.DB
+}
+
+const noteColumns = `id, patient_id, encounter_id, author_id, type, title, content, status,
+	signed_by, signed_at, created_at, updated_at, version`
+
+func scanNote(row rowScanner, extra ...any) (*Note, error) {
+	var n Note
+	var content []byte
+	dest := append(extra, &n.ID, &n.PatientID, &n.EncounterID, &n.AuthorID, &n.Type, &n.Title,
+		&content, &n.Status, &n.SignedBy, &n.SignedAt, &n.CreatedAt, &n.UpdatedAt, &n.Version)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &n.Content); err != nil {
+		return nil, fmt.Errorf("error unmarshaling note content: %w", err)
+	}
+	return &n, nil
+}
+
+func (m *NoteModel) Insert(ctx context.Context, n *Note) error {
+	content, err := json.Marshal(n.Content)
+	if err != nil {
+		return fmt.Errorf("error marshaling note content: %w", err)
+	}
+
+	query := `INSERT INTO clinical_notes (patient_id, encounter_id, author_id, type, title, content)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, status, created_at, updated_at, version`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, n.PatientID, n.EncounterID, n.AuthorID, n.Type, n.Title, content).
+		Scan(&n.ID, &n.Status, &n.CreatedAt, &n.UpdatedAt, &n.Version)
+}
+
+func (m *NoteModel) GetById(ctx context.Context, patientID, id int64) (*Note, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `SELECT ` + noteColumns + `
+	FROM clinical_notes
+	WHERE id = $1 AND patient_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	n, err := scanNote(m.DB.QueryRowContext(ctx, query, id, patientID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	n.Addenda, err = m.GetAddenda(ctx, n.ID)
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// GetLatestSigned returns the patient's most recently signed note of the given type.
+func (m *NoteModel) GetLatestSigned(ctx context.Context, patientID int64, noteType string) (*Note, error) {
+	query := `SELECT ` + noteColumns + `
+	FROM clinical_notes
+	WHERE patient_id = $1 AND type = $2 AND status = 'signed'
+	ORDER BY signed_at DESC, id DESC
+	LIMIT 1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	n, err := scanNote(m.DB.QueryRowContext(ctx, query, patientID, noteType))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	n.Addenda, err = m.GetAddenda(ctx, n.ID)
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// GetForPatient lists a patient's notes. Drafts are private to their author, so only
+// the viewer's own drafts are included.
+func (m *NoteModel) GetForPatient(ctx context.Context, patientID, viewerID int64, q NoteQuery, filters Filters) ([]*Note, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), `+noteColumns+`
+	FROM clinical_notes
+	WHERE patient_id = $1
+	AND (status = 'signed' OR author_id = $2)
+	AND (type = $3 OR $3 = '')
+	AND (status = $4 OR $4 = '')
+	AND (encounter_id = $5 OR $5 IS NULL)
+	ORDER BY %s %s, id DESC
+	LIMIT $6 OFFSET $7`, filters.sortColumn(), filters.sortDirection())
+
+	args := []interface{}{patientID, viewerID, q.Type, q.Status, q.EncounterID, filters.limit(), filters.offset()}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	notes := []*Note{}
+	for rows.Next() {
+		n, err := scanNote(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		notes = append(notes, n)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return notes, metadata, nil
+}
+
+// Update saves changes to a draft. Signed notes are left untouched and reported as an
+// edit conflict.
+func (m *NoteModel) Update(ctx context.Context, n *Note) error {
+	content, err := json.Marshal(n.Content)
+	if err != nil {
+		return fmt.Errorf("error marshaling note content: %w", err)
+	}
+
+	query := `UPDATE clinical_notes
+	SET title = $1, content = $2, encounter_id = $3, updated_at = NOW(), version = version + 1
+	WHERE id = $4 AND version = $5 AND status = 'draft'
+	RETURNING updated_at, version`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, n.Title, content, n.EncounterID, n.ID, n.Version).
+		Scan(&n.UpdatedAt, &n.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Sign locks the note. From then on it can only be added to with addenda.
+func (m *NoteModel) Sign(ctx context.Context, n *Note, signerID int64) error {
+	query := `UPDATE clinical_notes
+	SET status = 'signed', signed_by = $1, signed_at = NOW(), updated_at = NOW(), version = version + 1
+	WHERE id = $2 AND version = $3 AND status = 'draft'
+	RETURNING status, signed_by, signed_at, updated_at, version`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, signerID, n.ID, n.Version).
+		Scan(&n.Status, &n.SignedBy, &n.SignedAt, &n.UpdatedAt, &n.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteDraft removes a note that has not been signed.
+func (m *NoteModel) DeleteDraft(ctx context.Context, id int64) error {
+	query := `DELETE FROM clinical_notes WHERE id = $1 AND status = 'draft'`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrEditConflict
+	}
+	return nil
+}
+
+func (m *NoteModel) AddAddendum(ctx context.Context, a *Addendum) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(m.DB, ctx, func(tx *sql.Tx) error {
+		var status string
+		err := tx.QueryRowContext(ctx, `SELECT status FROM clinical_notes WHERE id = $1 FOR SHARE`, a.NoteID).
+			Scan(&status)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+		if status != NoteStatusSigned {
+			return ErrNoteNotSigned
+		}
+
+		query := `INSERT INTO clinical_note_addenda (note_id, author_id, text)
+		VALUES ($1, $2, $3) RETURNING id, created_at`
+		return tx.QueryRowContext(ctx, query, a.NoteID, a.AuthorID, a.Text).Scan(&a.ID, &a.CreatedAt)
+	})
+}
+
+func (m *NoteModel) GetAddenda(ctx context.Context, noteID int64) ([]*Addendum, error) {
+	query := `SELECT id, note_id, author_id, text, created_at
+	FROM clinical_note_addenda
+	WHERE note_id = $1
+	ORDER BY created_at ASC, id ASC`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, noteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	addenda := []*Addendum{}
+	for rows.Next() {
+		var a Addendum
+		if err := rows.Scan(&a.ID, &a.NoteID, &a.AuthorID, &a.Text, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		addenda = append(addenda, &a)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return addenda, nil
+}
@@ -0,0 +1,320 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+// The channels a notification can be sent on. Each has a provider registered with
+// the notifier under the same name.
+const (
+	ChannelEmail = "email"
+	ChannelSMS   = "sms"
+)
+
+var NotificationChannels = []string{ChannelEmail, ChannelSMS}
+
+// NotificationPreferences are the channels a patient wants to be notified on, and
+// the hours they don't want to be disturbed. Notifications due in quiet hours are
+// held until they end.
+type NotificationPreferences struct {
+	PatientID int64    `json:"patientId"`
+	Channels  []string `json:"channels"`
+	// Phone is the E.164 number text messages are sent to.
+	Phone      string    `json:"phone"`
+	QuietStart string    `json:"quietStart"`
+	QuietEnd   string    `json:"quietEnd"`
+	TimeZone   string    `json:"timeZone"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+	Version    int64     `json:"version"`
+}
+
+// DefaultNotificationPreferences are those of patients who haven't set any.
+func DefaultNotificationPreferences(patientID int64) *NotificationPreferences {
+	return &NotificationPreferences{
+		PatientID: patientID,
+		Channels:  []string{ChannelEmail},
+		TimeZone:  "UTC",
+	}
+}
+
+func ValidateNotificationPreferences(v *validator.Validator, p *NotificationPreferences) {
+	for _, channel := range p.Channels {
+		v.Check(validator.In(channel, NotificationChannels...), "channels", "must only contain email or sms")
+	}
+	v.Check(validator.Unique(p.Channels), "channels", "must not contain duplicate values")
+	if validator.In(ChannelSMS, p.Channels...) {
+		v.Check(p.Phone != "", "phone", "must be provided to be notified by sms")
+	}
+	if p.Phone != "" {
+		v.Check(validator.Matches(p.Phone, validator.PhoneRX), "phone", "must be an E.164 number, such as +2348012345678")
+	}
+
+	v.Check((p.QuietStart == "") == (p.QuietEnd == ""), "quietEnd", "must be given with quietStart")
+	if p.QuietStart != "" {
+		_, err := time.Parse("15:04", p.QuietStart)
+		v.Check(err == nil, "quietStart", "must be a time such as 22:00")
+	}
+	if p.QuietEnd != "" {
+		_, err := time.Parse("15:04", p.QuietEnd)
+		v.Check(err == nil, "quietEnd", "must be a time such as 07:00")
+	}
+	_, err := time.LoadLocation(p.TimeZone)
+	v.Check(p.TimeZone != "" && err == nil, "timeZone", "must be an IANA time zone, such as Africa/Lagos")
+}
+
+// NextSendTime returns when a notification due at t may be sent: t itself, or the
+// end of the quiet hours t falls in.
+func (p *NotificationPreferences) NextSendTime(t time.Time) time.Time {
+	if p.QuietStart == "" || p.QuietStart == p.QuietEnd {
+		return t
+	}
+	start, err := time.Parse("15:04", p.QuietStart)
+	if err != nil {
+		return t
+	}
+	end, err := time.Parse("15:04", p.QuietEnd)
+	if err != nil {
+		return t
+	}
+	loc, err := time.LoadLocation(p.TimeZone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	local := t.In(loc)
+	clock := func(t time.Time) int { return t.Hour()*60 + t.Minute() }
+	now, from, until := clock(local), clock(start), clock(end)
+
+	quiet := now >= from && now < until
+	if from > until {
+		// The quiet hours span midnight.
+		quiet = now >= from || now < until
+	}
+	if !quiet {
+		return t
+	}
+
+	resume := time.Date(local.Year(), local.Month(), local.Day(), end.Hour(), end.Minute(), 0, 0, loc)
+	if !resume.After(local) {
+		resume = resume.AddDate(0, 0, 1)
+	}
+	return resume
+}
+
+type NotificationPreferenceModel struct {
+	DB *sql.DB
+}
+
+// Get returns the patient's preferences, or the defaults if they haven't set any.
+func (m *NotificationPreferenceModel) Get(ctx context.Context, patientID int64) (*NotificationPreferences, error) {
+	query := `SELECT patient_id, channels, phone, quiet_start, quiet_end, time_zone, updated_at, version
+	FROM notification_preferences
+	WHERE patient_id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var p NotificationPreferences
+	err := m.DB.QueryRowContext(ctx, query, patientID).Scan(&p.PatientID, pq.Array(&p.Channels), &p.Phone,
+		&p.QuietStart, &p.QuietEnd, &p.TimeZone, &p.UpdatedAt, &p.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return DefaultNotificationPreferences(patientID), nil
+		default:
+			return nil, err
+		}
+	}
+	return &p, nil
+}
+
+// Put saves the patient's preferences. Preferences that have never been saved have
+// version 0; saving over ones changed since they were read is an edit conflict.
+func (m *NotificationPreferenceModel) Put(ctx context.Context, p *NotificationPreferences) error {
+	query := `INSERT INTO notification_preferences AS np (patient_id, channels, phone, quiet_start, quiet_end, time_zone)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT (patient_id) DO UPDATE
+	SET channels = EXCLUDED.channels, phone = EXCLUDED.phone, quiet_start = EXCLUDED.quiet_start,
+		quiet_end = EXCLUDED.quiet_end, time_zone = EXCLUDED.time_zone, updated_at = NOW(), version = np.version + 1
+	WHERE np.version = $7
+	RETURNING updated_at, version`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, p.PatientID, pq.Array(p.Channels), p.Phone, p.QuietStart, p.QuietEnd,
+		p.TimeZone, p.Version).Scan(&p.UpdatedAt, &p.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+const (
+	NotificationPending = "pending"
+	NotificationSent    = "sent"
+	NotificationFailed  = "failed"
+)
+
+var NotificationStatuses = []string{NotificationPending, NotificationSent, NotificationFailed}
+
+// Notification is one message to one recipient on one channel. A failed
+// notification is retried with its job, so it may still be sent later.
+type Notification struct {
+	ID        int64  `json:"id"`
+	PatientID *int64 `json:"patientId"`
+	Channel   string `json:"channel"`
+	Recipient string `json:"recipient"`
+	Template  string `json:"template"`
+	// Args are what the message is rendered from, decoded by the template's
+	// builder when it is sent.
+	Args        json.RawMessage `json:"-"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	ProviderRef string          `json:"providerRef"`
+	LastError   string          `json:"lastError"`
+	SendAfter   time.Time       `json:"sendAfter"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	SentAt      *time.Time      `json:"sentAt"`
+}
+
+type NotificationModel struct {
+	DB *sql.DB
+}
+
+func (m *NotificationModel) Insert(ctx context.Context, n *Notification) error {
+	if n.SendAfter.IsZero() {
+		n.SendAfter = time.Now()
+	}
+	query := `INSERT INTO notifications (patient_id, channel, recipient, template, args, status, send_after)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	n.Status = NotificationPending
+	return m.DB.QueryRowContext(ctx, query, n.PatientID, n.Channel, n.Recipient, n.Template, []byte(n.Args), n.Status,
+		n.SendAfter).Scan(&n.ID, &n.CreatedAt)
+}
+
+const notificationColumns = `id, patient_id, channel, recipient, template, args, status, attempts, provider_ref,
+	last_error, send_after, created_at, sent_at`
+
+func scanNotification(row rowScanner, extra ...any) (*Notification, error) {
+	var n Notification
+	var args []byte
+	dest := append(extra, &n.ID, &n.PatientID, &n.Channel, &n.Recipient, &n.Template, &args, &n.Status, &n.Attempts,
+		&n.ProviderRef, &n.LastError, &n.SendAfter, &n.CreatedAt, &n.SentAt)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	n.Args = args
+	return &n, nil
+}
+
+// GetById returns the notification, only if it was sent to the patient when
+// patientID is set.
+func (m *NotificationModel) GetById(ctx context.Context, patientID, id int64) (*Notification, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `SELECT ` + notificationColumns + `
+	FROM notifications
+	WHERE id = $1 AND (patient_id = $2 OR $2 = 0)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	n, err := scanNotification(m.DB.QueryRowContext(ctx, query, id, patientID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+// GetAll lists notifications, only those sent to the patient when patientID is set.
+func (m *NotificationModel) GetAll(ctx context.Context, patientID int64, status, channel string,
+	filters Filters) ([]*Notification, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), `+notificationColumns+`
+	FROM notifications
+	WHERE (patient_id = $1 OR $1 = 0)
+	AND (status = $2 OR $2 = '')
+	AND (channel = $3 OR $3 = '')
+	ORDER BY %s %s, id DESC
+	LIMIT $4 OFFSET $5`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, patientID, status, channel, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	notifications := []*Notification{}
+	for rows.Next() {
+		n, err := scanNotification(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		notifications = append(notifications, n)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return notifications, metadata, nil
+}
+
+// Sent records that the provider accepted the notification, under its reference.
+func (m *NotificationModel) Sent(ctx context.Context, n *Notification, providerRef string) error {
+	query := `UPDATE notifications
+	SET status = $1, attempts = attempts + 1, provider_ref = $2, last_error = '', sent_at = NOW()
+	WHERE id = $3
+	RETURNING attempts, sent_at`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	n.Status = NotificationSent
+	n.ProviderRef = providerRef
+	n.LastError = ""
+	return m.DB.QueryRowContext(ctx, query, n.Status, n.ProviderRef, n.ID).Scan(&n.Attempts, &n.SentAt)
+}
+
+func (m *NotificationModel) Failed(ctx context.Context, n *Notification, sendErr error) error {
+	query := `UPDATE notifications
+	SET status = $1, attempts = attempts + 1, last_error = $2
+	WHERE id = $3
+	RETURNING attempts`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	n.Status = NotificationFailed
+	n.LastError = sendErr.Error()
+	return m.DB.QueryRowContext(ctx, query, n.Status, n.LastError, n.ID).Scan(&n.Attempts)
+}
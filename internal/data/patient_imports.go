@@ -0,0 +1,644 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+const (
+	ImportPending   = "pending"
+	ImportRunning   = "running"
+	ImportCompleted = "completed"
+	ImportFailed    = "failed"
+)
+
+var ImportStatuses = []string{ImportPending, ImportRunning, ImportCompleted, ImportFailed}
+
+// Patient fields a CSV column can be mapped to.
+const (
+	ImportFirstName   = "firstName"
+	ImportLastName    = "lastName"
+	ImportEmail       = "email"
+	ImportDateOfBirth = "dateOfBirth"
+	ImportPassword    = "password"
+)
+
+var ImportFields = []string{ImportFirstName, ImportLastName, ImportEmail, ImportDateOfBirth, ImportPassword}
+
+// importAliases are the column headers a field is read from when the mapping doesn't
+// name one. Headers are compared lower-cased with spaces, dashes and underscores
+// removed.
+var importAliases = map[string][]string{
+	ImportFirstName:   {"firstname", "givenname", "forename"},
+	ImportLastName:    {"lastname", "surname", "familyname"},
+	ImportEmail:       {"email", "emailaddress"},
+	ImportDateOfBirth: {"dateofbirth", "dob", "birthdate"},
+	ImportPassword:    {"password"},
+}
+
+// importBatchSize is how many rows are hashed between progress updates.
+const importBatchSize = 500
+
+var ErrImportInvalidRows = errors.New("some rows are invalid")
+
+// PatientImportRow is a patient read from one line of an import file. Patients
+// imported without a password are given a random one and sign in after resetting it.
+type PatientImportRow struct {
+	Line        int
+	FirstName   string
+	LastName    string
+	Email       string
+	DateOfBirth *Date
+	Password    string
+}
+
+// ImportRowError reports what is wrong with one line of an import file, keyed by the
+// patient field.
+type ImportRowError struct {
+	Line   int               `json:"line"`
+	Errors map[string]string `json:"errors"`
+}
+
+// ImportCheck is the outcome of validating an import file.
+type ImportCheck struct {
+	TotalRows int                 `json:"totalRows"`
+	ValidRows int                 `json:"validRows"`
+	Errors    []ImportRowError    `json:"errors"`
+	Rows      []*PatientImportRow `json:"-"`
+}
+
+// ValidatePatientImportRow applies the rules patient registration does.
+func ValidatePatientImportRow(v *validator.Validator, row *PatientImportRow) {
+	v.Check(row.FirstName != "", ImportFirstName, "must be provided")
+	v.Check(len(row.FirstName) <= 100, ImportFirstName, "must not be more than 100 bytes long")
+	v.Check(row.LastName != "", ImportLastName, "must be provided")
+	v.Check(len(row.LastName) <= 100, ImportLastName, "must not be more than 100 bytes long")
+	v.Check(row.Email != "", ImportEmail, "must be provided")
+	v.Check(len(row.Email) <= 255, ImportEmail, "must not be more than 255 bytes long")
+	v.Check(row.Email == "" || validator.Matches(row.Email, validator.EmailRX), ImportEmail,
+		"must be a valid email address")
+	if row.Password != "" {
+		v.Check(len(row.Password) >= 3, ImportPassword, "must be at least 3 bytes long")
+		v.Check(len(row.Password) <= 72, ImportPassword, "must not be more than 72 bytes long")
+	}
+	if row.DateOfBirth != nil {
+		dv := validator.New()
+		ValidateDateOfBirth(dv, row.DateOfBirth)
+		for _, message := range dv.Errors {
+			v.AddError(ImportDateOfBirth, message)
+		}
+	}
+}
+
+// ValidateImportMapping checks that a column mapping only names patient fields.
+func ValidateImportMapping(v *validator.Validator, mapping map[string]string) {
+	for field, column := range mapping {
+		v.Check(validator.In(field, ImportFields...), "mapping", fmt.Sprintf("%q is not a patient field", field))
+		v.Check(strings.TrimSpace(column) != "", "mapping", fmt.Sprintf("column for %q must not be empty", field))
+	}
+}
+
+func normalizeImportHeader(s string) string {
+	return strings.NewReplacer(" ", "", "_", "", "-", "").Replace(strings.ToLower(strings.TrimSpace(s)))
+}
+
+// importColumns works out which column each field is read from. Fields the mapping
+// leaves out are read from a column with a usual name for them, if there is one.
+func importColumns(header []string, mapping map[string]string) (map[string]int, error) {
+	positions := map[string]int{}
+	for i, name := range header {
+		key := normalizeImportHeader(name)
+		if _, ok := positions[key]; !ok {
+			positions[key] = i
+		}
+	}
+
+	columns := map[string]int{}
+	for _, field := range ImportFields {
+		if column, ok := mapping[field]; ok {
+			i, ok := positions[normalizeImportHeader(column)]
+			if !ok {
+				return nil, fmt.Errorf("the file has no %q column for %s", column, field)
+			}
+			columns[field] = i
+			continue
+		}
+		for _, alias := range importAliases[field] {
+			if i, ok := positions[alias]; ok {
+				columns[field] = i
+				break
+			}
+		}
+	}
+
+	missing := []string{}
+	for _, field := range []string{ImportFirstName, ImportLastName, ImportEmail} {
+		if _, ok := columns[field]; !ok {
+			missing = append(missing, field)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("no column is mapped to %s", strings.Join(missing, ", "))
+	}
+	return columns, nil
+}
+
+// ReadPatientCSV reads and validates an import file. The first row must be a header.
+// Lines are numbered as in the file, so the header is line 1. An error is only
+// returned when the file can't be read at all; problems with individual rows are in
+// the check's Errors.
+func ReadPatientCSV(r io.Reader, mapping map[string]string) (*ImportCheck, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("the file is empty")
+		}
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+	columns, err := importColumns(header, mapping)
+	if err != nil {
+		return nil, err
+	}
+
+	check := &ImportCheck{Errors: []ImportRowError{}}
+	seen := map[string]int{}
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				check.TotalRows++
+				check.Errors = append(check.Errors, ImportRowError{Line: parseErr.StartLine,
+					Errors: map[string]string{"row": parseErr.Err.Error()}})
+				continue
+			}
+			return nil, err
+		}
+
+		line, _ := reader.FieldPos(0)
+		get := func(field string) string {
+			i, ok := columns[field]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+		if strings.Join(record, "") == "" {
+			continue
+		}
+		check.TotalRows++
+
+		row := &PatientImportRow{
+			Line:      line,
+			FirstName: get(ImportFirstName),
+			LastName:  get(ImportLastName),
+			Email:     get(ImportEmail),
+			Password:  get(ImportPassword),
+		}
+		v := validator.New()
+		if raw := get(ImportDateOfBirth); raw != "" {
+			dob, err := ParseDate(raw)
+			if err != nil {
+				v.AddError(ImportDateOfBirth, err.Error())
+			} else {
+				row.DateOfBirth = &dob
+			}
+		}
+		ValidatePatientImportRow(v, row)
+
+		email := strings.ToLower(row.Email)
+		if first, ok := seen[email]; ok && row.Email != "" {
+			v.AddError(ImportEmail, fmt.Sprintf("is the same as line %d", first))
+		}
+		if !v.Valid() {
+			check.Errors = append(check.Errors, ImportRowError{Line: line, Errors: v.Errors})
+			continue
+		}
+		seen[email] = line
+		check.Rows = append(check.Rows, row)
+	}
+
+	check.ValidRows = len(check.Rows)
+	return check, nil
+}
+
+// PatientImport is a bulk import of patients from a CSV file. The file may hold
+// passwords in plain text, so Source is cleared once the import has finished.
+type PatientImport struct {
+	ID             int64             `json:"id"`
+	ReceptionistID int64             `json:"receptionistId"`
+	FileName       string            `json:"fileName"`
+	Source         string            `json:"-"`
+	Mapping        map[string]string `json:"mapping"`
+	SkipInvalid    bool              `json:"skipInvalid"`
+	Status         string            `json:"status"`
+	TotalRows      int               `json:"totalRows"`
+	ProcessedRows  int               `json:"processedRows"`
+	ImportedRows   int               `json:"importedRows"`
+	Report         []ImportRowError  `json:"report"`
+	Error          string            `json:"error"`
+	CreatedAt      time.Time         `json:"createdAt"`
+	StartedAt      *time.Time        `json:"startedAt"`
+	FinishedAt     *time.Time        `json:"finishedAt"`
+}
+
+type PatientImportModel struct {
+	DB *sql.DB
+}
+
+// CheckExisting moves rows whose email address is already registered from the
+// check's valid rows to its errors.
+func (m *PatientImportModel) CheckExisting(ctx context.Context, check *ImportCheck) error {
+	if len(check.Rows) == 0 {
+		return nil
+	}
+
+	emails := make([]string, len(check.Rows))
+	for i, row := range check.Rows {
+		emails[i] = strings.ToLower(row.Email)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT lower(email) FROM patients WHERE lower(email) = ANY($1)`,
+		pq.Array(emails))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	existing := map[string]bool{}
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			return err
+		}
+		existing[email] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	valid := check.Rows[:0]
+	for _, row := range check.Rows {
+		if existing[strings.ToLower(row.Email)] {
+			check.Errors = append(check.Errors, ImportRowError{Line: row.Line,
+				Errors: map[string]string{ImportEmail: "a patient with this email address already exists"}})
+			continue
+		}
+		valid = append(valid, row)
+	}
+	check.Rows = valid
+	check.ValidRows = len(valid)
+	sortImportErrors(check.Errors)
+	return nil
+}
+
+func sortImportErrors(errs []ImportRowError) {
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Line < errs[j].Line })
+}
+
+func (m *PatientImportModel) Insert(ctx context.Context, job *PatientImport) error {
+	mapping, err := json.Marshal(job.Mapping)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO patient_imports (receptionist_id, file_name, source, mapping, skip_invalid, status)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	job.Status = ImportPending
+	job.Report = []ImportRowError{}
+	return m.DB.QueryRowContext(ctx, query, job.ReceptionistID, job.FileName, job.Source, mapping,
+		job.SkipInvalid, job.Status).Scan(&job.ID, &job.CreatedAt)
+}
+
+const patientImportColumns = `id, receptionist_id, file_name, source, mapping, skip_invalid, status, total_rows,
+	processed_rows, imported_rows, report, error, created_at, started_at, finished_at`
+
+func scanPatientImport(row rowScanner, extra ...any) (*PatientImport, error) {
+	var job PatientImport
+	var mapping, report []byte
+	dest := append(extra, &job.ID, &job.ReceptionistID, &job.FileName, &job.Source, &mapping, &job.SkipInvalid,
+		&job.Status, &job.TotalRows, &job.ProcessedRows, &job.ImportedRows, &report, &job.Error, &job.CreatedAt,
+		&job.StartedAt, &job.FinishedAt)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(mapping, &job.Mapping); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(report, &job.Report); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (m *PatientImportModel) GetById(ctx context.Context, id int64) (*PatientImport, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `SELECT ` + patientImportColumns + ` FROM patient_imports WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	job, err := scanPatientImport(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return job, nil
+}
+
+// GetAll lists imports, newest first, without their row reports.
+func (m *PatientImportModel) GetAll(ctx context.Context, status string, filters Filters) ([]*PatientImport, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, receptionist_id, file_name, '', mapping, skip_invalid, status, total_rows,
+		processed_rows, imported_rows, '[]', error, created_at, started_at, finished_at
+	FROM patient_imports
+	WHERE (status = $1 OR $1 = '')
+	ORDER BY %s %s, id DESC
+	LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	jobs := []*PatientImport{}
+	for rows.Next() {
+		job, err := scanPatientImport(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		jobs = append(jobs, job)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return jobs, metadata, nil
+}
+
+// Run imports the patients in a pending job. The file is validated again, since
+// patients may have been registered since it was uploaded. Passwords are hashed in
+// batches, with progress recorded after each batch, before the transaction begins,
+// so it is only held open to copy the rows into a temporary table and add them to
+// patients. A failed import adds nobody. The outcome is recorded on the job; the error is only returned when
+// that fails, or when ctx is cancelled and the job should be run again.
+func (m *PatientImportModel) Run(ctx context.Context, job *PatientImport) error {
+	if err := m.start(ctx, job); err != nil {
+		return err
+	}
+
+	check, err := ReadPatientCSV(strings.NewReader(job.Source), job.Mapping)
+	if err != nil {
+		return m.finish(ctx, job, err)
+	}
+	if err := m.CheckExisting(ctx, check); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return m.finish(ctx, job, err)
+	}
+	job.TotalRows = check.TotalRows
+	job.Report = check.Errors
+	if len(check.Errors) > 0 && !job.SkipInvalid {
+		return m.finish(ctx, job, fmt.Errorf("%w: %d of %d rows", ErrImportInvalidRows, len(check.Errors),
+			check.TotalRows))
+	}
+	job.ProcessedRows = len(check.Errors)
+	if err := m.progress(ctx, job); err != nil {
+		return err
+	}
+
+	hashes := make([][]byte, len(check.Rows))
+	for start := 0; start < len(check.Rows); start += importBatchSize {
+		end := min(start+importBatchSize, len(check.Rows))
+		for i := start; i < end; i++ {
+			if hashes[i], err = hashImportPassword(check.Rows[i].Password); err != nil {
+				return m.finish(ctx, job, err)
+			}
+		}
+		job.ProcessedRows += end - start
+		if err := m.progress(ctx, job); err != nil {
+			return err
+		}
+	}
+
+	err = withTx(m.DB, ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `CREATE TEMP TABLE patient_import_rows (line INT, first_name TEXT,
+			last_name TEXT, email TEXT, password BYTEA, date_of_birth DATE) ON COMMIT DROP`)
+		if err != nil {
+			return err
+		}
+		if err := copyImportRows(ctx, tx, check.Rows, hashes); err != nil {
+			return err
+		}
+
+		// Each imported patient gets a created event, shaped like PatientEvent.
+		rows, err := tx.QueryContext(ctx, `
+		WITH inserted AS (
+			INSERT INTO patients (first_name, last_name, email, password, receptionist_id, date_of_birth)
+			SELECT first_name, last_name, email, password, $1, date_of_birth
+			FROM patient_import_rows
+			ORDER BY line
+			ON CONFLICT (email) DO NOTHING
+			RETURNING id, first_name, last_name, email, date_of_birth, created_at, version
+		), events AS (
+			INSERT INTO outbox_events (type, payload)
+			SELECT $2, jsonb_strip_nulls(jsonb_build_object('id', id, 'firstName', first_name,
+				'lastName', last_name, 'email', email, 'dateOfBirth', date_of_birth, 'createdAt', created_at,
+				'version', version))
+			FROM inserted
+			ORDER BY id
+		)
+		SELECT lower(email) FROM inserted`, job.ReceptionistID, EventPatientCreated)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		imported := map[string]bool{}
+		for rows.Next() {
+			var email string
+			if err := rows.Scan(&email); err != nil {
+				return err
+			}
+			imported[email] = true
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		// Anyone registered between the check and the insert is reported rather
+		// than failing the whole import.
+		for _, row := range check.Rows {
+			if !imported[strings.ToLower(row.Email)] {
+				job.Report = append(job.Report, ImportRowError{Line: row.Line,
+					Errors: map[string]string{ImportEmail: "a patient with this email address already exists"}})
+			}
+		}
+		sortImportErrors(job.Report)
+		job.ImportedRows = len(imported)
+		return nil
+	})
+	if err != nil {
+		// Cut short by shutdown; nothing was imported and the job runs again.
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		job.ImportedRows = 0
+	}
+	return m.finish(ctx, job, err)
+}
+
+// copyImportRows copies the rows, with the hash of each one's password, into the
+// temporary table they are imported from.
+func copyImportRows(ctx context.Context, tx *sql.Tx, rows []*PatientImportRow, hashes [][]byte) error {
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("patient_import_rows", "line", "first_name", "last_name",
+		"email", "password", "date_of_birth"))
+	if err != nil {
+		return err
+	}
+	for i, row := range rows {
+		var dob any
+		if row.DateOfBirth != nil {
+			dob = row.DateOfBirth.String()
+		}
+		if _, err := stmt.ExecContext(ctx, row.Line, row.FirstName, row.LastName, row.Email, hashes[i],
+			dob); err != nil {
+			stmt.Close()
+			return err
+		}
+	}
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		stmt.Close()
+		return err
+	}
+	return stmt.Close()
+}
+
+// hashImportPassword hashes a row's password, or a random one when it has none.
+func hashImportPassword(plaintext string) ([]byte, error) {
+	if plaintext == "" {
+		var err error
+		if plaintext, err = randomImportPassword(); err != nil {
+			return nil, err
+		}
+	}
+	var pw password
+	if err := pw.Set(plaintext); err != nil {
+		return nil, err
+	}
+	return pw.hash, nil
+}
+
+func randomImportPassword() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (m *PatientImportModel) start(ctx context.Context, job *PatientImport) error {
+	query := `UPDATE patient_imports
+	SET status = $1, started_at = NOW(), processed_rows = 0, imported_rows = 0, report = '[]', error = ''
+	WHERE id = $2
+	RETURNING started_at`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	job.Status = ImportRunning
+	return m.DB.QueryRowContext(ctx, query, job.Status, job.ID).Scan(&job.StartedAt)
+}
+
+func (m *PatientImportModel) progress(ctx context.Context, job *PatientImport) error {
+	query := `UPDATE patient_imports SET total_rows = $1, processed_rows = $2 WHERE id = $3`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, job.TotalRows, job.ProcessedRows, job.ID)
+	return err
+}
+
+func (m *PatientImportModel) finish(ctx context.Context, job *PatientImport, runErr error) error {
+	job.Status = ImportCompleted
+	job.Error = ""
+	if runErr != nil {
+		job.Status = ImportFailed
+		job.Error = runErr.Error()
+	}
+	report, err := json.Marshal(job.Report)
+	if err != nil {
+		return err
+	}
+
+	query := `UPDATE patient_imports
+	SET status = $1, total_rows = $2, processed_rows = $3, imported_rows = $4, report = $5, error = $6,
+		source = '', finished_at = NOW()
+	WHERE id = $7
+	RETURNING finished_at`
+
+	// The job's own context may be what ended the import, so the outcome is
+	// recorded regardless.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), QueryTimeoutDuration)
+	defer cancel()
+
+	job.Source = ""
+	return m.DB.QueryRowContext(ctx, query, job.Status, job.TotalRows, job.ProcessedRows, job.ImportedRows,
+		report, job.Error, job.ID).Scan(&job.FinishedAt)
+}
+
+// Summary returns the outcome of the job as log properties.
+func (job *PatientImport) Summary() map[string]string {
+	return map[string]string{
+		"patient_import_id": strconv.FormatInt(job.ID, 10),
+		"status":            job.Status,
+		"total_rows":        strconv.Itoa(job.TotalRows),
+		"imported_rows":     strconv.Itoa(job.ImportedRows),
+		"skipped_rows":      strconv.Itoa(len(job.Report)),
+	}
+}
@@ -8,19 +8,30 @@ import (
 	"fmt"
 	"log/slog"
 	"time"
+
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
 )
 
 type Patient struct {
-	ID        int64         `json:"id"`
-	FirstName string        `json:"firstName"`
-	LastName  string        `json:"lastName"`
-	Email     string        `json:"email"`
-	Password  password      `json:"-"`
-	CreatedAt time.Time     `json:"createdAt"`
-	UpdatedAt time.Time     `json:"-"`
-	AddedBy   *Receptionist `json:"receptionist"`
-	Data      interface{}   `json:"data"`
-	Version   int64         `json:"version"`
+	ID          int64         `json:"id"`
+	FirstName   string        `json:"firstName"`
+	LastName    string        `json:"lastName"`
+	Email       string        `json:"email"`
+	DateOfBirth *Date         `json:"dateOfBirth"`
+	Password    password      `json:"-"`
+	CreatedAt   time.Time     `json:"createdAt"`
+	UpdatedAt   time.Time     `json:"-"`
+	AddedBy     *Receptionist `json:"receptionist"`
+	Data        interface{}   `json:"data"`
+	Version     int64         `json:"version"`
+}
+
+func ValidateDateOfBirth(v *validator.Validator, dob *Date) {
+	if dob == nil {
+		return
+	}
+	v.Check(!dob.After(time.Now()), "dateOfBirth", "must not be in the future")
+	v.Check(dob.Year() >= 1900, "dateOfBirth", "must not be before 1900")
 }
 
 type PatientModel struct {
@@ -28,20 +39,27 @@ type PatientModel struct {
 }
 
 func (s *PatientModel) CreatePatient(ctx context.Context, user *Patient) error {
-	query := `INSERT INTO patients (first_name,last_name, email, password, receptionist_id) 
-	VALUES ($1, $2, $3, $4, $5) RETURNING id, first_name, last_name, created_at`
+	query := `INSERT INTO patients (first_name,last_name, email, password, receptionist_id, date_of_birth) 
+	VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, first_name, last_name, created_at, updated_at, version`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 	fmt.Println(user)
 
-	err := s.DB.QueryRowContext(ctx, query, user.FirstName,
-		user.LastName, user.Email, user.Password.hash,
-		user.AddedBy.ID).
-		Scan(&user.ID, &user.FirstName, &user.LastName, &user.CreatedAt)
+	err := withTx(s.DB, ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, user.FirstName,
+			user.LastName, user.Email, user.Password.hash,
+			user.AddedBy.ID, user.DateOfBirth).
+			Scan(&user.ID, &user.FirstName, &user.LastName, &user.CreatedAt, &user.UpdatedAt, &user.Version)
+		if err != nil {
+			return err
+		}
+		return insertEvent(ctx, tx, EventPatientCreated, newPatientEvent(user))
+	})
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`,
+			err.Error() == `pq: duplicate key value violates unique constraint "patients_email_key"`:
 			return ErrDuplicateEmail
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_username_key"`:
 			return ErrDuplicateUsername
@@ -54,7 +72,7 @@ func (s *PatientModel) CreatePatient(ctx context.Context, user *Patient) error {
 
 func (m *PatientModel) Get(ctx context.Context, firstName, lastName string, filters Filters) ([]*Patient, Metadata, error) {
 	query := fmt.Sprintf(`
-	SELECT count(*) OVER(), id, email, created_at, first_name, last_name, version
+	SELECT count(*) OVER(), id, email, created_at, first_name, last_name, date_of_birth, version
 	FROM patients
 	WHERE (to_tsvector('simple', first_name) @@ plainto_tsquery('simple', $1) OR $1 = '')
 	AND (to_tsvector('simple', last_name) @@ plainto_tsquery('simple', $2) OR $2 = '')
@@ -89,6 +107,7 @@ func (m *PatientModel) Get(ctx context.Context, firstName, lastName string, filt
 			&patient.CreatedAt,
 			&patient.FirstName,
 			&patient.LastName,
+			&patient.DateOfBirth,
 			&patient.Version,
 		)
 		if err != nil {
@@ -112,19 +131,92 @@ func (m *PatientModel) Get(ctx context.Context, firstName, lastName string, filt
 
 }
 
+// PatientSearch narrows down the patients returned by Search. Empty strings, zero
+// IDs and nil dates match every patient. Names match case-insensitively from the
+// start of the first or last name.
+type PatientSearch struct {
+	ID        int64
+	Name      string
+	Family    string
+	Given     string
+	Email     string
+	BirthFrom *time.Time
+	BirthTo   *time.Time
+}
+
+// Search finds patients for the FHIR facade, with their updated_at set.
+func (m *PatientModel) Search(ctx context.Context, q PatientSearch, filters Filters) ([]*Patient, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, first_name, last_name, email, date_of_birth, created_at, updated_at, version
+	FROM patients
+	WHERE (id = $1 OR $1 = 0)
+	AND (first_name ILIKE $2 || '%%' OR last_name ILIKE $2 || '%%' OR $2 = '')
+	AND (last_name ILIKE $3 || '%%' OR $3 = '')
+	AND (first_name ILIKE $4 || '%%' OR $4 = '')
+	AND (lower(email) = lower($5) OR $5 = '')
+	AND (date_of_birth >= $6 OR $6::date IS NULL)
+	AND (date_of_birth < $7 OR $7::date IS NULL)
+	ORDER BY %s %s, id ASC
+	LIMIT $8 OFFSET $9`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, q.ID, q.Name, q.Family, q.Given, q.Email, q.BirthFrom, q.BirthTo,
+		filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	patients := []*Patient{}
+	for rows.Next() {
+		var p Patient
+		err := rows.Scan(&totalRecords, &p.ID, &p.FirstName, &p.LastName, &p.Email, &p.DateOfBirth, &p.CreatedAt,
+			&p.UpdatedAt, &p.Version)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		patients = append(patients, &p)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return patients, metadata, nil
+}
+
 func (m *PatientModel) UpdatePatient(ctx context.Context, patient *Patient) error {
+	return m.UpdateWithIdentifiers(ctx, patient, nil)
+}
+
+// UpdateWithIdentifiers saves changes to the patient along with identifiers other
+// systems know them by, so neither is saved without the other.
+func (m *PatientModel) UpdateWithIdentifiers(ctx context.Context, patient *Patient, ids []PatientIdentifier) error {
 	if patient.ID < 1 {
 		return ErrRecordNotFound
 	}
 	query := `UPDATE patients
-			 SET first_name = $1, last_name = $2, version = version + 1 
-			 WHERE id = $3 AND VERSION = $4 
-			 RETURNING version`
+			 SET first_name = $1, last_name = $2, date_of_birth = $3, updated_at = NOW(), version = version + 1 
+			 WHERE id = $4 AND VERSION = $5 
+			 RETURNING updated_at, version`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
-	err := m.DB.QueryRowContext(ctx, query, patient.FirstName, patient.LastName, patient.ID, patient.Version).
-		Scan(&patient.Version)
+	err := withTx(m.DB, ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, patient.FirstName, patient.LastName, patient.DateOfBirth,
+			patient.ID, patient.Version).
+			Scan(&patient.UpdatedAt, &patient.Version)
+		if err != nil {
+			return err
+		}
+		if err := addPatientIdentifiers(ctx, tx, patient.ID, ids); err != nil {
+			return err
+		}
+		return insertEvent(ctx, tx, EventPatientUpdated, newPatientEvent(patient))
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -140,7 +232,9 @@ func (m *PatientModel) UpdatePatient(ctx context.Context, patient *Patient) erro
 	return nil
 }
 
-func (m *PatientModel) UpdatePatientByDoc(ctx context.Context, patient *Patient) error {
+// UpdatePatientByDoc saves the doctor's changes to the patient along with the
+// diagnoses made at the same time, so neither is saved without the other.
+func (m *PatientModel) UpdatePatientByDoc(ctx context.Context, patient *Patient, diagnoses []*Diagnosis) error {
 	if patient.ID < 1 {
 		return ErrRecordNotFound
 	}
@@ -159,13 +253,22 @@ func (m *PatientModel) UpdatePatientByDoc(ctx context.Context, patient *Patient)
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	err = m.DB.QueryRowContext(ctx, query,
-		patient.FirstName,
-		patient.LastName,
-		dataJSON, // Use the marshaled JSON
-		patient.ID,
-		patient.Version).
-		Scan(&patient.Version)
+	err = withTx(m.DB, ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query,
+			patient.FirstName,
+			patient.LastName,
+			dataJSON, // Use the marshaled JSON
+			patient.ID,
+			patient.Version).
+			Scan(&patient.Version)
+		if err != nil {
+			return err
+		}
+		if err := recordDiagnoses(ctx, tx, diagnoses); err != nil {
+			return err
+		}
+		return insertEvent(ctx, tx, EventPatientUpdated, newPatientEvent(patient))
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -184,7 +287,7 @@ func (s *PatientModel) GetDocPatientById(ctx context.Context, id int64) (*Patien
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
-	query := `SELECT id, first_name, last_name, email, created_at,
+	query := `SELECT id, first_name, last_name, email, date_of_birth, created_at,
     updated_at, version, data
     FROM patients 
     WHERE id = $1`
@@ -198,6 +301,7 @@ func (s *PatientModel) GetDocPatientById(ctx context.Context, id int64) (*Patien
 			&user.FirstName,
 			&user.LastName,
 			&user.Email,
+			&user.DateOfBirth,
 			&user.CreatedAt,
 			&user.UpdatedAt,
 			&user.Version,
@@ -228,7 +332,7 @@ func (s *PatientModel) GetPatientById(ctx context.Context, id int64) (*Patient,
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
-	query := `SELECT id, first_name, last_name, email, created_at,
+	query := `SELECT id, first_name, last_name, email, date_of_birth, created_at,
     updated_at, version
     FROM patients 
     WHERE id = $1`
@@ -241,6 +345,7 @@ func (s *PatientModel) GetPatientById(ctx context.Context, id int64) (*Patient,
 			&user.FirstName,
 			&user.LastName,
 			&user.Email,
+			&user.DateOfBirth,
 			&user.CreatedAt,
 			&user.UpdatedAt,
 			&user.Version)
@@ -264,12 +369,113 @@ func (m *PatientModel) Delete(ctx context.Context, id int64) error {
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
-	_, err := m.DB.ExecContext(ctx, query, id)
+	return withTx(m.DB, ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, query, id)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return ErrRecordNotFound
+		}
+		return insertEvent(ctx, tx, EventPatientDeleted, PatientEvent{ID: id})
+	})
+}
+
+// PatientIdentifier is an identifier another system knows a patient by.
+type PatientIdentifier struct {
+	System string `json:"system"`
+	Value  string `json:"value"`
+}
+
+// GetByIdentifier finds the patient another system knows by the identifier.
+func (m *PatientModel) GetByIdentifier(ctx context.Context, system, value string) (*Patient, error) {
+	var id int64
+	query := `SELECT patient_id FROM patient_identifiers WHERE system = $1 AND value = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, system, value).Scan(&id)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return ErrRecordNotFound
+			return nil, ErrRecordNotFound
 		default:
+			return nil, err
+		}
+	}
+	return m.GetPatientById(ctx, id)
+}
+
+// CreateWithIdentifiers creates a patient along with the identifiers other systems
+// know them by.
+func (m *PatientModel) CreateWithIdentifiers(ctx context.Context, user *Patient, ids []PatientIdentifier) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(m.DB, ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, `INSERT INTO patients (first_name, last_name, email, password,
+			receptionist_id, date_of_birth)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at, updated_at, version`,
+			user.FirstName, user.LastName, user.Email, user.Password.hash, user.AddedBy.ID, user.DateOfBirth).
+			Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt, &user.Version)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "patients_email_key"`:
+				return ErrDuplicateEmail
+			default:
+				return err
+			}
+		}
+		if err := addPatientIdentifiers(ctx, tx, user.ID, ids); err != nil {
+			return err
+		}
+		return insertEvent(ctx, tx, EventPatientCreated, newPatientEvent(user))
+	})
+}
+
+// CreateWithConsents creates a patient along with the consents recorded when they
+// were registered.
+func (m *PatientModel) CreateWithConsents(ctx context.Context, user *Patient, consents []*Consent) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(m.DB, ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, `INSERT INTO patients (first_name, last_name, email, password,
+			receptionist_id, date_of_birth)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at, updated_at, version`,
+			user.FirstName, user.LastName, user.Email, user.Password.hash, user.AddedBy.ID, user.DateOfBirth).
+			Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt, &user.Version)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "patients_email_key"`:
+				return ErrDuplicateEmail
+			default:
+				return err
+			}
+		}
+		for _, c := range consents {
+			c.PatientID = user.ID
+			if err := insertConsent(ctx, tx, c); err != nil {
+				return err
+			}
+		}
+		return insertEvent(ctx, tx, EventPatientCreated, newPatientEvent(user))
+	})
+}
+
+// addPatientIdentifiers records identifiers for the patient. Identifiers already
+// known are left with the patient they belong to.
+func addPatientIdentifiers(ctx context.Context, tx *sql.Tx, patientID int64, ids []PatientIdentifier) error {
+	for _, id := range ids {
+		_, err := tx.ExecContext(ctx, `INSERT INTO patient_identifiers (patient_id, system, value)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (system, value) DO NOTHING`, patientID, id.System, id.Value)
+		if err != nil {
 			return err
 		}
 	}
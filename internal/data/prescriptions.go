@@ -0,0 +1,355 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+const (
+	AllergyStatusActive   = "active"
+	AllergyStatusInactive = "inactive"
+
+	PrescriptionStatusActive       = "active"
+	PrescriptionStatusDiscontinued = "discontinued"
+)
+
+var ErrUnacknowledgedWarnings = errors.New("the prescription raised warnings that must be overridden")
+
+var (
+	AllergySeverities  = []string{"mild", "moderate", "severe"}
+	PrescriptionRoutes = []string{"oral", "sublingual", "topical", "inhaled", "intravenous",
+		"intramuscular", "subcutaneous", "rectal", "ophthalmic", "otic", "nasal"}
+)
+
+type Allergy struct {
+	ID        int64     `json:"id"`
+	PatientID int64     `json:"patientId"`
+	Substance string    `json:"substance"`
+	Reaction  string    `json:"reaction"`
+	Severity  string    `json:"severity"`
+	Status    string    `json:"status"`
+	DoctorID  int64     `json:"doctorId"`
+	CreatedAt time.Time `json:"createdAt"`
+	Version   int64     `json:"version"`
+}
+
+func ValidateAllergy(v *validator.Validator, a *Allergy) {
+	v.Check(a.Substance != "", "substance", "must be provided")
+	v.Check(validator.In(a.Severity, AllergySeverities...), "severity", "must be mild, moderate or severe")
+	v.Check(validator.In(a.Status, AllergyStatusActive, AllergyStatusInactive), "status", "must be active or inactive")
+}
+
+type AllergyModel struct {
+	DB *sql.DB
+}
+
+func (m *AllergyModel) Insert(ctx context.Context, a *Allergy) error {
+	query := `INSERT INTO allergies (patient_id, substance, reaction, severity, doctor_id)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, status, created_at, version`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, a.PatientID, a.Substance, a.Reaction, a.Severity, a.DoctorID).
+		Scan(&a.ID, &a.Status, &a.CreatedAt, &a.Version)
+}
+
+func (m *AllergyModel) GetById(ctx context.Context, patientID, id int64) (*Allergy, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `SELECT id, patient_id, substance, reaction, severity, status, doctor_id, created_at, version
+	FROM allergies
+	WHERE id = $1 AND patient_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var a Allergy
+	err := m.DB.QueryRowContext(ctx, query, id, patientID).Scan(&a.ID, &a.PatientID, &a.Substance,
+		&a.Reaction, &a.Severity, &a.Status, &a.DoctorID, &a.CreatedAt, &a.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &a, nil
+}
+
+// GetForPatient returns the patient's allergies. When activeOnly is set, allergies
+// that have been marked inactive (e.g. entered in error or outgrown) are left out.
+func (m *AllergyModel) GetForPatient(ctx context.Context, patientID int64, activeOnly bool) ([]*Allergy, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return getAllergies(ctx, m.DB, patientID, activeOnly)
+}
+
+func getAllergies(ctx context.Context, q queryer, patientID int64, activeOnly bool) ([]*Allergy, error) {
+	query := `SELECT id, patient_id, substance, reaction, severity, status, doctor_id, created_at, version
+	FROM allergies
+	WHERE patient_id = $1 AND (status = 'active' OR NOT $2)
+	ORDER BY status ASC, created_at DESC`
+
+	rows, err := q.QueryContext(ctx, query, patientID, activeOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	allergies := []*Allergy{}
+	for rows.Next() {
+		var a Allergy
+		err := rows.Scan(&a.ID, &a.PatientID, &a.Substance, &a.Reaction, &a.Severity, &a.Status,
+			&a.DoctorID, &a.CreatedAt, &a.Version)
+		if err != nil {
+			return nil, err
+		}
+		allergies = append(allergies, &a)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return allergies, nil
+}
+
+func (m *AllergyModel) Update(ctx context.Context, a *Allergy) error {
+	query := `UPDATE allergies
+	SET reaction = $1, severity = $2, status = $3, version = version + 1
+	WHERE id = $4 AND version = $5
+	RETURNING version`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, a.Reaction, a.Severity, a.Status, a.ID, a.Version).Scan(&a.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// PrescriptionWarning is a warning raised by the interaction and allergy check when
+// the prescription was written. It is stored with the prescription together with the
+// prescriber's reason for overriding it.
+type PrescriptionWarning struct {
+	Type string `json:"type"`
+	// Severity is minor, moderate, major or contraindicated.
+	Severity string `json:"severity"`
+	With     string `json:"with"`
+	Message  string `json:"message"`
+}
+
+type Prescription struct {
+	ID                 int64                 `json:"id"`
+	PatientID          int64                 `json:"patientId"`
+	DoctorID           int64                 `json:"doctorId"`
+	EncounterID        *int64                `json:"encounterId"`
+	Drug               string                `json:"drug"`
+	Dose               string                `json:"dose"`
+	Route              string                `json:"route"`
+	Frequency          string                `json:"frequency"`
+	DurationDays       int                   `json:"durationDays"`
+	Refills            int                   `json:"refills"`
+	Instructions       string                `json:"instructions"`
+	Status             string                `json:"status"`
+	StartDate          Date                  `json:"startDate"`
+	EndDate            Date                  `json:"endDate"`
+	Warnings           []PrescriptionWarning `json:"warnings"`
+	OverrideReason     string                `json:"overrideReason,omitempty"`
+	DiscontinuedReason string                `json:"discontinuedReason,omitempty"`
+	CreatedAt          time.Time             `json:"createdAt"`
+	Version            int64                 `json:"version"`
+}
+
+func ValidatePrescription(v *validator.Validator, p *Prescription) {
+	v.Check(p.Drug != "", "drug", "must be provided")
+	v.Check(p.Dose != "", "dose", "must be provided")
+	v.Check(validator.In(p.Route, PrescriptionRoutes...), "route", "is not a recognised route of administration")
+	v.Check(p.Frequency != "", "frequency", "must be provided")
+	v.Check(validator.Between(p.DurationDays, 1, 365), "durationDays", "must be between 1 and 365")
+	v.Check(validator.Between(p.Refills, 0, 12), "refills", "must be between 0 and 12")
+}
+
+type PrescriptionModel struct {
+	DB *sql.DB
+}
+
+const prescriptionColumns = `id, patient_id, doctor_id, encounter_id, drug, dose, route, frequency,
+	duration_days, refills, instructions, status, start_date,
+	start_date + duration_days - 1, warnings, override_reason, discontinued_reason,
+	created_at, version`
+
+func scanPrescription(row rowScanner) (*Prescription, error) {
+	var p Prescription
+	var warnings []byte
+	err := row.Scan(&p.ID, &p.PatientID, &p.DoctorID, &p.EncounterID, &p.Drug, &p.Dose, &p.Route,
+		&p.Frequency, &p.DurationDays, &p.Refills, &p.Instructions, &p.Status, &p.StartDate,
+		&p.EndDate, &warnings, &p.OverrideReason, &p.DiscontinuedReason, &p.CreatedAt, &p.Version)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(warnings, &p.Warnings); err != nil {
+		return nil, fmt.Errorf("error unmarshaling prescription warnings: %w", err)
+	}
+	return &p, nil
+}
+
+// PrescriptionCheck runs the prescribing checks for a drug against the patient's
+// current prescriptions and active allergies.
+type PrescriptionCheck func(drug string, current []*Prescription, allergies []*Allergy) []PrescriptionWarning
+
+// Insert runs check against the patient's record and adds the prescription, setting
+// the warnings found on p. It fails with ErrUnacknowledgedWarnings, leaving the
+// warnings on p, when there are warnings and p has no override reason. The patient is
+// locked while it runs, so a prescription or allergy recorded at the same time can't
+// slip past the check.
+func (m *PrescriptionModel) Insert(ctx context.Context, p *Prescription, check PrescriptionCheck) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(m.DB, ctx, func(tx *sql.Tx) error {
+		// Allergies and prescriptions reference the patient, so recording either
+		// waits for this lock, and any recorded before it is taken are seen below.
+		_, err := tx.ExecContext(ctx, `SELECT id FROM patients WHERE id = $1 FOR UPDATE`, p.PatientID)
+		if err != nil {
+			return err
+		}
+		current, err := getPrescriptions(ctx, tx, p.PatientID, true)
+		if err != nil {
+			return err
+		}
+		allergies, err := getAllergies(ctx, tx, p.PatientID, true)
+		if err != nil {
+			return err
+		}
+
+		p.Warnings = check(p.Drug, current, allergies)
+		switch {
+		case len(p.Warnings) > 0 && p.OverrideReason == "":
+			return ErrUnacknowledgedWarnings
+		case len(p.Warnings) == 0:
+			// An override reason only means something when there was something to
+			// override.
+			p.OverrideReason = ""
+		}
+		return insertPrescription(ctx, tx, p)
+	})
+}
+
+func insertPrescription(ctx context.Context, q queryer, p *Prescription) error {
+	warnings, err := json.Marshal(p.Warnings)
+	if err != nil {
+		return fmt.Errorf("error marshaling prescription warnings: %w", err)
+	}
+
+	query := `INSERT INTO prescriptions (patient_id, doctor_id, encounter_id, drug, dose, route,
+	frequency, duration_days, refills, instructions, start_date, warnings, override_reason)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+	RETURNING ` + prescriptionColumns
+
+	created, err := scanPrescription(q.QueryRowContext(ctx, query, p.PatientID, p.DoctorID,
+		p.EncounterID, p.Drug, p.Dose, p.Route, p.Frequency, p.DurationDays, p.Refills,
+		p.Instructions, p.StartDate, warnings, p.OverrideReason))
+	if err != nil {
+		return err
+	}
+	*p = *created
+	return nil
+}
+
+func (m *PrescriptionModel) GetById(ctx context.Context, patientID, id int64) (*Prescription, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `SELECT ` + prescriptionColumns + `
+	FROM prescriptions
+	WHERE id = $1 AND patient_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	p, err := scanPrescription(m.DB.QueryRowContext(ctx, query, id, patientID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// GetForPatient returns the patient's prescriptions, newest first. With currentOnly
+// set it returns just the medications the patient should be taking today.
+func (m *PrescriptionModel) GetForPatient(ctx context.Context, patientID int64, currentOnly bool) ([]*Prescription, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return getPrescriptions(ctx, m.DB, patientID, currentOnly)
+}
+
+func getPrescriptions(ctx context.Context, q queryer, patientID int64, currentOnly bool) ([]*Prescription, error) {
+	query := `SELECT ` + prescriptionColumns + `
+	FROM prescriptions
+	WHERE patient_id = $1
+	AND (NOT $2 OR (status = 'active' AND start_date + duration_days - 1 >= CURRENT_DATE))
+	ORDER BY start_date DESC, id DESC`
+
+	rows, err := q.QueryContext(ctx, query, patientID, currentOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prescriptions := []*Prescription{}
+	for rows.Next() {
+		p, err := scanPrescription(rows)
+		if err != nil {
+			return nil, err
+		}
+		prescriptions = append(prescriptions, p)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return prescriptions, nil
+}
+
+func (m *PrescriptionModel) Discontinue(ctx context.Context, p *Prescription, reason string) error {
+	query := `UPDATE prescriptions
+	SET status = 'discontinued', discontinued_reason = $1, version = version + 1
+	WHERE id = $2 AND version = $3 AND status = 'active'
+	RETURNING status, discontinued_reason, version`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, reason, p.ID, p.Version).
+		Scan(&p.Status, &p.DiscontinuedReason, &p.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,319 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+// QueueEventsChannel is the Postgres NOTIFY channel that queue changes are published
+// on. Every API instance LISTENs on it so that SSE subscribers see changes made
+// through any instance.
+const QueueEventsChannel = "queue_events"
+
+const (
+	QueueStatusWaiting   = "waiting"
+	QueueStatusCalled    = "called"
+	QueueStatusCompleted = "completed"
+	QueueStatusLeft      = "left"
+)
+
+const (
+	QueueEventCheckedIn = "queue.checked_in"
+	QueueEventCalled    = "queue.called"
+	QueueEventUpdated   = "queue.updated"
+)
+
+var (
+	ErrQueueEmpty     = errors.New("there are no patients waiting in the queue")
+	ErrAlreadyInQueue = errors.New("patient is already in the queue")
+)
+
+// TriagePriority is a Manchester Triage System category.
+type TriagePriority struct {
+	Level         int    `json:"level"`
+	Name          string `json:"name"`
+	Colour        string `json:"colour"`
+	TargetMinutes int    `json:"targetMinutes"`
+}
+
+var TriagePriorities = []TriagePriority{
+	{Level: 1, Name: "Immediate", Colour: "red", TargetMinutes: 0},
+	{Level: 2, Name: "Very urgent", Colour: "orange", TargetMinutes: 10},
+	{Level: 3, Name: "Urgent", Colour: "yellow", TargetMinutes: 60},
+	{Level: 4, Name: "Standard", Colour: "green", TargetMinutes: 120},
+	{Level: 5, Name: "Non-urgent", Colour: "blue", TargetMinutes: 240},
+}
+
+// queueTransitions lists the statuses an entry may move to from a given status.
+var queueTransitions = map[string][]string{
+	QueueStatusWaiting: {QueueStatusCalled, QueueStatusLeft},
+	QueueStatusCalled:  {QueueStatusWaiting, QueueStatusCompleted, QueueStatusLeft},
+}
+
+type QueueEntry struct {
+	ID               int64          `json:"id"`
+	PatientID        int64          `json:"patientId"`
+	PatientFirstName string         `json:"patientFirstName"`
+	PatientLastName  string         `json:"patientLastName"`
+	ReceptionistID   int64          `json:"receptionistId"`
+	DoctorID         *int64         `json:"doctorId"`
+	Priority         TriagePriority `json:"priority"`
+	Complaint        string         `json:"complaint"`
+	Status           string         `json:"status"`
+	CheckedInAt      time.Time      `json:"checkedInAt"`
+	CalledAt         *time.Time     `json:"calledAt"`
+	CompletedAt      *time.Time     `json:"completedAt"`
+	Version          int64          `json:"version"`
+}
+
+// QueueEvent is the payload sent over QueueEventsChannel.
+type QueueEvent struct {
+	Type  string      `json:"type"`
+	Entry *QueueEntry `json:"entry"`
+}
+
+type QueueModel struct {
+	DB *sql.DB
+}
+
+func triagePriority(level int) TriagePriority {
+	for _, p := range TriagePriorities {
+		if p.Level == level {
+			return p
+		}
+	}
+	return TriagePriority{Level: level}
+}
+
+func ValidateQueueTransition(v *validator.Validator, from, to string) {
+	if from == to {
+		return
+	}
+	v.Check(validator.In(to, queueTransitions[from]...), "status", "cannot move a "+from+" entry to "+to)
+}
+
+const queueEntryColumns = `q.id, q.patient_id, p.first_name, p.last_name, q.receptionist_id,
+	q.doctor_id, q.priority, q.complaint, q.status, q.checked_in_at, q.called_at,
+	q.completed_at, q.version`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func scanQueueEntry(row rowScanner) (*QueueEntry, error) {
+	var entry QueueEntry
+	var priority int
+	err := row.Scan(
+		&entry.ID,
+		&entry.PatientID,
+		&entry.PatientFirstName,
+		&entry.PatientLastName,
+		&entry.ReceptionistID,
+		&entry.DoctorID,
+		&priority,
+		&entry.Complaint,
+		&entry.Status,
+		&entry.CheckedInAt,
+		&entry.CalledAt,
+		&entry.CompletedAt,
+		&entry.Version,
+	)
+	if err != nil {
+		return nil, err
+	}
+	entry.Priority = triagePriority(priority)
+	return &entry, nil
+}
+
+func (m *QueueModel) CheckIn(ctx context.Context, entry *QueueEntry) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(m.DB, ctx, func(tx *sql.Tx) error {
+		var id int64
+		err := tx.QueryRowContext(ctx, `
+		INSERT INTO queue_entries (patient_id, receptionist_id, doctor_id, priority, complaint)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+			entry.PatientID, entry.ReceptionistID, entry.DoctorID, entry.Priority.Level, entry.Complaint).
+			Scan(&id)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "queue_entries_active_patient_idx"`:
+				return ErrAlreadyInQueue
+			default:
+				return err
+			}
+		}
+
+		created, err := m.get(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		*entry = *created
+		return notifyQueue(ctx, tx, QueueEventCheckedIn, entry)
+	})
+}
+
+func (m *QueueModel) GetById(ctx context.Context, id int64) (*QueueEntry, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return m.get(ctx, m.DB, id)
+}
+
+func (m *QueueModel) get(ctx context.Context, q queryer, id int64) (*QueueEntry, error) {
+	query := `SELECT ` + queueEntryColumns + `
+	FROM queue_entries q
+	JOIN patients p ON p.id = q.patient_id
+	WHERE q.id = $1`
+
+	entry, err := scanQueueEntry(q.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return entry, nil
+}
+
+// GetActive returns every entry that is still waiting or has been called, most urgent
+// first and then in order of arrival.
+func (m *QueueModel) GetActive(ctx context.Context) ([]*QueueEntry, error) {
+	query := `SELECT ` + queueEntryColumns + `
+	FROM queue_entries q
+	JOIN patients p ON p.id = q.patient_id
+	WHERE q.status IN ('waiting', 'called')
+	ORDER BY q.priority ASC, q.checked_in_at ASC`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*QueueEntry{}
+	for rows.Next() {
+		entry, err := scanQueueEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// CallNext assigns the most urgent waiting patient to the doctor. SKIP LOCKED lets
+// several doctors pull from the queue at the same time without getting the same
+// patient.
+func (m *QueueModel) CallNext(ctx context.Context, doctorID int64) (*QueueEntry, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var entry *QueueEntry
+	err := withTx(m.DB, ctx, func(tx *sql.Tx) error {
+		var id int64
+		err := tx.QueryRowContext(ctx, `
+		SELECT id FROM queue_entries
+		WHERE status = 'waiting'
+		ORDER BY priority ASC, checked_in_at ASC
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED`).Scan(&id)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrQueueEmpty
+			default:
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, `
+		UPDATE queue_entries
+		SET status = 'called', doctor_id = $1, called_at = NOW(), version = version + 1
+		WHERE id = $2`, doctorID, id)
+		if err != nil {
+			return err
+		}
+
+		entry, err = m.get(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		return notifyQueue(ctx, tx, QueueEventCalled, entry)
+	})
+	return entry, err
+}
+
+// Update saves the priority and status of an entry, filling in the called/completed
+// timestamps as the status moves forward.
+func (m *QueueModel) Update(ctx context.Context, entry *QueueEntry, doctorID *int64) error {
+	if entry.ID < 1 {
+		return ErrRecordNotFound
+	}
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(m.DB, ctx, func(tx *sql.Tx) error {
+		query := `UPDATE queue_entries
+		SET priority = $1,
+			status = $2,
+			doctor_id = COALESCE($3, doctor_id),
+			called_at = CASE WHEN $2 = 'called' AND called_at IS NULL THEN NOW() ELSE called_at END,
+			completed_at = CASE WHEN $2 IN ('completed', 'left') THEN NOW() ELSE completed_at END,
+			version = version + 1
+		WHERE id = $4 AND version = $5
+		RETURNING version`
+
+		err := tx.QueryRowContext(ctx, query, entry.Priority.Level, entry.Status, doctorID,
+			entry.ID, entry.Version).Scan(&entry.Version)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflict
+			default:
+				return err
+			}
+		}
+
+		updated, err := m.get(ctx, tx, entry.ID)
+		if err != nil {
+			return err
+		}
+		*entry = *updated
+		return notifyQueue(ctx, tx, QueueEventUpdated, entry)
+	})
+}
+
+// notifyQueue publishes the change on QueueEventsChannel. Postgres only delivers the
+// notification once the surrounding transaction commits.
+func notifyQueue(ctx context.Context, tx *sql.Tx, eventType string, entry *QueueEntry) error {
+	payload, err := json.Marshal(QueueEvent{Type: eventType, Entry: entry})
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `SELECT pg_notify($1, $2)`, QueueEventsChannel, string(payload))
+	return err
+}
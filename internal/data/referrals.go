@@ -0,0 +1,252 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+const (
+	ReferralSent      = "sent"
+	ReferralAccepted  = "accepted"
+	ReferralDeclined  = "declined"
+	ReferralCompleted = "completed"
+)
+
+var (
+	ReferralStatuses  = []string{ReferralSent, ReferralAccepted, ReferralDeclined, ReferralCompleted}
+	ReferralUrgencies = []string{"routine", "urgent", "emergency"}
+)
+
+var ErrReferralAttachment = errors.New("attachment does not belong to the patient")
+
+// referralTransitions lists the statuses a referral may move to from each status.
+var referralTransitions = map[string][]string{
+	ReferralSent:     {ReferralAccepted, ReferralDeclined},
+	ReferralAccepted: {ReferralCompleted},
+}
+
+// Referral hands a patient over to another doctor, or to an external facility when
+// RecipientID is nil.
+type Referral struct {
+	ID               int64      `json:"id"`
+	PatientID        int64      `json:"patientId"`
+	SenderID         int64      `json:"senderId"`
+	RecipientID      *int64     `json:"recipientId"`
+	ExternalFacility string     `json:"externalFacility,omitempty"`
+	ExternalEmail    string     `json:"externalEmail,omitempty"`
+	Urgency          string     `json:"urgency"`
+	Reason           string     `json:"reason"`
+	Status           string     `json:"status"`
+	ResponseNote     string     `json:"responseNote"`
+	SentAt           time.Time  `json:"sentAt"`
+	RespondedAt      *time.Time `json:"respondedAt"`
+	CompletedAt      *time.Time `json:"completedAt"`
+	AttachmentIDs    []int64    `json:"attachmentIds"`
+	Version          int64      `json:"version"`
+}
+
+func (r *Referral) IsExternal() bool {
+	return r.RecipientID == nil
+}
+
+func ValidateReferral(v *validator.Validator, r *Referral) {
+	v.Check(r.RecipientID != nil || r.ExternalFacility != "", "recipientId",
+		"a receiving doctor or an external facility must be given")
+	v.Check(r.RecipientID == nil || r.ExternalFacility == "", "externalFacility",
+		"must not be given together with a receiving doctor")
+	v.Check(r.RecipientID == nil || *r.RecipientID != r.SenderID, "recipientId", "must not be yourself")
+	v.Check(len(r.ExternalFacility) <= 255, "externalFacility", "must not be more than 255 bytes long")
+	v.Check(r.ExternalEmail == "" || validator.Matches(r.ExternalEmail, validator.EmailRX), "externalEmail",
+		"must be a valid email address")
+	v.Check(validator.In(r.Urgency, ReferralUrgencies...), "urgency", "must be routine, urgent or emergency")
+	v.Check(r.Reason != "", "reason", "must be provided")
+	v.Check(validator.Unique(r.AttachmentIDs), "attachmentIds", "must not contain duplicates")
+}
+
+func ValidateReferralTransition(v *validator.Validator, from, to string) {
+	v.Check(validator.In(to, referralTransitions[from]...), "status", "cannot move a "+from+" referral to "+to)
+}
+
+type ReferralModel struct {
+	DB *sql.DB
+}
+
+const referralColumns = `r.id, r.patient_id, r.sender_id, r.recipient_id, r.external_facility, r.external_email,
+	r.urgency, r.reason, r.status, r.response_note, r.sent_at, r.responded_at, r.completed_at,
+	ARRAY(SELECT a.attachment_id FROM referral_attachments a WHERE a.referral_id = r.id ORDER BY a.attachment_id),
+	r.version`
+
+func scanReferral(row rowScanner, extra ...any) (*Referral, error) {
+	var r Referral
+	dest := append(extra, &r.ID, &r.PatientID, &r.SenderID, &r.RecipientID, &r.ExternalFacility,
+		&r.ExternalEmail, &r.Urgency, &r.Reason, &r.Status, &r.ResponseNote, &r.SentAt, &r.RespondedAt,
+		&r.CompletedAt, pq.Array(&r.AttachmentIDs), &r.Version)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	if r.AttachmentIDs == nil {
+		r.AttachmentIDs = []int64{}
+	}
+	return &r, nil
+}
+
+// Insert sends a referral. Attachments must belong to the referred patient.
+func (m *ReferralModel) Insert(ctx context.Context, r *Referral) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(m.DB, ctx, func(tx *sql.Tx) error {
+		query := `INSERT INTO referrals (patient_id, sender_id, recipient_id, external_facility, external_email,
+		urgency, reason)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, status, sent_at, version`
+
+		err := tx.QueryRowContext(ctx, query, r.PatientID, r.SenderID, r.RecipientID, r.ExternalFacility,
+			r.ExternalEmail, r.Urgency, r.Reason).Scan(&r.ID, &r.Status, &r.SentAt, &r.Version)
+		if err != nil {
+			return err
+		}
+
+		if len(r.AttachmentIDs) == 0 {
+			r.AttachmentIDs = []int64{}
+			return nil
+		}
+		result, err := tx.ExecContext(ctx, `INSERT INTO referral_attachments (referral_id, attachment_id)
+		SELECT $1, id FROM patient_attachments WHERE patient_id = $2 AND id = ANY($3)`,
+			r.ID, r.PatientID, pq.Array(r.AttachmentIDs))
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if int(n) != len(r.AttachmentIDs) {
+			return ErrReferralAttachment
+		}
+		return nil
+	})
+}
+
+// GetById returns a referral. A patientID of 0 matches a referral for any patient.
+func (m *ReferralModel) GetById(ctx context.Context, patientID, id int64) (*Referral, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `SELECT ` + referralColumns + `
+	FROM referrals r
+	WHERE r.id = $1 AND (r.patient_id = $2 OR $2 = 0)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	r, err := scanReferral(m.DB.QueryRowContext(ctx, query, id, patientID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// GetForPatient returns a patient's referrals, optionally only those with the given
+// status.
+func (m *ReferralModel) GetForPatient(ctx context.Context, patientID int64, status string, filters Filters) ([]*Referral, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), `+referralColumns+`
+	FROM referrals r
+	WHERE r.patient_id = $1 AND (r.status = $2 OR $2 = '')
+	ORDER BY r.%s %s, r.id DESC
+	LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	return m.list(ctx, query, filters, patientID, status, filters.limit(), filters.offset())
+}
+
+// Inbox returns the referrals sent to a doctor, optionally only those with the given
+// status. Emergency and urgent referrals come first.
+func (m *ReferralModel) Inbox(ctx context.Context, doctorID int64, status string, filters Filters) ([]*Referral, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), `+referralColumns+`
+	FROM referrals r
+	WHERE r.recipient_id = $1 AND (r.status = $2 OR $2 = '')
+	ORDER BY CASE r.urgency WHEN 'emergency' THEN 0 WHEN 'urgent' THEN 1 ELSE 2 END, r.%s %s, r.id DESC
+	LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	return m.list(ctx, query, filters, doctorID, status, filters.limit(), filters.offset())
+}
+
+// Sent returns the referrals a doctor has sent.
+func (m *ReferralModel) Sent(ctx context.Context, doctorID int64, status string, filters Filters) ([]*Referral, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), `+referralColumns+`
+	FROM referrals r
+	WHERE r.sender_id = $1 AND (r.status = $2 OR $2 = '')
+	ORDER BY r.%s %s, r.id DESC
+	LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	return m.list(ctx, query, filters, doctorID, status, filters.limit(), filters.offset())
+}
+
+func (m *ReferralModel) list(ctx context.Context, query string, filters Filters, args ...any) ([]*Referral, Metadata, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	referrals := []*Referral{}
+	for rows.Next() {
+		r, err := scanReferral(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		referrals = append(referrals, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return referrals, metadata, nil
+}
+
+// UpdateStatus moves a referral on in its workflow, recording the note that goes with
+// the answer.
+func (m *ReferralModel) UpdateStatus(ctx context.Context, r *Referral, status, note string) error {
+	query := `UPDATE referrals
+	SET status = $1,
+		response_note = CASE WHEN $2 = '' THEN response_note ELSE $2 END,
+		responded_at = CASE WHEN $1 IN ('accepted', 'declined') THEN NOW() ELSE responded_at END,
+		completed_at = CASE WHEN $1 = 'completed' THEN NOW() ELSE completed_at END,
+		version = version + 1
+	WHERE id = $3 AND version = $4
+	RETURNING status, response_note, responded_at, completed_at, version`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, status, note, r.ID, r.Version).Scan(&r.Status, &r.ResponseNote,
+		&r.RespondedAt, &r.CompletedAt, &r.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,549 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/muyiwadosunmu/hospital-management/internal/deid"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+var ErrDuplicateStudy = errors.New("a study with this name already exists")
+
+// How each column of a research table is de-identified.
+const (
+	RuleKeep      = "keep"
+	RulePseudonym = "pseudonym"
+	RuleYear      = "year"
+	RuleAge       = "age"
+	RuleBirthYear = "birth_year"
+	RuleData      = "data"
+)
+
+var researchRules = map[string]string{
+	RuleKeep:      "Exported as recorded.",
+	RulePseudonym: "Replaced with a pseudonym that is the same across the study's exports.",
+	RuleYear:      "Generalized to the year.",
+	RuleAge:       "Age in years on the day of export; 90 and over are grouped as 90+.",
+	RuleBirthYear: "Year of birth; left empty for patients aged 90 and over.",
+	RuleData:      "JSON object of the study's data fields, with identifying fields and values removed and dates generalized to the year.",
+}
+
+// ResearchColumn is one column of a research table. Kind is the kind of record a
+// pseudonymized column refers to, so that, for example, every patient_id in a study's
+// export has the same pseudonym for the same patient.
+type ResearchColumn struct {
+	Name        string
+	Type        string
+	Description string
+	Rule        string
+	Kind        string
+}
+
+// ResearchTable is the de-identified form of an export type. Its query selects one
+// text value per column, for patients with active research consent only, within the
+// since ($1) and until ($2) bounds of the type's date column. Free-text columns such
+// as notes and reasons are never selected, since they can hold anything.
+type ResearchTable struct {
+	Type        string
+	Description string
+	Query       string
+	Columns     []ResearchColumn
+}
+
+const researchConsented = `IN (SELECT patient_id FROM patient_consents
+		WHERE type = 'research' AND superseded_at IS NULL AND withdrawn_at IS NULL)`
+
+var (
+	patientColumn   = ResearchColumn{"patient_id", "string", "Pseudonym of the patient.", RulePseudonym, "patient"}
+	encounterColumn = ResearchColumn{"encounter_id", "string", "Pseudonym of the encounter, if any.", RulePseudonym, "encounter"}
+)
+
+var researchTables = map[string]*ResearchTable{
+	"Patient": {
+		Type:        "Patient",
+		Description: "Patients with active research consent.",
+		Query: `SELECT p.id::text, p.date_of_birth::text, p.date_of_birth::text, p.created_at::text, p.data::text
+			FROM patients p
+			WHERE p.id ` + researchConsented + `
+			AND ($1::timestamptz IS NULL OR p.updated_at >= $1) AND ($2::timestamptz IS NULL OR p.updated_at < $2)
+			ORDER BY p.id`,
+		Columns: []ResearchColumn{
+			patientColumn,
+			{"age", "string", "Age in years.", RuleAge, ""},
+			{"birth_year", "integer", "Year of birth.", RuleBirthYear, ""},
+			{"registered_year", "integer", "Year the patient was registered.", RuleYear, ""},
+			{"data", "json", "Additional patient data collected for the study.", RuleData, ""},
+		},
+	},
+	"Encounter": {
+		Type:        "Encounter",
+		Description: "Visits and consultations.",
+		Query: `SELECT e.id::text, e.patient_id::text, e.type, e.status, e.started_at::text, e.ended_at::text
+			FROM encounters e
+			WHERE e.patient_id ` + researchConsented + `
+			AND ($1::timestamptz IS NULL OR e.started_at >= $1) AND ($2::timestamptz IS NULL OR e.started_at < $2)
+			ORDER BY e.id`,
+		Columns: []ResearchColumn{
+			{"encounter_id", "string", "Pseudonym of the encounter.", RulePseudonym, "encounter"},
+			patientColumn,
+			{"type", "string", "Type of encounter.", RuleKeep, ""},
+			{"status", "string", "Status of the encounter.", RuleKeep, ""},
+			{"started_year", "integer", "Year the encounter started.", RuleYear, ""},
+			{"ended_year", "integer", "Year the encounter ended.", RuleYear, ""},
+		},
+	},
+	"VitalSigns": {
+		Type:        "VitalSigns",
+		Description: "Vital signs in canonical units.",
+		Query: `SELECT v.patient_id::text, v.encounter_id::text, v.recorded_at::text, v.systolic::text,
+				v.diastolic::text, v.heart_rate::text, v.respiratory_rate::text, v.temperature::text, v.spo2::text,
+				v.weight::text, v.height::text, v.bmi::text
+			FROM vital_signs v
+			WHERE v.patient_id ` + researchConsented + `
+			AND ($1::timestamptz IS NULL OR v.recorded_at >= $1) AND ($2::timestamptz IS NULL OR v.recorded_at < $2)
+			ORDER BY v.id`,
+		Columns: []ResearchColumn{
+			patientColumn,
+			encounterColumn,
+			{"recorded_year", "integer", "Year the signs were recorded.", RuleYear, ""},
+			{"systolic", "integer", "Systolic blood pressure in mmHg.", RuleKeep, ""},
+			{"diastolic", "integer", "Diastolic blood pressure in mmHg.", RuleKeep, ""},
+			{"heart_rate", "integer", "Heart rate in beats per minute.", RuleKeep, ""},
+			{"respiratory_rate", "integer", "Respiratory rate in breaths per minute.", RuleKeep, ""},
+			{"temperature", "decimal", "Temperature in degrees Celsius.", RuleKeep, ""},
+			{"spo2", "integer", "Oxygen saturation in percent.", RuleKeep, ""},
+			{"weight", "decimal", "Weight in kilograms.", RuleKeep, ""},
+			{"height", "decimal", "Height in centimetres.", RuleKeep, ""},
+			{"bmi", "decimal", "Body mass index.", RuleKeep, ""},
+		},
+	},
+	"Problem": {
+		Type:        "Problem",
+		Description: "Problem list entries.",
+		Query: `SELECT p.id::text, p.patient_id::text, p.code, p.status, p.onset_date::text, p.resolved_date::text
+			FROM problems p
+			WHERE p.patient_id ` + researchConsented + `
+			AND ($1::timestamptz IS NULL OR p.updated_at >= $1) AND ($2::timestamptz IS NULL OR p.updated_at < $2)
+			ORDER BY p.id`,
+		Columns: []ResearchColumn{
+			{"problem_id", "string", "Pseudonym of the problem.", RulePseudonym, "problem"},
+			patientColumn,
+			{"code", "string", "ICD-10 code.", RuleKeep, ""},
+			{"status", "string", "Status of the problem.", RuleKeep, ""},
+			{"onset_year", "integer", "Year of onset.", RuleYear, ""},
+			{"resolved_year", "integer", "Year the problem resolved.", RuleYear, ""},
+		},
+	},
+	"Diagnosis": {
+		Type:        "Diagnosis",
+		Description: "Diagnoses made against problems.",
+		Query: `SELECT d.patient_id::text, d.problem_id::text, d.encounter_id::text, d.code, d.diagnosed_at::text
+			FROM diagnoses d
+			WHERE d.patient_id ` + researchConsented + `
+			AND ($1::timestamptz IS NULL OR d.diagnosed_at >= $1) AND ($2::timestamptz IS NULL OR d.diagnosed_at < $2)
+			ORDER BY d.id`,
+		Columns: []ResearchColumn{
+			patientColumn,
+			{"problem_id", "string", "Pseudonym of the problem diagnosed.", RulePseudonym, "problem"},
+			encounterColumn,
+			{"code", "string", "ICD-10 code.", RuleKeep, ""},
+			{"diagnosed_year", "integer", "Year of diagnosis.", RuleYear, ""},
+		},
+	},
+	"Allergy": {
+		Type:        "Allergy",
+		Description: "Allergies and intolerances.",
+		Query: `SELECT a.patient_id::text, a.substance, a.reaction, a.severity, a.status, a.created_at::text
+			FROM allergies a
+			WHERE a.patient_id ` + researchConsented + `
+			AND ($1::timestamptz IS NULL OR a.created_at >= $1) AND ($2::timestamptz IS NULL OR a.created_at < $2)
+			ORDER BY a.id`,
+		Columns: []ResearchColumn{
+			patientColumn,
+			{"substance", "string", "Substance the patient reacts to.", RuleKeep, ""},
+			{"reaction", "string", "Reaction.", RuleKeep, ""},
+			{"severity", "string", "Severity of the reaction.", RuleKeep, ""},
+			{"status", "string", "Status of the allergy.", RuleKeep, ""},
+			{"recorded_year", "integer", "Year the allergy was recorded.", RuleYear, ""},
+		},
+	},
+	"Prescription": {
+		Type:        "Prescription",
+		Description: "Prescriptions.",
+		Query: `SELECT p.patient_id::text, p.encounter_id::text, p.drug, p.dose, p.route, p.frequency,
+				p.duration_days::text, p.refills::text, p.status, p.start_date::text
+			FROM prescriptions p
+			WHERE p.patient_id ` + researchConsented + `
+			AND ($1::timestamptz IS NULL OR p.created_at >= $1) AND ($2::timestamptz IS NULL OR p.created_at < $2)
+			ORDER BY p.id`,
+		Columns: []ResearchColumn{
+			patientColumn,
+			encounterColumn,
+			{"drug", "string", "Drug prescribed.", RuleKeep, ""},
+			{"dose", "string", "Dose.", RuleKeep, ""},
+			{"route", "string", "Route of administration.", RuleKeep, ""},
+			{"frequency", "string", "Frequency.", RuleKeep, ""},
+			{"duration_days", "integer", "Duration in days.", RuleKeep, ""},
+			{"refills", "integer", "Number of refills.", RuleKeep, ""},
+			{"status", "string", "Status of the prescription.", RuleKeep, ""},
+			{"start_year", "integer", "Year the prescription started.", RuleYear, ""},
+		},
+	},
+	"LabOrder": {
+		Type:        "LabOrder",
+		Description: "Laboratory test orders.",
+		Query: `SELECT o.id::text, o.patient_id::text, o.encounter_id::text, o.test_code, o.test_name, o.priority,
+				o.status, o.ordered_at::text, o.resulted_at::text
+			FROM lab_orders o
+			WHERE o.patient_id ` + researchConsented + `
+			AND ($1::timestamptz IS NULL OR o.ordered_at >= $1) AND ($2::timestamptz IS NULL OR o.ordered_at < $2)
+			ORDER BY o.id`,
+		Columns: []ResearchColumn{
+			{"order_id", "string", "Pseudonym of the order.", RulePseudonym, "lab_order"},
+			patientColumn,
+			encounterColumn,
+			{"test_code", "string", "Code of the test.", RuleKeep, ""},
+			{"test_name", "string", "Name of the test.", RuleKeep, ""},
+			{"priority", "string", "Priority of the order.", RuleKeep, ""},
+			{"status", "string", "Status of the order.", RuleKeep, ""},
+			{"ordered_year", "integer", "Year the test was ordered.", RuleYear, ""},
+			{"resulted_year", "integer", "Year the results came back.", RuleYear, ""},
+		},
+	},
+	"LabResult": {
+		Type:        "LabResult",
+		Description: "Numeric laboratory results.",
+		Query: `SELECT r.order_id::text, o.patient_id::text, r.code, r.name, r.value::text, r.unit,
+				r.reference_low::text, r.reference_high::text, r.flag, r.observed_at::text
+			FROM lab_results r
+			JOIN lab_orders o ON o.id = r.order_id
+			WHERE o.patient_id ` + researchConsented + `
+			AND ($1::timestamptz IS NULL OR r.observed_at >= $1) AND ($2::timestamptz IS NULL OR r.observed_at < $2)
+			ORDER BY r.id`,
+		Columns: []ResearchColumn{
+			{"order_id", "string", "Pseudonym of the order.", RulePseudonym, "lab_order"},
+			patientColumn,
+			{"code", "string", "Code of the observation.", RuleKeep, ""},
+			{"name", "string", "Name of the observation.", RuleKeep, ""},
+			{"value", "decimal", "Numeric value.", RuleKeep, ""},
+			{"unit", "string", "Unit of the value.", RuleKeep, ""},
+			{"reference_low", "decimal", "Lower bound of the reference range.", RuleKeep, ""},
+			{"reference_high", "decimal", "Upper bound of the reference range.", RuleKeep, ""},
+			{"flag", "string", "Abnormal flag.", RuleKeep, ""},
+			{"observed_year", "integer", "Year of the observation.", RuleYear, ""},
+		},
+	},
+	"Immunization": {
+		Type:        "Immunization",
+		Description: "Vaccinations given.",
+		Query: `SELECT i.patient_id::text, i.vaccine_code, i.vaccine_name, i.dose_number::text, i.site,
+				i.administered_on::text
+			FROM immunizations i
+			WHERE i.patient_id ` + researchConsented + `
+			AND ($1::timestamptz IS NULL OR i.administered_on >= $1) AND ($2::timestamptz IS NULL OR i.administered_on < $2)
+			ORDER BY i.id`,
+		Columns: []ResearchColumn{
+			patientColumn,
+			{"vaccine_code", "string", "Code of the vaccine.", RuleKeep, ""},
+			{"vaccine_name", "string", "Name of the vaccine.", RuleKeep, ""},
+			{"dose_number", "integer", "Dose in the series.", RuleKeep, ""},
+			{"site", "string", "Site of administration.", RuleKeep, ""},
+			{"administered_year", "integer", "Year the vaccine was given.", RuleYear, ""},
+		},
+	},
+	"Admission": {
+		Type:        "Admission",
+		Description: "Inpatient admissions.",
+		Query: `SELECT a.patient_id::text, a.status, a.admitted_at::text, a.discharged_at::text,
+				(a.discharged_at::date - a.admitted_at::date)::text, a.discharge_disposition
+			FROM admissions a
+			WHERE a.patient_id ` + researchConsented + `
+			AND ($1::timestamptz IS NULL OR a.admitted_at >= $1) AND ($2::timestamptz IS NULL OR a.admitted_at < $2)
+			ORDER BY a.id`,
+		Columns: []ResearchColumn{
+			patientColumn,
+			{"status", "string", "Status of the admission.", RuleKeep, ""},
+			{"admitted_year", "integer", "Year of admission.", RuleYear, ""},
+			{"discharged_year", "integer", "Year of discharge.", RuleYear, ""},
+			{"length_of_stay_days", "integer", "Days between admission and discharge.", RuleKeep, ""},
+			{"discharge_disposition", "string", "Where the patient went on discharge.", RuleKeep, ""},
+		},
+	},
+}
+
+// ResearchDictionary describes every column of the types' tables, with how each is
+// de-identified, as CSV records with a header.
+func ResearchDictionary(types []string, dataFields []string) [][]string {
+	records := [][]string{{"table", "column", "type", "description", "de-identification"}}
+	for _, t := range types {
+		table, ok := researchTables[t]
+		if !ok {
+			continue
+		}
+		records = append(records, []string{table.Type, "", "", table.Description, ""})
+		for _, c := range table.Columns {
+			description := c.Description
+			if c.Rule == RuleData {
+				description = fmt.Sprintf("%s Fields: %s.", description, strings.Join(dataFields, ", "))
+				if len(dataFields) == 0 {
+					description += " None are collected, so the column is empty."
+				}
+			}
+			records = append(records, []string{table.Type, c.Name, c.Type, description, researchRules[c.Rule]})
+		}
+	}
+	return records
+}
+
+// ResearchStudy is a study that receives de-identified exports. Its key pseudonymizes
+// IDs, so it is never returned to clients.
+type ResearchStudy struct {
+	ID          int64     `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Key         []byte    `json:"-"`
+	Types       []string  `json:"types"`
+	DataFields  []string  `json:"dataFields"`
+	CreatedBy   int64     `json:"createdBy"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	Version     int64     `json:"version"`
+}
+
+func ValidateResearchStudy(v *validator.Validator, s *ResearchStudy) {
+	v.Check(s.Name != "", "name", "must be provided")
+	v.Check(len(s.Name) <= 255, "name", "must not be more than 255 bytes long")
+	v.Check(len(s.Types) > 0, "types", "must contain at least one type")
+	v.Check(validator.Unique(s.Types), "types", "must not contain duplicate values")
+	for _, t := range s.Types {
+		v.Check(validator.In(t, ExportTypes...), "types", fmt.Sprintf("%q is not an export type", t))
+	}
+	v.Check(validator.Unique(s.DataFields), "dataFields", "must not contain duplicate values")
+	for _, f := range s.DataFields {
+		v.Check(f != "" && len(f) <= 100, "dataFields", "must be between 1 and 100 bytes long")
+		v.Check(!deid.IsIdentifierField(f), "dataFields", fmt.Sprintf("%q looks like an identifier and can't be exported", f))
+	}
+}
+
+type ResearchStudyModel struct {
+	DB *sql.DB
+}
+
+// Insert adds a study with a new pseudonym key.
+func (m *ResearchStudyModel) Insert(ctx context.Context, s *ResearchStudy) error {
+	key, err := deid.NewKey()
+	if err != nil {
+		return err
+	}
+	s.Key = key
+
+	query := `INSERT INTO research_studies (name, description, pseudonym_key, types, data_fields, created_by)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at, updated_at, version`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, s.Name, s.Description, s.Key, pq.Array(s.Types),
+		pq.Array(s.DataFields), s.CreatedBy).Scan(&s.ID, &s.CreatedAt, &s.UpdatedAt, &s.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "research_studies_name_key"`:
+			return ErrDuplicateStudy
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+const researchStudyColumns = `id, name, description, pseudonym_key, types, data_fields, created_by,
+	created_at, updated_at, version`
+
+func scanResearchStudy(row rowScanner, extra ...any) (*ResearchStudy, error) {
+	var s ResearchStudy
+	dest := append(extra, &s.ID, &s.Name, &s.Description, &s.Key, pq.Array(&s.Types), pq.Array(&s.DataFields),
+		&s.CreatedBy, &s.CreatedAt, &s.UpdatedAt, &s.Version)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	if s.DataFields == nil {
+		s.DataFields = []string{}
+	}
+	return &s, nil
+}
+
+func (m *ResearchStudyModel) GetById(ctx context.Context, id int64) (*ResearchStudy, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `SELECT ` + researchStudyColumns + ` FROM research_studies WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	s, err := scanResearchStudy(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (m *ResearchStudyModel) GetAll(ctx context.Context, filters Filters) ([]*ResearchStudy, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), `+researchStudyColumns+`
+	FROM research_studies
+	ORDER BY %s %s, id
+	LIMIT $1 OFFSET $2`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	studies := []*ResearchStudy{}
+	for rows.Next() {
+		s, err := scanResearchStudy(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		studies = append(studies, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return studies, metadata, nil
+}
+
+// Update changes a study's details and what its exports contain. The pseudonym key
+// never changes, so later exports still link up with earlier ones.
+func (m *ResearchStudyModel) Update(ctx context.Context, s *ResearchStudy) error {
+	query := `UPDATE research_studies
+	SET name = $1, description = $2, types = $3, data_fields = $4, updated_at = NOW(), version = version + 1
+	WHERE id = $5 AND version = $6
+	RETURNING updated_at, version`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, s.Name, s.Description, pq.Array(s.Types), pq.Array(s.DataFields),
+		s.ID, s.Version).Scan(&s.UpdatedAt, &s.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case err.Error() == `pq: duplicate key value violates unique constraint "research_studies_name_key"`:
+			return ErrDuplicateStudy
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Stream calls fn with the de-identified CSV record of each row of the type's table
+// in the export's date range. Like ExportModel.Stream it is bounded by ctx alone.
+func (m *ResearchStudyModel) Stream(ctx context.Context, s *ResearchStudy, e *Export, exportType string,
+	fn func(record []string) error) error {
+	table, ok := researchTables[exportType]
+	if !ok {
+		return fmt.Errorf("unknown export type %q", exportType)
+	}
+
+	rows, err := m.DB.QueryContext(ctx, table.Query, e.Since, e.Until)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	pseudonymizer := deid.NewPseudonymizer(s.Key)
+	now := time.Now()
+	values := make([]sql.NullString, len(table.Columns))
+	dest := make([]any, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		record, err := table.deidentify(values, pseudonymizer, s.DataFields, now)
+		if err != nil {
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (t *ResearchTable) deidentify(values []sql.NullString, p *deid.Pseudonymizer, dataFields []string,
+	now time.Time) ([]string, error) {
+	record := make([]string, len(t.Columns))
+	for i, c := range t.Columns {
+		if !values[i].Valid {
+			continue
+		}
+		value := values[i].String
+		switch c.Rule {
+		case RuleKeep:
+			record[i] = value
+		case RulePseudonym:
+			record[i] = p.Pseudonym(c.Kind, value)
+		case RuleYear:
+			record[i] = deid.Year(value)
+		case RuleAge, RuleBirthYear:
+			dob, err := time.Parse(time.DateOnly, value)
+			if err != nil {
+				return nil, err
+			}
+			age, birthYear := deid.Age(dob, now)
+			if c.Rule == RuleAge {
+				record[i] = age
+			} else {
+				record[i] = birthYear
+			}
+		case RuleData:
+			scrubbed, err := deid.ScrubJSON([]byte(value), dataFields)
+			if err != nil {
+				return nil, err
+			}
+			record[i] = scrubbed
+		default:
+			return nil, fmt.Errorf("unknown de-identification rule %q", c.Rule)
+		}
+	}
+	return record, nil
+}
+
+// ResearchTableHeader returns the CSV header of the type's table.
+func ResearchTableHeader(exportType string) ([]string, error) {
+	table, ok := researchTables[exportType]
+	if !ok {
+		return nil, fmt.Errorf("unknown export type %q", exportType)
+	}
+	header := make([]string, len(table.Columns))
+	for i, c := range table.Columns {
+		header[i] = c.Name
+	}
+	return header, nil
+}
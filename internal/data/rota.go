@@ -0,0 +1,606 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+const (
+	StaffDoctor       = "doctor"
+	StaffReceptionist = "receptionist"
+)
+
+const (
+	ShiftOnCall = "on_call"
+
+	SwapPending  = "pending"
+	SwapApproved = "approved"
+	SwapRejected = "rejected"
+	SwapCanceled = "canceled"
+)
+
+var (
+	StaffTypes    = []string{StaffDoctor, StaffReceptionist}
+	ShiftTypes    = []string{"day", "night", "clinic", ShiftOnCall}
+	SwapStatuses  = []string{SwapPending, SwapApproved, SwapRejected, SwapCanceled}
+	maxShiftHours = 36 * time.Hour
+)
+
+var (
+	ErrShiftOverlap   = errors.New("shift overlaps another shift for the same member of staff")
+	ErrSwapPending    = errors.New("a swap is already pending for this shift")
+	ErrSwapNotPending = errors.New("swap has already been decided")
+	ErrSwapStale      = errors.New("the shifts have changed hands since the swap was requested")
+	ErrNotRostered    = errors.New("doctor is not rostered at this time")
+)
+
+// Shift is a period a doctor or receptionist works in a department.
+type Shift struct {
+	ID           int64      `json:"id"`
+	StaffType    string     `json:"staffType"`
+	StaffID      int64      `json:"staffId"`
+	StaffName    string     `json:"staffName"`
+	DepartmentID int64      `json:"departmentId"`
+	Type         string     `json:"type"`
+	StartsAt     time.Time  `json:"startsAt"`
+	EndsAt       time.Time  `json:"endsAt"`
+	Notes        string     `json:"notes"`
+	PublishedAt  *time.Time `json:"publishedAt"`
+	CreatedBy    int64      `json:"createdBy"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	Version      int64      `json:"version"`
+}
+
+func ValidateShift(v *validator.Validator, s *Shift) {
+	v.Check(validator.In(s.StaffType, StaffTypes...), "staffType", "must be doctor or receptionist")
+	v.Check(s.StaffID > 0, "staffId", "must be provided")
+	v.Check(s.DepartmentID > 0, "departmentId", "must be provided")
+	v.Check(validator.In(s.Type, ShiftTypes...), "type", "must be day, night, clinic or on_call")
+	v.Check(s.EndsAt.After(s.StartsAt), "endsAt", "must be after startsAt")
+	v.Check(s.EndsAt.Sub(s.StartsAt) <= maxShiftHours, "endsAt", "shift must not be longer than 36 hours")
+	v.Check(len(s.Notes) <= 2000, "notes", "must not be more than 2000 bytes long")
+}
+
+// staffColumns returns the doctor_id and receptionist_id values for a member of staff.
+func staffColumns(staffType string, staffID int64) (doctorID, receptionistID *int64) {
+	if staffType == StaffDoctor {
+		return &staffID, nil
+	}
+	return nil, &staffID
+}
+
+type ShiftModel struct {
+	DB *sql.DB
+}
+
+// ShiftQuery narrows down the shifts returned by GetAll. Zero values match everything.
+type ShiftQuery struct {
+	DepartmentID  int64
+	StaffType     string
+	StaffID       int64
+	From          time.Time
+	To            time.Time
+	PublishedOnly bool
+}
+
+const shiftColumns = `s.id, s.doctor_id, s.receptionist_id,
+	COALESCE(d.first_name || ' ' || d.last_name, r.first_name || ' ' || r.last_name),
+	s.department_id, s.shift_type, s.starts_at, s.ends_at, s.notes, s.published_at, s.created_by, s.created_at,
+	s.version`
+
+const shiftFrom = `shifts s
+	LEFT JOIN doctors d ON d.id = s.doctor_id
+	LEFT JOIN receptionists r ON r.id = s.receptionist_id`
+
+func scanShift(row rowScanner) (*Shift, error) {
+	var s Shift
+	var doctorID, receptionistID *int64
+	err := row.Scan(&s.ID, &doctorID, &receptionistID, &s.StaffName, &s.DepartmentID, &s.Type, &s.StartsAt,
+		&s.EndsAt, &s.Notes, &s.PublishedAt, &s.CreatedBy, &s.CreatedAt, &s.Version)
+	if err != nil {
+		return nil, err
+	}
+	if doctorID != nil {
+		s.StaffType, s.StaffID = StaffDoctor, *doctorID
+	} else {
+		s.StaffType, s.StaffID = StaffReceptionist, *receptionistID
+	}
+	return &s, nil
+}
+
+func (m *ShiftModel) getShift(ctx context.Context, q queryer, id int64, lock bool) (*Shift, error) {
+	query := `SELECT ` + shiftColumns + ` FROM ` + shiftFrom + ` WHERE s.id = $1`
+	if lock {
+		query += ` FOR UPDATE OF s`
+	}
+	s, err := scanShift(q.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// checkOverlap returns ErrShiftOverlap if the member of staff already has a shift
+// during s, other than s itself.
+func checkOverlap(ctx context.Context, tx *sql.Tx, s *Shift) error {
+	doctorID, receptionistID := staffColumns(s.StaffType, s.StaffID)
+	var overlaps bool
+	err := tx.QueryRowContext(ctx, `SELECT EXISTS (
+		SELECT 1 FROM shifts
+		WHERE (doctor_id = $1 OR receptionist_id = $2)
+		AND id <> $3 AND starts_at < $5 AND ends_at > $4)`,
+		doctorID, receptionistID, s.ID, s.StartsAt, s.EndsAt).Scan(&overlaps)
+	if err != nil {
+		return err
+	}
+	if overlaps {
+		return ErrShiftOverlap
+	}
+	return nil
+}
+
+// Insert adds a shift. Shifts added to a week that has already been published are
+// published straight away.
+func (m *ShiftModel) Insert(ctx context.Context, s *Shift) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(m.DB, ctx, func(tx *sql.Tx) error {
+		if err := checkOverlap(ctx, tx, s); err != nil {
+			return err
+		}
+
+		doctorID, receptionistID := staffColumns(s.StaffType, s.StaffID)
+		var id int64
+		err := tx.QueryRowContext(ctx, `INSERT INTO shifts (doctor_id, receptionist_id, department_id, shift_type,
+		starts_at, ends_at, notes, created_by, published_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8,
+			CASE WHEN EXISTS (SELECT 1 FROM rota_publications
+				WHERE department_id = $3 AND week_start = date_trunc('week', $5::timestamptz)::date)
+			THEN NOW() END)
+		RETURNING id`, doctorID, receptionistID, s.DepartmentID, s.Type, s.StartsAt, s.EndsAt, s.Notes,
+			s.CreatedBy).Scan(&id)
+		if err != nil {
+			return err
+		}
+
+		created, err := m.getShift(ctx, tx, id, false)
+		if err != nil {
+			return err
+		}
+		*s = *created
+		return nil
+	})
+}
+
+func (m *ShiftModel) GetById(ctx context.Context, id int64) (*Shift, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return m.getShift(ctx, m.DB, id, false)
+}
+
+// GetAll returns the shifts overlapping [From, To) that match the query, in start
+// order.
+func (m *ShiftModel) GetAll(ctx context.Context, q ShiftQuery) ([]*Shift, error) {
+	doctorID, receptionistID := int64(0), int64(0)
+	switch q.StaffType {
+	case StaffDoctor:
+		doctorID = q.StaffID
+	case StaffReceptionist:
+		receptionistID = q.StaffID
+	}
+
+	query := `SELECT ` + shiftColumns + ` FROM ` + shiftFrom + `
+	WHERE (s.department_id = $1 OR $1 = 0)
+	AND ($2 = '' OR ($2 = 'doctor' AND s.doctor_id IS NOT NULL) OR ($2 = 'receptionist' AND s.receptionist_id IS NOT NULL))
+	AND (s.doctor_id = $3 OR $3 = 0)
+	AND (s.receptionist_id = $4 OR $4 = 0)
+	AND s.ends_at > $5 AND s.starts_at < $6
+	AND (s.published_at IS NOT NULL OR NOT $7)
+	ORDER BY s.starts_at, s.id`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, q.DepartmentID, q.StaffType, doctorID, receptionistID, q.From, q.To,
+		q.PublishedOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shifts := []*Shift{}
+	for rows.Next() {
+		s, err := scanShift(rows)
+		if err != nil {
+			return nil, err
+		}
+		shifts = append(shifts, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return shifts, nil
+}
+
+func (m *ShiftModel) Update(ctx context.Context, s *Shift) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(m.DB, ctx, func(tx *sql.Tx) error {
+		if err := checkOverlap(ctx, tx, s); err != nil {
+			return err
+		}
+
+		doctorID, receptionistID := staffColumns(s.StaffType, s.StaffID)
+		err := tx.QueryRowContext(ctx, `UPDATE shifts
+		SET doctor_id = $1, receptionist_id = $2, department_id = $3, shift_type = $4, starts_at = $5, ends_at = $6,
+			notes = $7, version = version + 1
+		WHERE id = $8 AND version = $9
+		RETURNING version`, doctorID, receptionistID, s.DepartmentID, s.Type, s.StartsAt, s.EndsAt, s.Notes,
+			s.ID, s.Version).Scan(&s.Version)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflict
+			default:
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (m *ShiftModel) Delete(ctx context.Context, id int64) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM shifts WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Publish makes the department's rota for the week starting on weekStart (a Monday)
+// visible to staff, returning the number of shifts published.
+func (m *ShiftModel) Publish(ctx context.Context, departmentID int64, weekStart Date, receptionistID int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var published int64
+	err := withTx(m.DB, ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `INSERT INTO rota_publications (department_id, week_start, published_by)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (department_id, week_start) DO UPDATE SET published_by = $3, published_at = NOW()`,
+			departmentID, weekStart, receptionistID)
+		if err != nil {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx, `UPDATE shifts
+		SET published_at = NOW(), version = version + 1
+		WHERE department_id = $1 AND published_at IS NULL
+		AND starts_at >= $2::date AND starts_at < $2::date + 7`, departmentID, weekStart)
+		if err != nil {
+			return err
+		}
+		published, err = result.RowsAffected()
+		return err
+	})
+	return published, err
+}
+
+// OnDuty returns the published shifts in the department covering at.
+func (m *ShiftModel) OnDuty(ctx context.Context, departmentID int64, at time.Time) ([]*Shift, error) {
+	query := `SELECT ` + shiftColumns + ` FROM ` + shiftFrom + `
+	WHERE s.department_id = $1 AND s.published_at IS NOT NULL AND s.starts_at <= $2 AND s.ends_at > $2
+	ORDER BY s.shift_type = 'on_call' DESC, s.starts_at, s.id`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, departmentID, at)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shifts := []*Shift{}
+	for rows.Next() {
+		s, err := scanShift(rows)
+		if err != nil {
+			return nil, err
+		}
+		shifts = append(shifts, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return shifts, nil
+}
+
+// IsRostered reports whether the doctor has a published shift covering at.
+func (m *ShiftModel) IsRostered(ctx context.Context, doctorID int64, at time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var rostered bool
+	err := m.DB.QueryRowContext(ctx, `SELECT EXISTS (
+		SELECT 1 FROM shifts
+		WHERE doctor_id = $1 AND published_at IS NOT NULL AND starts_at <= $2 AND ends_at > $2)`,
+		doctorID, at).Scan(&rostered)
+	return rostered, err
+}
+
+// ShiftSwap asks for a shift to be handed to another member of staff of the same
+// kind, optionally taking one of their shifts in return. Swaps take effect once a
+// receptionist approves them.
+type ShiftSwap struct {
+	ID            int64      `json:"id"`
+	ShiftID       int64      `json:"shiftId"`
+	StaffType     string     `json:"staffType"`
+	RequesterID   int64      `json:"requesterId"`
+	TargetStaffID int64      `json:"targetStaffId"`
+	TargetShiftID *int64     `json:"targetShiftId"`
+	Reason        string     `json:"reason"`
+	Status        string     `json:"status"`
+	RequestedAt   time.Time  `json:"requestedAt"`
+	DecidedBy     *int64     `json:"decidedBy"`
+	DecidedAt     *time.Time `json:"decidedAt"`
+	DecisionNote  string     `json:"decisionNote"`
+	Version       int64      `json:"version"`
+}
+
+// ValidateShiftSwap checks a swap of shift, giving target in return when it isn't nil.
+func ValidateShiftSwap(v *validator.Validator, swap *ShiftSwap, shift, target *Shift) {
+	v.Check(swap.TargetStaffID != shift.StaffID, "targetStaffId", "must not be the member of staff on the shift")
+	v.Check(shift.StartsAt.After(time.Now()), "shiftId", "shift has already started")
+	v.Check(len(swap.Reason) <= 2000, "reason", "must not be more than 2000 bytes long")
+	if target != nil {
+		v.Check(target.StaffType == shift.StaffType && target.StaffID == swap.TargetStaffID, "targetShiftId",
+			"must be a shift of the member of staff being swapped with")
+		v.Check(target.StartsAt.After(time.Now()), "targetShiftId", "shift has already started")
+	}
+}
+
+type ShiftSwapModel struct {
+	DB *sql.DB
+}
+
+const swapColumns = `w.id, w.shift_id, CASE WHEN s.doctor_id IS NULL THEN 'receptionist' ELSE 'doctor' END,
+	w.requester_id, w.target_staff_id, w.target_shift_id, w.reason, w.status, w.requested_at, w.decided_by,
+	w.decided_at, w.decision_note, w.version`
+
+func scanSwap(row rowScanner) (*ShiftSwap, error) {
+	var w ShiftSwap
+	err := row.Scan(&w.ID, &w.ShiftID, &w.StaffType, &w.RequesterID, &w.TargetStaffID, &w.TargetShiftID, &w.Reason,
+		&w.Status, &w.RequestedAt, &w.DecidedBy, &w.DecidedAt, &w.DecisionNote, &w.Version)
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+func (m *ShiftSwapModel) Insert(ctx context.Context, w *ShiftSwap) error {
+	query := `INSERT INTO shift_swaps (shift_id, requester_id, target_staff_id, target_shift_id, reason)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, status, requested_at, version`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, w.ShiftID, w.RequesterID, w.TargetStaffID, w.TargetShiftID, w.Reason).
+		Scan(&w.ID, &w.Status, &w.RequestedAt, &w.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "shift_swaps_pending_idx"`:
+			return ErrSwapPending
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *ShiftSwapModel) GetById(ctx context.Context, id int64) (*ShiftSwap, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `SELECT ` + swapColumns + ` FROM shift_swaps w JOIN shifts s ON s.id = w.shift_id WHERE w.id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	w, err := scanSwap(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// GetAll lists swaps in a status, newest first. When staffType is given only the
+// swaps requested by or asked of that member of staff are returned.
+func (m *ShiftSwapModel) GetAll(ctx context.Context, status, staffType string, staffID int64, filters Filters) ([]*ShiftSwap, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), `+swapColumns+`
+	FROM shift_swaps w
+	JOIN shifts s ON s.id = w.shift_id
+	WHERE (w.status = $1 OR $1 = '')
+	AND ($2 = '' OR (
+		($2 = 'doctor') = (s.doctor_id IS NOT NULL) AND (w.requester_id = $3 OR w.target_staff_id = $3)))
+	ORDER BY w.%s %s, w.id DESC
+	LIMIT $4 OFFSET $5`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, status, staffType, staffID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	swaps := []*ShiftSwap{}
+	for rows.Next() {
+		var w ShiftSwap
+		err := rows.Scan(&totalRecords, &w.ID, &w.ShiftID, &w.StaffType, &w.RequesterID, &w.TargetStaffID,
+			&w.TargetShiftID, &w.Reason, &w.Status, &w.RequestedAt, &w.DecidedBy, &w.DecidedAt, &w.DecisionNote,
+			&w.Version)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		swaps = append(swaps, &w)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return swaps, metadata, nil
+}
+
+// Approve hands the shift to the target and, for a two-way swap, the target's shift
+// to the requester. It fails if either shift has changed hands since the request or
+// if either member of staff would end up double booked.
+func (m *ShiftSwapModel) Approve(ctx context.Context, w *ShiftSwap, receptionistID int64, note string) error {
+	if w.Status != SwapPending {
+		return ErrSwapNotPending
+	}
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	shifts := ShiftModel{m.DB}
+	return withTx(m.DB, ctx, func(tx *sql.Tx) error {
+		shift, err := shifts.getShift(ctx, tx, w.ShiftID, true)
+		if err != nil {
+			return err
+		}
+		if shift.StaffID != w.RequesterID {
+			return ErrSwapStale
+		}
+		var target *Shift
+		if w.TargetShiftID != nil {
+			target, err = shifts.getShift(ctx, tx, *w.TargetShiftID, true)
+			if err != nil {
+				return err
+			}
+			if target.StaffID != w.TargetStaffID || target.StaffType != shift.StaffType {
+				return ErrSwapStale
+			}
+		}
+
+		// Each shift is checked against its new owner's other shifts, leaving out
+		// the two that are changing hands.
+		shift.StaffID = w.TargetStaffID
+		if target != nil {
+			target.StaffID = w.RequesterID
+		}
+		for _, s := range []*Shift{shift, target} {
+			if s == nil {
+				continue
+			}
+			if err := checkOverlapExcluding(ctx, tx, s, shift, target); err != nil {
+				return err
+			}
+		}
+		for _, s := range []*Shift{shift, target} {
+			if s == nil {
+				continue
+			}
+			doctorID, receptionistID := staffColumns(s.StaffType, s.StaffID)
+			_, err := tx.ExecContext(ctx, `UPDATE shifts
+			SET doctor_id = $1, receptionist_id = $2, version = version + 1
+			WHERE id = $3`, doctorID, receptionistID, s.ID)
+			if err != nil {
+				return err
+			}
+		}
+
+		return decideSwap(ctx, tx, w, SwapApproved, &receptionistID, note)
+	})
+}
+
+// checkOverlapExcluding is checkOverlap ignoring the shifts being swapped, which are
+// about to change hands.
+func checkOverlapExcluding(ctx context.Context, tx *sql.Tx, s *Shift, swapped ...*Shift) error {
+	ids := []int64{}
+	for _, other := range swapped {
+		if other != nil {
+			ids = append(ids, other.ID)
+		}
+	}
+	doctorID, receptionistID := staffColumns(s.StaffType, s.StaffID)
+	var overlaps bool
+	err := tx.QueryRowContext(ctx, `SELECT EXISTS (
+		SELECT 1 FROM shifts
+		WHERE (doctor_id = $1 OR receptionist_id = $2)
+		AND NOT (id = ANY($3)) AND starts_at < $5 AND ends_at > $4)`,
+		doctorID, receptionistID, pq.Array(ids), s.StartsAt, s.EndsAt).Scan(&overlaps)
+	if err != nil {
+		return err
+	}
+	if overlaps {
+		return ErrShiftOverlap
+	}
+	return nil
+}
+
+// Decide rejects or cancels a pending swap. Requesters cancel their own swaps, so
+// decidedBy is nil for a cancellation.
+func (m *ShiftSwapModel) Decide(ctx context.Context, w *ShiftSwap, status string, decidedBy *int64, note string) error {
+	if w.Status != SwapPending {
+		return ErrSwapNotPending
+	}
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(m.DB, ctx, func(tx *sql.Tx) error {
+		return decideSwap(ctx, tx, w, status, decidedBy, note)
+	})
+}
+
+func decideSwap(ctx context.Context, tx *sql.Tx, w *ShiftSwap, status string, decidedBy *int64, note string) error {
+	err := tx.QueryRowContext(ctx, `UPDATE shift_swaps
+	SET status = $1, decided_by = $2, decided_at = NOW(), decision_note = $3, version = version + 1
+	WHERE id = $4 AND version = $5 AND status = 'pending'
+	RETURNING status, decided_by, decided_at, decision_note, version`, status, decidedBy, note, w.ID, w.Version).
+		Scan(&w.Status, &w.DecidedBy, &w.DecidedAt, &w.DecisionNote, &w.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
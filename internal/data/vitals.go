@@ -0,0 +1,466 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+const (
+	FlagLow  = "low"
+	FlagHigh = "high"
+)
+
+// VitalUnits lists the canonical (UCUM) unit every measurement is stored and returned
+// in.
+var VitalUnits = map[string]string{
+	"systolic":        "mm[Hg]",
+	"diastolic":       "mm[Hg]",
+	"heartRate":       "/min",
+	"respiratoryRate": "/min",
+	"temperature":     "Cel",
+	"spo2":            "%",
+	"weight":          "kg",
+	"height":          "cm",
+	"bmi":             "kg/m2",
+}
+
+// vitalConversions converts measurements entered in other units into the canonical
+// unit. Measures that aren't listed only accept their canonical unit.
+var vitalConversions = map[string]map[string]func(float64) float64{
+	"temperature": {
+		"Cel":    func(v float64) float64 { return v },
+		"[degF]": func(v float64) float64 { return (v - 32) * 5 / 9 },
+	},
+	"weight": {
+		"kg":      func(v float64) float64 { return v },
+		"g":       func(v float64) float64 { return v / 1000 },
+		"[lb_av]": func(v float64) float64 { return v * 0.45359237 },
+	},
+	"height": {
+		"cm":     func(v float64) float64 { return v },
+		"m":      func(v float64) float64 { return v * 100 },
+		"[in_i]": func(v float64) float64 { return v * 2.54 },
+	},
+}
+
+// ToCanonicalUnit converts a measurement to the unit it is stored in, rounded to the
+// precision of the column.
+func ToCanonicalUnit(measure string, value float64, unit string) (float64, error) {
+	if unit == "" {
+		unit = VitalUnits[measure]
+	}
+	convert, ok := vitalConversions[measure][unit]
+	if !ok {
+		return 0, fmt.Errorf("unsupported unit %q for %s", unit, measure)
+	}
+	scale := math.Pow(10, float64(vitalPrecision[measure]))
+	return math.Round(convert(value)*scale) / scale, nil
+}
+
+// vitalPrecision is the number of decimal places kept for each converted measure.
+var vitalPrecision = map[string]int{
+	"temperature": 1,
+	"weight":      2,
+	"height":      1,
+}
+
+// vitalColumns maps a measure name onto its column in vital_signs. It doubles as the
+// safelist for measures that can be queried as a trend.
+var vitalColumns = map[string]string{
+	"systolic":        "systolic",
+	"diastolic":       "diastolic",
+	"heartRate":       "heart_rate",
+	"respiratoryRate": "respiratory_rate",
+	"temperature":     "temperature",
+	"spo2":            "spo2",
+	"weight":          "weight",
+	"height":          "height",
+	"bmi":             "bmi",
+}
+
+type VitalSigns struct {
+	ID              int64             `json:"id"`
+	PatientID       int64             `json:"patientId"`
+	DoctorID        int64             `json:"doctorId"`
+	EncounterID     *int64            `json:"encounterId"`
+	RecordedAt      time.Time         `json:"recordedAt"`
+	Systolic        *int              `json:"systolic,omitempty"`
+	Diastolic       *int              `json:"diastolic,omitempty"`
+	HeartRate       *int              `json:"heartRate,omitempty"`
+	RespiratoryRate *int              `json:"respiratoryRate,omitempty"`
+	Temperature     *float64          `json:"temperature,omitempty"`
+	SpO2            *int              `json:"spo2,omitempty"`
+	Weight          *float64          `json:"weight,omitempty"`
+	Height          *float64          `json:"height,omitempty"`
+	BMI             *float64          `json:"bmi,omitempty"`
+	Flags           map[string]string `json:"flags"`
+	CreatedAt       time.Time         `json:"createdAt"`
+}
+
+// measures returns every recorded measurement keyed by measure name.
+func (vs *VitalSigns) measures() map[string]float64 {
+	m := make(map[string]float64)
+	ints := map[string]*int{
+		"systolic":        vs.Systolic,
+		"diastolic":       vs.Diastolic,
+		"heartRate":       vs.HeartRate,
+		"respiratoryRate": vs.RespiratoryRate,
+		"spo2":            vs.SpO2,
+	}
+	for name, value := range ints {
+		if value != nil {
+			m[name] = float64(*value)
+		}
+	}
+	floats := map[string]*float64{
+		"temperature": vs.Temperature,
+		"weight":      vs.Weight,
+		"height":      vs.Height,
+		"bmi":         vs.BMI,
+	}
+	for name, value := range floats {
+		if value != nil {
+			m[name] = *value
+		}
+	}
+	return m
+}
+
+// ComputeBMI sets the BMI from the weight and height, rounded to one decimal place. It
+// is computed before validation, which checks the BMI is plausible.
+func (vs *VitalSigns) ComputeBMI() {
+	vs.BMI = nil
+	if vs.Weight == nil || vs.Height == nil || *vs.Height <= 0 {
+		return
+	}
+	metres := *vs.Height / 100
+	bmi := math.Round(*vs.Weight/(metres*metres)*10) / 10
+	vs.BMI = &bmi
+}
+
+// Flag compares every measurement against the thresholds for the patient's age and
+// records those that fall outside the normal range.
+func (vs *VitalSigns) Flag(t *VitalThresholds, dob *Date) {
+	ranges := t.For(dob, vs.RecordedAt)
+	vs.Flags = make(map[string]string)
+	for name, value := range vs.measures() {
+		if flag := ranges[name].flag(value); flag != "" {
+			vs.Flags[name] = flag
+		}
+	}
+}
+
+func ValidateVitalSigns(v *validator.Validator, vs *VitalSigns) {
+	v.Check(len(vs.measures()) > 0, "vitals", "at least one measurement must be provided")
+	v.Check(!vs.RecordedAt.After(time.Now().Add(5*time.Minute)), "recordedAt", "must not be in the future")
+
+	// These are plausibility limits to catch typing and unit mistakes, not clinical
+	// reference ranges.
+	if vs.Systolic != nil {
+		v.Check(validator.Between(*vs.Systolic, 40, 300), "systolic", "must be between 40 and 300 mm[Hg]")
+	}
+	if vs.Diastolic != nil {
+		v.Check(validator.Between(*vs.Diastolic, 20, 200), "diastolic", "must be between 20 and 200 mm[Hg]")
+	}
+	if (vs.Systolic == nil) != (vs.Diastolic == nil) {
+		v.AddError("bloodPressure", "systolic and diastolic must be provided together")
+	} else if vs.Systolic != nil {
+		v.Check(*vs.Diastolic < *vs.Systolic, "diastolic", "must be lower than systolic")
+	}
+	if vs.HeartRate != nil {
+		v.Check(validator.Between(*vs.HeartRate, 20, 300), "heartRate", "must be between 20 and 300 /min")
+	}
+	if vs.RespiratoryRate != nil {
+		v.Check(validator.Between(*vs.RespiratoryRate, 4, 100), "respiratoryRate", "must be between 4 and 100 /min")
+	}
+	if vs.Temperature != nil {
+		v.Check(validator.Between(*vs.Temperature, 25, 45), "temperature", "must be between 25 and 45 Cel")
+	}
+	if vs.SpO2 != nil {
+		v.Check(validator.Between(*vs.SpO2, 50, 100), "spo2", "must be between 50 and 100 %")
+	}
+	if vs.Weight != nil {
+		v.Check(validator.Between(*vs.Weight, 0.3, 500), "weight", "must be between 0.3 and 500 kg")
+	}
+	if vs.Height != nil {
+		v.Check(validator.Between(*vs.Height, 20, 280), "height", "must be between 20 and 280 cm")
+	}
+	// A weight and height can each be plausible and still be a mistake together, such
+	// as a newborn's height with an adult's weight.
+	if vs.BMI != nil {
+		v.Check(validator.Between(*vs.BMI, 5, 250), "bmi", "weight and height must give a BMI between 5 and 250 kg/m2")
+	}
+}
+
+// Range is a normal range. Either bound may be omitted.
+type Range struct {
+	Low  *float64 `json:"low,omitempty"`
+	High *float64 `json:"high,omitempty"`
+}
+
+func (r Range) flag(value float64) string {
+	switch {
+	case r.Low != nil && value < *r.Low:
+		return FlagLow
+	case r.High != nil && value > *r.High:
+		return FlagHigh
+	default:
+		return ""
+	}
+}
+
+// VitalThresholds holds the normal ranges used to flag abnormal vital signs. Patients
+// younger than PaediatricUnder years are checked against the Paediatric ranges, and
+// everyone else (including patients with no date of birth) against the Adult ones.
+type VitalThresholds struct {
+	PaediatricUnder int              `json:"paediatricUnder"`
+	Adult           map[string]Range `json:"adult"`
+	Paediatric      map[string]Range `json:"paediatric"`
+}
+
+func (t *VitalThresholds) For(dob *Date, at time.Time) map[string]Range {
+	if dob != nil && dob.AgeAt(at) < t.PaediatricUnder {
+		return t.Paediatric
+	}
+	return t.Adult
+}
+
+func bounds(low, high float64) Range {
+	r := Range{}
+	if low > 0 {
+		r.Low = &low
+	}
+	if high > 0 {
+		r.High = &high
+	}
+	return r
+}
+
+// DefaultVitalThresholds is used when no thresholds file has been configured.
+var DefaultVitalThresholds = VitalThresholds{
+	PaediatricUnder: 18,
+	Adult: map[string]Range{
+		"systolic":        bounds(90, 140),
+		"diastolic":       bounds(60, 90),
+		"heartRate":       bounds(60, 100),
+		"respiratoryRate": bounds(12, 20),
+		"temperature":     bounds(36.1, 37.8),
+		"spo2":            bounds(95, 0),
+		"bmi":             bounds(18.5, 30),
+	},
+	Paediatric: map[string]Range{
+		"systolic":        bounds(80, 120),
+		"diastolic":       bounds(50, 80),
+		"heartRate":       bounds(70, 130),
+		"respiratoryRate": bounds(18, 30),
+		"temperature":     bounds(36.1, 37.8),
+		"spo2":            bounds(95, 0),
+	},
+}
+
+// LoadVitalThresholds reads thresholds from a JSON file with the same shape as
+// VitalThresholds.
+func LoadVitalThresholds(path string) (*VitalThresholds, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var t VitalThresholds
+	if err := json.Unmarshal(b, &t); err != nil {
+		return nil, fmt.Errorf("error parsing vital thresholds %s: %w", path, err)
+	}
+	for name := range t.Adult {
+		if _, ok := vitalColumns[name]; !ok {
+			return nil, fmt.Errorf("unknown measure %q in vital thresholds", name)
+		}
+	}
+	for name := range t.Paediatric {
+		if _, ok := vitalColumns[name]; !ok {
+			return nil, fmt.Errorf("unknown measure %q in vital thresholds", name)
+		}
+	}
+	return &t, nil
+}
+
+// TrendPoint summarises the readings of one measure within a time bucket.
+type TrendPoint struct {
+	Time  time.Time `json:"time"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Mean  float64   `json:"mean"`
+	Count int       `json:"count"`
+}
+
+type Trend struct {
+	Measure     string        `json:"measure"`
+	Unit        string        `json:"unit"`
+	Interval    string        `json:"interval"`
+	NormalRange Range         `json:"normalRange"`
+	Points      []*TrendPoint `json:"points"`
+}
+
+// TrendQuery describes a time-series request. Interval is "raw" for individual
+// readings, or a Postgres date_trunc field ("hour", "day", "week", "month").
+type TrendQuery struct {
+	Measures []string
+	Interval string
+	From     *time.Time
+	To       *time.Time
+}
+
+var TrendIntervals = []string{"raw", "hour", "day", "week", "month"}
+
+func ValidateTrendQuery(v *validator.Validator, q TrendQuery) {
+	v.Check(len(q.Measures) > 0, "measures", "must be provided")
+	for _, m := range q.Measures {
+		_, ok := vitalColumns[m]
+		v.Check(ok, "measures", "unknown measure "+m)
+	}
+	v.Check(validator.In(q.Interval, TrendIntervals...), "interval", "invalid interval")
+}
+
+type VitalSignsModel struct {
+	DB *sql.DB
+}
+
+func (m *VitalSignsModel) Insert(ctx context.Context, vs *VitalSigns) error {
+	query := `INSERT INTO vital_signs (patient_id, doctor_id, encounter_id, recorded_at,
+	systolic, diastolic, heart_rate, respiratory_rate, temperature, spo2, weight, height, bmi)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+	RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, vs.PatientID, vs.DoctorID, vs.EncounterID, vs.RecordedAt,
+		vs.Systolic, vs.Diastolic, vs.HeartRate, vs.RespiratoryRate, vs.Temperature, vs.SpO2,
+		vs.Weight, vs.Height, vs.BMI).
+		Scan(&vs.ID, &vs.CreatedAt)
+}
+
+func (m *VitalSignsModel) GetForPatient(ctx context.Context, patientID int64, from, to *time.Time, filters Filters) ([]*VitalSigns, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, patient_id, doctor_id, encounter_id, recorded_at, systolic,
+	diastolic, heart_rate, respiratory_rate, temperature, spo2, weight, height, bmi, created_at
+	FROM vital_signs
+	WHERE patient_id = $1
+	AND (recorded_at >= $2 OR $2 IS NULL)
+	AND (recorded_at < $3 OR $3 IS NULL)
+	ORDER BY %s %s, id ASC
+	LIMIT $4 OFFSET $5`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, patientID, from, endOfDay(to), filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	readings := []*VitalSigns{}
+	for rows.Next() {
+		var vs VitalSigns
+		err := rows.Scan(&totalRecords, &vs.ID, &vs.PatientID, &vs.DoctorID, &vs.EncounterID,
+			&vs.RecordedAt, &vs.Systolic, &vs.Diastolic, &vs.HeartRate, &vs.RespiratoryRate,
+			&vs.Temperature, &vs.SpO2, &vs.Weight, &vs.Height, &vs.BMI, &vs.CreatedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		readings = append(readings, &vs)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return readings, metadata, nil
+}
+
+// GetTrend returns one time series per requested measure, oldest point first.
+func (m *VitalSignsModel) GetTrend(ctx context.Context, patientID int64, q TrendQuery) ([]*Trend, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	trends := []*Trend{}
+	for _, measure := range q.Measures {
+		column, ok := vitalColumns[measure]
+		if !ok {
+			return nil, fmt.Errorf("unknown measure %q", measure)
+		}
+
+		var query string
+		var args []interface{}
+		if q.Interval == "raw" {
+			query = fmt.Sprintf(`
+			SELECT recorded_at, %[1]s, %[1]s, %[1]s, 1
+			FROM vital_signs
+			WHERE patient_id = $1 AND %[1]s IS NOT NULL
+			AND (recorded_at >= $2 OR $2 IS NULL)
+			AND (recorded_at < $3 OR $3 IS NULL)
+			ORDER BY recorded_at ASC`, column)
+			args = []interface{}{patientID, q.From, endOfDay(q.To)}
+		} else {
+			query = fmt.Sprintf(`
+			SELECT date_trunc($4, recorded_at) AS bucket, min(%[1]s), max(%[1]s),
+			round(avg(%[1]s), 1), count(%[1]s)
+			FROM vital_signs
+			WHERE patient_id = $1 AND %[1]s IS NOT NULL
+			AND (recorded_at >= $2 OR $2 IS NULL)
+			AND (recorded_at < $3 OR $3 IS NULL)
+			GROUP BY bucket
+			ORDER BY bucket ASC`, column)
+			args = []interface{}{patientID, q.From, endOfDay(q.To), q.Interval}
+		}
+
+		points, err := m.trendPoints(ctx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+		trends = append(trends, &Trend{
+			Measure:  measure,
+			Unit:     VitalUnits[measure],
+			Interval: q.Interval,
+			Points:   points,
+		})
+	}
+	return trends, nil
+}
+
+func (m *VitalSignsModel) trendPoints(ctx context.Context, query string, args ...interface{}) ([]*TrendPoint, error) {
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []*TrendPoint{}
+	for rows.Next() {
+		var p TrendPoint
+		if err := rows.Scan(&p.Time, &p.Min, &p.Max, &p.Mean, &p.Count); err != nil {
+			return nil, err
+		}
+		points = append(points, &p)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+// endOfDay turns an inclusive date upper bound into an exclusive timestamp bound.
+func endOfDay(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	end := t.AddDate(0, 0, 1)
+	return &end
+}
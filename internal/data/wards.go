@@ -0,0 +1,395 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+const (
+	BedClean    = "clean"
+	BedDirty    = "dirty"
+	BedCleaning = "cleaning"
+)
+
+var BedCleaningStatuses = []string{BedClean, BedDirty, BedCleaning}
+
+var (
+	ErrDuplicateWard = errors.New("a ward with this name already exists")
+	ErrDuplicateRoom = errors.New("the ward already has a room with this name")
+	ErrDuplicateBed  = errors.New("the room already has a bed with this label")
+)
+
+type Ward struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Specialty string    `json:"specialty"`
+	CreatedAt time.Time `json:"createdAt"`
+	Version   int64     `json:"version"`
+}
+
+type Room struct {
+	ID        int64     `json:"id"`
+	WardID    int64     `json:"wardId"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type Bed struct {
+	ID             int64     `json:"id"`
+	RoomID         int64     `json:"roomId"`
+	Label          string    `json:"label"`
+	CleaningStatus string    `json:"cleaningStatus"`
+	OutOfService   bool      `json:"outOfService"`
+	CreatedAt      time.Time `json:"createdAt"`
+	Version        int64     `json:"version"`
+}
+
+// BedOccupant is the patient currently admitted to a bed.
+type BedOccupant struct {
+	AdmissionID int64     `json:"admissionId"`
+	PatientID   int64     `json:"patientId"`
+	FirstName   string    `json:"firstName"`
+	LastName    string    `json:"lastName"`
+	AdmittedAt  time.Time `json:"admittedAt"`
+}
+
+type BedBoardBed struct {
+	Bed
+	Occupant *BedOccupant `json:"occupant"`
+}
+
+type BedBoardRoom struct {
+	Room
+	Beds []*BedBoardBed `json:"beds"`
+}
+
+// BedBoardWard is a ward with all its beds and a summary of their state.
+type BedBoardWard struct {
+	Ward
+	Total        int             `json:"total"`
+	Occupied     int             `json:"occupied"`
+	Available    int             `json:"available"`
+	Cleaning     int             `json:"cleaning"`
+	OutOfService int             `json:"outOfService"`
+	Rooms        []*BedBoardRoom `json:"rooms"`
+}
+
+func ValidateWard(v *validator.Validator, w *Ward) {
+	v.Check(w.Name != "", "name", "must be provided")
+	v.Check(len(w.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(len(w.Specialty) <= 100, "specialty", "must not be more than 100 bytes long")
+}
+
+func ValidateBed(v *validator.Validator, b *Bed) {
+	v.Check(b.Label != "", "label", "must be provided")
+	v.Check(len(b.Label) <= 50, "label", "must not be more than 50 bytes long")
+	v.Check(validator.In(b.CleaningStatus, BedCleaningStatuses...), "cleaningStatus", "must be clean, dirty or cleaning")
+}
+
+type WardModel struct {
+	DB *sql.DB
+}
+
+func (m *WardModel) Insert(ctx context.Context, w *Ward) error {
+	query := `INSERT INTO wards (name, specialty) VALUES ($1, $2)
+	RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, w.Name, w.Specialty).Scan(&w.ID, &w.CreatedAt, &w.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "wards_name_key"`:
+			return ErrDuplicateWard
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *WardModel) GetById(ctx context.Context, id int64) (*Ward, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `SELECT id, name, specialty, created_at, version FROM wards WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var w Ward
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&w.ID, &w.Name, &w.Specialty, &w.CreatedAt, &w.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &w, nil
+}
+
+func (m *WardModel) GetAll(ctx context.Context) ([]*Ward, error) {
+	query := `SELECT id, name, specialty, created_at, version FROM wards ORDER BY name ASC`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	wards := []*Ward{}
+	for rows.Next() {
+		var w Ward
+		if err := rows.Scan(&w.ID, &w.Name, &w.Specialty, &w.CreatedAt, &w.Version); err != nil {
+			return nil, err
+		}
+		wards = append(wards, &w)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return wards, nil
+}
+
+func (m *WardModel) Update(ctx context.Context, w *Ward) error {
+	query := `UPDATE wards SET name = $1, specialty = $2, version = version + 1
+	WHERE id = $3 AND version = $4
+	RETURNING version`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, w.Name, w.Specialty, w.ID, w.Version).Scan(&w.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case err.Error() == `pq: duplicate key value violates unique constraint "wards_name_key"`:
+			return ErrDuplicateWard
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *WardModel) InsertRoom(ctx context.Context, r *Room) error {
+	query := `INSERT INTO rooms (ward_id, name) VALUES ($1, $2) RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, r.WardID, r.Name).Scan(&r.ID, &r.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "rooms_ward_name_key"`:
+			return ErrDuplicateRoom
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *WardModel) GetRoom(ctx context.Context, id int64) (*Room, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `SELECT id, ward_id, name, created_at FROM rooms WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var r Room
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&r.ID, &r.WardID, &r.Name, &r.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &r, nil
+}
+
+func (m *WardModel) InsertBed(ctx context.Context, b *Bed) error {
+	query := `INSERT INTO beds (room_id, label, cleaning_status, out_of_service)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, b.RoomID, b.Label, b.CleaningStatus, b.OutOfService).
+		Scan(&b.ID, &b.CreatedAt, &b.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "beds_room_label_key"`:
+			return ErrDuplicateBed
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *WardModel) GetBed(ctx context.Context, id int64) (*Bed, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `SELECT id, room_id, label, cleaning_status, out_of_service, created_at, version
+	FROM beds WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var b Bed
+	err := m.DB.QueryRowContext(ctx, query, id).
+		Scan(&b.ID, &b.RoomID, &b.Label, &b.CleaningStatus, &b.OutOfService, &b.CreatedAt, &b.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &b, nil
+}
+
+func (m *WardModel) UpdateBed(ctx context.Context, b *Bed) error {
+	query := `UPDATE beds SET label = $1, cleaning_status = $2, out_of_service = $3, version = version + 1
+	WHERE id = $4 AND version = $5
+	RETURNING version`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, b.Label, b.CleaningStatus, b.OutOfService, b.ID, b.Version).
+		Scan(&b.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case err.Error() == `pq: duplicate key value violates unique constraint "beds_room_label_key"`:
+			return ErrDuplicateBed
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// BedBoard returns every ward, or just one when wardID is set, with its rooms, beds
+// and the patients occupying them.
+func (m *WardModel) BedBoard(ctx context.Context, wardID *int64) ([]*BedBoardWard, error) {
+	query := `
+	SELECT w.id, w.name, w.specialty, w.created_at, w.version,
+		r.id, r.ward_id, r.name, r.created_at,
+		b.id, b.room_id, b.label, b.cleaning_status, b.out_of_service, b.created_at, b.version,
+		a.id, a.patient_id, p.first_name, p.last_name, a.admitted_at
+	FROM wards w
+	LEFT JOIN rooms r ON r.ward_id = w.id
+	LEFT JOIN beds b ON b.room_id = r.id
+	LEFT JOIN admissions a ON a.bed_id = b.id AND a.status = 'admitted'
+	LEFT JOIN patients p ON p.id = a.patient_id
+	WHERE (w.id = $1 OR $1 IS NULL)
+	ORDER BY w.name, r.name, b.label`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, wardID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	board := []*BedBoardWard{}
+	var ward *BedBoardWard
+	var room *BedBoardRoom
+	for rows.Next() {
+		var w Ward
+		var roomID, roomWardID, bedID, bedRoomID, bedVersion sql.NullInt64
+		var roomName, bedLabel, cleaning sql.NullString
+		var roomCreated, bedCreated sql.NullTime
+		var outOfService sql.NullBool
+		var admissionID, patientID sql.NullInt64
+		var firstName, lastName sql.NullString
+		var admittedAt sql.NullTime
+
+		err := rows.Scan(&w.ID, &w.Name, &w.Specialty, &w.CreatedAt, &w.Version,
+			&roomID, &roomWardID, &roomName, &roomCreated,
+			&bedID, &bedRoomID, &bedLabel, &cleaning, &outOfService, &bedCreated, &bedVersion,
+			&admissionID, &patientID, &firstName, &lastName, &admittedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if ward == nil || ward.ID != w.ID {
+			ward = &BedBoardWard{Ward: w, Rooms: []*BedBoardRoom{}}
+			board = append(board, ward)
+			room = nil
+		}
+		if !roomID.Valid {
+			continue
+		}
+		if room == nil || room.ID != roomID.Int64 {
+			room = &BedBoardRoom{
+				Room: Room{ID: roomID.Int64, WardID: roomWardID.Int64, Name: roomName.String, CreatedAt: roomCreated.Time},
+				Beds: []*BedBoardBed{},
+			}
+			ward.Rooms = append(ward.Rooms, room)
+		}
+		if !bedID.Valid {
+			continue
+		}
+
+		bed := &BedBoardBed{Bed: Bed{
+			ID:             bedID.Int64,
+			RoomID:         bedRoomID.Int64,
+			Label:          bedLabel.String,
+			CleaningStatus: cleaning.String,
+			OutOfService:   outOfService.Bool,
+			CreatedAt:      bedCreated.Time,
+			Version:        bedVersion.Int64,
+		}}
+		if admissionID.Valid {
+			bed.Occupant = &BedOccupant{
+				AdmissionID: admissionID.Int64,
+				PatientID:   patientID.Int64,
+				FirstName:   firstName.String,
+				LastName:    lastName.String,
+				AdmittedAt:  admittedAt.Time,
+			}
+		}
+		room.Beds = append(room.Beds, bed)
+
+		ward.Total++
+		switch {
+		case bed.Occupant != nil:
+			ward.Occupied++
+		case bed.OutOfService:
+			ward.OutOfService++
+		case bed.CleaningStatus != BedClean:
+			ward.Cleaning++
+		default:
+			ward.Available++
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return board, nil
+}
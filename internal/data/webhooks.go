@@ -0,0 +1,482 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+// Domain events published to webhook subscribers.
+const (
+	EventPatientCreated     = "patient.created"
+	EventPatientUpdated     = "patient.updated"
+	EventPatientDeleted     = "patient.deleted"
+	EventPatientAdmitted    = "patient.admitted"
+	EventPatientTransferred = "patient.transferred"
+	EventPatientDischarged  = "patient.discharged"
+)
+
+var EventTypes = []string{EventPatientCreated, EventPatientUpdated, EventPatientDeleted, EventPatientAdmitted,
+	EventPatientTransferred, EventPatientDischarged}
+
+// PatientEvent is the data of patient events. Deleted patients only have an ID.
+type PatientEvent struct {
+	ID          int64      `json:"id"`
+	FirstName   string     `json:"firstName,omitempty"`
+	LastName    string     `json:"lastName,omitempty"`
+	Email       string     `json:"email,omitempty"`
+	DateOfBirth *Date      `json:"dateOfBirth,omitempty"`
+	CreatedAt   *time.Time `json:"createdAt,omitempty"`
+	Version     int64      `json:"version,omitempty"`
+}
+
+func newPatientEvent(p *Patient) PatientEvent {
+	return PatientEvent{
+		ID:          p.ID,
+		FirstName:   p.FirstName,
+		LastName:    p.LastName,
+		Email:       p.Email,
+		DateOfBirth: p.DateOfBirth,
+		CreatedAt:   &p.CreatedAt,
+		Version:     p.Version,
+	}
+}
+
+// insertEvent adds an event to the outbox. It must be called in the transaction that
+// makes the change, so the event is only published if the change is committed.
+func insertEvent(ctx context.Context, tx *sql.Tx, eventType string, payload any) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO outbox_events (type, payload) VALUES ($1, $2)`, eventType, b)
+	return err
+}
+
+// WebhookSubscription is an endpoint events are delivered to. Its secret signs
+// deliveries; it is only shown when the subscription is created.
+type WebhookSubscription struct {
+	ID          int64     `json:"id"`
+	URL         string    `json:"url"`
+	Description string    `json:"description"`
+	Secret      string    `json:"-"`
+	EventTypes  []string  `json:"eventTypes"`
+	Active      bool      `json:"active"`
+	CreatedBy   int64     `json:"createdBy"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	Version     int64     `json:"version"`
+}
+
+// ErrInsecureWebhookURL is why a delivery to a plain http URL not on the allowed
+// hosts is dead-lettered.
+var ErrInsecureWebhookURL = errors.New("webhook URL must use https")
+
+// WebhookURLAllowed reports whether events can be delivered to rawURL. Events carry
+// patient details, so they are only sent over https, or over plain http to one of
+// insecureHosts, such as a subscriber running locally during development.
+func WebhookURLAllowed(rawURL string, insecureHosts []string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		for _, host := range insecureHosts {
+			if strings.EqualFold(u.Hostname(), host) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func ValidateWebhookSubscription(v *validator.Validator, s *WebhookSubscription, insecureHosts []string) {
+	v.Check(s.URL != "", "url", "must be provided")
+	v.Check(len(s.URL) <= 2000, "url", "must not be more than 2000 bytes long")
+	v.Check(WebhookURLAllowed(s.URL, insecureHosts), "url", "must be an absolute https URL")
+	v.Check(len(s.Description) <= 2000, "description", "must not be more than 2000 bytes long")
+	v.Check(validator.Unique(s.EventTypes), "eventTypes", "must not contain duplicate values")
+	for _, t := range s.EventTypes {
+		v.Check(validator.In(t, EventTypes...), "eventTypes", fmt.Sprintf("%q is not an event type", t))
+	}
+}
+
+type WebhookModel struct {
+	DB *sql.DB
+}
+
+func (m *WebhookModel) Insert(ctx context.Context, s *WebhookSubscription) error {
+	query := `INSERT INTO webhook_subscriptions (url, description, secret, event_types, active, created_by)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at, updated_at, version`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, s.URL, s.Description, s.Secret, pq.Array(s.EventTypes), s.Active,
+		s.CreatedBy).Scan(&s.ID, &s.CreatedAt, &s.UpdatedAt, &s.Version)
+}
+
+const webhookColumns = `id, url, description, secret, event_types, active, created_by, created_at, updated_at,
+	version`
+
+func scanWebhook(row rowScanner, extra ...any) (*WebhookSubscription, error) {
+	var s WebhookSubscription
+	dest := append(extra, &s.ID, &s.URL, &s.Description, &s.Secret, pq.Array(&s.EventTypes), &s.Active,
+		&s.CreatedBy, &s.CreatedAt, &s.UpdatedAt, &s.Version)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	if s.EventTypes == nil {
+		s.EventTypes = []string{}
+	}
+	return &s, nil
+}
+
+func (m *WebhookModel) GetById(ctx context.Context, id int64) (*WebhookSubscription, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `SELECT ` + webhookColumns + ` FROM webhook_subscriptions WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	s, err := scanWebhook(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (m *WebhookModel) GetAll(ctx context.Context, filters Filters) ([]*WebhookSubscription, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), `+webhookColumns+`
+	FROM webhook_subscriptions
+	ORDER BY %s %s, id
+	LIMIT $1 OFFSET $2`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	subscriptions := []*WebhookSubscription{}
+	for rows.Next() {
+		s, err := scanWebhook(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		subscriptions = append(subscriptions, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return subscriptions, metadata, nil
+}
+
+func (m *WebhookModel) Update(ctx context.Context, s *WebhookSubscription) error {
+	query := `UPDATE webhook_subscriptions
+	SET url = $1, description = $2, event_types = $3, active = $4, updated_at = NOW(), version = version + 1
+	WHERE id = $5 AND version = $6
+	RETURNING updated_at, version`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, s.URL, s.Description, pq.Array(s.EventTypes), s.Active, s.ID,
+		s.Version).Scan(&s.UpdatedAt, &s.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes a subscription along with its deliveries.
+func (m *WebhookModel) Delete(ctx context.Context, id int64) error {
+	query := `DELETE FROM webhook_subscriptions WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+var DeliveryStatuses = []string{DeliveryPending, DeliveryDelivered, DeliveryDead}
+
+var ErrDeliveryPending = errors.New("the delivery is still being attempted")
+
+// WebhookDelivery is the delivery of one event to one subscription. A delivery that
+// keeps failing is retried with increasing delays until it runs out of attempts and
+// is dead-lettered, after which it is only retried when asked to be redelivered.
+type WebhookDelivery struct {
+	ID             int64      `json:"id"`
+	SubscriptionID int64      `json:"subscriptionId"`
+	EventID        int64      `json:"eventId"`
+	EventType      string     `json:"eventType"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  time.Time  `json:"nextAttemptAt"`
+	LastAttemptAt  *time.Time `json:"lastAttemptAt"`
+	ResponseStatus *int       `json:"responseStatus"`
+	LastError      string     `json:"lastError"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	DeliveredAt    *time.Time `json:"deliveredAt"`
+	// URL, Secret and Body are only set on deliveries claimed to be sent.
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+	Body   []byte `json:"-"`
+}
+
+type WebhookDeliveryModel struct {
+	DB *sql.DB
+}
+
+// Dispatch creates a delivery of each undispatched event for every active
+// subscription to it, up to limit events, and returns how many were dispatched.
+// Events are locked while they are dispatched, so several instances can dispatch at
+// once without delivering an event twice.
+func (m *WebhookDeliveryModel) Dispatch(ctx context.Context, limit int) (int64, error) {
+	query := `
+	WITH events AS (
+		SELECT id, type FROM outbox_events
+		WHERE dispatched_at IS NULL
+		ORDER BY id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	), deliveries AS (
+		INSERT INTO webhook_deliveries (subscription_id, event_id)
+		SELECT s.id, e.id
+		FROM events e
+		JOIN webhook_subscriptions s ON s.active AND (cardinality(s.event_types) = 0 OR e.type = ANY (s.event_types))
+		ON CONFLICT (subscription_id, event_id) DO NOTHING
+	)
+	UPDATE outbox_events SET dispatched_at = NOW() WHERE id IN (SELECT id FROM events)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ClaimDue returns up to limit deliveries that are due, with what is needed to send
+// them. Claimed deliveries aren't due again until lease has passed, so a delivery
+// whose sender dies part way is picked up again later.
+func (m *WebhookDeliveryModel) ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]*WebhookDelivery, error) {
+	query := `
+	UPDATE webhook_deliveries d
+	SET next_attempt_at = NOW() + $2 * INTERVAL '1 second'
+	FROM webhook_subscriptions s, outbox_events e
+	WHERE d.id IN (
+		SELECT dd.id FROM webhook_deliveries dd
+		JOIN webhook_subscriptions ss ON ss.id = dd.subscription_id
+		WHERE dd.status = 'pending' AND dd.next_attempt_at <= NOW() AND ss.active
+		ORDER BY dd.next_attempt_at
+		LIMIT $1
+		FOR UPDATE OF dd SKIP LOCKED
+	)
+	AND s.id = d.subscription_id AND e.id = d.event_id
+	RETURNING d.id, d.subscription_id, d.event_id, e.type, d.attempts, s.url, s.secret,
+		jsonb_build_object('id', e.id, 'type', e.type, 'createdAt', e.created_at, 'data', e.payload)::text`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*WebhookDelivery{}
+	for rows.Next() {
+		d := WebhookDelivery{Status: DeliveryPending}
+		err := rows.Scan(&d.ID, &d.SubscriptionID, &d.EventID, &d.EventType, &d.Attempts, &d.URL, &d.Secret, &d.Body)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, &d)
+	}
+	return deliveries, rows.Err()
+}
+
+// Delivered records a successful attempt.
+func (m *WebhookDeliveryModel) Delivered(ctx context.Context, d *WebhookDelivery, responseStatus int) error {
+	query := `UPDATE webhook_deliveries
+	SET status = $1, attempts = attempts + 1, last_attempt_at = NOW(), response_status = $2, last_error = '',
+		delivered_at = NOW()
+	WHERE id = $3`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, DeliveryDelivered, responseStatus, d.ID)
+	return err
+}
+
+// Failed records a failed attempt. The delivery is tried again after retryIn, or
+// dead-lettered if retryIn is zero. responseStatus is zero when there was no response.
+func (m *WebhookDeliveryModel) Failed(ctx context.Context, d *WebhookDelivery, responseStatus int, attemptErr error,
+	retryIn time.Duration) error {
+	status := DeliveryPending
+	if retryIn <= 0 {
+		status = DeliveryDead
+	}
+	query := `UPDATE webhook_deliveries
+	SET status = $1, attempts = attempts + 1, last_attempt_at = NOW(), response_status = NULLIF($2, 0),
+		last_error = $3, next_attempt_at = NOW() + $4 * INTERVAL '1 second'
+	WHERE id = $5`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, status, responseStatus, attemptErr.Error(), retryIn.Seconds(), d.ID)
+	return err
+}
+
+const webhookDeliveryColumns = `d.id, d.subscription_id, d.event_id, e.type, d.status, d.attempts,
+	d.next_attempt_at, d.last_attempt_at, d.response_status, d.last_error, d.created_at, d.delivered_at`
+
+func scanWebhookDelivery(row rowScanner, extra ...any) (*WebhookDelivery, error) {
+	var d WebhookDelivery
+	dest := append(extra, &d.ID, &d.SubscriptionID, &d.EventID, &d.EventType, &d.Status, &d.Attempts,
+		&d.NextAttemptAt, &d.LastAttemptAt, &d.ResponseStatus, &d.LastError, &d.CreatedAt, &d.DeliveredAt)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (m *WebhookDeliveryModel) GetById(ctx context.Context, subscriptionID, id int64) (*WebhookDelivery, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `SELECT ` + webhookDeliveryColumns + `
+	FROM webhook_deliveries d
+	JOIN outbox_events e ON e.id = d.event_id
+	WHERE d.subscription_id = $1 AND d.id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	d, err := scanWebhookDelivery(m.DB.QueryRowContext(ctx, query, subscriptionID, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+func (m *WebhookDeliveryModel) GetAll(ctx context.Context, subscriptionID int64, status string,
+	filters Filters) ([]*WebhookDelivery, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), `+webhookDeliveryColumns+`
+	FROM webhook_deliveries d
+	JOIN outbox_events e ON e.id = d.event_id
+	WHERE d.subscription_id = $1 AND (d.status = $2 OR $2 = '')
+	ORDER BY d.%s %s, d.id DESC
+	LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, subscriptionID, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	deliveries := []*WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return deliveries, metadata, nil
+}
+
+// Redeliver queues a delivered or dead-lettered delivery to be sent again straight
+// away, with a fresh set of attempts.
+func (m *WebhookDeliveryModel) Redeliver(ctx context.Context, d *WebhookDelivery) error {
+	query := `UPDATE webhook_deliveries
+	SET status = $1, attempts = 0, next_attempt_at = NOW(), last_error = '', delivered_at = NULL
+	WHERE id = $2 AND status <> $1
+	RETURNING status, attempts, next_attempt_at, delivered_at`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, DeliveryPending, d.ID).
+		Scan(&d.Status, &d.Attempts, &d.NextAttemptAt, &d.DeliveredAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrDeliveryPending
+		default:
+			return err
+		}
+	}
+	d.LastError = ""
+	return nil
+}
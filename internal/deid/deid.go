@@ -0,0 +1,197 @@
+// Package deid de-identifies patient data for research following the HIPAA Safe
+// Harbor method: direct identifiers are removed, dates are reduced to the year and
+// record IDs are replaced with keyed pseudonyms.
+package deid
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// KeySize is the length of a pseudonym key in bytes.
+const KeySize = 32
+
+// NewKey returns a random pseudonym key.
+func NewKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Pseudonymizer replaces IDs with pseudonyms. The same key always gives the same
+// pseudonym for an ID, so records stay linked within a study, but pseudonyms can't be
+// traced back to IDs or linked across studies without the key.
+type Pseudonymizer struct {
+	key []byte
+}
+
+func NewPseudonymizer(key []byte) *Pseudonymizer {
+	return &Pseudonymizer{key: key}
+}
+
+// Pseudonym returns the pseudonym for the ID of a kind of record, such as "patient".
+// Kinds keep IDs of different records from sharing pseudonyms.
+func (p *Pseudonymizer) Pseudonym(kind, id string) string {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(kind + ":" + id))
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// identifierWords are words in a field name that mark it as holding one of the Safe
+// Harbor identifiers, such as a name, contact detail, record number or birth date.
+var identifierWords = map[string]bool{
+	"name": true, "surname": true, "initials": true,
+	"email": true, "phone": true, "telephone": true, "mobile": true, "fax": true,
+	"address": true, "street": true, "city": true, "town": true, "county": true, "zip": true, "zipcode": true,
+	"postcode": true, "postal": true,
+	"ssn": true, "nin": true, "mrn": true, "passport": true, "licence": true, "license": true,
+	"account": true, "policy": true, "member": true, "insurance": true,
+	"vehicle": true, "plate": true, "device": true, "serial": true,
+	"url": true, "website": true, "ip": true,
+	"photo": true, "image": true, "biometric": true, "fingerprint": true,
+	"birth": true, "birthday": true, "dob": true,
+}
+
+// words splits a field name such as "nextOfKin_phone" into lower-case words.
+func words(key string) []string {
+	var out []string
+	var cur []rune
+	flush := func() {
+		if len(cur) > 0 {
+			out = append(out, strings.ToLower(string(cur)))
+			cur = cur[:0]
+		}
+	}
+	runes := []rune(key)
+	for i, r := range runes {
+		switch {
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			flush()
+		case unicode.IsUpper(r) && i > 0 && (unicode.IsLower(runes[i-1]) ||
+			(i+1 < len(runes) && unicode.IsLower(runes[i+1]))):
+			flush()
+			cur = append(cur, r)
+		default:
+			cur = append(cur, r)
+		}
+	}
+	flush()
+	return out
+}
+
+// IsIdentifierField reports whether a field name suggests it holds an identifier.
+func IsIdentifierField(key string) bool {
+	for _, w := range words(key) {
+		if identifierWords[w] {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	emailRX = regexp.MustCompile(`[^@\s]+@[^@\s]+\.[^@\s]+`)
+	phoneRX = regexp.MustCompile(`^\+?[0-9][0-9 ()./-]{6,}[0-9]$`)
+	dateRX  = regexp.MustCompile(`^(\d{4})-\d{2}-\d{2}([T ].*)?$`)
+)
+
+// ScrubJSON de-identifies a JSON object such as the free-form data kept against a
+// patient. Only the top-level fields in keep are kept. Within them, fields whose
+// names suggest identifiers are removed, strings that look like email addresses or
+// phone numbers are removed and dates are reduced to the year. It returns "" when
+// nothing is left.
+func ScrubJSON(raw []byte, keep []string) (string, error) {
+	if len(raw) == 0 || len(keep) == 0 {
+		return "", nil
+	}
+	var obj map[string]any
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return "", err
+	}
+
+	out := map[string]any{}
+	for _, key := range keep {
+		value, ok := obj[key]
+		if !ok || IsIdentifierField(key) {
+			continue
+		}
+		if value, ok = scrub(value); ok {
+			out[key] = value
+		}
+	}
+	if len(out) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(out)
+	return string(b), err
+}
+
+func scrub(value any) (any, bool) {
+	switch v := value.(type) {
+	case map[string]any:
+		out := map[string]any{}
+		for key, inner := range v {
+			if IsIdentifierField(key) {
+				continue
+			}
+			if inner, ok := scrub(inner); ok {
+				out[key] = inner
+			}
+		}
+		return out, true
+	case []any:
+		out := []any{}
+		for _, inner := range v {
+			if inner, ok := scrub(inner); ok {
+				out = append(out, inner)
+			}
+		}
+		return out, true
+	case string:
+		s := strings.TrimSpace(v)
+		if m := dateRX.FindStringSubmatch(s); m != nil {
+			return m[1], true
+		}
+		if emailRX.MatchString(s) || phoneRX.MatchString(s) {
+			return nil, false
+		}
+		return v, true
+	default:
+		return v, true
+	}
+}
+
+// AgeLimit is the age from which Safe Harbor groups ages together.
+const AgeLimit = 90
+
+// Age returns the age in whole years on the given day, grouped as "90+" from
+// AgeLimit, and the birth year, which is left out for those ages.
+func Age(dob, on time.Time) (age string, birthYear string) {
+	years := on.Year() - dob.Year()
+	if on.Month() < dob.Month() || (on.Month() == dob.Month() && on.Day() < dob.Day()) {
+		years--
+	}
+	if years >= AgeLimit {
+		return "90+", ""
+	}
+	return strconv.Itoa(years), strconv.Itoa(dob.Year())
+}
+
+// Year reduces a date or timestamp, as Postgres writes them, to its year. It returns
+// "" for anything else.
+func Year(value string) string {
+	if m := dateRX.FindStringSubmatch(strings.TrimSpace(value)); m != nil {
+		return m[1]
+	}
+	return ""
+}
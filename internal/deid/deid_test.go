@@ -0,0 +1,174 @@
+package deid
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+	"time"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestAge(t *testing.T) {
+	tests := []struct {
+		name      string
+		dob, on   time.Time
+		age, year string
+	}{
+		{"day before the birthday", date(1980, 6, 15), date(2024, 6, 14), "43", "1980"},
+		{"on the birthday", date(1980, 6, 15), date(2024, 6, 15), "44", "1980"},
+		{"newborn", date(2024, 6, 15), date(2024, 6, 15), "0", "2024"},
+		{"leap day before 1 March", date(2000, 2, 29), date(2001, 2, 28), "0", "2000"},
+		{"leap day on 1 March", date(2000, 2, 29), date(2001, 3, 1), "1", "2000"},
+		{"day before turning 90", date(1934, 3, 15), date(2024, 3, 14), "89", "1934"},
+		{"turning 90", date(1934, 3, 15), date(2024, 3, 15), "90+", ""},
+		{"over 90", date(1920, 1, 1), date(2024, 3, 15), "90+", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			age, year := Age(tt.dob, tt.on)
+			if age != tt.age || year != tt.year {
+				t.Errorf("Age(%s, %s) = %q, %q, want %q, %q", tt.dob.Format(time.DateOnly),
+					tt.on.Format(time.DateOnly), age, year, tt.age, tt.year)
+			}
+		})
+	}
+}
+
+func TestWords(t *testing.T) {
+	tests := []struct {
+		key  string
+		want []string
+	}{
+		{"name", []string{"name"}},
+		{"firstName", []string{"first", "name"}},
+		{"nextOfKin_phone", []string{"next", "of", "kin", "phone"}},
+		{"patientID", []string{"patient", "id"}},
+		{"HTTPServer", []string{"http", "server"}},
+		{"home-address 2", []string{"home", "address", "2"}},
+		{"DOB", []string{"dob"}},
+		{"__", nil},
+	}
+	for _, tt := range tests {
+		if got := words(tt.key); !slices.Equal(got, tt.want) {
+			t.Errorf("words(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestIsIdentifierField(t *testing.T) {
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"lastName", true},
+		{"emergency_contact_phone", true},
+		{"ipAddress", true},
+		{"dateOfBirth", true},
+		{"MRN", true},
+		{"insurancePolicyNumber", true},
+		{"bloodGroup", false},
+		{"smoker", false},
+		{"allergyNotes", false},
+	}
+	for _, tt := range tests {
+		if got := IsIdentifierField(tt.key); got != tt.want {
+			t.Errorf("IsIdentifierField(%q) = %t, want %t", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestScrubJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		keep []string
+		want string
+	}{
+		{"nothing kept", `{"bloodGroup": "O+"}`, nil, ""},
+		{"empty", "", []string{"bloodGroup"}, ""},
+		{"only kept fields", `{"bloodGroup": "O+", "smoker": false, "religion": "none"}`,
+			[]string{"bloodGroup", "smoker"}, `{"bloodGroup":"O+","smoker":false}`},
+		{"kept identifier field", `{"bloodGroup": "O+", "maidenName": "Adeyemi"}`,
+			[]string{"bloodGroup", "maidenName"}, `{"bloodGroup":"O+"}`},
+		{"missing field", `{"bloodGroup": "O+"}`, []string{"bloodGroup", "smoker"}, `{"bloodGroup":"O+"}`},
+		{"nested identifiers",
+			`{"nextOfKin": {"relationship": "sister", "fullName": "Ada Okafor", "mobileNumber": "+2348012345678"}}`,
+			[]string{"nextOfKin"}, `{"nextOfKin":{"relationship":"sister"}}`},
+		{"dates reduced to the year",
+			`{"history": {"lastVisit": "2023-11-02", "surgery": "2019-04-30T09:15:00Z"}}`,
+			[]string{"history"}, `{"history":{"lastVisit":"2023","surgery":"2019"}}`},
+		{"contact details in values",
+			`{"contacts": ["ada@example.com", "+234 801 234 5678", "ask at the front desk"], "weeks": 12}`,
+			[]string{"contacts", "weeks"}, `{"contacts":["ask at the front desk"],"weeks":12}`},
+		{"everything removed", `{"notes": "ada@example.com"}`, []string{"notes"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ScrubJSON([]byte(tt.raw), tt.keep)
+			if err != nil {
+				t.Fatalf("ScrubJSON: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ScrubJSON = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ScrubJSON([]byte(`["not", "an", "object"]`), []string{"notes"}); err == nil {
+		t.Error("ScrubJSON of an array: want an error")
+	}
+}
+
+func TestYear(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"2024-03-15", "2024"},
+		{"2024-03-15T12:30:45Z", "2024"},
+		{" 2024-03-15 12:30:45.123+01 ", "2024"},
+		{"15/03/2024", ""},
+		{"2024", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := Year(tt.in); got != tt.want {
+			t.Errorf("Year(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPseudonym(t *testing.T) {
+	p := NewPseudonymizer([]byte("study-key"))
+
+	// The pseudonym must not change between releases, or records exported for a
+	// study before an upgrade would no longer link to those exported after it.
+	if got := p.Pseudonym("patient", "42"); got != "3385fd5d6ec7b16d" {
+		t.Errorf(`Pseudonym("patient", "42") = %q`, got)
+	}
+	if p.Pseudonym("patient", "42") != NewPseudonymizer([]byte("study-key")).Pseudonym("patient", "42") {
+		t.Error("the same key gave different pseudonyms")
+	}
+	if p.Pseudonym("patient", "42") == p.Pseudonym("encounter", "42") {
+		t.Error("records of different kinds share a pseudonym")
+	}
+	if p.Pseudonym("patient", "42") == NewPseudonymizer([]byte("other-key")).Pseudonym("patient", "42") {
+		t.Error("different keys gave the same pseudonym")
+	}
+}
+
+func TestNewKey(t *testing.T) {
+	a, err := NewKey()
+	if err != nil {
+		t.Fatalf("NewKey: %v", err)
+	}
+	b, err := NewKey()
+	if err != nil {
+		t.Fatalf("NewKey: %v", err)
+	}
+	if len(a) != KeySize || bytes.Equal(a, b) {
+		t.Errorf("NewKey gave %x and %x", a, b)
+	}
+}
@@ -0,0 +1,82 @@
+// Package documents renders the printable documents the hospital hands to patients.
+// Each document is a text/template in templates/ using a small line based markup,
+// which is laid out as a PDF:
+//
+//	# Title
+//	## Section heading
+//	### Sub heading
+//	- bullet point
+//
+// Any other line is a paragraph and blank lines add space.
+package documents
+
+import (
+	"bytes"
+	"embed"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/muyiwadosunmu/hospital-management/internal/data"
+	"github.com/muyiwadosunmu/hospital-management/internal/pdf"
+)
+
+//go:embed "templates"
+var templateFS embed.FS
+
+// DischargeSummary is everything that goes into a patient's discharge summary.
+type DischargeSummary struct {
+	Hospital      string
+	GeneratedAt   time.Time
+	Patient       *data.Patient
+	Doctor        *data.Doctor
+	Admission     *data.Admission
+	Problems      []*data.Problem
+	Prescriptions []*data.Prescription
+	Note          *data.Note
+}
+
+// Render lays the discharge summary out as a PDF.
+func (s DischargeSummary) Render() ([]byte, error) {
+	return render("discharge_summary.tmpl", "Discharge summary", s)
+}
+
+func render(templateFile, title string, data any) ([]byte, error) {
+	tmpl, err := template.New(templateFile).ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var text bytes.Buffer
+	if err := tmpl.Execute(&text, data); err != nil {
+		return nil, err
+	}
+
+	doc := pdf.New(title)
+	for _, line := range strings.Split(text.String(), "\n") {
+		switch {
+		case strings.HasPrefix(line, "# "):
+			doc.SetFont(true, 18)
+			doc.Text(strings.TrimPrefix(line, "# "))
+			doc.Space(4)
+		case strings.HasPrefix(line, "## "):
+			doc.Space(8)
+			doc.SetFont(true, 12)
+			doc.Text(strings.TrimPrefix(line, "## "))
+			doc.Rule()
+		case strings.HasPrefix(line, "### "):
+			doc.Space(4)
+			doc.SetFont(true, 10)
+			doc.Text(strings.TrimPrefix(line, "### "))
+		case strings.HasPrefix(line, "- "):
+			doc.SetFont(false, 10)
+			doc.TextIndent(strings.TrimPrefix(line, "- "), 14, "•")
+		case strings.TrimSpace(line) == "":
+			doc.Space(4)
+		default:
+			doc.SetFont(false, 10)
+			doc.Text(line)
+		}
+	}
+	return doc.Bytes(), nil
+}
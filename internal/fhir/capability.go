@@ -0,0 +1,98 @@
+package fhir
+
+import "time"
+
+type SearchParam struct {
+	Name          string `json:"name"`
+	Type          string `json:"type"`
+	Documentation string `json:"documentation,omitempty"`
+}
+
+type Interaction struct {
+	Code string `json:"code"`
+}
+
+type RestResource struct {
+	Type              string        `json:"type"`
+	Interaction       []Interaction `json:"interaction"`
+	Versioning        string        `json:"versioning"`
+	ReadHistory       bool          `json:"readHistory"`
+	UpdateCreate      bool          `json:"updateCreate"`
+	ConditionalCreate bool          `json:"conditionalCreate"`
+	SearchParam       []SearchParam `json:"searchParam"`
+}
+
+type Security struct {
+	Service     []CodeableConcept `json:"service,omitempty"`
+	Description string            `json:"description,omitempty"`
+}
+
+type Rest struct {
+	Mode     string         `json:"mode"`
+	Security *Security      `json:"security,omitempty"`
+	Resource []RestResource `json:"resource"`
+}
+
+type Software struct {
+	Name string `json:"name"`
+}
+
+type Implementation struct {
+	Description string `json:"description"`
+	URL         string `json:"url"`
+}
+
+type CapabilityStatement struct {
+	ResourceType   string         `json:"resourceType"`
+	Status         string         `json:"status"`
+	Date           string         `json:"date"`
+	Kind           string         `json:"kind"`
+	Software       Software       `json:"software"`
+	Implementation Implementation `json:"implementation"`
+	FHIRVersion    string         `json:"fhirVersion"`
+	Format         []string       `json:"format"`
+	Rest           []Rest         `json:"rest"`
+}
+
+// NewCapabilityStatement describes a server at baseURL offering read, search, create
+// and update of the given resources.
+func NewCapabilityStatement(name, baseURL string, resources ...RestResource) *CapabilityStatement {
+	return &CapabilityStatement{
+		ResourceType:   "CapabilityStatement",
+		Status:         "active",
+		Date:           time.Now().UTC().Format("2006-01-02"),
+		Kind:           "instance",
+		Software:       Software{Name: name},
+		Implementation: Implementation{Description: name + " FHIR facade", URL: baseURL},
+		FHIRVersion:    Version,
+		Format:         []string{"json", ContentType},
+		Rest: []Rest{{
+			Mode: "server",
+			Security: &Security{
+				Service: []CodeableConcept{{Coding: []Coding{{
+					System: "http://terminology.hl7.org/CodeSystem/restful-security-service",
+					Code:   "OAuth",
+				}}}},
+				Description: "Send a receptionist bearer token in the Authorization header.",
+			},
+			Resource: resources,
+		}},
+	}
+}
+
+// CRUDResource describes a resource type supporting read, search, create and update.
+func CRUDResource(resourceType string, params ...SearchParam) RestResource {
+	params = append(params,
+		SearchParam{Name: "_id", Type: "token"},
+		SearchParam{Name: "_count", Type: "number", Documentation: "Page size, at most 100."},
+		SearchParam{Name: "_page", Type: "number", Documentation: "Page number, starting at 1."},
+	)
+	return RestResource{
+		Type: resourceType,
+		Interaction: []Interaction{
+			{Code: "read"}, {Code: "search-type"}, {Code: "create"}, {Code: "update"},
+		},
+		Versioning:  "versioned-update",
+		SearchParam: params,
+	}
+}
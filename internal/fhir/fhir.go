@@ -0,0 +1,279 @@
+// Package fhir holds the subset of FHIR R4 resources and search parameter parsing the
+// API's FHIR facade needs. Resources are plain structs that marshal to FHIR JSON.
+package fhir
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ContentType is the media type FHIR JSON is sent with.
+const ContentType = "application/fhir+json"
+
+const Version = "4.0.1"
+
+// Issue severities and codes used in OperationOutcome.
+const (
+	SeverityError   = "error"
+	SeverityFatal   = "fatal"
+	SeverityWarning = "warning"
+
+	IssueInvalid      = "invalid"
+	IssueRequired     = "required"
+	IssueValue        = "value"
+	IssueNotFound     = "not-found"
+	IssueNotSupported = "not-supported"
+	IssueConflict     = "conflict"
+	IssueDuplicate    = "duplicate"
+	IssueSecurity     = "security"
+	IssueException    = "exception"
+	IssueProcessing   = "processing"
+)
+
+type Meta struct {
+	VersionID   string     `json:"versionId,omitempty"`
+	LastUpdated *time.Time `json:"lastUpdated,omitempty"`
+}
+
+type Identifier struct {
+	Use    string `json:"use,omitempty"`
+	System string `json:"system,omitempty"`
+	Value  string `json:"value,omitempty"`
+}
+
+type HumanName struct {
+	Use    string   `json:"use,omitempty"`
+	Text   string   `json:"text,omitempty"`
+	Family string   `json:"family,omitempty"`
+	Given  []string `json:"given,omitempty"`
+	Prefix []string `json:"prefix,omitempty"`
+}
+
+type ContactPoint struct {
+	System string `json:"system,omitempty"`
+	Value  string `json:"value,omitempty"`
+	Use    string `json:"use,omitempty"`
+}
+
+type Coding struct {
+	System  string `json:"system,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Display string `json:"display,omitempty"`
+}
+
+type CodeableConcept struct {
+	Coding []Coding `json:"coding,omitempty"`
+	Text   string   `json:"text,omitempty"`
+}
+
+type Reference struct {
+	Reference string `json:"reference,omitempty"`
+	Display   string `json:"display,omitempty"`
+}
+
+type Patient struct {
+	ResourceType string         `json:"resourceType"`
+	ID           string         `json:"id,omitempty"`
+	Meta         *Meta          `json:"meta,omitempty"`
+	Identifier   []Identifier   `json:"identifier,omitempty"`
+	Active       *bool          `json:"active,omitempty"`
+	Name         []HumanName    `json:"name,omitempty"`
+	Telecom      []ContactPoint `json:"telecom,omitempty"`
+	BirthDate    string         `json:"birthDate,omitempty"`
+}
+
+type Qualification struct {
+	Identifier []Identifier    `json:"identifier,omitempty"`
+	Code       CodeableConcept `json:"code"`
+}
+
+type Practitioner struct {
+	ResourceType  string          `json:"resourceType"`
+	ID            string          `json:"id,omitempty"`
+	Meta          *Meta           `json:"meta,omitempty"`
+	Identifier    []Identifier    `json:"identifier,omitempty"`
+	Active        *bool           `json:"active,omitempty"`
+	Name          []HumanName     `json:"name,omitempty"`
+	Telecom       []ContactPoint  `json:"telecom,omitempty"`
+	Qualification []Qualification `json:"qualification,omitempty"`
+}
+
+// OfficialName returns the name to store for a person: the official one if there is
+// one, otherwise the first.
+func OfficialName(names []HumanName) (HumanName, bool) {
+	for _, n := range names {
+		if n.Use == "official" {
+			return n, true
+		}
+	}
+	if len(names) == 0 {
+		return HumanName{}, false
+	}
+	return names[0], true
+}
+
+// Telecom returns the first contact point of the given system, such as "email" or
+// "phone".
+func Telecom(points []ContactPoint, system string) string {
+	for _, p := range points {
+		if p.System == system && p.Value != "" {
+			return p.Value
+		}
+	}
+	return ""
+}
+
+// IdentifierValue returns the value of the first identifier in the system.
+func IdentifierValue(ids []Identifier, system string) string {
+	for _, id := range ids {
+		if id.System == system {
+			return id.Value
+		}
+	}
+	return ""
+}
+
+type BundleLink struct {
+	Relation string `json:"relation"`
+	URL      string `json:"url"`
+}
+
+type BundleSearch struct {
+	Mode string `json:"mode,omitempty"`
+}
+
+type BundleEntry struct {
+	FullURL  string        `json:"fullUrl,omitempty"`
+	Resource any           `json:"resource"`
+	Search   *BundleSearch `json:"search,omitempty"`
+}
+
+type Bundle struct {
+	ResourceType string        `json:"resourceType"`
+	Type         string        `json:"type"`
+	Timestamp    time.Time     `json:"timestamp"`
+	Total        int           `json:"total"`
+	Link         []BundleLink  `json:"link,omitempty"`
+	Entry        []BundleEntry `json:"entry,omitempty"`
+}
+
+// NewSearchset returns an empty searchset Bundle matching total resources.
+func NewSearchset(total int) *Bundle {
+	return &Bundle{ResourceType: "Bundle", Type: "searchset", Timestamp: time.Now().UTC(), Total: total}
+}
+
+// AddMatch adds a resource found by the search.
+func (b *Bundle) AddMatch(fullURL string, resource any) {
+	b.Entry = append(b.Entry, BundleEntry{FullURL: fullURL, Resource: resource, Search: &BundleSearch{Mode: "match"}})
+}
+
+type Issue struct {
+	Severity    string   `json:"severity"`
+	Code        string   `json:"code"`
+	Diagnostics string   `json:"diagnostics,omitempty"`
+	Expression  []string `json:"expression,omitempty"`
+}
+
+type OperationOutcome struct {
+	ResourceType string  `json:"resourceType"`
+	Issue        []Issue `json:"issue"`
+}
+
+// NewOperationOutcome returns an outcome with a single issue.
+func NewOperationOutcome(severity, code, diagnostics string) *OperationOutcome {
+	return &OperationOutcome{
+		ResourceType: "OperationOutcome",
+		Issue:        []Issue{{Severity: severity, Code: code, Diagnostics: diagnostics}},
+	}
+}
+
+// AddIssue adds an error about the element at expression.
+func (o *OperationOutcome) AddIssue(code, expression, diagnostics string) {
+	o.Issue = append(o.Issue, Issue{
+		Severity:    SeverityError,
+		Code:        code,
+		Diagnostics: diagnostics,
+		Expression:  []string{expression},
+	})
+}
+
+var ErrInvalidDate = errors.New("must be a date in the form YYYY, YYYY-MM or YYYY-MM-DD, optionally prefixed with eq, lt, le, gt or ge")
+
+// DateParam is a parsed date search parameter. The value covers [Start, End), so
+// "2020" matches the whole year.
+type DateParam struct {
+	Prefix string
+	Start  time.Time
+	End    time.Time
+}
+
+// ParseDateParam parses a date search value such as "ge1990-01" or "2001-02-03".
+func ParseDateParam(s string) (DateParam, error) {
+	p := DateParam{Prefix: "eq"}
+	for _, prefix := range []string{"eq", "lt", "le", "gt", "ge"} {
+		if strings.HasPrefix(s, prefix) {
+			p.Prefix, s = prefix, s[len(prefix):]
+			break
+		}
+	}
+
+	var err error
+	switch len(s) {
+	case len("2006"):
+		p.Start, err = time.Parse("2006", s)
+		p.End = p.Start.AddDate(1, 0, 0)
+	case len("2006-01"):
+		p.Start, err = time.Parse("2006-01", s)
+		p.End = p.Start.AddDate(0, 1, 0)
+	case len("2006-01-02"):
+		p.Start, err = time.Parse("2006-01-02", s)
+		p.End = p.Start.AddDate(0, 0, 1)
+	default:
+		err = ErrInvalidDate
+	}
+	if err != nil {
+		return DateParam{}, ErrInvalidDate
+	}
+	return p, nil
+}
+
+// Bounds returns the range of dates matching the parameter as [from, to). A nil bound
+// is open.
+func (p DateParam) Bounds() (from, to *time.Time) {
+	switch p.Prefix {
+	case "lt":
+		return nil, &p.Start
+	case "le":
+		return nil, &p.End
+	case "gt":
+		return &p.End, nil
+	case "ge":
+		return &p.Start, nil
+	default:
+		return &p.Start, &p.End
+	}
+}
+
+// SplitToken splits a token search value of the form [system|]code.
+func SplitToken(s string) (system, code string, hasSystem bool) {
+	if i := strings.IndexByte(s, '|'); i >= 0 {
+		return s[:i], s[i+1:], true
+	}
+	return "", s, false
+}
+
+// ParseETag reads the version from an If-Match value such as W/"3".
+func ParseETag(s string) (string, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "W/")
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return "", fmt.Errorf("invalid ETag %q", s)
+	}
+	return s[1 : len(s)-1], nil
+}
+
+// ETag returns the weak ETag for a resource version.
+func ETag(version int64) string {
+	return fmt.Sprintf(`W/"%d"`, version)
+}
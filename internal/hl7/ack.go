@@ -0,0 +1,116 @@
+package hl7
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Acknowledgement codes sent in MSA-1.
+const (
+	AckAccept = "AA"
+	AckError  = "AE"
+	AckReject = "AR"
+)
+
+// Error codes from HL7 table 0357.
+const (
+	CodeRequiredFieldMissing = 101
+	CodeDataTypeError        = 102
+	CodeUnsupportedMessage   = 200
+	CodeUnsupportedEvent     = 201
+	CodeUnknownKey           = 204
+	CodeDuplicateKey         = 205
+	CodeInternalError        = 207
+)
+
+var codeNames = map[int]string{
+	CodeRequiredFieldMissing: "Required field missing",
+	CodeDataTypeError:        "Data type error",
+	CodeUnsupportedMessage:   "Unsupported message type",
+	CodeUnsupportedEvent:     "Unsupported event code",
+	CodeUnknownKey:           "Unknown key identifier",
+	CodeDuplicateKey:         "Duplicate key identifier",
+	CodeInternalError:        "Application internal error",
+}
+
+// Error is a problem with a message, reported back in the ERR segment of its
+// acknowledgement.
+type Error struct {
+	Code int
+	Text string
+}
+
+func (e *Error) Error() string {
+	return e.Text
+}
+
+func NewError(code int, text string) *Error {
+	return &Error{Code: code, Text: text}
+}
+
+// Sender identifies us in the MSH of acknowledgements.
+type Sender struct {
+	Application string
+	Facility    string
+}
+
+// BuildAck returns the acknowledgement for in. A non-nil err is reported in an ERR
+// segment, with error code 207 unless it is an *Error. in may be nil when the message
+// couldn't be parsed at all.
+func BuildAck(in *Message, from Sender, code string, err error, now time.Time) []byte {
+	d := DefaultDelimiters
+	var toApp, toFacility, event, controlID, processingID, version string
+	processingID, version = "P", "2.5"
+	if in != nil {
+		d = in.Delimiters
+		toApp, toFacility = in.SendingApplication(), in.SendingFacility()
+		_, event = in.Type()
+		controlID = in.ControlID()
+		if id := in.ProcessingID(); id != "" {
+			processingID = id
+		}
+		if v := in.Version(); v != "" {
+			version = v
+		}
+	}
+
+	ackID := "ACK" + controlID
+	if len(ackID) > 20 {
+		ackID = ackID[:20]
+	}
+
+	field := string(d.Field)
+	component := string(d.Component)
+	segments := []string{
+		strings.Join([]string{
+			"MSH", d.encodingCharacters(), d.EscapeText(from.Application), d.EscapeText(from.Facility),
+			d.EscapeText(toApp), d.EscapeText(toFacility), FormatTime(now), "",
+			"ACK" + component + d.EscapeText(event) + component + "ACK",
+			d.EscapeText(ackID), d.EscapeText(processingID), d.EscapeText(version),
+		}, field),
+	}
+
+	text := ""
+	if err != nil {
+		text = err.Error()
+	}
+	segments = append(segments, strings.Join([]string{"MSA", code, d.EscapeText(controlID), d.EscapeText(text)}, field))
+
+	if err != nil {
+		errCode := CodeInternalError
+		var hl7Err *Error
+		if errors.As(err, &hl7Err) {
+			errCode = hl7Err.Code
+		}
+		severity := "E"
+		segments = append(segments, strings.Join([]string{
+			"ERR", "", "",
+			strconv.Itoa(errCode) + component + codeNames[errCode] + component + "HL70357",
+			severity, "", "", "", d.EscapeText(text),
+		}, field))
+	}
+
+	return []byte(strings.Join(segments, "\r") + "\r")
+}
@@ -0,0 +1,112 @@
+package hl7
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var ackTime = time.Date(2024, 3, 15, 12, 31, 0, 0, time.UTC)
+
+var hms = Sender{Application: "HMS", Facility: "General Hospital"}
+
+func TestBuildAck(t *testing.T) {
+	in, err := Parse([]byte(admitMessage))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		code    string
+		err     error
+		msa     string
+		errCode string
+	}{
+		{"accepted", AckAccept, nil, "MSA|AA|MSG00001|", ""},
+		{"hl7 error", AckError, NewError(CodeRequiredFieldMissing, "PID-3 is required"),
+			"MSA|AE|MSG00001|PID-3 is required", "101^Required field missing^HL70357"},
+		{"other error", AckError, errors.New("connection refused"),
+			"MSA|AE|MSG00001|connection refused", "207^Application internal error^HL70357"},
+		{"escaped text", AckReject, NewError(CodeUnsupportedEvent, "A99|A98 not supported"),
+			`MSA|AR|MSG00001|A99\F\A98 not supported`, "201^Unsupported event code^HL70357"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := BuildAck(in, hms, tt.code, tt.err, ackTime)
+			if !strings.HasSuffix(string(raw), "\r") {
+				t.Error("acknowledgement doesn't end with a segment separator")
+			}
+			segments := strings.Split(strings.TrimSuffix(string(raw), "\r"), "\r")
+
+			wantMSH := "MSH|^~\\&|HMS|General Hospital|PAS|GENERAL|20240315123100+0000||ACK^A01^ACK|ACKMSG00001|P|2.5"
+			if segments[0] != wantMSH {
+				t.Errorf("MSH =\n%s\nwant\n%s", segments[0], wantMSH)
+			}
+			if segments[1] != tt.msa {
+				t.Errorf("MSA = %q, want %q", segments[1], tt.msa)
+			}
+
+			ack, err := Parse(raw)
+			if err != nil {
+				t.Fatalf("Parse(ack): %v", err)
+			}
+			errSeg, ok := ack.Segment("ERR")
+			if tt.errCode == "" {
+				if ok || len(segments) != 2 {
+					t.Errorf("accepted message has segments %q", segments)
+				}
+				return
+			}
+			if !ok {
+				t.Fatal("no ERR segment")
+			}
+			if errSeg.Field(3) != tt.errCode || errSeg.Field(4) != "E" {
+				t.Errorf("ERR-3, ERR-4 = %q, %q, want %q, E", errSeg.Field(3), errSeg.Field(4), tt.errCode)
+			}
+			if got := errSeg.Value(8); got != tt.err.Error() {
+				t.Errorf("ERR-8 = %q, want %q", got, tt.err.Error())
+			}
+		})
+	}
+}
+
+func TestBuildAckUnparsed(t *testing.T) {
+	raw := BuildAck(nil, hms, AckReject, ErrNoMSH, ackTime)
+	ack, err := Parse(raw)
+	if err != nil {
+		t.Fatalf("Parse(ack): %v", err)
+	}
+	if got := ack.ControlID(); got != "ACK" {
+		t.Errorf("ControlID() = %q, want ACK", got)
+	}
+	if got := ack.ProcessingID(); got != "P" {
+		t.Errorf("ProcessingID() = %q, want P", got)
+	}
+	if got := ack.Version(); got != "2.5" {
+		t.Errorf("Version() = %q, want 2.5", got)
+	}
+	msa, _ := ack.Segment("MSA")
+	if msa.Value(1) != AckReject || msa.Value(2) != "" {
+		t.Errorf("MSA = %q", strings.Join(msa.fields, "|"))
+	}
+}
+
+func TestBuildAckControlIDLength(t *testing.T) {
+	in, err := Parse([]byte(strings.Replace(admitMessage, "MSG00001", "20240315123045000123", 1)))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	ack, err := Parse(BuildAck(in, hms, AckAccept, nil, ackTime))
+	if err != nil {
+		t.Fatalf("Parse(ack): %v", err)
+	}
+	if got := ack.ControlID(); len(got) != 20 || !strings.HasPrefix(got, "ACK") {
+		t.Errorf("ControlID() = %q, want the first 20 characters", got)
+	}
+	msa, _ := ack.Segment("MSA")
+	if got := msa.Value(2); got != "20240315123045000123" {
+		t.Errorf("MSA-2 = %q, want the original control ID", got)
+	}
+}
@@ -0,0 +1,129 @@
+package hl7
+
+import (
+	"strings"
+	"time"
+)
+
+// Identifier is one repetition of a CX field such as PID-3.
+type Identifier struct {
+	Value     string `json:"value"`
+	Authority string `json:"authority"`
+	Type      string `json:"type"`
+}
+
+// Person is what we read from a PID segment.
+type Person struct {
+	Identifiers []Identifier
+	Family      string
+	Given       string
+	BirthDate   *time.Time
+	Sex         string
+	Email       string
+	Phone       string
+}
+
+// ParsePID reads the patient identifiers (PID-3), name (PID-5), birth date (PID-7),
+// sex (PID-8) and home contact details (PID-13).
+func ParsePID(pid Segment) (*Person, error) {
+	p := &Person{
+		Family: pid.Component(5, 1),
+		Given:  pid.Component(5, 2),
+		Sex:    pid.Value(8),
+	}
+
+	for _, rep := range pid.Repetitions(3) {
+		cx := pid.Components(rep)
+		id := Identifier{Value: cx[0]}
+		if len(cx) > 3 {
+			id.Authority = strings.Split(cx[3], string(pid.delims.Subcomponent))[0]
+		}
+		if len(cx) > 4 {
+			id.Type = cx[4]
+		}
+		if id.Value != "" {
+			p.Identifiers = append(p.Identifiers, id)
+		}
+	}
+
+	if raw := pid.Value(7); raw != "" {
+		t, err := ParseTime(raw, time.UTC)
+		if err != nil {
+			return nil, NewError(CodeDataTypeError, "PID-7 is not a valid date of birth")
+		}
+		dob := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		p.BirthDate = &dob
+	}
+
+	// XTN: the number is in XTN-1 (or XTN-12 in later versions), and an email
+	// address in XTN-4 with an equipment type of Internet.
+	for _, rep := range pid.Repetitions(13) {
+		xtn := pid.Components(rep)
+		get := func(i int) string {
+			if i < len(xtn) {
+				return strings.TrimSpace(xtn[i])
+			}
+			return ""
+		}
+		switch {
+		case get(3) != "" || get(1) == "NET" || strings.EqualFold(get(2), "Internet"):
+			if p.Email == "" {
+				p.Email = get(3)
+				if p.Email == "" {
+					p.Email = get(0)
+				}
+			}
+		case p.Phone == "":
+			p.Phone = get(0)
+			if p.Phone == "" {
+				p.Phone = get(11)
+			}
+		}
+	}
+	return p, nil
+}
+
+// Visit is what we read from a PV1 segment.
+type Visit struct {
+	PatientClass string     `json:"patientClass"`
+	Location     string     `json:"location,omitempty"`
+	Attending    string     `json:"attending,omitempty"`
+	VisitNumber  string     `json:"visitNumber,omitempty"`
+	AdmitTime    *time.Time `json:"admitTime,omitempty"`
+}
+
+// ParsePV1 reads the patient class (PV1-2), assigned location (PV1-3), attending
+// doctor (PV1-7), visit number (PV1-19) and admit time (PV1-44).
+func ParsePV1(pv1 Segment) *Visit {
+	v := &Visit{
+		PatientClass: pv1.Value(2),
+		VisitNumber:  pv1.Value(19),
+	}
+
+	location := []string{}
+	for i := 1; i <= 3; i++ {
+		if c := pv1.Component(3, i); c != "" {
+			location = append(location, c)
+		}
+	}
+	v.Location = strings.Join(location, "/")
+
+	// XCN: ID^family^given
+	attending := []string{}
+	for _, i := range []int{3, 2} {
+		if c := pv1.Component(7, i); c != "" {
+			attending = append(attending, c)
+		}
+	}
+	v.Attending = strings.Join(attending, " ")
+	if id := pv1.Component(7, 1); id != "" {
+		v.Attending = strings.TrimSpace(v.Attending + " (" + id + ")")
+	}
+
+	if raw := pv1.Value(44); raw != "" {
+		if t, err := ParseTime(raw, time.UTC); err == nil {
+			v.AdmitTime = &t
+		}
+	}
+	return v
+}
@@ -0,0 +1,255 @@
+// Package hl7 parses HL7 v2 messages, builds the acknowledgements sent back for them
+// and serves them over MLLP.
+package hl7
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNoMSH      = errors.New("message does not start with an MSH segment")
+	ErrBadMSH     = errors.New("MSH segment is too short")
+	ErrNoSegments = errors.New("message is empty")
+)
+
+// Delimiters are the separators declared in MSH-1 and MSH-2.
+type Delimiters struct {
+	Field        byte
+	Component    byte
+	Repetition   byte
+	Escape       byte
+	Subcomponent byte
+}
+
+var DefaultDelimiters = Delimiters{'|', '^', '~', '\\', '&'}
+
+func (d Delimiters) encodingCharacters() string {
+	return string([]byte{d.Component, d.Repetition, d.Escape, d.Subcomponent})
+}
+
+// Segment is a parsed segment. Fields are numbered as in the HL7 specification, so
+// Field(3) of a PID segment is PID-3. For MSH, Field(1) is the field separator.
+type Segment struct {
+	fields []string
+	delims Delimiters
+}
+
+func (s Segment) Name() string {
+	return s.fields[0]
+}
+
+// Field returns field n still escaped, or "" if the segment doesn't have it.
+func (s Segment) Field(n int) string {
+	if n < 0 || n >= len(s.fields) {
+		return ""
+	}
+	return s.fields[n]
+}
+
+// Repetitions splits field n into its repetitions.
+func (s Segment) Repetitions(n int) []string {
+	field := s.Field(n)
+	if field == "" {
+		return nil
+	}
+	if s.Name() == "MSH" && n <= 2 {
+		return []string{field}
+	}
+	return strings.Split(field, string(s.delims.Repetition))
+}
+
+// Components splits a field or repetition into its unescaped components.
+func (s Segment) Components(value string) []string {
+	parts := strings.Split(value, string(s.delims.Component))
+	for i, p := range parts {
+		parts[i] = s.Unescape(p)
+	}
+	return parts
+}
+
+// Component returns component c (counting from 1) of the first repetition of field n,
+// unescaped.
+func (s Segment) Component(n, c int) string {
+	reps := s.Repetitions(n)
+	if len(reps) == 0 {
+		return ""
+	}
+	components := s.Components(reps[0])
+	if c < 1 || c > len(components) {
+		return ""
+	}
+	return components[c-1]
+}
+
+// Value returns the first component of field n, unescaped.
+func (s Segment) Value(n int) string {
+	return s.Component(n, 1)
+}
+
+// Unescape replaces HL7 escape sequences such as \F\ with the characters they stand
+// for. Formatting and hexadecimal sequences are dropped.
+func (s Segment) Unescape(value string) string {
+	esc := s.delims.Escape
+	if strings.IndexByte(value, esc) < 0 {
+		return value
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != esc {
+			b.WriteByte(value[i])
+			continue
+		}
+		end := strings.IndexByte(value[i+1:], esc)
+		if end < 0 {
+			b.WriteString(value[i:])
+			break
+		}
+		switch seq := value[i+1 : i+1+end]; seq {
+		case "F":
+			b.WriteByte(s.delims.Field)
+		case "S":
+			b.WriteByte(s.delims.Component)
+		case "R":
+			b.WriteByte(s.delims.Repetition)
+		case "E":
+			b.WriteByte(s.delims.Escape)
+		case "T":
+			b.WriteByte(s.delims.Subcomponent)
+		case ".br":
+			b.WriteByte('\n')
+		}
+		i += end + 1
+	}
+	return b.String()
+}
+
+// Message is a parsed HL7 v2 message.
+type Message struct {
+	Segments   []Segment
+	Delimiters Delimiters
+}
+
+// Parse splits a message into segments. Segments may be separated by CR, LF or CRLF.
+func Parse(raw []byte) (*Message, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return nil, ErrNoSegments
+	}
+	if !bytes.HasPrefix(raw, []byte("MSH")) {
+		return nil, ErrNoMSH
+	}
+	if len(raw) < 8 {
+		return nil, ErrBadMSH
+	}
+
+	d := Delimiters{
+		Field:        raw[3],
+		Component:    raw[4],
+		Repetition:   raw[5],
+		Escape:       raw[6],
+		Subcomponent: raw[7],
+	}
+
+	lines := strings.FieldsFunc(string(raw), func(r rune) bool { return r == '\r' || r == '\n' })
+	msg := &Message{Delimiters: d}
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.Split(line, string(d.Field))
+		if fields[0] == "MSH" {
+			// Put the field separator back in as MSH-1 so fields keep their numbers.
+			fields = append([]string{"MSH", string(d.Field)}, fields[1:]...)
+		}
+		msg.Segments = append(msg.Segments, Segment{fields: fields, delims: d})
+	}
+	if len(msg.Segments[0].fields) < 12 {
+		return nil, ErrBadMSH
+	}
+	return msg, nil
+}
+
+// Segment returns the first segment with the given name.
+func (m *Message) Segment(name string) (Segment, bool) {
+	for _, s := range m.Segments {
+		if s.Name() == name {
+			return s, true
+		}
+	}
+	return Segment{}, false
+}
+
+func (m *Message) msh() Segment {
+	return m.Segments[0]
+}
+
+func (m *Message) SendingApplication() string { return m.msh().Value(3) }
+func (m *Message) SendingFacility() string    { return m.msh().Value(4) }
+func (m *Message) ControlID() string          { return m.msh().Value(10) }
+func (m *Message) ProcessingID() string       { return m.msh().Value(11) }
+func (m *Message) Version() string            { return m.msh().Value(12) }
+
+// Type returns the message code and trigger event from MSH-9, such as "ADT" and "A01".
+func (m *Message) Type() (code, event string) {
+	return m.msh().Component(9, 1), m.msh().Component(9, 2)
+}
+
+// EscapeText escapes the delimiters in value so it can be written into a field.
+func (d Delimiters) EscapeText(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case d.Field:
+			b.WriteString(string(d.Escape) + "F" + string(d.Escape))
+		case d.Component:
+			b.WriteString(string(d.Escape) + "S" + string(d.Escape))
+		case d.Repetition:
+			b.WriteString(string(d.Escape) + "R" + string(d.Escape))
+		case d.Subcomponent:
+			b.WriteString(string(d.Escape) + "T" + string(d.Escape))
+		case d.Escape:
+			b.WriteString(string(d.Escape) + "E" + string(d.Escape))
+		case '\r', '\n':
+			b.WriteString(string(d.Escape) + ".br" + string(d.Escape))
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// ParseTime parses an HL7 DTM value, YYYY[MM[DD[HH[MM[SS[.S+]]]]]][+/-ZZZZ]. Values
+// without an offset are read in loc.
+func ParseTime(value string, loc *time.Location) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, errors.New("empty time")
+	}
+	offset := ""
+	if i := strings.IndexAny(value, "+-"); i >= 0 {
+		value, offset = value[:i], value[i:]
+	}
+	if i := strings.IndexByte(value, '.'); i >= 0 {
+		value = value[:i]
+	}
+
+	layouts := map[int]string{4: "2006", 6: "200601", 8: "20060102", 10: "2006010215", 12: "200601021504",
+		14: "20060102150405"}
+	layout, ok := layouts[len(value)]
+	if !ok {
+		return time.Time{}, fmt.Errorf("invalid HL7 time %q", value)
+	}
+	if offset != "" {
+		return time.Parse(layout+"-0700", value+offset)
+	}
+	return time.ParseInLocation(layout, value, loc)
+}
+
+// FormatTime formats t as an HL7 DTM with seconds and offset.
+func FormatTime(t time.Time) string {
+	return t.Format("20060102150405-0700")
+}
@@ -0,0 +1,189 @@
+package hl7
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+const admitMessage = "MSH|^~\\&|PAS|GENERAL|HMS|HMS|20240315123045||ADT^A01^ADT_A01|MSG00001|P|2.5\r" +
+	"EVN|A01|20240315123045\r" +
+	"PID|1||12345^^^PAS^MR~99887766^^^NHS^NH||Okafor^Ada^N||19850704|F|||||^NET^Internet^ada@example.com\r" +
+	"PV1|1|I|WARD1^12^A||||D042^Bello^Tunde|||||||||||V778\r"
+
+func TestParse(t *testing.T) {
+	for name, raw := range map[string]string{
+		"CR":   admitMessage,
+		"LF":   "MSH|^~\\&|PAS|GENERAL|HMS|HMS|20240315123045||ADT^A01^ADT_A01|MSG00001|P|2.5\nEVN|A01\nPID|1\n",
+		"CRLF": "MSH|^~\\&|PAS|GENERAL|HMS|HMS|20240315123045||ADT^A01^ADT_A01|MSG00001|P|2.5\r\nEVN|A01\r\nPID|1\r\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			msg, err := Parse([]byte(raw))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if len(msg.Segments) < 3 {
+				t.Fatalf("got %d segments, want at least 3", len(msg.Segments))
+			}
+			if code, event := msg.Type(); code != "ADT" || event != "A01" {
+				t.Errorf("Type() = %q, %q, want ADT, A01", code, event)
+			}
+			if got := msg.ControlID(); got != "MSG00001" {
+				t.Errorf("ControlID() = %q", got)
+			}
+			if got := msg.SendingApplication(); got != "PAS" {
+				t.Errorf("SendingApplication() = %q", got)
+			}
+			if got := msg.Version(); got != "2.5" {
+				t.Errorf("Version() = %q", got)
+			}
+			msh := msg.Segments[0]
+			if msh.Field(1) != "|" || msh.Field(2) != "^~\\&" {
+				t.Errorf("MSH-1, MSH-2 = %q, %q", msh.Field(1), msh.Field(2))
+			}
+		})
+	}
+}
+
+func TestParseFields(t *testing.T) {
+	msg, err := Parse([]byte(admitMessage))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	pid, ok := msg.Segment("PID")
+	if !ok {
+		t.Fatal("no PID segment")
+	}
+	if reps := pid.Repetitions(3); len(reps) != 2 || reps[1] != "99887766^^^NHS^NH" {
+		t.Errorf("PID-3 repetitions = %q", reps)
+	}
+	if got := pid.Component(5, 2); got != "Ada" {
+		t.Errorf("PID-5.2 = %q, want Ada", got)
+	}
+	if got := pid.Component(5, 9); got != "" {
+		t.Errorf("PID-5.9 = %q, want none", got)
+	}
+	if got := pid.Field(40); got != "" {
+		t.Errorf("PID-40 = %q, want none", got)
+	}
+	if _, ok := msg.Segment("OBX"); ok {
+		t.Error("found an OBX segment that isn't there")
+	}
+}
+
+func TestParseDelimiters(t *testing.T) {
+	raw := "MSH#$*%@#PAS#GENERAL#HMS#HMS#20240315##ADT$A04#MSG2#P#2.3\rPID#1##7$$$PAS##Bello$Tunde%S%Jr\r"
+	msg, err := Parse([]byte(raw))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	want := Delimiters{Field: '#', Component: '$', Repetition: '*', Escape: '%', Subcomponent: '@'}
+	if msg.Delimiters != want {
+		t.Errorf("Delimiters = %+v, want %+v", msg.Delimiters, want)
+	}
+	if _, event := msg.Type(); event != "A04" {
+		t.Errorf("event = %q, want A04", event)
+	}
+	pid, _ := msg.Segment("PID")
+	if got := pid.Component(5, 2); got != "Tunde$Jr" {
+		t.Errorf("PID-5.2 = %q, want the escaped component separator unescaped", got)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want error
+	}{
+		{"empty", "", ErrNoSegments},
+		{"blank", " \r\n ", ErrNoSegments},
+		{"no MSH", "PID|1||12345\r", ErrNoMSH},
+		{"no encoding characters", "MSH|^~", ErrBadMSH},
+		{"too few fields", "MSH|^~\\&|PAS|GENERAL\r", ErrBadMSH},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse([]byte(tt.raw)); !errors.Is(err, tt.want) {
+				t.Errorf("Parse(%q): err = %v, want %v", tt.raw, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	s := Segment{fields: []string{"NTE"}, delims: DefaultDelimiters}
+	tests := []struct {
+		in, want string
+	}{
+		{"plain text", "plain text"},
+		{`a\F\b`, "a|b"},
+		{`a\S\b\R\c`, "a^b~c"},
+		{`C:\E\temp`, `C:\temp`},
+		{`salt \T\ pepper`, "salt & pepper"},
+		{`line one\.br\line two`, "line one\nline two"},
+		{`\H\bold\N\`, "bold"},
+		{`\X0D\`, ""},
+		{`trailing \F`, `trailing \F`},
+	}
+	for _, tt := range tests {
+		if got := s.Unescape(tt.in); got != tt.want {
+			t.Errorf("Unescape(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestEscapeTextRoundTrip(t *testing.T) {
+	s := Segment{fields: []string{"NTE"}, delims: DefaultDelimiters}
+	for _, in := range []string{"a|b^c~d&e\\f", "two\nlines", "plain"} {
+		escaped := DefaultDelimiters.EscapeText(in)
+		if got := s.Unescape(escaped); got != in {
+			t.Errorf("Unescape(EscapeText(%q)) = %q via %q", in, got, escaped)
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	lagos := time.FixedZone("WAT", 60*60)
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"2024", time.Date(2024, 1, 1, 0, 0, 0, 0, lagos)},
+		{"202403", time.Date(2024, 3, 1, 0, 0, 0, 0, lagos)},
+		{"20240315", time.Date(2024, 3, 15, 0, 0, 0, 0, lagos)},
+		{"2024031512", time.Date(2024, 3, 15, 12, 0, 0, 0, lagos)},
+		{"202403151230", time.Date(2024, 3, 15, 12, 30, 0, 0, lagos)},
+		{"20240315123045", time.Date(2024, 3, 15, 12, 30, 45, 0, lagos)},
+		{"20240315123045.1234", time.Date(2024, 3, 15, 12, 30, 45, 0, lagos)},
+		{"20240315123045+0000", time.Date(2024, 3, 15, 12, 30, 45, 0, time.UTC)},
+		{"20240315123045.5-0500", time.Date(2024, 3, 15, 17, 30, 45, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := ParseTime(tt.in, lagos)
+		if err != nil {
+			t.Errorf("ParseTime(%q): %v", tt.in, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("ParseTime(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "2024031", "20240315T1230", "20241315", "-0500"} {
+		if _, err := ParseTime(in, lagos); err == nil {
+			t.Errorf("ParseTime(%q): want an error", in)
+		}
+	}
+}
+
+func TestFormatTime(t *testing.T) {
+	at := time.Date(2024, 3, 15, 12, 30, 45, 0, time.FixedZone("WAT", 60*60))
+	if got := FormatTime(at); got != "20240315123045+0100" {
+		t.Errorf("FormatTime = %q", got)
+	}
+	back, err := ParseTime(FormatTime(at), time.UTC)
+	if err != nil || !back.Equal(at) {
+		t.Errorf("ParseTime(FormatTime) = %v, %v, want %v", back, err, at)
+	}
+}
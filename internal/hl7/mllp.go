@@ -0,0 +1,267 @@
+package hl7
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// MLLP frames each message between a vertical tab and a file separator followed by a
+// carriage return.
+const (
+	startBlock = 0x0b
+	endBlock   = 0x1c
+	endFrame   = 0x0d
+)
+
+// MaxMessageBytes caps the size of a single framed message.
+const MaxMessageBytes = 1 << 20
+
+var (
+	ErrServerClosed    = errors.New("hl7: server closed")
+	ErrMessageTooLarge = fmt.Errorf("hl7: message larger than %d bytes", MaxMessageBytes)
+)
+
+// ReadFrame reads the next MLLP framed message, skipping anything before the start
+// block.
+func ReadFrame(r *bufio.Reader) ([]byte, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == startBlock {
+			break
+		}
+	}
+
+	var msg []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if b == endBlock {
+			// The trailing carriage return is required, but some senders leave it
+			// off, so only consume it when it is there.
+			if next, err := r.Peek(1); err == nil && next[0] == endFrame {
+				r.ReadByte()
+			}
+			return msg, nil
+		}
+		if len(msg) >= MaxMessageBytes {
+			return nil, ErrMessageTooLarge
+		}
+		msg = append(msg, b)
+	}
+}
+
+// WriteFrame writes msg framed for MLLP.
+func WriteFrame(w io.Writer, msg []byte) error {
+	frame := make([]byte, 0, len(msg)+3)
+	frame = append(frame, startBlock)
+	frame = append(frame, msg...)
+	frame = append(frame, endBlock, endFrame)
+	_, err := w.Write(frame)
+	return err
+}
+
+// HandlerFunc handles one message and returns the acknowledgement to send back.
+type HandlerFunc func(ctx context.Context, msg []byte) []byte
+
+// Server accepts MLLP connections and hands each message to Handler, one at a time
+// per connection, writing back the acknowledgement it returns. Like http.Server it is
+// stopped with Shutdown, which lets messages being handled finish first.
+type Server struct {
+	Addr    string
+	Handler HandlerFunc
+	// IdleTimeout closes connections that send nothing for this long. Zero means
+	// connections are never timed out.
+	IdleTimeout time.Duration
+	// ErrorLog is told about connection errors. They are dropped when it is nil.
+	ErrorLog func(err error, remoteAddr string)
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[*conn]struct{}
+	closing  bool
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+type conn struct {
+	net.Conn
+	mu     sync.Mutex
+	active bool
+}
+
+func (c *conn) setActive(active bool) {
+	c.mu.Lock()
+	c.active = active
+	c.mu.Unlock()
+}
+
+// closeIfIdle closes the connection unless a message is being handled on it.
+func (c *conn) closeIfIdle() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.active {
+		return false
+	}
+	c.Conn.Close()
+	return true
+}
+
+// ListenAndServe listens on Addr and serves connections until Shutdown is called,
+// when it returns ErrServerClosed.
+func (s *Server) ListenAndServe() error {
+	ln, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		ln.Close()
+		return ErrServerClosed
+	}
+	s.listener = ln
+	s.conns = map[*conn]struct{}{}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.mu.Unlock()
+
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closing := s.closing
+			s.mu.Unlock()
+			if closing {
+				return ErrServerClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		c := &conn{Conn: nc}
+		s.mu.Lock()
+		if s.closing {
+			s.mu.Unlock()
+			nc.Close()
+			return ErrServerClosed
+		}
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+
+		go s.serveConn(c)
+	}
+}
+
+func (s *Server) serveConn(c *conn) {
+	defer func() {
+		c.Close()
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+	}()
+
+	r := bufio.NewReader(c)
+	for {
+		if s.IdleTimeout > 0 {
+			c.SetReadDeadline(time.Now().Add(s.IdleTimeout))
+		}
+		msg, err := ReadFrame(r)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				s.logError(err, c.RemoteAddr().String())
+			}
+			return
+		}
+
+		c.setActive(true)
+		ack := s.Handler(s.ctx, msg)
+		c.SetWriteDeadline(time.Now().Add(30 * time.Second))
+		err = WriteFrame(c, ack)
+		c.setActive(false)
+		if err != nil {
+			s.logError(err, c.RemoteAddr().String())
+			return
+		}
+
+		s.mu.Lock()
+		closing := s.closing
+		s.mu.Unlock()
+		if closing {
+			return
+		}
+	}
+}
+
+func (s *Server) logError(err error, remoteAddr string) {
+	if s.ErrorLog != nil {
+		s.ErrorLog(err, remoteAddr)
+	}
+}
+
+// Shutdown stops accepting connections, closes idle ones and waits for messages being
+// handled to be acknowledged. If ctx ends first the remaining connections are closed
+// and the handlers' context is canceled.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closing = true
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		s.mu.Lock()
+		for c := range s.conns {
+			c.closeIfIdle()
+		}
+		remaining := len(s.conns)
+		s.mu.Unlock()
+		if remaining == 0 {
+			s.stop()
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			s.mu.Lock()
+			for c := range s.conns {
+				c.Close()
+			}
+			s.mu.Unlock()
+			s.stop()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
@@ -0,0 +1,85 @@
+package hl7
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func frame(msg string) string {
+	return "\x0b" + msg + "\x1c\r"
+}
+
+func TestReadFrame(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"one frame", frame("MSH|one"), []string{"MSH|one"}},
+		{"back to back", frame("MSH|one") + frame("MSH|two"), []string{"MSH|one", "MSH|two"}},
+		{"no trailing carriage return", "\x0bMSH|one\x1c\x0bMSH|two\x1c", []string{"MSH|one", "MSH|two"}},
+		{"noise before the start block", "\r\nhello" + frame("MSH|one"), []string{"MSH|one"}},
+		{"empty frame", frame(""), []string{""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(tt.input))
+			for _, want := range tt.want {
+				got, err := ReadFrame(r)
+				if err != nil {
+					t.Fatalf("ReadFrame: %v", err)
+				}
+				if string(got) != want {
+					t.Errorf("ReadFrame = %q, want %q", got, want)
+				}
+			}
+			if _, err := ReadFrame(r); !errors.Is(err, io.EOF) {
+				t.Errorf("ReadFrame at the end: err = %v, want EOF", err)
+			}
+		})
+	}
+}
+
+func TestReadFrameUnterminated(t *testing.T) {
+	for _, input := range []string{"\x0b", "\x0bMSH|one", "\x0bMSH|one\r"} {
+		r := bufio.NewReader(strings.NewReader(input))
+		if _, err := ReadFrame(r); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("ReadFrame(%q): err = %v, want ErrUnexpectedEOF", input, err)
+		}
+	}
+}
+
+func TestReadFrameSize(t *testing.T) {
+	largest := strings.Repeat("a", MaxMessageBytes)
+	r := bufio.NewReader(strings.NewReader(frame(largest)))
+	got, err := ReadFrame(r)
+	if err != nil {
+		t.Fatalf("ReadFrame of %d bytes: %v", MaxMessageBytes, err)
+	}
+	if len(got) != MaxMessageBytes {
+		t.Errorf("read %d bytes, want %d", len(got), MaxMessageBytes)
+	}
+
+	r = bufio.NewReader(strings.NewReader(frame(largest + "a")))
+	if _, err := ReadFrame(r); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("ReadFrame of %d bytes: err = %v, want ErrMessageTooLarge", MaxMessageBytes+1, err)
+	}
+}
+
+func TestWriteFrame(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteFrame(&buf, []byte("MSH|one")); err != nil {
+		t.Fatalf("WriteFrame: %v", err)
+	}
+	if buf.String() != frame("MSH|one") {
+		t.Errorf("WriteFrame wrote %q", buf.String())
+	}
+	got, err := ReadFrame(bufio.NewReader(&buf))
+	if err != nil || string(got) != "MSH|one" {
+		t.Errorf("ReadFrame(WriteFrame) = %q, %v", got, err)
+	}
+}
@@ -0,0 +1,265 @@
+// Package immunization works out which vaccine doses a patient is due for from a
+// national schedule and the doses they have already had.
+package immunization
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/muyiwadosunmu/hospital-management/internal/data"
+)
+
+const (
+	StatusOverdue  = "overdue"
+	StatusDue      = "due"
+	StatusUpcoming = "upcoming"
+)
+
+// defaultSchedule is an example based on the WHO routine childhood schedule. Real
+// deployments should configure their own national schedule.
+//
+//go:embed default_schedule.json
+var defaultSchedule []byte
+
+// Age is a patient age written as a number and a unit, e.g. "0d", "6w", "9m" or
+// "4y". Months and years are calendar months and years.
+type Age struct {
+	n    int
+	unit byte
+}
+
+func ParseAge(s string) (Age, error) {
+	s = strings.TrimSpace(s)
+	if len(s) < 2 {
+		return Age{}, fmt.Errorf("invalid age %q", s)
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	unit := s[len(s)-1]
+	if err != nil || n < 0 || !strings.ContainsRune("dwmy", rune(unit)) {
+		return Age{}, fmt.Errorf("invalid age %q, must be a number followed by d, w, m or y", s)
+	}
+	return Age{n: n, unit: unit}, nil
+}
+
+// After returns the date this age is reached by someone born on t.
+func (a Age) After(t time.Time) time.Time {
+	switch a.unit {
+	case 'w':
+		return t.AddDate(0, 0, 7*a.n)
+	case 'm':
+		return t.AddDate(0, a.n, 0)
+	case 'y':
+		return t.AddDate(a.n, 0, 0)
+	}
+	return t.AddDate(0, 0, a.n)
+}
+
+func (a Age) IsZero() bool {
+	return a.unit == 0
+}
+
+func (a Age) String() string {
+	if a.IsZero() {
+		return ""
+	}
+	return strconv.Itoa(a.n) + string(a.unit)
+}
+
+func (a Age) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+func (a *Age) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := ParseAge(s)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+// Dose is one dose in a vaccine's series. The dose is due from Age, or MinInterval
+// after the previous dose if that is later, and becomes overdue Grace after that. It
+// is no longer offered once the patient reaches MaxAge.
+type Dose struct {
+	Dose        int `json:"dose"`
+	Age         Age `json:"age"`
+	MinInterval Age `json:"minInterval,omitempty"`
+	Grace       Age `json:"grace,omitempty"`
+	MaxAge      Age `json:"maxAge,omitempty"`
+}
+
+type Vaccine struct {
+	Code  string `json:"code"`
+	Name  string `json:"name"`
+	Doses []Dose `json:"doses"`
+}
+
+// Schedule is a national immunization schedule.
+type Schedule struct {
+	Name     string    `json:"name"`
+	Vaccines []Vaccine `json:"vaccines"`
+	// Grace is how long a dose may go unrecorded after it is due before it is
+	// counted as overdue, unless the dose sets its own.
+	Grace Age `json:"grace"`
+}
+
+// Given is a dose the patient has already had.
+type Given struct {
+	VaccineCode string
+	DoseNumber  int
+	On          time.Time
+}
+
+// DueDose is a dose the patient still needs.
+type DueDose struct {
+	VaccineCode string    `json:"vaccineCode"`
+	VaccineName string    `json:"vaccineName"`
+	DoseNumber  int       `json:"doseNumber"`
+	DueOn       data.Date `json:"dueOn"`
+	OverdueOn   data.Date `json:"overdueOn"`
+	Status      string    `json:"status"`
+}
+
+// Load reads a schedule from a JSON file.
+func Load(path string) (*Schedule, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing immunization schedule %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Default returns the built-in example schedule.
+func Default() *Schedule {
+	s, err := parse(defaultSchedule)
+	if err != nil {
+		panic(err) // the embedded schedule is broken
+	}
+	return s
+}
+
+func parse(b []byte) (*Schedule, error) {
+	var s Schedule
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, err
+	}
+	codes := map[string]bool{}
+	for i := range s.Vaccines {
+		v := &s.Vaccines[i]
+		v.Code = strings.ToUpper(strings.TrimSpace(v.Code))
+		if v.Code == "" {
+			return nil, fmt.Errorf("vaccine %d has no code", i)
+		}
+		if codes[v.Code] {
+			return nil, fmt.Errorf("vaccine %s is listed more than once", v.Code)
+		}
+		codes[v.Code] = true
+		if len(v.Doses) == 0 {
+			return nil, fmt.Errorf("vaccine %s has no doses", v.Code)
+		}
+		sort.Slice(v.Doses, func(a, b int) bool { return v.Doses[a].Dose < v.Doses[b].Dose })
+		for j, d := range v.Doses {
+			if d.Dose != j+1 {
+				return nil, fmt.Errorf("vaccine %s: doses must be numbered 1 to %d", v.Code, len(v.Doses))
+			}
+			if d.Age.IsZero() {
+				return nil, fmt.Errorf("vaccine %s dose %d has no age", v.Code, d.Dose)
+			}
+		}
+	}
+	return &s, nil
+}
+
+// Vaccine returns the vaccine with the given code.
+func (s *Schedule) Vaccine(code string) (Vaccine, bool) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	for _, v := range s.Vaccines {
+		if v.Code == code {
+			return v, true
+		}
+	}
+	return Vaccine{}, false
+}
+
+// Due works out the doses a patient born on dob still needs as of asOf. Doses that are
+// overdue or due are always returned; later ones only when they fall due within the
+// window. The result is ordered by due date.
+func (s *Schedule) Due(dob time.Time, given []Given, asOf time.Time, window time.Duration) []DueDose {
+	had := map[string]map[int]time.Time{}
+	for _, g := range given {
+		code := strings.ToUpper(g.VaccineCode)
+		if had[code] == nil {
+			had[code] = map[int]time.Time{}
+		}
+		had[code][g.DoseNumber] = g.On
+	}
+
+	today := data.NewDate(asOf).Time
+	due := []DueDose{}
+	for _, v := range s.Vaccines {
+		var previous time.Time
+		for _, d := range v.Doses {
+			if on, ok := had[v.Code][d.Dose]; ok {
+				previous = on
+				continue
+			}
+			if !d.MaxAge.IsZero() && !today.Before(d.MaxAge.After(dob)) {
+				// Too old for this dose, and so for the rest of the series.
+				break
+			}
+
+			dueOn := d.Age.After(dob)
+			if !d.MinInterval.IsZero() && !previous.IsZero() {
+				if next := d.MinInterval.After(previous); next.After(dueOn) {
+					dueOn = next
+				}
+			}
+			grace := d.Grace
+			if grace.IsZero() {
+				grace = s.Grace
+			}
+			overdueOn := grace.After(dueOn)
+
+			dose := DueDose{
+				VaccineCode: v.Code,
+				VaccineName: v.Name,
+				DoseNumber:  d.Dose,
+				DueOn:       data.NewDate(dueOn),
+				OverdueOn:   data.NewDate(overdueOn),
+			}
+			switch {
+			case today.After(overdueOn):
+				dose.Status = StatusOverdue
+			case !today.Before(dueOn):
+				dose.Status = StatusDue
+			case dueOn.Sub(today) <= window:
+				dose.Status = StatusUpcoming
+			}
+			if dose.Status != "" {
+				due = append(due, dose)
+			}
+
+			// Later doses in the series can't be given before this one, so the
+			// interval is counted from when it is due.
+			previous = dueOn
+		}
+	}
+
+	sort.SliceStable(due, func(i, j int) bool { return due[i].DueOn.Before(due[j].DueOn.Time) })
+	return due
+}
@@ -3,6 +3,8 @@ package mailer
 import (
 	"bytes"
 	"embed"
+	"io"
+	"io/fs"
 	"text/template"
 	"time"
 
@@ -32,10 +34,28 @@ func New(host string, port int, username, password, sender string) Mailer {
 	}
 }
 
+// Attachment is a file sent along with an email.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// HasTemplate reports whether there is an email template of the given file name.
+func HasTemplate(templateFile string) bool {
+	_, err := fs.Stat(templateFS, "templates/"+templateFile)
+	return err == nil
+}
+
 // Define a Send() method on the Mailer type. This takes the recipient email address
 // as the first parameter, the name of the file containing the templates, and any
 // dynamic data for the templates as an interface{} parameter.
 func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
+	return m.SendWithAttachments(recipient, templateFile, data)
+}
+
+// SendWithAttachments works like Send() and attaches the given files to the email.
+func (m Mailer) SendWithAttachments(recipient, templateFile string, data interface{}, attachments ...Attachment) error {
 	// Use the ParseFS() method to parse the required template file from the embedded
 	// file system.
 	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
@@ -73,6 +93,18 @@ func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
 	msg.SetHeader("Subject", subject.String())
 	msg.SetBody("text/plain", plainBody.String())
 	msg.AddAlternative("text/html", htmlBody.String())
+	// The attachment is written from a copy func rather than a reader so that it is
+	// sent in full on every retry below.
+	for _, a := range attachments {
+		a := a
+		msg.Attach(a.Filename,
+			mail.SetHeader(map[string][]string{"Content-Type": {a.ContentType}}),
+			mail.SetCopyFunc(func(w io.Writer) error {
+				_, err := w.Write(a.Data)
+				return err
+			}),
+		)
+	}
 	// Call the DialAndSend() method on the dialer, passing in the message to send. This
 	// opens a connection to the SMTP server, sends the message, then closes the
 	// connection. If there is a timeout, it will return a "dial tcp: i/o timeout"
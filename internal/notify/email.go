@@ -0,0 +1,20 @@
+package notify
+
+import (
+	"context"
+
+	"github.com/muyiwadosunmu/hospital-management/internal/mailer"
+)
+
+// Email sends messages over SMTP with the mailer's templates.
+type Email struct {
+	Mailer mailer.Mailer
+}
+
+func (e Email) Send(ctx context.Context, msg Message) (string, error) {
+	return "", e.Mailer.SendWithAttachments(msg.To, msg.Template+".tmpl", msg.Data, msg.Attachments...)
+}
+
+func (e Email) HasTemplate(name string) bool {
+	return mailer.HasTemplate(name + ".tmpl")
+}
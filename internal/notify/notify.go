@@ -0,0 +1,61 @@
+// Package notify sends messages to people over the channels they can be reached
+// on. Each channel has a provider, which renders a message with its own template of
+// the message's name, so the same notification can be a full email with
+// attachments and a short text message.
+package notify
+
+import (
+	"context"
+	"errors"
+
+	"github.com/muyiwadosunmu/hospital-management/internal/mailer"
+)
+
+var ErrNoProvider = errors.New("notify: no provider is configured for the channel")
+
+// Message is one notification to one recipient, an email address or phone number
+// depending on the channel. Template names the message, such as "user_welcome".
+type Message struct {
+	To       string
+	Template string
+	Data     any
+	// Attachments are only sent by channels that can carry files.
+	Attachments []mailer.Attachment
+}
+
+// Provider sends messages over one channel.
+type Provider interface {
+	// Send renders and sends the message. It returns the provider's reference for
+	// the message, if it gives one.
+	Send(ctx context.Context, msg Message) (string, error)
+	// HasTemplate reports whether the provider can render messages of the template.
+	HasTemplate(name string) bool
+}
+
+// Service sends messages through the provider registered for each channel.
+type Service struct {
+	providers map[string]Provider
+}
+
+func New() *Service {
+	return &Service{providers: map[string]Provider{}}
+}
+
+// Register sets the provider messages on the channel are sent through.
+func (s *Service) Register(channel string, p Provider) {
+	s.providers[channel] = p
+}
+
+// Supports reports whether messages of the template can be sent on the channel.
+func (s *Service) Supports(channel, template string) bool {
+	p, ok := s.providers[channel]
+	return ok && p.HasTemplate(template)
+}
+
+func (s *Service) Send(ctx context.Context, channel string, msg Message) (string, error) {
+	p, ok := s.providers[channel]
+	if !ok {
+		return "", ErrNoProvider
+	}
+	return p.Send(ctx, msg)
+}
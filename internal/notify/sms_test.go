@@ -0,0 +1,84 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var welcomeData = map[string]any{"firstName": "Ada", "hospital": "General Hospital", "userID": 42}
+
+func TestSMSGatewaySend(t *testing.T) {
+	var got struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+		Text string `json:"text"`
+	}
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": "msg-123", "status": "queued"}`))
+	}))
+	defer srv.Close()
+
+	g := NewSMSGateway(srv.URL, "secret", "HMS", 5*time.Second)
+	ref, err := g.Send(context.Background(), Message{To: "+2348012345678", Template: "user_welcome", Data: welcomeData})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if ref != "msg-123" {
+		t.Errorf("ref = %q, want %q", ref, "msg-123")
+	}
+	if auth != "Bearer secret" {
+		t.Errorf("Authorization = %q, want %q", auth, "Bearer secret")
+	}
+	if got.From != "HMS" || got.To != "+2348012345678" {
+		t.Errorf("from, to = %q, %q, want %q, %q", got.From, got.To, "HMS", "+2348012345678")
+	}
+	if !strings.Contains(got.Text, "Ada") || strings.Contains(got.Text, "\n") {
+		t.Errorf("text = %q, want the rendered template on one line", got.Text)
+	}
+}
+
+func TestSMSGatewaySendWithoutID(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	g := NewSMSGateway(srv.URL, "", "HMS", 5*time.Second)
+	ref, err := g.Send(context.Background(), Message{To: "+2348012345678", Template: "user_welcome", Data: welcomeData})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if ref != "" {
+		t.Errorf("ref = %q, want none", ref)
+	}
+}
+
+func TestSMSGatewaySendRejected(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"id": "msg-123"}`, http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	g := NewSMSGateway(srv.URL, "", "HMS", 5*time.Second)
+	ref, err := g.Send(context.Background(), Message{To: "+2348012345678", Template: "user_welcome", Data: welcomeData})
+	if err == nil {
+		t.Fatal("Send: want an error for a 400 response")
+	}
+	if !strings.Contains(err.Error(), "400") {
+		t.Errorf("error = %q, want the response status", err)
+	}
+	if ref != "" {
+		t.Errorf("ref = %q, want none", ref)
+	}
+}
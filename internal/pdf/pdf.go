@@ -0,0 +1,261 @@
+// Package pdf is a small PDF writer for the plain, text based documents the hospital
+// prints, such as discharge summaries. It only uses the standard Helvetica fonts, so
+// nothing needs to be embedded, and it has no dependencies outside the standard
+// library.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A4 in points.
+const (
+	PageWidth  = 595.28
+	PageHeight = 841.89
+	margin     = 50.0
+)
+
+type font struct {
+	resource string
+	name     string
+	widths   *[95]int
+}
+
+var (
+	regular = font{"F1", "Helvetica", &helveticaWidths}
+	bold    = font{"F2", "Helvetica-Bold", &helveticaBoldWidths}
+)
+
+// Document is a PDF being written. Text flows down the page and onto new pages as
+// needed.
+type Document struct {
+	title   string
+	created time.Time
+	pages   []*bytes.Buffer
+	page    *bytes.Buffer
+	y       float64
+	font    font
+	size    float64
+}
+
+func New(title string) *Document {
+	d := &Document{title: title, created: time.Now(), font: regular, size: 10}
+	d.addPage()
+	return d
+}
+
+func (d *Document) addPage() {
+	d.page = new(bytes.Buffer)
+	d.pages = append(d.pages, d.page)
+	d.y = PageHeight - margin
+}
+
+// SetFont sets the font used for text written from now on.
+func (d *Document) SetFont(isBold bool, size float64) {
+	d.font = regular
+	if isBold {
+		d.font = bold
+	}
+	d.size = size
+}
+
+func (d *Document) lineHeight() float64 {
+	return d.size * 1.35
+}
+
+// ensure starts a new page when there is less than h points left on this one.
+func (d *Document) ensure(h float64) {
+	if d.y-h < margin+20 {
+		d.addPage()
+	}
+}
+
+// Space moves down the page by h points.
+func (d *Document) Space(h float64) {
+	d.y -= h
+	if d.y < margin+20 {
+		d.addPage()
+	}
+}
+
+// Rule draws a horizontal line across the page.
+func (d *Document) Rule() {
+	d.ensure(6)
+	d.y -= 3
+	fmt.Fprintf(d.page, "0.6 G 0.5 w %.2f %.2f m %.2f %.2f l S 0 G\n", margin, d.y, PageWidth-margin, d.y)
+	d.y -= 6
+}
+
+// Text writes a paragraph, wrapping it to the width of the page.
+func (d *Document) Text(s string) {
+	d.TextIndent(s, 0, "")
+}
+
+// TextIndent writes a paragraph indented by indent points. The prefix, e.g. a bullet,
+// is written in the indent on the first line.
+func (d *Document) TextIndent(s string, indent float64, prefix string) {
+	width := PageWidth - 2*margin - indent
+	lines := d.wrap(s, width)
+	for i, line := range lines {
+		d.ensure(d.lineHeight())
+		d.y -= d.lineHeight()
+		if i == 0 && prefix != "" {
+			d.show(margin+indent-d.width(prefix)-4, d.y, prefix)
+		}
+		d.show(margin+indent, d.y, line)
+	}
+}
+
+func (d *Document) show(x, y float64, s string) {
+	fmt.Fprintf(d.page, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", d.font.resource, d.size, x, y, escape(encode(s)))
+}
+
+// width is the width of s in points in the current font.
+func (d *Document) width(s string) float64 {
+	total := 0
+	for _, b := range encode(s) {
+		if b >= 32 && b <= 126 {
+			total += d.font.widths[b-32]
+		} else {
+			total += 556
+		}
+	}
+	return float64(total) * d.size / 1000
+}
+
+// wrap splits s into lines no wider than width, breaking on spaces and hard line
+// breaks. Words longer than a line are split.
+func (d *Document) wrap(s string, width float64) []string {
+	var lines []string
+	for _, para := range strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n") {
+		words := strings.Fields(para)
+		if len(words) == 0 {
+			lines = append(lines, "")
+			continue
+		}
+		line := ""
+		for _, word := range words {
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+			if d.width(candidate) <= width {
+				line = candidate
+				continue
+			}
+			if line != "" {
+				lines = append(lines, line)
+			}
+			// Split on rune boundaries, so no character is cut in half.
+			for runes := []rune(word); len(runes) > 1 && d.width(word) > width; runes = []rune(word) {
+				cut := len(runes) - 1
+				for cut > 1 && d.width(string(runes[:cut])) > width {
+					cut--
+				}
+				lines = append(lines, string(runes[:cut]))
+				word = string(runes[cut:])
+			}
+			line = word
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// Bytes lays out the footers and returns the finished PDF.
+func (d *Document) Bytes() []byte {
+	var out bytes.Buffer
+	var offsets []int
+
+	obj := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// Objects 1-5 are fixed; each page then takes two objects, the page and its
+	// content stream.
+	const firstPage = 6
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPage+2*i)
+	}
+
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	obj(fmt.Sprintf("<< /Title (%s) /Producer (hospital-management) /CreationDate (D:%s) >>",
+		escape(encode(d.title)), d.created.UTC().Format("20060102150405Z")))
+
+	for i, page := range d.pages {
+		footer := fmt.Sprintf("%s - page %d of %d", d.title, i+1, len(d.pages))
+		content := page.String() + fmt.Sprintf("0.4 g BT /F1 8 Tf %.2f %.2f Td (%s) Tj ET 0 g\n",
+			margin, margin-20, escape(encode(footer)))
+
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] "+
+			"/Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			PageWidth, PageHeight, firstPage+2*i+1))
+		obj(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(content), content))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.Bytes()
+}
+
+// winAnsi maps the characters outside Latin-1 that WinAnsiEncoding can show.
+var winAnsi = map[rune]byte{
+	'€': 0x80, '‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '…': 0x85,
+}
+
+// encode converts s to WinAnsiEncoding. Characters the standard fonts can't show are
+// replaced with '?'.
+func encode(s string) string {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r == '\t':
+			b = append(b, ' ')
+		case r >= 32 && r <= 126, r >= 160 && r <= 255:
+			b = append(b, byte(r))
+		case winAnsi[r] != 0:
+			b = append(b, winAnsi[r])
+		default:
+			b = append(b, '?')
+		}
+	}
+	return string(b)
+}
+
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `(`, `\(`, `)`, `\)`).Replace(s)
+}
+
+// Character widths for ASCII 32-126 from the Adobe font metrics, in thousandths of
+// the font size.
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+var helveticaBoldWidths = [95]int{
+	278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+	975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+	333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+	611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+}
@@ -0,0 +1,182 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestWrap(t *testing.T) {
+	d := New("Test")
+	tests := []struct {
+		name  string
+		in    string
+		width float64
+		want  []string
+	}{
+		{"fits", "Take with food", 200, []string{"Take with food"}},
+		{"breaks on spaces", "Take with food", 50, []string{"Take with", "food"}},
+		{"hard line breaks", "one\r\n\ntwo", 200, []string{"one", "", "two"}},
+		{"blank", "   ", 200, []string{""}},
+		{"long word", "see abcdefghij", 30, []string{"see", "abcde", "fghij"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := d.wrap(tt.in, tt.width); !slices.Equal(got, tt.want) {
+				t.Errorf("wrap(%q, %g) = %q, want %q", tt.in, tt.width, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWrapMultibyteWord(t *testing.T) {
+	d := New("Test")
+	word := strings.Repeat("é", 100) + strings.Repeat("–", 50)
+	width := PageWidth - 2*margin
+
+	lines := d.wrap("see "+word, width)
+	if len(lines) < 3 || lines[0] != "see" {
+		t.Fatalf("wrap = %q, want the short word on its own line and the long one split", lines)
+	}
+	for i, line := range lines {
+		if !utf8.ValidString(line) {
+			t.Errorf("line %d = %q, which cuts a character in half", i, line)
+		}
+		if w := d.width(line); w > width {
+			t.Errorf("line %d is %g points wide, more than %g", i, w, width)
+		}
+	}
+	if got := strings.Join(lines[1:], ""); got != word {
+		t.Errorf("the split word is %q, want %q", got, word)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain", "plain"},
+		{"café", "caf\xe9"},
+		{"5 € – “dose”…", "5 \x80 \x96 \x93dose\x94\x85"},
+		{"a\tb", "a b"},
+		{"日本 😀", "?? ?"},
+	}
+	for _, tt := range tests {
+		if got := encode(tt.in); got != tt.want {
+			t.Errorf("encode(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain", "plain"},
+		{"(bd)", `\(bd\)`},
+		{`C:\temp`, `C:\\temp`},
+		{`\)`, `\\\)`},
+	}
+	for _, tt := range tests {
+		if got := escape(tt.in); got != tt.want {
+			t.Errorf("escape(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+var startxrefRX = regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`)
+
+// checkXref checks that the cross-reference table of a PDF points at each of its
+// objects, and returns the number of objects.
+func checkXref(t *testing.T, out []byte) int {
+	t.Helper()
+	m := startxrefRX.FindSubmatch(out)
+	if m == nil {
+		t.Fatalf("no startxref at the end of %q", out[max(0, len(out)-60):])
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	if !bytes.HasPrefix(out[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref %d doesn't point at the xref table", xref)
+	}
+
+	lines := strings.Split(string(out[xref:]), "\n")
+	var first, count int
+	if _, err := fmt.Sscanf(lines[1], "%d %d", &first, &count); err != nil || first != 0 {
+		t.Fatalf("xref subsection header = %q", lines[1])
+	}
+	if lines[2] != "0000000000 65535 f " {
+		t.Errorf("xref entry 0 = %q", lines[2])
+	}
+	for i := 1; i < count; i++ {
+		entry := lines[2+i]
+		if len(entry) != 19 || !strings.HasSuffix(entry, " 00000 n ") {
+			t.Errorf("xref entry %d = %q", i, entry)
+			continue
+		}
+		offset, _ := strconv.Atoi(entry[:10])
+		if want := fmt.Sprintf("%d 0 obj\n", i); !bytes.HasPrefix(out[offset:], []byte(want)) {
+			t.Errorf("xref entry %d points at %q", i, out[offset:min(len(out), offset+12)])
+		}
+	}
+	if lines[2+count] != "trailer" {
+		t.Errorf("xref table has more entries than its header's %d", count)
+	}
+	if want := fmt.Sprintf("/Size %d ", count); !bytes.Contains(out[xref:], []byte(want)) {
+		t.Errorf("trailer doesn't have %s", want)
+	}
+	return count - 1
+}
+
+func TestBytes(t *testing.T) {
+	d := New("Discharge (Ada)")
+	d.SetFont(true, 14)
+	d.Text("Discharge summary")
+	d.SetFont(false, 10)
+	d.Rule()
+	d.TextIndent("Paracetamol 1g (oral)", 12, "•")
+
+	out := d.Bytes()
+	if !bytes.HasPrefix(out, []byte("%PDF-1.4\n")) {
+		t.Errorf("header = %q", out[:10])
+	}
+	if n := checkXref(t, out); n != 7 {
+		t.Errorf("got %d objects, want 7 for one page", n)
+	}
+	for _, want := range []string{
+		`/Title (Discharge \(Ada\))`,
+		`(Paracetamol 1g \(oral\)) Tj`,
+		"(\x95) Tj",
+		`(Discharge \(Ada\) - page 1 of 1) Tj`,
+		"/Count 1",
+	} {
+		if !bytes.Contains(out, []byte(want)) {
+			t.Errorf("output doesn't contain %q", want)
+		}
+	}
+}
+
+func TestBytesPages(t *testing.T) {
+	d := New("Notes")
+	for i := range 120 {
+		d.Text(fmt.Sprintf("Line %d", i))
+	}
+	if len(d.pages) < 2 {
+		t.Fatalf("120 lines took %d pages", len(d.pages))
+	}
+
+	out := d.Bytes()
+	if n := checkXref(t, out); n != 5+2*len(d.pages) {
+		t.Errorf("got %d objects, want %d", n, 5+2*len(d.pages))
+	}
+	if want := fmt.Sprintf("/Count %d", len(d.pages)); !bytes.Contains(out, []byte(want)) {
+		t.Errorf("output doesn't contain %q", want)
+	}
+	if want := fmt.Sprintf("page %d of %d", len(d.pages), len(d.pages)); !bytes.Contains(out, []byte(want)) {
+		t.Errorf("output doesn't contain %q", want)
+	}
+}
@@ -0,0 +1,179 @@
+package prescribing
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/muyiwadosunmu/hospital-management/internal/validator"
+)
+
+const (
+	WarningInteraction = "interaction"
+	WarningAllergy     = "allergy"
+	WarningDuplicate   = "duplicate"
+)
+
+var severityRank = map[string]int{
+	"minor":           1,
+	"moderate":        2,
+	"major":           3,
+	"contraindicated": 4,
+}
+
+// allergySeverities maps the severity of an allergic reaction onto the scale
+// interactions are graded on, so that all warnings can be ranked together.
+var allergySeverities = map[string]string{
+	"mild":     "minor",
+	"moderate": "moderate",
+	"severe":   "major",
+}
+
+// Warning is a problem found with a new prescription. Every warning must be
+// acknowledged with an override reason before the prescription can be saved.
+type Warning struct {
+	Type string `json:"type"`
+	// Severity is minor, moderate, major or contraindicated, whatever the type.
+	Severity string `json:"severity"`
+	With     string `json:"with"`
+	Message  string `json:"message"`
+}
+
+// Interaction is a known interaction between two drugs or drug classes.
+type Interaction struct {
+	A           string `json:"a"`
+	B           string `json:"b"`
+	Severity    string `json:"severity"`
+	Description string `json:"description"`
+}
+
+// Dataset is the interaction data the Checker works from. Classes group drugs under a
+// class name (e.g. "penicillins": ["amoxicillin", ...]) so that interactions and
+// allergies can be recorded against a whole class.
+type Dataset struct {
+	Classes      map[string][]string `json:"classes"`
+	Interactions []Interaction       `json:"interactions"`
+}
+
+// Allergy is the part of a patient allergy the checker needs.
+type Allergy struct {
+	Substance string
+	Severity  string
+	Reaction  string
+}
+
+type Checker struct {
+	// classesOf maps a drug onto the classes it belongs to.
+	classesOf    map[string][]string
+	interactions []Interaction
+}
+
+func normalize(name string) string {
+	return strings.Join(strings.Fields(strings.ToLower(name)), " ")
+}
+
+// Load reads a dataset from a JSON file.
+func Load(path string) (*Checker, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ds Dataset
+	if err := json.Unmarshal(b, &ds); err != nil {
+		return nil, fmt.Errorf("error parsing interaction dataset %s: %w", path, err)
+	}
+	for i, in := range ds.Interactions {
+		if _, ok := severityRank[in.Severity]; !ok {
+			return nil, fmt.Errorf("interaction %d: unknown severity %q", i, in.Severity)
+		}
+	}
+	return New(ds), nil
+}
+
+func New(ds Dataset) *Checker {
+	c := &Checker{classesOf: make(map[string][]string)}
+	for class, drugs := range ds.Classes {
+		for _, drug := range drugs {
+			drug = normalize(drug)
+			c.classesOf[drug] = append(c.classesOf[drug], normalize(class))
+		}
+	}
+	for _, in := range ds.Interactions {
+		c.interactions = append(c.interactions, Interaction{
+			A:           normalize(in.A),
+			B:           normalize(in.B),
+			Severity:    in.Severity,
+			Description: in.Description,
+		})
+	}
+	return c
+}
+
+// names returns the drug itself followed by every class it belongs to.
+func (c *Checker) names(drug string) []string {
+	drug = normalize(drug)
+	return append([]string{drug}, c.classesOf[drug]...)
+}
+
+func overlaps(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Check evaluates a new drug against the patient's active medications and allergies.
+// Warnings are returned most severe first.
+func (c *Checker) Check(drug string, active []string, allergies []Allergy) []Warning {
+	warnings := []Warning{}
+	newNames := c.names(drug)
+
+	for _, a := range allergies {
+		if overlaps(newNames, c.names(a.Substance)) {
+			severity, ok := allergySeverities[a.Severity]
+			if !ok {
+				severity = "major"
+			}
+			msg := fmt.Sprintf("patient is allergic to %s", a.Substance)
+			if a.Reaction != "" {
+				msg += " (" + a.Reaction + ")"
+			}
+			warnings = append(warnings, Warning{Type: WarningAllergy, Severity: severity, With: a.Substance, Message: msg})
+		}
+	}
+
+	for _, current := range active {
+		currentNames := c.names(current)
+		if normalize(current) == normalize(drug) {
+			warnings = append(warnings, Warning{
+				Type:     WarningDuplicate,
+				Severity: "moderate",
+				With:     current,
+				Message:  fmt.Sprintf("patient already has an active prescription for %s", current),
+			})
+			continue
+		}
+		for _, in := range c.interactions {
+			if (validator.In(in.A, newNames...) && validator.In(in.B, currentNames...)) ||
+				(validator.In(in.B, newNames...) && validator.In(in.A, currentNames...)) {
+				warnings = append(warnings, Warning{
+					Type:     WarningInteraction,
+					Severity: in.Severity,
+					With:     current,
+					Message:  in.Description,
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(warnings, func(i, j int) bool {
+		return severityRank[warnings[i].Severity] > severityRank[warnings[j].Severity]
+	})
+	return warnings
+}
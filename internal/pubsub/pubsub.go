@@ -0,0 +1,80 @@
+package pubsub
+
+import (
+	"sync"
+)
+
+// Message is a single event fanned out to every subscriber. Event is the event name
+// and Data is the already encoded payload.
+type Message struct {
+	Event string
+	Data  []byte
+}
+
+// Broker fans messages out to any number of in-process subscribers. It doesn't block
+// on slow consumers: a subscriber whose buffer is full is dropped and its channel
+// closed, so it can reconnect and resynchronise instead of silently missing events.
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[chan Message]struct{}
+	bufferSize  int
+	closed      bool
+}
+
+func New(bufferSize int) *Broker {
+	return &Broker{
+		subscribers: make(map[chan Message]struct{}),
+		bufferSize:  bufferSize,
+	}
+}
+
+// Subscribe registers a new subscriber. The returned function must be called once the
+// subscriber is no longer interested in messages.
+func (b *Broker) Subscribe() (<-chan Message, func()) {
+	ch := make(chan Message, b.bufferSize)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	b.subscribers[ch] = struct{}{}
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.remove(ch)
+	}
+}
+
+func (b *Broker) Publish(msg Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- msg:
+		default:
+			b.remove(ch)
+		}
+	}
+}
+
+// Close disconnects every subscriber. Subscribe calls made after Close return a
+// closed channel.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		b.remove(ch)
+	}
+	b.closed = true
+}
+
+// remove must be called with the mutex held.
+func (b *Broker) remove(ch chan Message) {
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
@@ -0,0 +1,23 @@
+// Package storage keeps uploaded files, such as scanned letters and ID cards, out of
+// the database. Objects are addressed by a key chosen by the caller and can be kept
+// on the local filesystem or in any S3-compatible object store.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+var ErrNotFound = errors.New("storage: object not found")
+
+// Store is a place objects can be written to and read back from.
+type Store interface {
+	// Put writes size bytes from r to key, replacing anything already there.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Open returns the object at key. Seeking is cheap, so the object can be served
+	// with http.ServeContent to support range requests.
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	// Delete removes key. Deleting a key that doesn't exist is not an error.
+	Delete(ctx context.Context, key string) error
+}
@@ -5,6 +5,8 @@ import (
 )
 
 var (
+	// PhoneRX matches E.164 phone numbers, such as +2348012345678.
+	PhoneRX = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
 	EmailRX = regexp.MustCompile(`^[a-zA-Z0-9.!#$%&'*+/=?^_` + "`" + `{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$`)
 )
 
@@ -53,11 +55,16 @@ func Matches(value string, rx *regexp.Regexp) bool {
 	return rx.MatchString(value)
 }
 
-// Unique returns true if all string values in a slice are unique.
-func Unique(values []string) bool {
-	uniqueValues := make(map[string]bool)
+// Unique returns true if all values in a slice are unique.
+func Unique[T comparable](values []T) bool {
+	uniqueValues := make(map[T]bool)
 	for _, value := range values {
 		uniqueValues[value] = true
 	}
 	return len(values) == len(uniqueValues)
 }
+
+// Between returns true if a value lies within the inclusive range [min, max].
+func Between[T int | int64 | float64](value, min, max T) bool {
+	return value >= min && value <= max
+}
@@ -0,0 +1,101 @@
+// Package webhook delivers signed event notifications to subscribers over HTTP.
+//
+// Each request carries the headers below. Receivers verify a request by computing
+// HMAC-SHA256 over the timestamp, a full stop and the raw body with their
+// subscription secret, comparing it with the signature in constant time, and
+// rejecting timestamps too far from their own clock to stop replays.
+//
+//	Webhook-Id:        the delivery ID, the same on every retry
+//	Webhook-Event:     the event type, such as patient.created
+//	Webhook-Timestamp: Unix seconds when the request was signed
+//	Webhook-Signature: sha256=<hex HMAC>
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// NewSecret returns a random signing secret for a subscription.
+func NewSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// Sign returns the signature of a body sent at timestamp.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Request is one attempt to deliver an event.
+type Request struct {
+	URL       string
+	Secret    string
+	ID        string
+	EventType string
+	Body      []byte
+}
+
+// Sender posts requests to subscribers.
+type Sender struct {
+	Client    *http.Client
+	UserAgent string
+}
+
+func NewSender(timeout time.Duration, userAgent string) *Sender {
+	return &Sender{
+		Client: &http.Client{
+			Timeout: timeout,
+			// Subscribers must give the URL events are delivered to, not redirect to it.
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		UserAgent: userAgent,
+	}
+}
+
+// Send posts the request and returns the response status. Any status other than 2xx
+// is returned as an error along with the status.
+func (s *Sender) Send(ctx context.Context, r Request) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.URL, bytes.NewReader(r.Body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", s.UserAgent)
+	req.Header.Set("Webhook-Id", r.ID)
+	req.Header.Set("Webhook-Event", r.EventType)
+	req.Header.Set("Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("Webhook-Signature", Sign(r.Secret, timestamp, r.Body))
+
+	res, err := s.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	// Read a little of the body so the connection can be reused.
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("subscriber responded %s", res.Status)
+	}
+	return res.StatusCode, nil
+}
@@ -0,0 +1,187 @@
+package x12
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Relationship codes of the patient to the subscriber, used when the patient isn't
+// the policy holder.
+var relationshipCodes = map[string]string{
+	"spouse": "01",
+	"child":  "19",
+	"other":  "G8",
+}
+
+type Submitter struct {
+	Name         string
+	ID           string
+	ContactName  string
+	ContactPhone string
+}
+
+type Receiver struct {
+	Name string
+	ID   string
+}
+
+// Provider is the billing provider claims are made by.
+type Provider struct {
+	Name       string
+	NPI        string
+	TaxID      string
+	Address    string
+	City       string
+	State      string
+	PostalCode string
+}
+
+type Person struct {
+	FirstName   string
+	LastName    string
+	DateOfBirth time.Time
+	// Gender is F, M or U.
+	Gender     string
+	Address    string
+	City       string
+	State      string
+	PostalCode string
+}
+
+type ServiceLine struct {
+	// ProcedureCode is a HCPCS or CPT code.
+	ProcedureCode string
+	// Charge is a decimal amount, e.g. "150.00".
+	Charge      string
+	Units       int
+	ServiceDate time.Time
+}
+
+// Claim is one professional claim. Amounts are decimal strings.
+type Claim struct {
+	ControlNumber string
+	// Sequence is P, S or T for the primary, secondary or tertiary payer.
+	Sequence    string
+	PayerName   string
+	PayerID     string
+	MemberID    string
+	GroupNumber string
+	// Relationship of the patient to the subscriber: self, spouse, child or other.
+	Relationship   string
+	Subscriber     Person
+	Patient        Person
+	Charge         string
+	PlaceOfService string
+	// Diagnoses are ICD-10-CM codes, principal first.
+	Diagnoses []string
+	Lines     []ServiceLine
+}
+
+// ProfessionalClaims is a batch of claims sent to one receiver.
+type ProfessionalClaims struct {
+	Reference string
+	Created   time.Time
+	Submitter Submitter
+	Receiver  Receiver
+	Provider  Provider
+	Claims    []Claim
+}
+
+// Write837P writes the claims as an 837 professional (005010X222A1) interchange.
+func Write837P(out io.Writer, env Envelope, b *ProfessionalClaims) error {
+	if len(b.Claims) == 0 {
+		return fmt.Errorf("x12: no claims to write")
+	}
+
+	var w writer
+	w.segment("ST", "837", "0001", "005010X222A1")
+	w.segment("BHT", "0019", "00", b.Reference, b.Created.Format("20060102"), b.Created.Format("1504"), "CH")
+	w.segment("NM1", "41", "2", b.Submitter.Name, "", "", "", "", "46", b.Submitter.ID)
+	w.segment("PER", "IC", b.Submitter.ContactName, "TE", digits(b.Submitter.ContactPhone))
+	w.segment("NM1", "40", "2", b.Receiver.Name, "", "", "", "", "46", b.Receiver.ID)
+
+	w.segment("HL", "1", "", "20", "1")
+	w.segment("NM1", "85", "2", b.Provider.Name, "", "", "", "", "XX", b.Provider.NPI)
+	w.segment("N3", b.Provider.Address)
+	w.segment("N4", b.Provider.City, b.Provider.State, b.Provider.PostalCode)
+	w.segment("REF", "EI", digits(b.Provider.TaxID))
+
+	hl := 1
+	for _, c := range b.Claims {
+		self := c.Relationship == "self" || c.Relationship == ""
+		hl++
+		subscriberHL := hl
+		child := "1"
+		relationship := ""
+		if self {
+			child = "0"
+			relationship = "18"
+		}
+		w.segment("HL", fmt.Sprint(subscriberHL), "1", "22", child)
+		w.segment("SBR", c.Sequence, relationship, c.GroupNumber, "", "", "", "", "", "CI")
+		writePerson(&w, "IL", c.Subscriber, c.MemberID, self)
+		w.segment("NM1", "PR", "2", c.PayerName, "", "", "", "", "PI", c.PayerID)
+
+		if !self {
+			hl++
+			w.segment("HL", fmt.Sprint(hl), fmt.Sprint(subscriberHL), "23", "0")
+			w.segment("PAT", relationshipCodes[c.Relationship])
+			writePerson(&w, "QC", c.Patient, "", true)
+		}
+
+		w.segment("CLM", c.ControlNumber, c.Charge, "", "", composite{c.PlaceOfService, "B", "1"}, "Y", "A", "Y", "Y")
+		hi := make([]any, 0, len(c.Diagnoses))
+		for i, code := range c.Diagnoses {
+			qualifier := "ABF"
+			if i == 0 {
+				qualifier = "ABK"
+			}
+			hi = append(hi, composite{qualifier, strings.ReplaceAll(code, ".", "")})
+		}
+		w.segment("HI", hi...)
+
+		for i, line := range c.Lines {
+			w.segment("LX", fmt.Sprint(i+1))
+			w.segment("SV1", composite{"HC", line.ProcedureCode}, line.Charge, "UN", fmt.Sprint(line.Units), "", "", "1")
+			w.segment("DTP", "472", "D8", line.ServiceDate.Format("20060102"))
+		}
+	}
+	w.segment("SE", fmt.Sprint(w.segments+1), "0001")
+
+	return writeInterchange(out, env, "HC", "005010X222A1", &w)
+}
+
+// writePerson writes the name loop of a subscriber or patient. Demographics are
+// only sent for the person receiving care.
+func writePerson(w *writer, entity string, p Person, memberID string, demographics bool) {
+	idQualifier := ""
+	if memberID != "" {
+		idQualifier = "MI"
+	}
+	w.segment("NM1", entity, "1", p.LastName, p.FirstName, "", "", "", idQualifier, memberID)
+	if !demographics {
+		return
+	}
+	if p.Address != "" {
+		w.segment("N3", p.Address)
+		w.segment("N4", p.City, p.State, p.PostalCode)
+	}
+	gender := p.Gender
+	if gender == "" {
+		gender = "U"
+	}
+	if !p.DateOfBirth.IsZero() {
+		w.segment("DMG", "D8", p.DateOfBirth.Format("20060102"), gender)
+	}
+}
+
+func digits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+}
@@ -0,0 +1,132 @@
+package x12
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// golden compares got with the named file in testdata, or rewrites the file when the
+// tests are run with -update.
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatalf("updating %s: %v", path, err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading %s: %v", path, err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output doesn't match %s\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+func TestWrite837P(t *testing.T) {
+	created := time.Date(2026, 10, 19, 9, 30, 0, 0, time.UTC)
+	env := Envelope{SenderID: "HMS001", ReceiverID: "CLEARHOUSE", ControlNumber: 42, Time: created}
+	batch := &ProfessionalClaims{
+		Reference: "BATCH-42",
+		Created:   created,
+		Submitter: Submitter{Name: "General Hospital", ID: "HMS001", ContactName: "Billing Office",
+			ContactPhone: "+234 (801) 234-5678"},
+		Receiver: Receiver{Name: "Clearhouse", ID: "CLEARHOUSE"},
+		Provider: Provider{Name: "General Hospital", NPI: "1234567893", TaxID: "12-3456789",
+			Address: "1 Marina Road", City: "Lagos", State: "LA", PostalCode: "101001"},
+		Claims: []Claim{
+			{
+				ControlNumber: "CLM1001",
+				Sequence:      "P",
+				PayerName:     "Acme Health: Gold*Plan",
+				PayerID:       "ACME01",
+				MemberID:      "M123456",
+				GroupNumber:   "G100",
+				Relationship:  "self",
+				Subscriber: Person{FirstName: "Ada", LastName: "Okafor", Gender: "F",
+					DateOfBirth: time.Date(1985, 7, 4, 0, 0, 0, 0, time.UTC),
+					Address:     "Flat 2: 14 Allen Ave~", City: "Ikeja", State: "LA", PostalCode: "100271"},
+				Charge:         "250.00",
+				PlaceOfService: "11",
+				Diagnoses:      []string{"J45.909", "E11.9"},
+				Lines: []ServiceLine{
+					{ProcedureCode: "99213", Charge: "150.00", Units: 1,
+						ServiceDate: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)},
+					{ProcedureCode: "94010", Charge: "100.00", Units: 1,
+						ServiceDate: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)},
+				},
+			},
+			{
+				ControlNumber: "CLM1002",
+				Sequence:      "S",
+				PayerName:     "Beta Mutual",
+				PayerID:       "BETA02",
+				MemberID:      "B998877",
+				Relationship:  "child",
+				Subscriber:    Person{FirstName: "Tunde", LastName: "Bello"},
+				Patient: Person{FirstName: "Kemi", LastName: "Bello^Jr", Gender: "",
+					DateOfBirth: time.Date(2015, 2, 28, 0, 0, 0, 0, time.UTC)},
+				Charge:         "80.00",
+				PlaceOfService: "22",
+				Diagnoses:      []string{"H66.90"},
+				Lines: []ServiceLine{
+					{ProcedureCode: "99212", Charge: "80.00", Units: 2,
+						ServiceDate: time.Date(2026, 10, 3, 0, 0, 0, 0, time.UTC)},
+				},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := Write837P(&buf, env, batch); err != nil {
+		t.Fatalf("Write837P: %v", err)
+	}
+	golden(t, "claims.837", buf.Bytes())
+
+	segments, err := Parse(&buf)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	for _, s := range segments {
+		if s.ID() == "SE" && s.Element(1) != "39" {
+			t.Errorf("SE01 = %s, want the 39 segments from ST to SE", s.Element(1))
+		}
+	}
+}
+
+func TestWrite837PNoClaims(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write837P(&buf, Envelope{}, &ProfessionalClaims{}); err == nil {
+		t.Error("Write837P with no claims: want an error")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("wrote %q with no claims", buf.String())
+	}
+}
+
+func TestClean(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{" Acme Health ", "ACME HEALTH"},
+		{"Gold*Plan~2", "GOLD PLAN 2"},
+		{"14 Allen Ave~", "14 ALLEN AVE"},
+		{"Suite 4: Block B^C", "SUITE 4  BLOCK B C"},
+		{"two\r\nlines", "TWO  LINES"},
+	}
+	for _, tt := range tests {
+		if got := clean(tt.in); got != tt.want {
+			t.Errorf("clean(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+	if got := (composite{"HC", "99213:25", "", ""}).String(); got != "HC:99213 25" {
+		t.Errorf("composite = %q, want %q", got, "HC:99213 25")
+	}
+}
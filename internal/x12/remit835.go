@@ -0,0 +1,141 @@
+package x12
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// Claim status codes (CLP02) with a meaning of their own. The others all say that the
+// claim was processed by a primary, secondary or tertiary payer.
+const (
+	ClaimStatusDenied   = "4"
+	ClaimStatusReversal = "22"
+)
+
+var ErrNo835 = errors.New("x12: no 835 transaction sets found")
+
+// Adjustment is a CAS adjustment explaining why a claim wasn't paid in full.
+type Adjustment struct {
+	// Group is CO, OA, PI, PR or CR.
+	Group  string
+	Reason string
+	Amount string
+}
+
+// ClaimPayment is how the payer settled one claim.
+type ClaimPayment struct {
+	ControlNumber         string
+	StatusCode            string
+	Charge                string
+	Paid                  string
+	PatientResponsibility string
+	PayerClaimNumber      string
+	Adjustments           []Adjustment
+}
+
+// Remittance is one 835 transaction set: a single payment and the claims it covers.
+type Remittance struct {
+	PaymentAmount string
+	// PaymentMethod is CHK for a cheque, ACH for a transfer or NON when nothing was paid.
+	PaymentMethod string
+	PaymentDate   time.Time
+	TraceNumber   string
+	PayerName     string
+	PayerID       string
+	Claims        []ClaimPayment
+}
+
+// Parse835 reads every 835 remittance in an interchange.
+func Parse835(r io.Reader) ([]*Remittance, error) {
+	segments, err := Parse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var remittances []*Remittance
+	var current *Remittance
+	var claim *ClaimPayment
+	loop := ""
+
+	for _, s := range segments {
+		switch s.ID() {
+		case "ST":
+			if s.Element(1) != "835" {
+				current = nil
+				continue
+			}
+			current = &Remittance{}
+			claim = nil
+			loop = ""
+		case "SE":
+			if current != nil {
+				remittances = append(remittances, current)
+			}
+			current = nil
+		}
+		if current == nil {
+			continue
+		}
+
+		switch s.ID() {
+		case "BPR":
+			current.PaymentAmount = s.Element(2)
+			current.PaymentMethod = s.Element(4)
+			if d, err := time.Parse("20060102", s.Element(16)); err == nil {
+				current.PaymentDate = d
+			}
+		case "TRN":
+			current.TraceNumber = s.Element(2)
+		case "N1":
+			loop = s.Element(1)
+			if loop == "PR" {
+				current.PayerName = s.Element(2)
+				if s.Element(3) == "XV" || s.Element(3) == "PI" {
+					current.PayerID = s.Element(4)
+				}
+			}
+		case "REF":
+			if loop == "PR" && s.Element(1) == "2U" && current.PayerID == "" {
+				current.PayerID = s.Element(2)
+			}
+			if claim != nil && s.Element(1) == "1K" && claim.PayerClaimNumber == "" {
+				claim.PayerClaimNumber = s.Element(2)
+			}
+		case "CLP":
+			loop = "CLP"
+			current.Claims = append(current.Claims, ClaimPayment{
+				ControlNumber:         s.Element(1),
+				StatusCode:            s.Element(2),
+				Charge:                s.Element(3),
+				Paid:                  s.Element(4),
+				PatientResponsibility: s.Element(5),
+				PayerClaimNumber:      s.Element(7),
+			})
+			claim = &current.Claims[len(current.Claims)-1]
+		case "SVC":
+			// Service line detail isn't kept; later CAS segments belong to the line.
+			loop = "SVC"
+		case "CAS":
+			if claim == nil || loop != "CLP" {
+				continue
+			}
+			// Reason, amount and quantity repeat up to six times after the group code.
+			for i := 2; i+1 < len(s); i += 3 {
+				if s.Element(i) == "" {
+					continue
+				}
+				claim.Adjustments = append(claim.Adjustments, Adjustment{
+					Group:  s.Element(1),
+					Reason: s.Element(i),
+					Amount: s.Element(i + 1),
+				})
+			}
+		}
+	}
+
+	if len(remittances) == 0 {
+		return nil, ErrNo835
+	}
+	return remittances, nil
+}
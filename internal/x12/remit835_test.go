@@ -0,0 +1,68 @@
+package x12
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestParse835 reads two 835 transaction sets with a 999 between them. Adjustments
+// under CLP belong to the claim; those after an SVC belong to the service line and
+// are skipped.
+func TestParse835(t *testing.T) {
+	f, err := os.Open("testdata/remittance.835")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	remittances, err := Parse835(f)
+	if err != nil {
+		t.Fatalf("Parse835: %v", err)
+	}
+	got, err := json.MarshalIndent(remittances, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "remittance.835.json", append(got, '\n'))
+}
+
+func TestParse835Delimiters(t *testing.T) {
+	raw, err := os.ReadFile("testdata/remittance.835")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := Parse835(strings.NewReader(string(raw)))
+	if err != nil {
+		t.Fatalf("Parse835: %v", err)
+	}
+
+	// The same file from a payer using | between elements and ! after segments, with
+	// no line breaks.
+	replaced := strings.NewReplacer("*", "|", "~\n", "!", "~", "!").Replace(string(raw))
+	got, err := Parse835(strings.NewReader(replaced))
+	if err != nil {
+		t.Fatalf("Parse835 with other delimiters: %v", err)
+	}
+	wantJSON, _ := json.Marshal(want)
+	gotJSON, _ := json.Marshal(got)
+	if string(gotJSON) != string(wantJSON) {
+		t.Errorf("with other delimiters got\n%s\nwant\n%s", gotJSON, wantJSON)
+	}
+}
+
+func TestParse835Errors(t *testing.T) {
+	if _, err := Parse835(strings.NewReader("GS*HP*ACMEPAYER~")); !errors.Is(err, ErrNotX12) {
+		t.Errorf("without ISA: err = %v, want ErrNotX12", err)
+	}
+
+	raw, err := os.ReadFile("testdata/claims.837")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Parse835(strings.NewReader(string(raw))); !errors.Is(err, ErrNo835) {
+		t.Errorf("837 interchange: err = %v, want ErrNo835", err)
+	}
+}
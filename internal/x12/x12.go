@@ -0,0 +1,166 @@
+// Package x12 reads and writes the ASC X12 5010 healthcare transactions exchanged
+// with payers: 837P professional claims going out and 835 remittance advice coming
+// back.
+package x12
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	elementSeparator   = '*'
+	componentSeparator = ':'
+	repetitionChar     = '^'
+	segmentTerminator  = '~'
+)
+
+var ErrNotX12 = errors.New("x12: input does not start with an ISA segment")
+
+// Segment is one segment, with its ID as the first element.
+type Segment []string
+
+func (s Segment) ID() string {
+	if len(s) == 0 {
+		return ""
+	}
+	return s[0]
+}
+
+// Element returns element n (1-based as in the implementation guides), or "" when the
+// segment is shorter.
+func (s Segment) Element(n int) string {
+	if n < len(s) {
+		return s[n]
+	}
+	return ""
+}
+
+// Envelope identifies the sender and receiver of an interchange.
+type Envelope struct {
+	SenderID   string
+	ReceiverID string
+	// ControlNumber is the interchange control number, unique per sender.
+	ControlNumber int64
+	// Production sets the usage indicator to P. Test files are marked T.
+	Production bool
+	Time       time.Time
+}
+
+// writer accumulates the segments of one transaction set.
+type writer struct {
+	buf      bytes.Buffer
+	segments int
+}
+
+// segment writes a segment, dropping trailing empty elements as the standard
+// requires. Elements are free text, cleaned of delimiters, or composites.
+func (w *writer) segment(id string, elements ...any) {
+	values := make([]string, len(elements))
+	for i, e := range elements {
+		switch e := e.(type) {
+		case composite:
+			values[i] = e.String()
+		default:
+			values[i] = clean(fmt.Sprint(e))
+		}
+	}
+	for len(values) > 0 && values[len(values)-1] == "" {
+		values = values[:len(values)-1]
+	}
+	w.buf.WriteString(id)
+	for _, v := range values {
+		w.buf.WriteByte(elementSeparator)
+		w.buf.WriteString(v)
+	}
+	w.buf.WriteByte(segmentTerminator)
+	w.buf.WriteByte('\n')
+	w.segments++
+}
+
+// composite is a composite element. Its components are cleaned and joined with the
+// component separator when the segment is written.
+type composite []string
+
+func (c composite) String() string {
+	for len(c) > 0 && c[len(c)-1] == "" {
+		c = c[:len(c)-1]
+	}
+	components := make([]string, len(c))
+	for i, component := range c {
+		components[i] = clean(component)
+	}
+	return strings.Join(components, string(componentSeparator))
+}
+
+// clean removes delimiter characters from free text and upper cases it.
+func clean(s string) string {
+	return strings.TrimSpace(strings.Map(func(r rune) rune {
+		switch r {
+		case elementSeparator, componentSeparator, segmentTerminator, repetitionChar, '\n', '\r':
+			return ' '
+		}
+		return r
+	}, strings.ToUpper(s)))
+}
+
+// fixed cuts s to n characters, for the fixed width ISA elements.
+func fixed(s string, n int) string {
+	s = clean(s)
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// writeInterchange wraps one transaction set in the ISA/GS and GE/IEA envelopes.
+func writeInterchange(out io.Writer, env Envelope, functionalID, version string, body *writer) error {
+	usage := "T"
+	if env.Production {
+		usage = "P"
+	}
+	t := env.Time.UTC()
+	control := fmt.Sprintf("%09d", env.ControlNumber)
+
+	var w writer
+	w.buf.WriteString(fmt.Sprintf("ISA*00*%-10s*00*%-10s*ZZ*%-15s*ZZ*%-15s*%s*%s*%c*00501*%s*0*%s*%c%c\n",
+		"", "", fixed(env.SenderID, 15), fixed(env.ReceiverID, 15), t.Format("060102"), t.Format("1504"),
+		repetitionChar, control, usage, componentSeparator, segmentTerminator))
+	w.segment("GS", functionalID, env.SenderID, env.ReceiverID, t.Format("20060102"), t.Format("1504"),
+		fmt.Sprint(env.ControlNumber), "X", version)
+	w.buf.Write(body.buf.Bytes())
+	w.segment("GE", "1", fmt.Sprint(env.ControlNumber))
+	w.segment("IEA", "1", control)
+
+	_, err := out.Write(w.buf.Bytes())
+	return err
+}
+
+// Parse splits an interchange into its segments. The delimiters are taken from the
+// ISA segment, so files from any payer can be read.
+func Parse(r io.Reader) ([]Segment, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	raw = bytes.TrimLeft(raw, " \t\r\n\ufeff")
+	if len(raw) < 106 || string(raw[:3]) != "ISA" {
+		return nil, ErrNotX12
+	}
+	element := raw[3]
+	terminator := raw[105]
+
+	var segments []Segment
+	for _, s := range bytes.Split(raw, []byte{terminator}) {
+		s = bytes.TrimSpace(s)
+		if len(s) == 0 {
+			continue
+		}
+		segments = append(segments, strings.Split(string(s), string(element)))
+	}
+	return segments, nil
+}
@@ -0,0 +1,35 @@
+-- +goose Up
+-- Walk-in triage queue. Priority follows the Manchester Triage System categories,
+-- 1 (immediate) being the most urgent and 5 (non-urgent) the least.
+CREATE TABLE
+    IF NOT EXISTS queue_entries (
+        id BIGSERIAL PRIMARY KEY,
+        patient_id BIGINT NOT NULL REFERENCES patients (id) ON DELETE CASCADE,
+        receptionist_id BIGINT NOT NULL REFERENCES receptionists (id),
+        doctor_id BIGINT REFERENCES doctors (id),
+        priority SMALLINT NOT NULL CHECK (priority BETWEEN 1 AND 5),
+        complaint TEXT NOT NULL DEFAULT '',
+        status VARCHAR(20) NOT NULL DEFAULT 'waiting',
+        checked_in_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            called_at TIMESTAMP
+        WITH
+            TIME ZONE,
+            completed_at TIMESTAMP
+        WITH
+            TIME ZONE,
+            version INT NOT NULL DEFAULT 1
+    );
+
+-- A patient can only be in the queue once at a time.
+CREATE UNIQUE INDEX queue_entries_active_patient_idx ON queue_entries (patient_id)
+WHERE
+    status IN ('waiting', 'called');
+
+CREATE INDEX idx_queue_entries_waiting ON queue_entries (priority, checked_in_at)
+WHERE
+    status = 'waiting';
+
+-- +goose Down
+DROP TABLE IF EXISTS queue_entries;
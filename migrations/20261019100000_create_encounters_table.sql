@@ -0,0 +1,42 @@
+-- +goose Up
+CREATE TABLE
+    IF NOT EXISTS encounters (
+        id BIGSERIAL PRIMARY KEY,
+        patient_id BIGINT NOT NULL REFERENCES patients (id) ON DELETE CASCADE,
+        doctor_id BIGINT NOT NULL REFERENCES doctors (id),
+        type VARCHAR(20) NOT NULL,
+        status VARCHAR(20) NOT NULL DEFAULT 'open',
+        reason TEXT NOT NULL DEFAULT '',
+        location VARCHAR(255) NOT NULL DEFAULT '',
+        started_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            ended_at TIMESTAMP
+        WITH
+            TIME ZONE,
+            created_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            version INT NOT NULL DEFAULT 1
+    );
+
+CREATE INDEX idx_encounters_patient ON encounters (patient_id, started_at DESC);
+
+CREATE TABLE
+    IF NOT EXISTS encounter_entries (
+        id BIGSERIAL PRIMARY KEY,
+        encounter_id BIGINT NOT NULL REFERENCES encounters (id) ON DELETE CASCADE,
+        doctor_id BIGINT NOT NULL REFERENCES doctors (id),
+        kind VARCHAR(20) NOT NULL,
+        content JSONB NOT NULL DEFAULT '{}'::jsonb,
+        created_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW ()
+    );
+
+CREATE INDEX idx_encounter_entries_encounter ON encounter_entries (encounter_id, created_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS encounter_entries;
+
+DROP TABLE IF EXISTS encounters;
@@ -0,0 +1,36 @@
+-- +goose Up
+ALTER TABLE patients
+ADD COLUMN date_of_birth DATE;
+
+-- Measurements are stored in canonical units: mmHg, beats and breaths per minute,
+-- degrees Celsius, percent, kilograms and centimetres.
+CREATE TABLE
+    IF NOT EXISTS vital_signs (
+        id BIGSERIAL PRIMARY KEY,
+        patient_id BIGINT NOT NULL REFERENCES patients (id) ON DELETE CASCADE,
+        doctor_id BIGINT NOT NULL REFERENCES doctors (id),
+        encounter_id BIGINT REFERENCES encounters (id) ON DELETE SET NULL,
+        recorded_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            systolic SMALLINT,
+            diastolic SMALLINT,
+            heart_rate SMALLINT,
+            respiratory_rate SMALLINT,
+            temperature NUMERIC(4, 1),
+            spo2 SMALLINT,
+            weight NUMERIC(6, 2),
+            height NUMERIC(5, 1),
+            bmi NUMERIC(4, 1),
+            created_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW ()
+    );
+
+CREATE INDEX idx_vital_signs_patient ON vital_signs (patient_id, recorded_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS vital_signs;
+
+ALTER TABLE patients
+DROP COLUMN date_of_birth;
@@ -0,0 +1,52 @@
+-- +goose Up
+CREATE TABLE
+    IF NOT EXISTS icd10_codes (
+        code VARCHAR(8) PRIMARY KEY,
+        description TEXT NOT NULL
+    );
+
+CREATE INDEX idx_icd10_codes_description ON icd10_codes USING GIN (to_tsvector('english', description));
+
+CREATE TABLE
+    IF NOT EXISTS problems (
+        id BIGSERIAL PRIMARY KEY,
+        patient_id BIGINT NOT NULL REFERENCES patients (id) ON DELETE CASCADE,
+        code VARCHAR(8) NOT NULL REFERENCES icd10_codes (code),
+        status VARCHAR(20) NOT NULL DEFAULT 'active',
+        onset_date DATE,
+        resolved_date DATE,
+        notes TEXT NOT NULL DEFAULT '',
+        doctor_id BIGINT NOT NULL REFERENCES doctors (id),
+        created_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            updated_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            version INT NOT NULL DEFAULT 1
+    );
+
+CREATE INDEX idx_problems_patient ON problems (patient_id, status);
+
+CREATE TABLE
+    IF NOT EXISTS diagnoses (
+        id BIGSERIAL PRIMARY KEY,
+        patient_id BIGINT NOT NULL REFERENCES patients (id) ON DELETE CASCADE,
+        problem_id BIGINT NOT NULL REFERENCES problems (id) ON DELETE CASCADE,
+        encounter_id BIGINT REFERENCES encounters (id) ON DELETE SET NULL,
+        doctor_id BIGINT NOT NULL REFERENCES doctors (id),
+        code VARCHAR(8) NOT NULL REFERENCES icd10_codes (code),
+        notes TEXT NOT NULL DEFAULT '',
+        diagnosed_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW ()
+    );
+
+CREATE INDEX idx_diagnoses_patient ON diagnoses (patient_id, diagnosed_at DESC);
+
+-- +goose Down
+DROP TABLE IF EXISTS diagnoses;
+
+DROP TABLE IF EXISTS problems;
+
+DROP TABLE IF EXISTS icd10_codes;
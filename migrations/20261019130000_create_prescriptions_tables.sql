@@ -0,0 +1,48 @@
+-- +goose Up
+CREATE TABLE
+    IF NOT EXISTS allergies (
+        id BIGSERIAL PRIMARY KEY,
+        patient_id BIGINT NOT NULL REFERENCES patients (id) ON DELETE CASCADE,
+        substance VARCHAR(255) NOT NULL,
+        reaction VARCHAR(255) NOT NULL DEFAULT '',
+        severity VARCHAR(20) NOT NULL,
+        status VARCHAR(20) NOT NULL DEFAULT 'active',
+        doctor_id BIGINT NOT NULL REFERENCES doctors (id),
+        created_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            version INT NOT NULL DEFAULT 1
+    );
+
+CREATE INDEX idx_allergies_patient ON allergies (patient_id);
+
+CREATE TABLE
+    IF NOT EXISTS prescriptions (
+        id BIGSERIAL PRIMARY KEY,
+        patient_id BIGINT NOT NULL REFERENCES patients (id) ON DELETE CASCADE,
+        doctor_id BIGINT NOT NULL REFERENCES doctors (id),
+        encounter_id BIGINT REFERENCES encounters (id) ON DELETE SET NULL,
+        drug VARCHAR(255) NOT NULL,
+        dose VARCHAR(100) NOT NULL,
+        route VARCHAR(50) NOT NULL,
+        frequency VARCHAR(100) NOT NULL,
+        duration_days INT NOT NULL,
+        refills INT NOT NULL DEFAULT 0,
+        instructions TEXT NOT NULL DEFAULT '',
+        status VARCHAR(20) NOT NULL DEFAULT 'active',
+        start_date DATE NOT NULL DEFAULT CURRENT_DATE,
+        warnings JSONB NOT NULL DEFAULT '[]'::jsonb,
+        override_reason TEXT NOT NULL DEFAULT '',
+        discontinued_reason TEXT NOT NULL DEFAULT '',
+        created_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            version INT NOT NULL DEFAULT 1
+    );
+
+CREATE INDEX idx_prescriptions_patient ON prescriptions (patient_id, status);
+
+-- +goose Down
+DROP TABLE IF EXISTS prescriptions;
+
+DROP TABLE IF EXISTS allergies;
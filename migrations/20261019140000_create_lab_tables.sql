@@ -0,0 +1,68 @@
+-- +goose Up
+CREATE TABLE
+    IF NOT EXISTS lab_orders (
+        id BIGSERIAL PRIMARY KEY,
+        patient_id BIGINT NOT NULL REFERENCES patients (id) ON DELETE CASCADE,
+        doctor_id BIGINT NOT NULL REFERENCES doctors (id),
+        encounter_id BIGINT REFERENCES encounters (id) ON DELETE SET NULL,
+        test_code VARCHAR(50) NOT NULL,
+        test_name VARCHAR(255) NOT NULL,
+        priority VARCHAR(20) NOT NULL DEFAULT 'routine',
+        notes TEXT NOT NULL DEFAULT '',
+        status VARCHAR(20) NOT NULL DEFAULT 'ordered',
+        ordered_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            collected_at TIMESTAMP
+        WITH
+            TIME ZONE,
+            resulted_at TIMESTAMP
+        WITH
+            TIME ZONE,
+            cancelled_at TIMESTAMP
+        WITH
+            TIME ZONE,
+            cancel_reason TEXT NOT NULL DEFAULT '',
+            acknowledged_at TIMESTAMP
+        WITH
+            TIME ZONE,
+            acknowledged_by BIGINT REFERENCES doctors (id),
+            version INT NOT NULL DEFAULT 1
+    );
+
+CREATE INDEX idx_lab_orders_patient ON lab_orders (patient_id, ordered_at DESC);
+
+-- Drives the results-to-acknowledge inbox.
+CREATE INDEX idx_lab_orders_unacknowledged ON lab_orders (doctor_id)
+WHERE
+    status = 'resulted'
+    AND acknowledged_at IS NULL;
+
+CREATE TABLE
+    IF NOT EXISTS lab_results (
+        id BIGSERIAL PRIMARY KEY,
+        order_id BIGINT NOT NULL REFERENCES lab_orders (id) ON DELETE CASCADE,
+        code VARCHAR(50) NOT NULL,
+        name VARCHAR(255) NOT NULL,
+        value DOUBLE PRECISION,
+        value_text TEXT NOT NULL DEFAULT '',
+        unit VARCHAR(50) NOT NULL DEFAULT '',
+        reference_low DOUBLE PRECISION,
+        reference_high DOUBLE PRECISION,
+        critical_low DOUBLE PRECISION,
+        critical_high DOUBLE PRECISION,
+        flag VARCHAR(10) NOT NULL DEFAULT '',
+        observed_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            created_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW ()
+    );
+
+CREATE INDEX idx_lab_results_order ON lab_results (order_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS lab_results;
+
+DROP TABLE IF EXISTS lab_orders;
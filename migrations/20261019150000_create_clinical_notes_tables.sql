@@ -0,0 +1,70 @@
+-- +goose Up
+CREATE TABLE
+    IF NOT EXISTS clinical_notes (
+        id BIGSERIAL PRIMARY KEY,
+        patient_id BIGINT NOT NULL REFERENCES patients (id) ON DELETE CASCADE,
+        encounter_id BIGINT REFERENCES encounters (id) ON DELETE SET NULL,
+        author_id BIGINT NOT NULL REFERENCES doctors (id),
+        type VARCHAR(20) NOT NULL,
+        title VARCHAR(255) NOT NULL DEFAULT '',
+        content JSONB NOT NULL,
+        status VARCHAR(20) NOT NULL DEFAULT 'draft',
+        signed_by BIGINT REFERENCES doctors (id),
+        signed_at TIMESTAMP
+        WITH
+            TIME ZONE,
+            created_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            updated_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            version INT NOT NULL DEFAULT 1
+    );
+
+CREATE INDEX idx_clinical_notes_patient ON clinical_notes (patient_id, created_at DESC);
+
+CREATE TABLE
+    IF NOT EXISTS clinical_note_addenda (
+        id BIGSERIAL PRIMARY KEY,
+        note_id BIGINT NOT NULL REFERENCES clinical_notes (id) ON DELETE CASCADE,
+        author_id BIGINT NOT NULL REFERENCES doctors (id),
+        text TEXT NOT NULL,
+        created_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW ()
+    );
+
+CREATE INDEX idx_clinical_note_addenda_note ON clinical_note_addenda (note_id);
+
+-- Signed notes are part of the legal record: once signed a note's text can never be
+-- changed, only added to with addenda.
+-- +goose StatementBegin
+CREATE FUNCTION clinical_notes_lock_signed() RETURNS trigger AS $$
+BEGIN
+    IF OLD.status = 'signed' AND (
+        NEW.content IS DISTINCT FROM OLD.content
+        OR NEW.title IS DISTINCT FROM OLD.title
+        OR NEW.type IS DISTINCT FROM OLD.type
+        OR NEW.status IS DISTINCT FROM OLD.status
+        OR NEW.author_id IS DISTINCT FROM OLD.author_id
+        OR NEW.signed_by IS DISTINCT FROM OLD.signed_by
+        OR NEW.signed_at IS DISTINCT FROM OLD.signed_at
+    ) THEN
+        RAISE EXCEPTION 'clinical note % is signed and cannot be changed', OLD.id;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER clinical_notes_lock_signed BEFORE
+UPDATE ON clinical_notes FOR EACH ROW
+EXECUTE FUNCTION clinical_notes_lock_signed ();
+
+-- +goose Down
+DROP TABLE IF EXISTS clinical_note_addenda;
+
+DROP TABLE IF EXISTS clinical_notes;
+
+DROP FUNCTION IF EXISTS clinical_notes_lock_signed;
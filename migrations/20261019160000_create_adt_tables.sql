@@ -0,0 +1,91 @@
+-- +goose Up
+CREATE TABLE
+    IF NOT EXISTS wards (
+        id BIGSERIAL PRIMARY KEY,
+        name VARCHAR(100) NOT NULL UNIQUE,
+        specialty VARCHAR(100) NOT NULL DEFAULT '',
+        created_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            version INT NOT NULL DEFAULT 1
+    );
+
+CREATE TABLE
+    IF NOT EXISTS rooms (
+        id BIGSERIAL PRIMARY KEY,
+        ward_id BIGINT NOT NULL REFERENCES wards (id) ON DELETE CASCADE,
+        name VARCHAR(100) NOT NULL,
+        created_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            CONSTRAINT rooms_ward_name_key UNIQUE (ward_id, name)
+    );
+
+CREATE TABLE
+    IF NOT EXISTS beds (
+        id BIGSERIAL PRIMARY KEY,
+        room_id BIGINT NOT NULL REFERENCES rooms (id) ON DELETE CASCADE,
+        label VARCHAR(50) NOT NULL,
+        cleaning_status VARCHAR(20) NOT NULL DEFAULT 'clean',
+        out_of_service BOOLEAN NOT NULL DEFAULT FALSE,
+        created_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            version INT NOT NULL DEFAULT 1,
+            CONSTRAINT beds_room_label_key UNIQUE (room_id, label)
+    );
+
+CREATE TABLE
+    IF NOT EXISTS admissions (
+        id BIGSERIAL PRIMARY KEY,
+        patient_id BIGINT NOT NULL REFERENCES patients (id) ON DELETE CASCADE,
+        bed_id BIGINT NOT NULL REFERENCES beds (id),
+        doctor_id BIGINT REFERENCES doctors (id),
+        receptionist_id BIGINT NOT NULL REFERENCES receptionists (id),
+        reason TEXT NOT NULL DEFAULT '',
+        status VARCHAR(20) NOT NULL DEFAULT 'admitted',
+        admitted_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            discharged_at TIMESTAMP
+        WITH
+            TIME ZONE,
+            discharge_disposition VARCHAR(50) NOT NULL DEFAULT '',
+            version INT NOT NULL DEFAULT 1
+    );
+
+-- A patient can only be admitted once at a time and a bed can only hold one patient.
+CREATE UNIQUE INDEX admissions_active_patient_idx ON admissions (patient_id)
+WHERE
+    status = 'admitted';
+
+CREATE UNIQUE INDEX admissions_active_bed_idx ON admissions (bed_id)
+WHERE
+    status = 'admitted';
+
+CREATE TABLE
+    IF NOT EXISTS bed_movements (
+        id BIGSERIAL PRIMARY KEY,
+        admission_id BIGINT NOT NULL REFERENCES admissions (id) ON DELETE CASCADE,
+        type VARCHAR(20) NOT NULL,
+        from_bed_id BIGINT REFERENCES beds (id),
+        to_bed_id BIGINT REFERENCES beds (id),
+        receptionist_id BIGINT NOT NULL REFERENCES receptionists (id),
+        reason TEXT NOT NULL DEFAULT '',
+        moved_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW ()
+    );
+
+CREATE INDEX idx_bed_movements_admission ON bed_movements (admission_id, moved_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS bed_movements;
+
+DROP TABLE IF EXISTS admissions;
+
+DROP TABLE IF EXISTS beds;
+
+DROP TABLE IF EXISTS rooms;
+
+DROP TABLE IF EXISTS wards;
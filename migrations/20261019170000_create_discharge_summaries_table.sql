@@ -0,0 +1,23 @@
+-- +goose Up
+CREATE TABLE
+    IF NOT EXISTS discharge_summaries (
+        id BIGSERIAL PRIMARY KEY,
+        patient_id BIGINT NOT NULL REFERENCES patients (id) ON DELETE CASCADE,
+        admission_id BIGINT REFERENCES admissions (id) ON DELETE SET NULL,
+        note_id BIGINT NOT NULL REFERENCES clinical_notes (id),
+        doctor_id BIGINT NOT NULL REFERENCES doctors (id),
+        file_name VARCHAR(255) NOT NULL,
+        content BYTEA NOT NULL,
+        size_bytes INT NOT NULL,
+        emailed_at TIMESTAMP
+        WITH
+            TIME ZONE,
+            created_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW ()
+    );
+
+CREATE INDEX idx_discharge_summaries_patient ON discharge_summaries (patient_id, created_at DESC);
+
+-- +goose Down
+DROP TABLE IF EXISTS discharge_summaries;
@@ -0,0 +1,27 @@
+-- +goose Up
+CREATE TABLE
+    IF NOT EXISTS patient_attachments (
+        id BIGSERIAL PRIMARY KEY,
+        patient_id BIGINT NOT NULL REFERENCES patients (id) ON DELETE CASCADE,
+        category VARCHAR(30) NOT NULL,
+        title VARCHAR(255) NOT NULL DEFAULT '',
+        file_name VARCHAR(255) NOT NULL,
+        content_type VARCHAR(100) NOT NULL,
+        size_bytes BIGINT NOT NULL,
+        checksum_sha256 CHAR(64) NOT NULL,
+        storage_key VARCHAR(255) NOT NULL UNIQUE,
+        uploaded_by_doctor_id BIGINT REFERENCES doctors (id),
+        uploaded_by_receptionist_id BIGINT REFERENCES receptionists (id),
+        created_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            CHECK (
+                uploaded_by_doctor_id IS NOT NULL
+                OR uploaded_by_receptionist_id IS NOT NULL
+            )
+    );
+
+CREATE INDEX idx_patient_attachments_patient ON patient_attachments (patient_id, created_at DESC);
+
+-- +goose Down
+DROP TABLE IF EXISTS patient_attachments;
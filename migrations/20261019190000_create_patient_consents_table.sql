@@ -0,0 +1,33 @@
+-- +goose Up
+CREATE TABLE
+    IF NOT EXISTS patient_consents (
+        id BIGSERIAL PRIMARY KEY,
+        patient_id BIGINT NOT NULL REFERENCES patients (id) ON DELETE CASCADE,
+        type VARCHAR(20) NOT NULL,
+        text_version VARCHAR(50) NOT NULL,
+        consented_on DATE NOT NULL,
+        witness_id BIGINT NOT NULL REFERENCES receptionists (id),
+        superseded_at TIMESTAMP
+        WITH
+            TIME ZONE,
+            withdrawn_at TIMESTAMP
+        WITH
+            TIME ZONE,
+            withdrawn_witness_id BIGINT REFERENCES receptionists (id),
+            withdrawal_reason TEXT NOT NULL DEFAULT '',
+            created_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            version INT NOT NULL DEFAULT 1
+    );
+
+CREATE INDEX idx_patient_consents_patient ON patient_consents (patient_id, type, created_at DESC);
+
+-- A patient holds at most one active consent of each type.
+CREATE UNIQUE INDEX patient_consents_active_idx ON patient_consents (patient_id, type)
+WHERE
+    superseded_at IS NULL
+    AND withdrawn_at IS NULL;
+
+-- +goose Down
+DROP TABLE IF EXISTS patient_consents;
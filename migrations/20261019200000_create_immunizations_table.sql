@@ -0,0 +1,24 @@
+-- +goose Up
+CREATE TABLE
+    IF NOT EXISTS immunizations (
+        id BIGSERIAL PRIMARY KEY,
+        patient_id BIGINT NOT NULL REFERENCES patients (id) ON DELETE CASCADE,
+        vaccine_code VARCHAR(30) NOT NULL,
+        vaccine_name VARCHAR(255) NOT NULL,
+        dose_number INT NOT NULL CHECK (dose_number > 0),
+        lot_number VARCHAR(50) NOT NULL DEFAULT '',
+        site VARCHAR(20) NOT NULL,
+        administered_by VARCHAR(255) NOT NULL,
+        administered_on DATE NOT NULL,
+        notes TEXT NOT NULL DEFAULT '',
+        recorded_by BIGINT NOT NULL REFERENCES doctors (id),
+        created_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            CONSTRAINT immunizations_patient_dose_key UNIQUE (patient_id, vaccine_code, dose_number)
+    );
+
+CREATE INDEX idx_immunizations_patient ON immunizations (patient_id, administered_on DESC);
+
+-- +goose Down
+DROP TABLE IF EXISTS immunizations;
@@ -0,0 +1,43 @@
+-- +goose Up
+CREATE TABLE
+    IF NOT EXISTS referrals (
+        id BIGSERIAL PRIMARY KEY,
+        patient_id BIGINT NOT NULL REFERENCES patients (id) ON DELETE CASCADE,
+        sender_id BIGINT NOT NULL REFERENCES doctors (id),
+        recipient_id BIGINT REFERENCES doctors (id),
+        external_facility VARCHAR(255) NOT NULL DEFAULT '',
+        external_email VARCHAR(255) NOT NULL DEFAULT '',
+        urgency VARCHAR(20) NOT NULL DEFAULT 'routine',
+        reason TEXT NOT NULL,
+        status VARCHAR(20) NOT NULL DEFAULT 'sent',
+        response_note TEXT NOT NULL DEFAULT '',
+        sent_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            responded_at TIMESTAMP
+        WITH
+            TIME ZONE,
+            completed_at TIMESTAMP
+        WITH
+            TIME ZONE,
+            version INT NOT NULL DEFAULT 1,
+            CHECK (
+                (recipient_id IS NULL) <> (external_facility = '')
+            )
+    );
+
+CREATE INDEX idx_referrals_patient ON referrals (patient_id, sent_at DESC);
+
+CREATE INDEX idx_referrals_recipient ON referrals (recipient_id, status, sent_at DESC);
+
+CREATE TABLE
+    IF NOT EXISTS referral_attachments (
+        referral_id BIGINT NOT NULL REFERENCES referrals (id) ON DELETE CASCADE,
+        attachment_id BIGINT NOT NULL REFERENCES patient_attachments (id) ON DELETE CASCADE,
+        PRIMARY KEY (referral_id, attachment_id)
+    );
+
+-- +goose Down
+DROP TABLE IF EXISTS referral_attachments;
+
+DROP TABLE IF EXISTS referrals;
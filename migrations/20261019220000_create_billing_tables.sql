@@ -0,0 +1,87 @@
+-- +goose Up
+-- Money is stored as BIGINT minor units (e.g. kobo or cents) next to its ISO 4217
+-- currency code.
+CREATE TABLE
+    IF NOT EXISTS billing_services (
+        id BIGSERIAL PRIMARY KEY,
+        code VARCHAR(30) NOT NULL,
+        name VARCHAR(255) NOT NULL,
+        unit_price BIGINT NOT NULL CHECK (unit_price >= 0),
+        currency CHAR(3) NOT NULL,
+        active BOOLEAN NOT NULL DEFAULT TRUE,
+        created_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            version INT NOT NULL DEFAULT 1,
+            CONSTRAINT billing_services_code_key UNIQUE (code)
+    );
+
+CREATE TABLE
+    IF NOT EXISTS invoices (
+        id BIGSERIAL PRIMARY KEY,
+        number TEXT GENERATED ALWAYS AS ('INV-' || lpad (id::text, 6, '0')) STORED,
+        patient_id BIGINT NOT NULL REFERENCES patients (id) ON DELETE RESTRICT,
+        currency CHAR(3) NOT NULL,
+        total BIGINT NOT NULL CHECK (total >= 0),
+        paid BIGINT NOT NULL DEFAULT 0 CHECK (
+            paid >= 0
+            AND paid <= total
+        ),
+        status VARCHAR(10) NOT NULL DEFAULT 'open',
+        notes TEXT NOT NULL DEFAULT '',
+        due_on DATE,
+        created_by BIGINT NOT NULL REFERENCES receptionists (id),
+        issued_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            voided_at TIMESTAMP
+        WITH
+            TIME ZONE,
+            void_reason TEXT NOT NULL DEFAULT '',
+            version INT NOT NULL DEFAULT 1
+    );
+
+CREATE INDEX idx_invoices_patient ON invoices (patient_id, issued_at DESC);
+
+CREATE TABLE
+    IF NOT EXISTS invoice_lines (
+        id BIGSERIAL PRIMARY KEY,
+        invoice_id BIGINT NOT NULL REFERENCES invoices (id) ON DELETE CASCADE,
+        service_id BIGINT REFERENCES billing_services (id),
+        description VARCHAR(255) NOT NULL,
+        quantity INT NOT NULL CHECK (quantity > 0),
+        unit_price BIGINT NOT NULL CHECK (unit_price >= 0),
+        amount BIGINT NOT NULL CHECK (amount >= 0)
+    );
+
+CREATE INDEX idx_invoice_lines_invoice ON invoice_lines (invoice_id);
+
+CREATE TABLE
+    IF NOT EXISTS payments (
+        id BIGSERIAL PRIMARY KEY,
+        invoice_id BIGINT NOT NULL REFERENCES invoices (id),
+        patient_id BIGINT NOT NULL REFERENCES patients (id) ON DELETE RESTRICT,
+        amount BIGINT NOT NULL CHECK (amount > 0),
+        currency CHAR(3) NOT NULL,
+        method VARCHAR(20) NOT NULL,
+        receipt_number VARCHAR(50) NOT NULL,
+        notes TEXT NOT NULL DEFAULT '',
+        received_by BIGINT NOT NULL REFERENCES receptionists (id),
+        received_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            CONSTRAINT payments_receipt_number_key UNIQUE (receipt_number)
+    );
+
+CREATE INDEX idx_payments_patient ON payments (patient_id, received_at);
+
+CREATE INDEX idx_payments_invoice ON payments (invoice_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS payments;
+
+DROP TABLE IF EXISTS invoice_lines;
+
+DROP TABLE IF EXISTS invoices;
+
+DROP TABLE IF EXISTS billing_services;
@@ -0,0 +1,139 @@
+-- +goose Up
+CREATE TABLE
+    IF NOT EXISTS insurance_policies (
+        id BIGSERIAL PRIMARY KEY,
+        patient_id BIGINT NOT NULL REFERENCES patients (id) ON DELETE CASCADE,
+        payer_name VARCHAR(255) NOT NULL,
+        -- payer_id is the payer's electronic ID that claims are routed by.
+        payer_id VARCHAR(80) NOT NULL,
+        member_id VARCHAR(80) NOT NULL,
+        group_number VARCHAR(50) NOT NULL DEFAULT '',
+        plan_name VARCHAR(255) NOT NULL DEFAULT '',
+        -- 1 is the primary policy, 2 secondary and 3 tertiary.
+        priority SMALLINT NOT NULL DEFAULT 1 CHECK (priority BETWEEN 1 AND 3),
+        relationship VARCHAR(10) NOT NULL DEFAULT 'self',
+        subscriber_first_name VARCHAR(100) NOT NULL DEFAULT '',
+        subscriber_last_name VARCHAR(100) NOT NULL DEFAULT '',
+        subscriber_date_of_birth DATE,
+        subscriber_gender CHAR(1) NOT NULL DEFAULT 'U',
+        address VARCHAR(255) NOT NULL DEFAULT '',
+        city VARCHAR(100) NOT NULL DEFAULT '',
+        state VARCHAR(50) NOT NULL DEFAULT '',
+        postal_code VARCHAR(20) NOT NULL DEFAULT '',
+        valid_from DATE NOT NULL,
+        valid_to DATE CHECK (
+            valid_to IS NULL
+            OR valid_to >= valid_from
+        ),
+        created_by BIGINT NOT NULL REFERENCES receptionists (id),
+        created_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            version INT NOT NULL DEFAULT 1
+    );
+
+CREATE INDEX idx_insurance_policies_patient ON insurance_policies (patient_id, priority);
+
+CREATE INDEX idx_insurance_policies_payer ON insurance_policies (payer_id);
+
+-- Eligibility is checked with the payer when the patient checks in and recorded
+-- against the visit.
+CREATE TABLE
+    IF NOT EXISTS eligibility_checks (
+        id BIGSERIAL PRIMARY KEY,
+        queue_entry_id BIGINT NOT NULL REFERENCES queue_entries (id) ON DELETE CASCADE,
+        patient_id BIGINT NOT NULL REFERENCES patients (id) ON DELETE CASCADE,
+        policy_id BIGINT NOT NULL REFERENCES insurance_policies (id) ON DELETE CASCADE,
+        status VARCHAR(20) NOT NULL,
+        reference VARCHAR(80) NOT NULL DEFAULT '',
+        notes TEXT NOT NULL DEFAULT '',
+        checked_by BIGINT NOT NULL REFERENCES receptionists (id),
+        checked_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW ()
+    );
+
+CREATE INDEX idx_eligibility_checks_queue_entry ON eligibility_checks (queue_entry_id, checked_at DESC);
+
+CREATE TABLE
+    IF NOT EXISTS claim_batches (
+        id BIGSERIAL PRIMARY KEY,
+        payer_id VARCHAR(80) NOT NULL,
+        payer_name VARCHAR(255) NOT NULL,
+        currency CHAR(3) NOT NULL,
+        claim_count INT NOT NULL DEFAULT 0,
+        total_charge BIGINT NOT NULL DEFAULT 0,
+        -- file is the 837 interchange as generated, kept so that it can be
+        -- downloaded again unchanged.
+        file TEXT NOT NULL DEFAULT '',
+        created_by BIGINT NOT NULL REFERENCES receptionists (id),
+        created_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW ()
+    );
+
+CREATE TABLE
+    IF NOT EXISTS remittances (
+        id BIGSERIAL PRIMARY KEY,
+        payer_id VARCHAR(80) NOT NULL DEFAULT '',
+        payer_name VARCHAR(255) NOT NULL DEFAULT '',
+        trace_number VARCHAR(80) NOT NULL,
+        payment_amount BIGINT NOT NULL,
+        currency CHAR(3) NOT NULL,
+        payment_method VARCHAR(10) NOT NULL DEFAULT '',
+        payment_date DATE,
+        claims_matched INT NOT NULL DEFAULT 0,
+        claims_unmatched INT NOT NULL DEFAULT 0,
+        imported_by BIGINT NOT NULL REFERENCES receptionists (id),
+        imported_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            CONSTRAINT remittances_trace_key UNIQUE (payer_id, trace_number)
+    );
+
+CREATE TABLE
+    IF NOT EXISTS claims (
+        id BIGSERIAL PRIMARY KEY,
+        control_number TEXT GENERATED ALWAYS AS ('CLM' || lpad (id::text, 9, '0')) STORED,
+        batch_id BIGINT NOT NULL REFERENCES claim_batches (id) ON DELETE CASCADE,
+        invoice_id BIGINT NOT NULL REFERENCES invoices (id),
+        patient_id BIGINT NOT NULL REFERENCES patients (id) ON DELETE RESTRICT,
+        policy_id BIGINT NOT NULL REFERENCES insurance_policies (id),
+        charge BIGINT NOT NULL,
+        currency CHAR(3) NOT NULL,
+        status VARCHAR(20) NOT NULL DEFAULT 'submitted',
+        paid BIGINT NOT NULL DEFAULT 0,
+        patient_responsibility BIGINT NOT NULL DEFAULT 0,
+        payer_claim_number VARCHAR(80) NOT NULL DEFAULT '',
+        adjustments JSONB NOT NULL DEFAULT '[]'::jsonb,
+        remittance_id BIGINT REFERENCES remittances (id),
+        adjudicated_at TIMESTAMP
+        WITH
+            TIME ZONE,
+            created_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            version INT NOT NULL DEFAULT 1
+    );
+
+CREATE UNIQUE INDEX claims_control_number_idx ON claims (control_number);
+
+-- An invoice can only be claimed again once the earlier claim has been denied.
+CREATE UNIQUE INDEX claims_open_invoice_idx ON claims (invoice_id)
+WHERE
+    status <> 'denied';
+
+CREATE INDEX idx_claims_batch ON claims (batch_id);
+
+CREATE INDEX idx_claims_patient ON claims (patient_id, created_at DESC);
+
+-- +goose Down
+DROP TABLE IF EXISTS claims;
+
+DROP TABLE IF EXISTS remittances;
+
+DROP TABLE IF EXISTS claim_batches;
+
+DROP TABLE IF EXISTS eligibility_checks;
+
+DROP TABLE IF EXISTS insurance_policies;
@@ -0,0 +1,62 @@
+-- +goose Up
+CREATE TABLE
+    IF NOT EXISTS departments (
+        id BIGSERIAL PRIMARY KEY,
+        name VARCHAR(100) NOT NULL,
+        description TEXT NOT NULL DEFAULT '',
+        created_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            version INT NOT NULL DEFAULT 1,
+            CONSTRAINT departments_name_key UNIQUE (name)
+    );
+
+CREATE TABLE
+    IF NOT EXISTS specialties (
+        id BIGSERIAL PRIMARY KEY,
+        name VARCHAR(100) NOT NULL,
+        created_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            CONSTRAINT specialties_name_key UNIQUE (name)
+    );
+
+ALTER TABLE doctors
+ADD COLUMN department_id BIGINT REFERENCES departments (id) ON DELETE SET NULL,
+ADD COLUMN title VARCHAR(100) NOT NULL DEFAULT '',
+ADD COLUMN license_number VARCHAR(50) NOT NULL DEFAULT '',
+ADD COLUMN license_expires_on DATE,
+ADD COLUMN phone VARCHAR(30) NOT NULL DEFAULT '',
+ADD COLUMN bio TEXT NOT NULL DEFAULT '',
+ADD COLUMN version INT NOT NULL DEFAULT 1;
+
+CREATE UNIQUE INDEX doctors_license_number_idx ON doctors (license_number)
+WHERE
+    license_number <> '';
+
+CREATE INDEX idx_doctors_department ON doctors (department_id);
+
+CREATE TABLE
+    IF NOT EXISTS doctor_specialties (
+        doctor_id BIGINT NOT NULL REFERENCES doctors (id) ON DELETE CASCADE,
+        specialty_id BIGINT NOT NULL REFERENCES specialties (id) ON DELETE CASCADE,
+        PRIMARY KEY (doctor_id, specialty_id)
+    );
+
+CREATE INDEX idx_doctor_specialties_specialty ON doctor_specialties (specialty_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS doctor_specialties;
+
+ALTER TABLE doctors
+DROP COLUMN IF EXISTS department_id,
+DROP COLUMN IF EXISTS title,
+DROP COLUMN IF EXISTS license_number,
+DROP COLUMN IF EXISTS license_expires_on,
+DROP COLUMN IF EXISTS phone,
+DROP COLUMN IF EXISTS bio,
+DROP COLUMN IF EXISTS version;
+
+DROP TABLE IF EXISTS specialties;
+
+DROP TABLE IF EXISTS departments;
@@ -0,0 +1,84 @@
+-- +goose Up
+-- A shift belongs to exactly one member of staff, either a doctor or a
+-- receptionist. Shifts are drafts until the department's week is published.
+CREATE TABLE
+    IF NOT EXISTS shifts (
+        id BIGSERIAL PRIMARY KEY,
+        doctor_id BIGINT REFERENCES doctors (id) ON DELETE CASCADE,
+        receptionist_id BIGINT REFERENCES receptionists (id) ON DELETE CASCADE,
+        department_id BIGINT NOT NULL REFERENCES departments (id) ON DELETE CASCADE,
+        shift_type VARCHAR(20) NOT NULL,
+        starts_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL,
+            ends_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL,
+            notes TEXT NOT NULL DEFAULT '',
+            published_at TIMESTAMP
+        WITH
+            TIME ZONE,
+            created_by BIGINT NOT NULL REFERENCES receptionists (id),
+            created_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            version INT NOT NULL DEFAULT 1,
+            CHECK ((doctor_id IS NULL) <> (receptionist_id IS NULL)),
+            CHECK (ends_at > starts_at)
+    );
+
+CREATE INDEX idx_shifts_department ON shifts (department_id, starts_at);
+
+CREATE INDEX idx_shifts_doctor ON shifts (doctor_id, starts_at)
+WHERE
+    doctor_id IS NOT NULL;
+
+CREATE INDEX idx_shifts_receptionist ON shifts (receptionist_id, starts_at)
+WHERE
+    receptionist_id IS NOT NULL;
+
+-- week_start is the Monday of the published week.
+CREATE TABLE
+    IF NOT EXISTS rota_publications (
+        department_id BIGINT NOT NULL REFERENCES departments (id) ON DELETE CASCADE,
+        week_start DATE NOT NULL,
+        published_by BIGINT NOT NULL REFERENCES receptionists (id),
+        published_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            PRIMARY KEY (department_id, week_start)
+    );
+
+CREATE TABLE
+    IF NOT EXISTS shift_swaps (
+        id BIGSERIAL PRIMARY KEY,
+        shift_id BIGINT NOT NULL REFERENCES shifts (id) ON DELETE CASCADE,
+        -- The member of staff the shift was with when the swap was requested.
+        requester_id BIGINT NOT NULL,
+        target_staff_id BIGINT NOT NULL,
+        -- target_shift_id is the shift given back in return, if any.
+        target_shift_id BIGINT REFERENCES shifts (id) ON DELETE CASCADE,
+        reason TEXT NOT NULL DEFAULT '',
+        status VARCHAR(10) NOT NULL DEFAULT 'pending',
+        requested_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            decided_by BIGINT REFERENCES receptionists (id),
+            decided_at TIMESTAMP
+        WITH
+            TIME ZONE,
+            decision_note TEXT NOT NULL DEFAULT '',
+            version INT NOT NULL DEFAULT 1
+    );
+
+-- Only one swap can be pending for a shift at a time.
+CREATE UNIQUE INDEX shift_swaps_pending_idx ON shift_swaps (shift_id)
+WHERE
+    status = 'pending';
+
+-- +goose Down
+DROP TABLE IF EXISTS shift_swaps;
+
+DROP TABLE IF EXISTS rota_publications;
+
+DROP TABLE IF EXISTS shifts;
@@ -0,0 +1,52 @@
+-- +goose Up
+-- Identifiers other systems know patients by, such as the MRN from the legacy PAS.
+-- system is the assigning authority, or the sending application when the
+-- identifier doesn't name one.
+CREATE TABLE
+    IF NOT EXISTS patient_identifiers (
+        patient_id BIGINT NOT NULL REFERENCES patients (id) ON DELETE CASCADE,
+        system VARCHAR(100) NOT NULL,
+        value VARCHAR(100) NOT NULL,
+        created_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            PRIMARY KEY (system, value)
+    );
+
+CREATE INDEX idx_patient_identifiers_patient ON patient_identifiers (patient_id);
+
+-- Every HL7 v2 message received, kept so that failed messages can be replayed.
+CREATE TABLE
+    IF NOT EXISTS hl7_messages (
+        id BIGSERIAL PRIMARY KEY,
+        sending_application VARCHAR(180) NOT NULL DEFAULT '',
+        sending_facility VARCHAR(180) NOT NULL DEFAULT '',
+        control_id VARCHAR(199) NOT NULL DEFAULT '',
+        message_type VARCHAR(20) NOT NULL DEFAULT '',
+        raw TEXT NOT NULL,
+        status VARCHAR(20) NOT NULL DEFAULT 'received',
+        ack_code VARCHAR(2) NOT NULL DEFAULT '',
+        error TEXT NOT NULL DEFAULT '',
+        patient_id BIGINT REFERENCES patients (id) ON DELETE SET NULL,
+        visit JSONB,
+        attempts INT NOT NULL DEFAULT 0,
+        received_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            processed_at TIMESTAMP
+        WITH
+            TIME ZONE
+    );
+
+-- Senders resend messages they didn't get an ACK for, so a control ID is only
+-- processed once per sender. Messages too broken to have one are all kept.
+CREATE UNIQUE INDEX hl7_messages_control_idx ON hl7_messages (sending_application, sending_facility, control_id)
+WHERE
+    control_id <> '';
+
+CREATE INDEX idx_hl7_messages_status ON hl7_messages (status, received_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS hl7_messages;
+
+DROP TABLE IF EXISTS patient_identifiers;
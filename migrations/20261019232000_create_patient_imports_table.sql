@@ -0,0 +1,32 @@
+-- +goose Up
+-- Bulk patient imports from CSV. The uploaded file is kept with the job so the
+-- import can be run after the request that uploaded it has returned.
+CREATE TABLE
+    IF NOT EXISTS patient_imports (
+        id BIGSERIAL PRIMARY KEY,
+        receptionist_id BIGINT NOT NULL REFERENCES receptionists (id),
+        file_name VARCHAR(255) NOT NULL DEFAULT '',
+        source TEXT NOT NULL,
+        mapping JSONB NOT NULL DEFAULT '{}',
+        skip_invalid BOOLEAN NOT NULL DEFAULT FALSE,
+        status VARCHAR(20) NOT NULL DEFAULT 'pending',
+        total_rows INT NOT NULL DEFAULT 0,
+        processed_rows INT NOT NULL DEFAULT 0,
+        imported_rows INT NOT NULL DEFAULT 0,
+        report JSONB NOT NULL DEFAULT '[]',
+        error TEXT NOT NULL DEFAULT '',
+        created_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            started_at TIMESTAMP
+        WITH
+            TIME ZONE,
+            finished_at TIMESTAMP
+        WITH
+            TIME ZONE
+    );
+
+CREATE INDEX idx_patient_imports_created ON patient_imports (created_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS patient_imports;
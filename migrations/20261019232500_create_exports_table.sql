@@ -0,0 +1,38 @@
+-- +goose Up
+-- Bulk exports of patient data. Each exported type is written to its own NDJSON
+-- file, listed in output once the export has finished.
+CREATE TABLE
+    IF NOT EXISTS exports (
+        id BIGSERIAL PRIMARY KEY,
+        receptionist_id BIGINT NOT NULL REFERENCES receptionists (id),
+        types TEXT[] NOT NULL,
+        since TIMESTAMP
+        WITH
+            TIME ZONE,
+            until TIMESTAMP
+        WITH
+            TIME ZONE,
+            status VARCHAR(20) NOT NULL DEFAULT 'pending',
+            current_type VARCHAR(50) NOT NULL DEFAULT '',
+            exported_types INT NOT NULL DEFAULT 0,
+            exported_records BIGINT NOT NULL DEFAULT 0,
+            output JSONB NOT NULL DEFAULT '[]',
+            error TEXT NOT NULL DEFAULT '',
+            created_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            started_at TIMESTAMP
+        WITH
+            TIME ZONE,
+            finished_at TIMESTAMP
+        WITH
+            TIME ZONE,
+            expires_at TIMESTAMP
+        WITH
+            TIME ZONE
+    );
+
+CREATE INDEX idx_exports_status ON exports (status, expires_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS exports;
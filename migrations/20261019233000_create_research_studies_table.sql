@@ -0,0 +1,30 @@
+-- +goose Up
+-- Research studies receive de-identified exports. Each study has its own pseudonym
+-- key, so pseudonyms are stable across a study's exports but can't be linked
+-- between studies.
+CREATE TABLE
+    IF NOT EXISTS research_studies (
+        id BIGSERIAL PRIMARY KEY,
+        name VARCHAR(255) NOT NULL UNIQUE,
+        description TEXT NOT NULL DEFAULT '',
+        pseudonym_key BYTEA NOT NULL,
+        types TEXT[] NOT NULL,
+        data_fields TEXT[] NOT NULL DEFAULT '{}',
+        created_by BIGINT NOT NULL REFERENCES receptionists (id),
+        created_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            updated_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            version INT NOT NULL DEFAULT 1
+    );
+
+ALTER TABLE exports
+ADD COLUMN study_id BIGINT REFERENCES research_studies (id) ON DELETE CASCADE;
+
+-- +goose Down
+ALTER TABLE exports
+DROP COLUMN study_id;
+
+DROP TABLE IF EXISTS research_studies;
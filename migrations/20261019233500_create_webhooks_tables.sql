@@ -0,0 +1,75 @@
+-- +goose Up
+-- Domain events are written to the outbox in the same transaction as the change they
+-- describe, so an event is recorded if and only if the change is committed.
+CREATE TABLE
+    IF NOT EXISTS outbox_events (
+        id BIGSERIAL PRIMARY KEY,
+        type VARCHAR(100) NOT NULL,
+        payload JSONB NOT NULL,
+        created_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            dispatched_at TIMESTAMP
+        WITH
+            TIME ZONE
+    );
+
+CREATE INDEX idx_outbox_events_pending ON outbox_events (id)
+WHERE
+    dispatched_at IS NULL;
+
+-- An empty event_types list subscribes to every event.
+CREATE TABLE
+    IF NOT EXISTS webhook_subscriptions (
+        id BIGSERIAL PRIMARY KEY,
+        url TEXT NOT NULL,
+        description TEXT NOT NULL DEFAULT '',
+        secret TEXT NOT NULL,
+        event_types TEXT[] NOT NULL DEFAULT '{}',
+        active BOOLEAN NOT NULL DEFAULT TRUE,
+        created_by BIGINT NOT NULL REFERENCES receptionists (id),
+        created_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            updated_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            version INT NOT NULL DEFAULT 1
+    );
+
+CREATE TABLE
+    IF NOT EXISTS webhook_deliveries (
+        id BIGSERIAL PRIMARY KEY,
+        subscription_id BIGINT NOT NULL REFERENCES webhook_subscriptions (id) ON DELETE CASCADE,
+        event_id BIGINT NOT NULL REFERENCES outbox_events (id) ON DELETE CASCADE,
+        status VARCHAR(20) NOT NULL DEFAULT 'pending',
+        attempts INT NOT NULL DEFAULT 0,
+        next_attempt_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            last_attempt_at TIMESTAMP
+        WITH
+            TIME ZONE,
+            response_status INT,
+            last_error TEXT NOT NULL DEFAULT '',
+            created_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            delivered_at TIMESTAMP
+        WITH
+            TIME ZONE,
+            CONSTRAINT webhook_deliveries_subscription_event_key UNIQUE (subscription_id, event_id)
+    );
+
+CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries (next_attempt_at)
+WHERE
+    status = 'pending';
+
+CREATE INDEX idx_webhook_deliveries_subscription ON webhook_deliveries (subscription_id, created_at DESC);
+
+-- +goose Down
+DROP TABLE IF EXISTS webhook_deliveries;
+
+DROP TABLE IF EXISTS webhook_subscriptions;
+
+DROP TABLE IF EXISTS outbox_events;
@@ -0,0 +1,45 @@
+-- +goose Up
+-- Background work is queued here rather than run in goroutines, so it survives
+-- restarts and failures are retried. A running job holds a lease in locked_until,
+-- which its worker keeps extending; a job whose lease runs out is picked up again.
+CREATE TABLE
+    IF NOT EXISTS jobs (
+        id BIGSERIAL PRIMARY KEY,
+        kind VARCHAR(100) NOT NULL,
+        args JSONB NOT NULL DEFAULT '{}',
+        status VARCHAR(20) NOT NULL DEFAULT 'pending',
+        attempts INT NOT NULL DEFAULT 0,
+        max_attempts INT NOT NULL,
+        run_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            unique_key VARCHAR(255),
+            locked_until TIMESTAMP
+        WITH
+            TIME ZONE,
+            last_error TEXT NOT NULL DEFAULT '',
+            created_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            started_at TIMESTAMP
+        WITH
+            TIME ZONE,
+            finished_at TIMESTAMP
+        WITH
+            TIME ZONE
+    );
+
+-- Only one job with a given unique key can be waiting or running at a time.
+CREATE UNIQUE INDEX jobs_unique_key ON jobs (unique_key)
+WHERE
+    unique_key IS NOT NULL
+    AND status IN ('pending', 'running');
+
+CREATE INDEX idx_jobs_due ON jobs (run_at)
+WHERE
+    status = 'pending';
+
+CREATE INDEX idx_jobs_status ON jobs (status, created_at DESC);
+
+-- +goose Down
+DROP TABLE IF EXISTS jobs;
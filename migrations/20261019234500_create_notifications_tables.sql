@@ -0,0 +1,49 @@
+-- +goose Up
+-- Patients without a row get notifications by email at any time of day. Quiet
+-- hours are "HH:MM" times in time_zone, and may span midnight.
+CREATE TABLE
+    IF NOT EXISTS notification_preferences (
+        patient_id BIGINT PRIMARY KEY REFERENCES patients (id) ON DELETE CASCADE,
+        channels TEXT[] NOT NULL DEFAULT '{email}',
+        phone VARCHAR(30) NOT NULL DEFAULT '',
+        quiet_start VARCHAR(5) NOT NULL DEFAULT '',
+        quiet_end VARCHAR(5) NOT NULL DEFAULT '',
+        time_zone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+        updated_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            version INT NOT NULL DEFAULT 1
+    );
+
+-- Every message sent to anyone, patient or staff, on any channel.
+CREATE TABLE
+    IF NOT EXISTS notifications (
+        id BIGSERIAL PRIMARY KEY,
+        patient_id BIGINT REFERENCES patients (id) ON DELETE CASCADE,
+        channel VARCHAR(20) NOT NULL,
+        recipient VARCHAR(255) NOT NULL,
+        template VARCHAR(100) NOT NULL,
+        args JSONB NOT NULL DEFAULT '{}',
+        status VARCHAR(20) NOT NULL DEFAULT 'pending',
+        attempts INT NOT NULL DEFAULT 0,
+        provider_ref VARCHAR(255) NOT NULL DEFAULT '',
+        last_error TEXT NOT NULL DEFAULT '',
+        send_after TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            created_at TIMESTAMP
+        WITH
+            TIME ZONE NOT NULL DEFAULT NOW (),
+            sent_at TIMESTAMP
+        WITH
+            TIME ZONE
+    );
+
+CREATE INDEX idx_notifications_patient ON notifications (patient_id, created_at DESC);
+
+CREATE INDEX idx_notifications_status ON notifications (status, created_at DESC);
+
+-- +goose Down
+DROP TABLE IF EXISTS notifications;
+
+DROP TABLE IF EXISTS notification_preferences;
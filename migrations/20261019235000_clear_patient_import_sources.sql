@@ -0,0 +1,10 @@
+-- +goose Up
+-- Uploaded files may hold patients' passwords in plain text, so they are only kept
+-- until the import has run.
+UPDATE patient_imports
+SET
+    source = ''
+WHERE
+    status IN ('completed', 'failed');
+
+-- +goose Down
@@ -0,0 +1,22 @@
+-- +goose Up
+-- Doctors can start bulk exports of clinical records, so an export belongs to either
+-- a receptionist or a doctor.
+ALTER TABLE exports
+ALTER COLUMN receptionist_id
+DROP NOT NULL,
+ADD COLUMN doctor_id BIGINT REFERENCES doctors (id),
+ADD CONSTRAINT exports_requester_check CHECK (
+    receptionist_id IS NOT NULL
+    OR doctor_id IS NOT NULL
+);
+
+-- +goose Down
+DELETE FROM exports
+WHERE
+    receptionist_id IS NULL;
+
+ALTER TABLE exports
+DROP CONSTRAINT IF EXISTS exports_requester_check,
+DROP COLUMN IF EXISTS doctor_id,
+ALTER COLUMN receptionist_id
+SET NOT NULL;
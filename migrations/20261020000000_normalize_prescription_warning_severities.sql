@@ -0,0 +1,63 @@
+-- +goose Up
+-- Allergy warnings were stored with the severity of the reaction. They are graded on
+-- the same scale as interactions now, so all of a prescription's warnings compare.
+UPDATE prescriptions
+SET
+    warnings = (
+        SELECT
+            jsonb_agg(
+                CASE
+                    WHEN w ->> 'type' = 'allergy' THEN jsonb_set(
+                        w,
+                        '{severity}',
+                        to_jsonb(
+                            CASE w ->> 'severity'
+                                WHEN 'mild' THEN 'minor'
+                                WHEN 'severe' THEN 'major'
+                                ELSE w ->> 'severity'
+                            END
+                        )
+                    )
+                    ELSE w
+                END
+                ORDER BY
+                    ord
+            )
+        FROM
+            jsonb_array_elements(warnings)
+        WITH
+            ORDINALITY AS t (w, ord)
+    )
+WHERE
+    warnings @> '[{"type": "allergy"}]';
+
+-- +goose Down
+UPDATE prescriptions
+SET
+    warnings = (
+        SELECT
+            jsonb_agg(
+                CASE
+                    WHEN w ->> 'type' = 'allergy' THEN jsonb_set(
+                        w,
+                        '{severity}',
+                        to_jsonb(
+                            CASE w ->> 'severity'
+                                WHEN 'minor' THEN 'mild'
+                                WHEN 'major' THEN 'severe'
+                                ELSE w ->> 'severity'
+                            END
+                        )
+                    )
+                    ELSE w
+                END
+                ORDER BY
+                    ord
+            )
+        FROM
+            jsonb_array_elements(warnings)
+        WITH
+            ORDINALITY AS t (w, ord)
+    )
+WHERE
+    warnings @> '[{"type": "allergy"}]';